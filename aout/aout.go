@@ -0,0 +1,338 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package aout reads and writes Sixth Edition Unix a.out files,
+// the executables and object files produced by as and ld.
+//
+// An a.out file begins with an 8-word header (see Header),
+// followed by the text and data segments,
+// then the relocation words for text and data (unless suppressed),
+// and finally the symbol table.
+// All words are little-endian.
+package aout
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// Magic numbers.
+const (
+	MagicOverlay = 0o405 // text overlay, laid out like MagicImpure
+	MagicImpure  = 0o407 // writable text, data follows text
+	MagicPure    = 0o410 // read-only shared text, data at the next 8K boundary
+	MagicSep     = 0o411 // read-only shared text in a separate instruction space
+)
+
+// HeaderSize is the size of the header in bytes.
+const HeaderSize = 16
+
+// A Header is the header of an a.out file.
+// The sizes are in bytes and must be even.
+type Header struct {
+	Magic    uint16
+	TextSize uint16
+	DataSize uint16 // initialized data
+	BSSSize  uint16 // uninitialized data
+	SymSize  uint16 // symbol table
+	Entry    uint16 // entry point (always 0 in V6)
+	Unused   uint16
+	RelFlag  uint16 // 1 if relocation has been suppressed
+}
+
+// HasReloc reports whether the file has relocation words.
+// Like ld, it checks only the low bit of the flag.
+func (h *Header) HasReloc() bool {
+	return h.RelFlag&1 == 0
+}
+
+// DataAddr returns the address at which the data segment is loaded.
+// The text segment is always loaded at 0.
+func (h *Header) DataAddr() uint16 {
+	switch h.Magic {
+	case MagicPure:
+		const round = 0o20000
+		return uint16((int(h.TextSize) + round - 1) &^ (round - 1))
+	case MagicSep:
+		return 0
+	}
+	return h.TextSize
+}
+
+// Size returns the size in bytes of the file described by h.
+func (h *Header) Size() int {
+	n := HeaderSize + int(h.TextSize) + int(h.DataSize) + int(h.SymSize)
+	if h.HasReloc() {
+		n += int(h.TextSize) + int(h.DataSize)
+	}
+	return n
+}
+
+// A File is a parsed a.out file.
+type File struct {
+	Header
+	Text      []byte
+	Data      []byte
+	TextReloc []Reloc // one per text word; nil if relocation is suppressed
+	DataReloc []Reloc // one per data word; nil if relocation is suppressed
+	Syms      []Sym
+}
+
+// A RelocKind says what a word of text or data refers to.
+type RelocKind uint8
+
+const (
+	RelAbs  RelocKind = iota // absolute
+	RelText                  // text segment
+	RelData                  // initialized data
+	RelBSS                   // uninitialized data
+	RelExt                   // undefined external symbol (Reloc.Sym)
+)
+
+var relocKinds = [...]string{"abs", "text", "data", "bss", "ext"}
+
+func (k RelocKind) String() string {
+	if int(k) < len(relocKinds) {
+		return relocKinds[k]
+	}
+	return fmt.Sprintf("RelocKind(%d)", k)
+}
+
+// A Reloc is the relocation for a single word of text or data.
+type Reloc struct {
+	Kind  RelocKind
+	PCRel bool // word is relative to the PC, as in "clr x"
+	Sym   int  // index in File.Syms, for RelExt
+}
+
+func (r Reloc) word() uint16 {
+	w := uint16(r.Kind) << 1
+	if r.PCRel {
+		w |= 1
+	}
+	if r.Kind == RelExt {
+		w |= uint16(r.Sym) << 4
+	}
+	return w
+}
+
+// A SymType is a symbol's type.
+type SymType uint16
+
+const (
+	SymUndef SymType = 0o00 // undefined
+	SymAbs   SymType = 0o01 // absolute
+	SymText  SymType = 0o02 // text segment
+	SymData  SymType = 0o03 // data segment
+	SymBSS   SymType = 0o04 // bss segment
+	SymFile  SymType = 0o37 // file name (produced by ld)
+	SymExt   SymType = 0o40 // external (.globl) bit
+)
+
+// A Sym is a symbol table entry.
+// An undefined external symbol with a non-zero value
+// names a common region of that size.
+type Sym struct {
+	Name  string // at most 8 bytes
+	Type  SymType
+	Value uint16
+}
+
+// SymEntSize is the size of a symbol table entry in bytes.
+const SymEntSize = 12
+
+// Lookup returns the first symbol with the given name.
+func (f *File) Lookup(name string) (Sym, bool) {
+	for _, s := range f.Syms {
+		if s.Name == name {
+			return s, true
+		}
+	}
+	return Sym{}, false
+}
+
+// A FormatError reports a problem with an a.out file.
+type FormatError struct {
+	Off int // offset of the problem in the file
+	Msg string
+}
+
+func (e *FormatError) Error() string {
+	return fmt.Sprintf("a.out: %s (offset %#o)", e.Msg, e.Off)
+}
+
+func formatErr(off int, format string, args ...any) error {
+	return &FormatError{off, fmt.Sprintf(format, args...)}
+}
+
+// ParseHeader parses and checks the header at the start of data.
+// It checks that data is long enough to hold the text and data segments
+// but does not look past them, so that, like the kernel's exec,
+// it accepts files with truncated or malformed symbol tables.
+func ParseHeader(data []byte) (Header, error) {
+	var h Header
+	if len(data) < HeaderSize {
+		return h, formatErr(0, "file too short for header (%d bytes)", len(data))
+	}
+	w := func(i int) uint16 { return binary.LittleEndian.Uint16(data[2*i:]) }
+	h = Header{w(0), w(1), w(2), w(3), w(4), w(5), w(6), w(7)}
+	switch h.Magic {
+	default:
+		return h, formatErr(0, "bad magic number %06o", h.Magic)
+	case MagicOverlay, MagicImpure, MagicPure, MagicSep:
+	}
+	for _, sz := range []struct {
+		name string
+		n    uint16
+		off  int
+	}{{"text", h.TextSize, 2}, {"data", h.DataSize, 4}, {"bss", h.BSSSize, 6}} {
+		if sz.n&1 != 0 {
+			return h, formatErr(sz.off, "odd %s size %#o", sz.name, sz.n)
+		}
+	}
+	if n := HeaderSize + int(h.TextSize) + int(h.DataSize); n > len(data) {
+		return h, formatErr(len(data), "file too short for text and data (%d bytes, want %d)", len(data), n)
+	}
+	return h, nil
+}
+
+// Parse parses the a.out file in data.
+// In addition to the checks made by ParseHeader, it checks that
+// the relocation words and symbol table are well-formed
+// and that data has no bytes after the symbol table.
+// The returned File's Text and Data slices refer to data.
+func Parse(data []byte) (*File, error) {
+	h, err := ParseHeader(data)
+	if err != nil {
+		return nil, err
+	}
+	if h.SymSize%SymEntSize != 0 {
+		return nil, formatErr(8, "symbol table size %#o not a multiple of %d", h.SymSize, SymEntSize)
+	}
+	if n := h.Size(); n != len(data) {
+		if n > len(data) {
+			return nil, formatErr(len(data), "file too short (%d bytes, want %d)", len(data), n)
+		}
+		return nil, formatErr(n, "extra data at end of file (%d bytes, want %d)", len(data), n)
+	}
+
+	f := &File{Header: h}
+	off := HeaderSize
+	f.Text = data[off : off+int(h.TextSize)]
+	off += len(f.Text)
+	f.Data = data[off : off+int(h.DataSize)]
+	off += len(f.Data)
+
+	relOff := off
+	if h.HasReloc() {
+		off += len(f.Text) + len(f.Data)
+	}
+	nsym := int(h.SymSize) / SymEntSize
+	f.Syms = make([]Sym, nsym)
+	for i := range f.Syms {
+		b := data[off : off+SymEntSize]
+		name, _, _ := strings.Cut(string(b[:8]), "\x00")
+		f.Syms[i] = Sym{
+			Name:  name,
+			Type:  SymType(binary.LittleEndian.Uint16(b[8:])),
+			Value: binary.LittleEndian.Uint16(b[10:]),
+		}
+		off += SymEntSize
+	}
+
+	if h.HasReloc() {
+		if f.TextReloc, err = parseReloc(data, relOff, len(f.Text)/2, nsym); err != nil {
+			return nil, err
+		}
+		if f.DataReloc, err = parseReloc(data, relOff+len(f.Text), len(f.Data)/2, nsym); err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+// parseReloc parses the n relocation words at data[off:].
+func parseReloc(data []byte, off, n, nsym int) ([]Reloc, error) {
+	rel := make([]Reloc, n)
+	for i := range rel {
+		w := binary.LittleEndian.Uint16(data[off+2*i:])
+		r := Reloc{Kind: RelocKind(w >> 1 & 7), PCRel: w&1 != 0}
+		switch {
+		case r.Kind > RelExt:
+			return nil, formatErr(off+2*i, "bad relocation %06o", w)
+		case r.Kind == RelExt:
+			r.Sym = int(w >> 4)
+			if r.Sym >= nsym {
+				return nil, formatErr(off+2*i, "relocation %06o refers to symbol %d of %d", w, r.Sym, nsym)
+			}
+		case w>>4 != 0:
+			return nil, formatErr(off+2*i, "bad relocation %06o: symbol number in %v relocation", w, r.Kind)
+		}
+		rel[i] = r
+	}
+	return rel, nil
+}
+
+// Encode returns the encoding of f.
+// It sets f's TextSize, DataSize, and SymSize from the lengths of
+// Text, Data, and Syms, and it sets or clears RelFlag according
+// to whether there is any relocation.
+// If there is, TextReloc and DataReloc must have one entry
+// for each word of Text and Data.
+func (f *File) Encode() ([]byte, error) {
+	switch f.Magic {
+	default:
+		return nil, fmt.Errorf("a.out: bad magic number %06o", f.Magic)
+	case MagicOverlay, MagicImpure, MagicPure, MagicSep:
+	}
+	if len(f.Text)&1 != 0 || len(f.Data)&1 != 0 || f.BSSSize&1 != 0 {
+		return nil, fmt.Errorf("a.out: odd segment size")
+	}
+	if len(f.Text) > 0xFFFF || len(f.Data) > 0xFFFF || len(f.Syms)*SymEntSize > 0xFFFF {
+		return nil, fmt.Errorf("a.out: segment too large")
+	}
+	f.TextSize = uint16(len(f.Text))
+	f.DataSize = uint16(len(f.Data))
+	f.SymSize = uint16(len(f.Syms) * SymEntSize)
+	reloc := f.TextReloc != nil || f.DataReloc != nil
+	if reloc {
+		if len(f.TextReloc) != len(f.Text)/2 || len(f.DataReloc) != len(f.Data)/2 {
+			return nil, fmt.Errorf("a.out: relocation does not match text and data")
+		}
+		f.RelFlag &^= 1
+	} else {
+		f.RelFlag |= 1
+	}
+
+	b := make([]byte, 0, f.Size())
+	put := func(w uint16) { b = binary.LittleEndian.AppendUint16(b, w) }
+	h := &f.Header
+	for _, w := range []uint16{h.Magic, h.TextSize, h.DataSize, h.BSSSize, h.SymSize, h.Entry, h.Unused, h.RelFlag} {
+		put(w)
+	}
+	b = append(b, f.Text...)
+	b = append(b, f.Data...)
+	if reloc {
+		for _, rel := range [][]Reloc{f.TextReloc, f.DataReloc} {
+			for _, r := range rel {
+				if r.Kind > RelExt || r.Kind == RelExt && (r.Sym < 0 || r.Sym >= len(f.Syms) || r.Sym >= 1<<12) {
+					return nil, fmt.Errorf("a.out: bad relocation %+v", r)
+				}
+				put(r.word())
+			}
+		}
+	}
+	for _, s := range f.Syms {
+		if len(s.Name) > 8 {
+			return nil, fmt.Errorf("a.out: symbol name %q too long", s.Name)
+		}
+		var name [8]byte
+		copy(name[:], s.Name)
+		b = append(b, name[:]...)
+		put(uint16(s.Type))
+		put(s.Value)
+	}
+	return b, nil
+}
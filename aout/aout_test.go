@@ -0,0 +1,209 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package aout
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/tools/txtar"
+)
+
+// diskFiles returns the a.out files in the V6 disk image,
+// including the members of archives.
+func diskFiles(t *testing.T) map[string][]byte {
+	data, err := os.ReadFile("../v6unix/disk.txtar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := make(map[string][]byte)
+	for _, f := range txtar.Parse(data).Files {
+		if !strings.Contains(f.Name, " base64=1") {
+			continue
+		}
+		name, _, _ := strings.Cut(f.Name, " ")
+		b, err := base64.StdEncoding.DecodeString(string(f.Data))
+		if err != nil || len(b) < 2 {
+			continue
+		}
+		switch binary.LittleEndian.Uint16(b) {
+		case MagicImpure, MagicPure, MagicSep, MagicOverlay:
+			files[name] = b
+		case 0o177555: // archive
+			for b = b[2:]; len(b) >= 16; {
+				mname, _, _ := strings.Cut(string(b[:8]), "\x00")
+				size := int(binary.LittleEndian.Uint16(b[14:]))
+				b = b[16:]
+				if size > len(b) {
+					t.Fatalf("%s: truncated archive", name)
+				}
+				if strings.HasSuffix(mname, ".o") {
+					files[name+"("+mname+")"] = b[:size]
+				}
+				b = b[min((size+1)&^1, len(b)):]
+			}
+		}
+	}
+	return files
+}
+
+// Every a.out file on the disk parses and encodes back to the same bytes.
+func TestDiskFiles(t *testing.T) {
+	files := diskFiles(t)
+	if len(files) < 100 {
+		t.Fatalf("found only %d a.out files", len(files))
+	}
+	for name, data := range files {
+		f, err := Parse(data)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		enc, err := f.Encode()
+		if err != nil {
+			t.Errorf("%s: Encode: %v", name, err)
+			continue
+		}
+		if !bytes.Equal(enc, data) {
+			t.Errorf("%s: Encode does not match original", name)
+		}
+	}
+
+	f, err := Parse(files["/lib/crt0.o"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s, ok := f.Lookup("_main"); !ok || s.Type != SymExt|SymUndef {
+		t.Errorf("crt0.o: _main = %+v, %v, want undefined external", s, ok)
+	}
+	if s, ok := f.Lookup("start"); !ok || s.Type != SymText {
+		t.Errorf("crt0.o: start = %+v, %v, want text", s, ok)
+	}
+	if len(f.TextReloc) != len(f.Text)/2 {
+		t.Errorf("crt0.o: %d text relocations for %d words", len(f.TextReloc), len(f.Text)/2)
+	}
+}
+
+func TestEncode(t *testing.T) {
+	f := &File{
+		Header:    Header{Magic: MagicSep, BSSSize: 4, Entry: 2},
+		Text:      []byte{0o37, 0o11, 0, 0, 0, 0},
+		Data:      []byte{2, 0},
+		TextReloc: []Reloc{{}, {Kind: RelExt, Sym: 1}, {Kind: RelData, PCRel: true}},
+		DataReloc: []Reloc{{Kind: RelText}},
+		Syms: []Sym{
+			{"start", SymText | SymExt, 0},
+			{"_exit", SymUndef | SymExt, 0},
+			{"buf", SymBSS, 2},
+		},
+	}
+	data, err := f.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Header{MagicSep, 6, 2, 4, 36, 2, 0, 0}
+	if f.Header != want {
+		t.Errorf("Header = %+v, want %+v", f.Header, want)
+	}
+	if len(data) != want.Size() {
+		t.Errorf("len(data) = %d, want %d", len(data), want.Size())
+	}
+	if w := binary.LittleEndian.Uint16(data[HeaderSize+8+2:]); w != 0o30 {
+		t.Errorf("external relocation = %06o, want %06o", w, 0o30)
+	}
+	g, err := Parse(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(g, f) {
+		t.Errorf("Parse(Encode(f)) = %+v\nwant %+v", g, f)
+	}
+	if a := g.DataAddr(); a != 0 {
+		t.Errorf("DataAddr = %#o, want 0", a)
+	}
+
+	// Without relocation, the flag is set and the symbols follow the data.
+	f.TextReloc, f.DataReloc = nil, nil
+	data, err = f.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.RelFlag != 1 || len(data) != HeaderSize+6+2+36 {
+		t.Errorf("unrelocated: RelFlag = %d, len = %d", f.RelFlag, len(data))
+	}
+	if g, err = Parse(data); err != nil || g.TextReloc != nil || len(g.Syms) != 3 {
+		t.Errorf("Parse unrelocated = %+v, %v", g, err)
+	}
+}
+
+var dataAddrTests = []struct {
+	magic uint16
+	text  uint16
+	want  uint16
+}{
+	{MagicImpure, 0o1234, 0o1234},
+	{MagicOverlay, 0o1234, 0o1234},
+	{MagicPure, 0o1234, 0o20000},
+	{MagicPure, 0o20000, 0o20000},
+	{MagicPure, 0o20002, 0o40000},
+	{MagicPure, 0, 0},
+	{MagicSep, 0o1234, 0},
+}
+
+func TestDataAddr(t *testing.T) {
+	for _, tt := range dataAddrTests {
+		h := Header{Magic: tt.magic, TextSize: tt.text}
+		if a := h.DataAddr(); a != tt.want {
+			t.Errorf("%06o with text %#o: DataAddr = %#o, want %#o", tt.magic, tt.text, a, tt.want)
+		}
+	}
+}
+
+func hdr(words ...uint16) []byte {
+	var b []byte
+	for _, w := range words {
+		b = binary.LittleEndian.AppendUint16(b, w)
+	}
+	return b
+}
+
+var parseErrorTests = []struct {
+	data []byte
+	err  string
+}{
+	{hdr(0o407, 0, 0), "file too short for header"},
+	{hdr(0o406, 0, 0, 0, 0, 0, 0, 1), "bad magic number 000406"},
+	{hdr(0o407, 3, 0, 0, 0, 0, 0, 1), "odd text size 03"},
+	{hdr(0o410, 0, 0, 5, 0, 0, 0, 1), "odd bss size 05"},
+	{hdr(0o407, 2, 2, 0, 0, 0, 0, 1, 0), "file too short for text and data (18 bytes, want 20)"},
+	{hdr(0o407, 0, 0, 0, 10, 0, 0, 1), "symbol table size 012 not a multiple of 12"},
+	{hdr(0o407, 2, 0, 0, 12, 0, 0, 1, 0), "file too short (18 bytes, want 30)"},
+	{hdr(0o407, 2, 0, 0, 0, 0, 0, 1, 0, 0), "extra data at end of file"},
+	{hdr(0o407, 2, 0, 0, 0, 0, 0, 0, 0, 0o12), "bad relocation 000012"},
+	{hdr(0o407, 2, 0, 0, 0, 0, 0, 0, 0, 0o10), "relocation 000010 refers to symbol 0 of 0"},
+	{hdr(0o407, 2, 0, 0, 0, 0, 0, 0, 0, 0o22), "symbol number in text relocation"},
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, tt := range parseErrorTests {
+		_, err := Parse(tt.data)
+		var ferr *FormatError
+		if !errors.As(err, &ferr) || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("Parse(%x) = %v, want %q", tt.data, err, tt.err)
+		}
+	}
+
+	// ParseHeader does not look past the data segment.
+	h, err := ParseHeader(hdr(0o410, 2, 0, 0, 12, 0, 0, 1, 0))
+	if err != nil || h.SymSize != 12 {
+		t.Errorf("ParseHeader = %+v, %v", h, err)
+	}
+}
@@ -0,0 +1,674 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Ported from _fs/usr/source/s1/ld.c.
+//
+// Copyright 2001-2002 Caldera International Inc. All rights reserved.
+// Use of this source code is governed by a 4-clause BSD-style
+// license that can be found in the LICENSE file.
+
+// Package ld implements the Sixth Edition Unix link editor.
+//
+// Link combines V6 object files and archives (libraries) into an a.out file
+// exactly as /bin/ld does, producing byte-identical output.
+// Like ld, it makes two passes over its arguments:
+// the first collects the symbol tables and decides which archive members to load,
+// and the second relocates and writes the text, data, and symbols.
+// An archive is searched once, in order: a member is loaded only if
+// it defines a symbol that is undefined at that point,
+// so libraries must be ordered so that members precede the members they use.
+package ld
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+
+	"rsc.io/unix/aout"
+)
+
+// ArMagic is the magic number at the start of an archive.
+const ArMagic = 0o177555
+
+// Symbol types. Types are a single byte in ld's symbol table.
+const (
+	symExt   = uint8(aout.SymExt)
+	symUndef = uint8(aout.SymUndef)
+	symAbs   = uint8(aout.SymAbs)
+	symText  = uint8(aout.SymText)
+	symData  = uint8(aout.SymData)
+	symBSS   = uint8(aout.SymBSS)
+	symComm  = 0o05 // common, during middle only
+)
+
+// Table sizes, as in ld.
+const (
+	nsymMax   = 501 // symbols in the global symbol table
+	nlocalMax = 250 // undefined external references in a single object file
+)
+
+// An Error reports the problems that ld reports without stopping:
+// undefined and multiply defined symbols, and object files
+// without relocation. When Link returns an *Error,
+// it also returns the output that ld would have written
+// (but not made executable).
+type Error struct {
+	Undefined []string // undefined symbols
+	Msgs      []string // other problems, as ld prints them
+}
+
+func (e *Error) Error() string {
+	var msgs []string
+	if len(e.Undefined) > 0 {
+		msgs = append(msgs, "undefined: "+strings.Join(e.Undefined, ", "))
+	}
+	msgs = append(msgs, e.Msgs...)
+	return strings.Join(msgs, "\n")
+}
+
+// A fatalError is an error that stops ld.
+// It is passed to panic and recovered by Link.
+type fatalError struct {
+	err error
+}
+
+// A symbol is an entry in ld's global symbol table.
+type symbol struct {
+	name  string
+	typ   uint8
+	value uint16
+}
+
+// An input is a single object file or archive argument.
+type input struct {
+	name    string
+	data    []byte
+	f       *aout.File // object file, if not an archive
+	members []member   // archive members loaded by pass 1
+}
+
+// A member is an archive member.
+type member struct {
+	name string
+	f    *aout.File
+}
+
+// A local records an undefined external symbol
+// referred to by the current object file.
+type local struct {
+	symno int // index in the object file's symbol table
+	sym   *symbol
+}
+
+type linker struct {
+	read func(string) ([]byte, error)
+
+	xflag  bool // discard local symbols
+	Xflag  bool // discard locals starting with 'L'
+	rflag  bool // preserve relocation bits, don't define common
+	arflag bool // original copy of rflag
+	sflag  bool // discard all symbols
+	nflag  bool // pure procedure
+	dflag  bool // define common even with rflag
+	iflag  bool // I/D space separated
+
+	inputs  map[int]*input // inputs, by argument index
+	filname string         // current file, for errors
+	arname  string         // current archive member, for errors
+
+	syms   []*symbol
+	lookup map[string]*symbol
+	locals []local
+
+	tsize, dsize, bsize uint16
+	ssize               int // local symbols in output, in entries
+	nsym                int // local symbols in output, in entries, after middle
+
+	torigin, dorigin, borigin uint16
+	ctrel, cdrel, cbrel       uint16
+
+	text, data           []byte
+	textReloc, dataReloc []aout.Reloc
+	localSyms            []aout.Sym
+
+	err Error
+}
+
+// Link links the object files and archives named by args
+// and returns the resulting a.out file.
+// The arguments are those of the ld command:
+//
+//	-s      discard all symbols
+//	-u sym  enter sym as undefined, to load a library member defining it
+//	-lx     search the library /lib/libx.a (x defaults to a)
+//	-x      discard local symbols
+//	-X      discard local symbols starting with L
+//	-r      keep relocation in the output, and do not define common
+//	-d      define common even with -r
+//	-n      make the text read-only and shared (0410)
+//	-i      separate instruction and data spaces (0411)
+//
+// Arguments not starting with - name object files or archives,
+// which Link reads using read.
+// If there are undefined symbols, Link behaves as if -r were given
+// and returns the output together with an *Error.
+func Link(args []string, read func(name string) ([]byte, error)) (out []byte, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			f, ok := e.(fatalError)
+			if !ok {
+				panic(e)
+			}
+			out, err = nil, f.err
+		}
+	}()
+
+	l := &linker{
+		read:   read,
+		inputs: make(map[int]*input),
+		lookup: make(map[string]*symbol),
+	}
+	for i := 0; i < len(args); i++ {
+		l.filname = ""
+		arg := args[i]
+		if strings.HasPrefix(arg, "-") && len(arg) >= 2 {
+			switch arg[1] {
+			case 'u':
+				if i++; i >= len(args) {
+					l.fatalf("Bad 'use'")
+				}
+				if name := cp8c(args[i]); l.lookup[name] == nil {
+					l.enter(name, symExt|symUndef, 0)
+				}
+				continue
+			case 'l':
+				// library; load below
+			case 'x':
+				l.xflag = true
+				continue
+			case 'X':
+				l.Xflag = true
+				continue
+			case 'r':
+				l.rflag = true
+				l.arflag = true
+				continue
+			case 's':
+				l.sflag = true
+				l.xflag = true
+				continue
+			case 'n':
+				l.nflag = true
+				continue
+			case 'd':
+				l.dflag = true
+				continue
+			case 'i':
+				l.iflag = true
+				continue
+			}
+		}
+		l.load1arg(i, arg)
+	}
+	l.middle()
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if strings.HasPrefix(arg, "-") && len(arg) >= 2 {
+			switch arg[1] {
+			case 'u':
+				i++
+				continue
+			default:
+				continue
+			case 'l':
+				// library; load below
+			}
+		}
+		l.load2arg(i, arg)
+	}
+	return l.finish()
+}
+
+// fatalf reports an error that stops ld.
+func (l *linker) fatalf(format string, args ...any) {
+	panic(fatalError{fmt.Errorf("%s", l.errorPrefix()+fmt.Sprintf(format, args...))})
+}
+
+// errorf reports an error that does not stop ld.
+func (l *linker) errorf(format string, args ...any) {
+	l.err.Msgs = append(l.err.Msgs, l.errorPrefix()+fmt.Sprintf(format, args...))
+}
+
+func (l *linker) errorPrefix() string {
+	if l.filname == "" {
+		return ""
+	}
+	if l.arname != "" {
+		return l.filname + "(" + l.arname + "): "
+	}
+	return l.filname + ": "
+}
+
+// getfile reads the file for argument i
+// and parses it as an object file or archive.
+func (l *linker) getfile(i int, arg string) *input {
+	name := arg
+	if strings.HasPrefix(arg, "-l") {
+		c := "a"
+		if len(arg) > 2 {
+			c = arg[2:3]
+		}
+		name = "/lib/lib" + c + ".a"
+	}
+	l.filname = name
+	l.arname = ""
+	if in := l.inputs[i]; in != nil {
+		return in
+	}
+	data, err := l.read(name)
+	if err != nil {
+		l.fatalf("cannot open")
+	}
+	if len(data) < 2 {
+		l.fatalf("Premature EOF on %s", name)
+	}
+	in := &input{name: name, data: data}
+	if binary.LittleEndian.Uint16(data) != ArMagic {
+		in.f = l.readhdr(data)
+	}
+	l.inputs[i] = in
+	return in
+}
+
+// readhdr parses an object file.
+func (l *linker) readhdr(data []byte) *aout.File {
+	f, err := aout.Parse(data)
+	if err != nil || f.Magic != aout.MagicImpure {
+		l.fatalf("Bad format")
+	}
+	return f
+}
+
+// load1arg runs pass 1 on argument i.
+func (l *linker) load1arg(i int, arg string) {
+	in := l.getfile(i, arg)
+	if in.f != nil {
+		if !l.load1(in.f, false) {
+			in.f = nil // no relocation bits; skip in pass 2
+		}
+		return
+	}
+	for off := 2; off < len(in.data); {
+		if off+16 > len(in.data) {
+			l.fatalf("Premature EOF on %s", in.name)
+		}
+		hdr := in.data[off : off+16]
+		l.arname = cp8c(string(hdr[:8]))
+		size := int(binary.LittleEndian.Uint16(hdr[14:]))
+		off += 16
+		if off+size > len(in.data) {
+			l.fatalf("Premature EOF on %s", in.name)
+		}
+		f := l.readhdr(in.data[off : off+size])
+		if l.load1(f, true) {
+			in.members = append(in.members, member{l.arname, f})
+		}
+		off += size &^ 1
+	}
+}
+
+// load1 runs pass 1 on the object file f.
+// If lib is true, f is an archive member, and load1 loads it only
+// if it defines a symbol that is currently undefined.
+// load1 reports whether it loaded f.
+func (l *linker) load1(f *aout.File, lib bool) bool {
+	l.ctrel = l.tsize
+	l.cdrel = l.dsize - f.TextSize
+	l.cbrel = l.bsize - (f.TextSize + f.DataSize)
+	if !f.HasReloc() {
+		l.errorf("No relocation bits")
+		return false
+	}
+	ndef := 0
+	nloc := 1 // file name symbol
+	nsyms := len(l.syms)
+	var entered []string
+	for _, s := range f.Syms {
+		name, typ, value := l.symreloc(s)
+		if typ&symExt == 0 {
+			if !l.Xflag || !strings.HasPrefix(name, "L") {
+				nloc++
+			}
+			continue
+		}
+		sp := l.lookup[name]
+		if sp == nil {
+			l.enter(name, typ, value)
+			entered = append(entered, name)
+			continue
+		}
+		if sp.typ != symExt|symUndef {
+			continue
+		}
+		if typ == symExt|symUndef {
+			// common: keep the largest size
+			if int16(value) > int16(sp.value) {
+				sp.value = value
+			}
+			continue
+		}
+		if sp.value != 0 && typ == symExt|symText {
+			continue
+		}
+		ndef++
+		sp.typ = typ
+		sp.value = value
+	}
+	if !lib || ndef > 0 {
+		l.tsize += f.TextSize
+		l.dsize += f.DataSize
+		l.bsize += f.BSSSize
+		l.ssize += nloc
+		return true
+	}
+
+	// No symbols defined by this library member.
+	// Rip out the symbol table entries.
+	l.syms = l.syms[:nsyms]
+	for _, name := range entered {
+		delete(l.lookup, name)
+	}
+	return false
+}
+
+// symreloc returns the name, type, and value of s,
+// relocated using l.ctrel, l.cdrel, and l.cbrel.
+func (l *linker) symreloc(s aout.Sym) (name string, typ uint8, value uint16) {
+	name, typ, value = s.Name, uint8(s.Type), s.Value
+	switch typ {
+	case symText, symExt | symText:
+		value += l.ctrel
+		return
+	case symData, symExt | symData:
+		value += l.cdrel
+		return
+	case symBSS, symExt | symBSS:
+		value += l.cbrel
+		return
+	case symExt | symUndef:
+		return
+	}
+	if typ&symExt != 0 {
+		typ = symExt | symAbs
+	}
+	return
+}
+
+// enter adds a symbol to the global symbol table.
+func (l *linker) enter(name string, typ uint8, value uint16) *symbol {
+	if len(l.syms) >= nsymMax {
+		l.fatalf("Symbol table overflow")
+	}
+	sp := &symbol{name, typ, value}
+	l.syms = append(l.syms, sp)
+	l.lookup[name] = sp
+	return sp
+}
+
+// middle assigns common storage and final symbol values.
+func (l *linker) middle() {
+	etext := l.lookup["_etext"]
+	edata := l.lookup["_edata"]
+	end := l.lookup["_end"]
+
+	// If there are any undefined symbols, save the relocation bits.
+	if !l.rflag {
+		for _, sp := range l.syms {
+			if sp.typ == symExt|symUndef && sp.value == 0 && sp != end && sp != edata && sp != etext {
+				l.rflag = true
+				l.dflag = false
+				l.nflag = false
+				l.iflag = false
+				l.sflag = false
+				break
+			}
+		}
+	}
+
+	// Assign common locations.
+	var csize uint16
+	if l.dflag || !l.rflag {
+		for _, sp := range l.syms {
+			if sp.typ == symExt|symUndef && sp.value != 0 {
+				t := (sp.value + 1) &^ 1
+				sp.value = csize
+				sp.typ = symExt | symComm
+				csize += t
+			}
+		}
+		if etext != nil && etext.typ == symExt|symUndef {
+			etext.typ = symExt | symText
+			etext.value = l.tsize
+		}
+		if edata != nil && edata.typ == symExt|symUndef {
+			edata.typ = symExt | symData
+			edata.value = l.dsize
+		}
+		if end != nil && end.typ == symExt|symUndef {
+			end.typ = symExt | symBSS
+			end.value = l.bsize
+		}
+	}
+
+	// Now set symbols to their final values.
+	if l.nflag || l.iflag {
+		l.tsize = (l.tsize + 0o77) &^ 0o77
+	}
+	l.dorigin = l.tsize
+	if l.nflag {
+		l.dorigin = (l.tsize + 0o17777) &^ 0o17777
+	}
+	if l.iflag {
+		l.dorigin = 0
+	}
+	corigin := l.dorigin + l.dsize
+	l.borigin = corigin + csize
+	for _, sp := range l.syms {
+		switch sp.typ {
+		case symExt | symUndef:
+			if !l.arflag && sp.value == 0 {
+				l.err.Undefined = append(l.err.Undefined, sp.name)
+			}
+		case symExt | symText:
+			sp.value += l.torigin
+		case symExt | symData:
+			sp.value += l.dorigin
+		case symExt | symBSS:
+			sp.value += l.borigin
+		case symExt | symComm:
+			sp.typ = symExt | symBSS
+			sp.value += corigin
+		}
+	}
+	if l.sflag || l.xflag {
+		l.ssize = 0
+	}
+	l.bsize += csize
+	l.nsym = l.ssize
+}
+
+// load2arg runs pass 2 on argument i.
+func (l *linker) load2arg(i int, arg string) {
+	in := l.getfile(i, arg)
+	if in.f != nil {
+		l.mkfsym(arg[strings.LastIndex(arg, "/")+1:])
+		l.load2(in.f)
+		return
+	}
+	for _, m := range in.members {
+		l.arname = m.name
+		l.mkfsym(m.name)
+		l.load2(m.f)
+	}
+}
+
+// mkfsym adds a file name symbol to the local symbols.
+func (l *linker) mkfsym(name string) {
+	if l.sflag || l.xflag {
+		return
+	}
+	l.localSyms = append(l.localSyms, aout.Sym{Name: cp8c(name), Type: aout.SymFile, Value: l.torigin})
+}
+
+// load2 runs pass 2 on the object file f,
+// appending its relocated text, data, and symbols to the output.
+func (l *linker) load2(f *aout.File) {
+	l.ctrel = l.torigin
+	l.cdrel = l.dorigin - f.TextSize
+	l.cbrel = l.borigin - (f.TextSize + f.DataSize)
+
+	// Reread the symbol table, recording the numbering
+	// of symbols for fixing external references.
+	l.locals = l.locals[:0]
+	for symno, s := range f.Syms {
+		name, typ, value := l.symreloc(s)
+		if typ&symExt == 0 {
+			if !l.sflag && !l.xflag && (!l.Xflag || !strings.HasPrefix(name, "L")) {
+				l.localSyms = append(l.localSyms, aout.Sym{Name: name, Type: s.Type&^0xFF | aout.SymType(typ), Value: value})
+			}
+			continue
+		}
+		sp := l.lookup[name]
+		if sp == nil {
+			l.fatalf("internal error: symbol not found")
+		}
+		if typ == symExt|symUndef {
+			if len(l.locals) >= nlocalMax {
+				l.fatalf("Local symbol overflow")
+			}
+			l.locals = append(l.locals, local{symno, sp})
+			continue
+		}
+		if typ != sp.typ || value != sp.value {
+			// ld prints the symbol name before the file name.
+			l.err.Msgs = append(l.err.Msgs, name+": "+l.errorPrefix()+"Multiply defined")
+		}
+	}
+	l.text, l.textReloc = l.load2td(l.text, l.textReloc, f.Text, f.TextReloc, l.ctrel)
+	l.data, l.dataReloc = l.load2td(l.data, l.dataReloc, f.Data, f.DataReloc, l.cdrel)
+	l.torigin += f.TextSize
+	l.dorigin += f.DataSize
+	l.borigin += f.BSSSize
+}
+
+// load2td relocates the words in data using rel,
+// appending the result to out and the new relocation to outRel.
+func (l *linker) load2td(out []byte, outRel []aout.Reloc, data []byte, rel []aout.Reloc, creloc uint16) ([]byte, []aout.Reloc) {
+	for i, r := range rel {
+		t := binary.LittleEndian.Uint16(data[2*i:])
+		switch r.Kind {
+		case aout.RelText:
+			t += l.ctrel
+		case aout.RelData:
+			t += l.cdrel
+		case aout.RelBSS:
+			t += l.cbrel
+		case aout.RelExt:
+			sp := l.lookloc(r.Sym)
+			if sp.typ == symExt|symUndef {
+				r.Sym = l.nsym + l.symIndex(sp)
+				break
+			}
+			t += sp.value
+			r = aout.Reloc{Kind: aout.RelocKind(sp.typ - (symExt | symAbs)), PCRel: r.PCRel}
+		}
+		if r.PCRel {
+			t -= creloc
+		}
+		out = binary.LittleEndian.AppendUint16(out, t)
+		if l.rflag {
+			outRel = append(outRel, r)
+		}
+	}
+	return out, outRel
+}
+
+// lookloc returns the global symbol for the undefined external
+// symbol number symno in the current object file.
+func (l *linker) lookloc(symno int) *symbol {
+	for _, lp := range l.locals {
+		if lp.symno == symno {
+			return lp.sym
+		}
+	}
+	l.fatalf("Local symbol botch")
+	return nil
+}
+
+// symIndex returns the index of sp in the global symbol table.
+func (l *linker) symIndex(sp *symbol) int {
+	for i, s := range l.syms {
+		if s == sp {
+			return i
+		}
+	}
+	l.fatalf("internal error: symbol not found")
+	return 0
+}
+
+// finish returns the encoded output file.
+func (l *linker) finish() ([]byte, error) {
+	if l.nflag || l.iflag {
+		for len(l.text)&0o77 != 0 {
+			l.text = append(l.text, 0, 0)
+			if l.rflag {
+				l.textReloc = append(l.textReloc, aout.Reloc{})
+			}
+		}
+	}
+	f := &aout.File{
+		Header: aout.Header{Magic: aout.MagicImpure, BSSSize: l.bsize},
+		Text:   l.text,
+		Data:   l.data,
+	}
+	if l.nflag {
+		f.Magic = aout.MagicPure
+	}
+	if l.iflag {
+		f.Magic = aout.MagicSep
+	}
+	if l.rflag {
+		f.TextReloc = l.textReloc
+		f.DataReloc = l.dataReloc
+		if f.TextReloc == nil {
+			f.TextReloc = []aout.Reloc{}
+		}
+	}
+	if !l.sflag {
+		if !l.xflag {
+			f.Syms = l.localSyms
+		}
+		for _, sp := range l.syms {
+			f.Syms = append(f.Syms, aout.Sym{Name: sp.name, Type: aout.SymType(sp.typ), Value: sp.value})
+		}
+	}
+	out, err := f.Encode()
+	if err != nil {
+		return nil, err
+	}
+	if len(l.err.Undefined) > 0 || len(l.err.Msgs) > 0 {
+		return out, &l.err
+	}
+	return out, nil
+}
+
+// cp8c returns s truncated at its first NUL and to 8 bytes,
+// as it would be stored in a symbol table entry.
+func cp8c(s string) string {
+	s, _, _ = strings.Cut(s, "\x00")
+	if len(s) > 8 {
+		s = s[:8]
+	}
+	return s
+}
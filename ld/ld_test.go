@@ -0,0 +1,183 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ld
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"rsc.io/unix/aout"
+	"rsc.io/unix/v6unix"
+)
+
+// Sources compiled on the simulated V6 system for the link tests.
+// They define text, data, bss, common, local, and L symbols,
+// and refer to each other, to the C library, and to _end.
+const linkSources = `
+-- /tmp/x.c mode=0100666 --
+int c[10];
+int tab[] {1, 2, 3};
+char *msg "hello %d %o\n";
+extern end;
+main() {
+	int s;
+
+	s = f(2);
+	printf(msg, s, &end);
+	return(tab[s&1]);
+}
+-- /tmp/y.c mode=0100666 --
+int c[20];
+int d 5;
+g(x) { return(x+d); }
+f(x) { return(g(x)*2+c[1]); }
+-- /tmp/z.s mode=0100666 --
+.globl _z, _f, _zc
+.comm _zc,6
+.text
+_z:	jsr pc,_f
+	mov $_zc,r0
+	mov zd,r1
+	br _z
+.data
+zd:	_z; zd; zb
+.bss
+zb:	.=.+4
+`
+
+var linkTests = []string{
+	"/lib/crt0.o x.o y.o -lc",
+	"-X /lib/crt0.o x.o y.o -lc -l",
+	"-s /lib/crt0.o x.o y.o -lc",
+	"-x -n /lib/crt0.o x.o y.o -lc",
+	"-i /lib/crt0.o x.o y.o z.o -lc",
+	"-n -i -X /lib/crt0.o y.o x.o -lc",
+	"-r x.o y.o",
+	"-r -d x.o y.o z.o",
+	"-r x.o -lc",
+	"-u _exit -lc",
+	"x.o z.o",
+	"/lib/crt0.o x.o x.o y.o -lc",
+	"-X -r /lib/crt0.o z.o x.o",
+	"-d /lib/crt0.o x.o y.o z.o -lc -la",
+}
+
+// TestLink checks that Link produces the same output as
+// the real ld running on the simulated V6 system.
+func TestLink(t *testing.T) {
+	sys, err := v6unix.NewSystem(append(bytes.Clone(v6unix.FS), linkSources...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	init, err := sys.ReadFile("/etc/init")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stdout bytes.Buffer
+	if _, err := sys.Start(init, []string{"/etc/init"}, &stdout); err != nil {
+		t.Fatal(err)
+	}
+	sys.Wait()
+	run := func(cmd string) string {
+		stdout.Reset()
+		for _, c := range []byte(cmd + "\r") {
+			sys.TTY[8].WriteByte(c)
+		}
+		sys.Wait()
+		out := strings.ReplaceAll(stdout.String(), "\r\n", "\n")
+		return strings.TrimSuffix(strings.TrimPrefix(out, cmd+"\n"), "# ")
+	}
+	run("root")
+	run("root")
+	run("chdir /tmp")
+	if out := run("cc -c x.c y.c"); out != "x.c:\ny.c:\n" {
+		t.Fatalf("cc: %q", out)
+	}
+	if out := run("as z.s"); out != "" {
+		t.Fatalf("as: %q", out)
+	}
+	run("mv a.out z.o")
+
+	read := func(name string) ([]byte, error) {
+		if !strings.HasPrefix(name, "/") {
+			name = "/tmp/" + name
+		}
+		return sys.ReadFile(name)
+	}
+	for i, args := range linkTests {
+		ldout := run("ld " + args)
+		run(fmt.Sprintf("mv a.out ld%d", i))
+		want, err := sys.ReadFile(fmt.Sprintf("/tmp/ld%d", i))
+		if err != nil {
+			t.Fatalf("ld %s: %v\n%s", args, err, ldout)
+		}
+
+		have, err := Link(strings.Fields(args), read)
+		var lerr *Error
+		if err != nil && !errors.As(err, &lerr) {
+			t.Errorf("Link(%s): %v", args, err)
+			continue
+		}
+		if !bytes.Equal(have, want) {
+			t.Errorf("Link(%s): output differs from ld%s", args, diff(have, want))
+		}
+		var msgs string
+		if lerr != nil {
+			if len(lerr.Undefined) > 0 {
+				msgs = "Undefined:\n" + strings.Join(lerr.Undefined, "\n") + "\n"
+			}
+			for _, m := range lerr.Msgs {
+				msgs += m + "\n"
+			}
+		}
+		if msgs != ldout {
+			t.Errorf("Link(%s): messages:\n%s\nld printed:\n%s", args, msgs, ldout)
+		}
+	}
+}
+
+// diff describes the first difference between two a.out files.
+func diff(have, want []byte) string {
+	hf, herr := aout.Parse(have)
+	wf, werr := aout.Parse(want)
+	if herr != nil || werr != nil {
+		return fmt.Sprintf(": %v, %v", herr, werr)
+	}
+	if hf.Header != wf.Header {
+		return fmt.Sprintf(":\nhave %+v\nwant %+v", hf.Header, wf.Header)
+	}
+	for i := range have {
+		if have[i] != want[i] {
+			return fmt.Sprintf(" at offset %#o: have %03o, want %03o", i, have[i], want[i])
+		}
+	}
+	return ""
+}
+
+var errorTests = []struct {
+	args string
+	err  string
+}{
+	{"-u", "Bad 'use'"},
+	{"nosuch.o", "nosuch.o: cannot open"},
+	{"/bin/ls", "/bin/ls: Bad format"},
+	{"-lz", "/lib/libz.a: cannot open"},
+}
+
+func TestLinkErrors(t *testing.T) {
+	sys, err := v6unix.NewSystem(v6unix.FS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range errorTests {
+		out, err := Link(strings.Fields(tt.args), sys.ReadFile)
+		if err == nil || err.Error() != tt.err || out != nil {
+			t.Errorf("Link(%s) = %d bytes, %v, want %q", tt.args, len(out), err, tt.err)
+		}
+	}
+}
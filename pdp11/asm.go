@@ -11,76 +11,212 @@ import (
 	"strings"
 )
 
+// Asm assembles the instruction text at pc,
+// returning the instruction and its immediate and index words.
+// It is DEC.Asm.
 func Asm(pc uint16, text string) (codes []uint16, err error) {
-	defer func() {
-		if e := recover(); e != nil {
-			if _, ok := e.(runtime.Error); ok {
-				panic(e)
-			}
-			err = fmt.Errorf("asm %q: %v", text, e)
+	return DEC.Asm(pc, text)
+}
+
+// AsmInst assembles the decoded instruction inst at pc,
+// returning the instruction and its immediate and index words.
+// The index word of a PC-relative operand is computed from the operand's Target;
+// all other index and immediate words are taken from Word.
+// Inst.Len is ignored.
+func AsmInst(pc uint16, inst Inst) (codes []uint16, err error) {
+	defer catchAsm(&err, inst)
+	return encode(pc, &inst), nil
+}
+
+// Operands returns the operands taken by the instruction with mnemonic op,
+// as Args with only Kind and Float set.
+// It returns ok == false if there is no such instruction.
+func Operands(op string) (args []Arg, ok bool) {
+	i := lookupAsm(op)
+	if i < 0 {
+		return nil, false
+	}
+	for _, form := range forms[i].args {
+		var a Arg
+		switch form {
+		case "%b", "%B":
+			a.Kind = ArgBranch
+		case "%n", "%N", "%M":
+			a.Kind = ArgNum
+		case "%r", "%R":
+			a.Kind = ArgReg
+		case "%a":
+			a.Kind = ArgAC
+		case "%d", "%s", "%f":
+			a = Arg{Kind: ArgGen, Float: form == "%f"}
 		}
-	}()
+		args = append(args, a)
+	}
+	return args, true
+}
+
+// catchAsm converts a panic during assembly of what into an error.
+func catchAsm(err *error, what any) {
+	if e := recover(); e != nil {
+		if _, ok := e.(runtime.Error); ok {
+			panic(e)
+		}
+		*err = fmt.Errorf("asm %q: %v", what, e)
+	}
+}
 
+// parseInst parses the instruction text.
+// PC-relative operands have only their Target set,
+// and the result's Len is zero.
+func parseInst(text string) Inst {
 	op, args := parseAsm(text)
-	inst := lookupAsm(op)
-	if inst == nil {
+	i := lookupAsm(op)
+	if i < 0 {
 		panic("unknown instruction")
 	}
-	_, iargs := parseAsm(inst.text)
+	iargs := forms[i].args
 	if len(args) != len(iargs) {
 		panic(fmt.Sprintf("invalid argument count %d != %d", len(args), len(iargs)))
 	}
 
-	out := []uint16{inst.code}
+	inst := Inst{Op: op}
 	for i, arg := range args {
+		var a Arg
+		switch iargs[i] {
+		case "%b", "%B": // branch target
+			a = Arg{Kind: ArgBranch, Target: parseConst(arg)}
+		case "%n", "%N", "%M": // emt/trap number, spl level, mark count
+			a = Arg{Kind: ArgNum, Word: parseConst(arg)}
+		case "%r", "%R": // register number
+			a = Arg{Kind: ArgReg, Reg: parseReg(arg)}
+		case "%d", "%s": // destination, source
+			a = parseArg(arg, false)
+		case "%f": // fdst/fsrc
+			a = parseArg(arg, true)
+		case "%a": // accumulator index
+			a = Arg{Kind: ArgAC, Reg: parseAC(arg)}
+		}
+		inst.Args = append(inst.Args, a)
+	}
+	return inst
+}
+
+// encode encodes inst at pc.
+func encode(pc uint16, inst *Inst) []uint16 {
+	i := lookupAsm(inst.Op)
+	if i < 0 {
+		panic("unknown instruction")
+	}
+	iargs := forms[i].args
+	if len(inst.Args) != len(iargs) {
+		panic(fmt.Sprintf("invalid argument count %d != %d", len(inst.Args), len(iargs)))
+	}
+
+	out := []uint16{itab[i].code}
+	for i := range inst.Args {
+		a := &inst.Args[i]
 		switch iarg := iargs[i]; iarg {
 		case "%b": // branch offset
-			n := parseConst(arg)
-			d := int16(n-(pc+2)) / 2
+			checkKind(a, ArgBranch)
+			d := int16(a.Target-(pc+2)) / 2
 			if d != int16(int8(d)) {
 				panic("branch target out of range")
 			}
 			out[0] |= uint16(d) & 0o377
 		case "%B": // sob offset
-			n := parseConst(arg)
-			d := int16(n-(pc+2)) / 2
+			checkKind(a, ArgBranch)
+			d := int16(a.Target-(pc+2)) / 2
 			if d > 0 || d < -2*0o77 {
 				panic("branch target out of range")
 			}
 			out[0] |= uint16(-d) & 0o77
 		case "%n": // emt/trap number
-			n := parseConst(arg)
-			if n != n&0o377 {
+			checkKind(a, ArgNum)
+			if a.Word != a.Word&0o377 {
 				panic("emt/trap number out of range")
 			}
-			out[0] |= n
+			out[0] |= a.Word
 		case "%N": // spl level
-			n := parseConst(arg)
-			if n != n&0o7 {
+			checkKind(a, ArgNum)
+			if a.Word != a.Word&0o7 {
 				panic("spl level out of range")
 			}
-			out[0] |= n
+			out[0] |= a.Word
+		case "%M": // mark count
+			checkKind(a, ArgNum)
+			if a.Word != a.Word&0o77 {
+				panic("mark count out of range")
+			}
+			out[0] |= a.Word
 		case "%r": // register number at bit 6
-			out[0] |= uint16(parseReg(arg)) << 6
+			checkKind(a, ArgReg)
+			checkReg(a)
+			out[0] |= uint16(a.Reg) << 6
 		case "%R": // register number at bit 0
-			out[0] |= uint16(parseReg(arg)) << 0
+			checkKind(a, ArgReg)
+			checkReg(a)
+			out[0] |= uint16(a.Reg) << 0
 		case "%d": // destination
-			out = parseArg(pc, arg, 0, false, out)
+			out = encodeArg(pc, a, 0, false, out)
 		case "%s": // source
-			out = parseArg(pc, arg, 6, false, out)
+			out = encodeArg(pc, a, 6, false, out)
 		case "%f": // fdst/fsrc
-			out = parseArg(pc, arg, 0, true, out)
+			out = encodeArg(pc, a, 0, true, out)
 		case "%a": // accumulator index
-			out[0] |= parseAC(arg)
+			checkKind(a, ArgAC)
+			if a.Reg > 3 {
+				panic("invalid float accumulator")
+			}
+			out[0] |= uint16(a.Reg) << 6
 		}
 	}
-	return out, nil
+	return out
+}
+
+// checkKind panics if a is not of kind k.
+func checkKind(a *Arg, k ArgKind) {
+	if a.Kind != k {
+		panic(fmt.Sprintf("invalid argument %s", a))
+	}
+}
+
+// checkReg panics if a's register is out of range.
+func checkReg(a *Arg) {
+	if a.Reg > 7 {
+		panic("invalid register")
+	}
+}
+
+// encodeArg encodes the general operand a into codes[0] at the given shift,
+// appending its index or immediate word, if any, to codes.
+func encodeArg(pc uint16, a *Arg, shift uint, fp bool, codes []uint16) []uint16 {
+	checkKind(a, ArgGen)
+	checkReg(a)
+	if a.Mode > 7 {
+		panic("invalid addressing mode")
+	}
+	if a.Mode == 0 && a.Float != fp {
+		panic(fmt.Sprintf("invalid argument %s", a))
+	}
+	if a.Mode == 0 && fp && a.Reg >= 6 {
+		panic("invalid float accumulator")
+	}
+	codes[0] |= (uint16(a.Mode)<<3 | uint16(a.Reg)) << shift
+	if !a.HasWord() {
+		return codes
+	}
+	w := a.Word
+	if a.Reg == PC && a.Mode >= 6 {
+		next := pc + 2*uint16(1+len(codes))
+		w = a.Target - next
+	}
+	return append(codes, w)
 }
 
-func parseAC(arg string) uint16 {
+func parseAC(arg string) RegNum {
 	switch arg {
 	case "f0", "f1", "f2", "f3":
-		return uint16(arg[1]-'0') << 6
+		return RegNum(arg[1] - '0')
 	}
 	panic("invalid float accumulator")
 }
@@ -107,23 +243,22 @@ func parseConst(arg string) uint16 {
 	panic(fmt.Sprintf("invalid constant %q", arg))
 }
 
-func parseArg(pc uint16, arg string, shift uint, fp bool, codes []uint16) []uint16 {
+// parseArg parses a general operand.
+// If fp is true, mode 0 operands name floating accumulators.
+func parseArg(arg string, fp bool) Arg {
 	if arg == "" {
 		panic("empty arg")
 	}
 	if !fp && (arg[0] == 'r' || arg[0] == 'p' || arg[0] == 's') {
-		r := parseReg(arg)
-		codes[0] |= uint16(r) << shift
-		return codes
+		return Arg{Kind: ArgGen, Reg: parseReg(arg)}
 	}
 	if fp && len(arg) == 2 && arg[0] == 'f' && '0' <= arg[1] && arg[1] <= '5' {
-		codes[0] |= uint16(arg[1]-'0') << shift
-		return codes
+		return Arg{Kind: ArgGen, Reg: RegNum(arg[1] - '0'), Float: true}
 	}
 
-	mode := uint16(0)
+	a := Arg{Kind: ArgGen, Float: fp}
 	if arg[0] == '@' {
-		mode |= 0o10 // indirect bit
+		a.Mode |= 1 // indirect bit
 		arg = arg[1:]
 		if arg == "" {
 			panic("invalid indirect")
@@ -132,29 +267,34 @@ func parseArg(pc uint16, arg string, shift uint, fp bool, codes []uint16) []uint
 
 	if '0' <= arg[0] && arg[0] <= '7' && !strings.Contains(arg, "(") {
 		// pc-relative address, offset loaded from instruction stream
-		n := parseConst(arg)
-		codes[0] |= (0o67 | mode) << shift
-		next := pc + 2*uint16(1+len(codes))
-		return append(codes, n-next)
+		a.Mode |= 6
+		a.Reg = PC
+		a.Target = parseConst(arg)
+		return a
 	}
 	if arg[0] == '#' {
 		// constant loaded from instruction stream
-		codes[0] |= (0o27 | mode) << shift
-		return append(codes, parseConst(arg[1:]))
+		a.Mode |= 2
+		a.Reg = PC
+		a.Word = parseConst(arg[1:])
+		if a.Mode == 3 {
+			a.Target = a.Word
+		}
+		return a
 	}
 
-	imm, haveImm := uint16(0), false
+	haveImm := false
 	if '0' <= arg[0] && arg[0] <= '7' {
 		// immediate offset
 		i := strings.Index(arg, "(")
-		imm, arg = parseConst(arg[:i]), arg[i:]
+		a.Word, arg = parseConst(arg[:i]), arg[i:]
 		haveImm = true
 	}
 	if arg[0] == '-' {
 		if haveImm {
 			panic("decrement with immediate")
 		}
-		mode |= 0o40 // pre-decrement
+		a.Mode |= 4 // pre-decrement
 		arg = arg[1:]
 		if arg == "" {
 			panic("bad argument syntax")
@@ -167,24 +307,22 @@ func parseArg(pc uint16, arg string, shift uint, fp bool, codes []uint16) []uint
 	if !ok {
 		panic("bad argument syntax")
 	}
-	r := parseReg(reg)
+	a.Reg = parseReg(reg)
 	if arg == "+" {
 		if haveImm {
 			panic("increment with immediate")
 		}
-		mode |= 0o20 // post-increment
+		a.Mode |= 2 // post-increment
 	} else {
 		if arg != "" {
 			panic("bad argument syntax")
 		}
 		if haveImm {
-			mode |= 0o60
-			codes = append(codes, imm)
+			a.Mode |= 6
 		}
-		if mode == 0 {
-			mode = 0o10
+		if a.Mode == 0 {
+			a.Mode = 1
 		}
 	}
-	codes[0] |= (mode | uint16(r)) << shift
-	return codes
+	return a
 }
@@ -0,0 +1,656 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package asm implements a two-pass assembler for PDP-11 assembly language.
+//
+// Instructions use the syntax of pdp11.Asm, except that
+// wherever pdp11.Asm accepts a number, asm accepts an expression.
+// A source line has the form
+//
+//	label: label: op arg, arg ; comment
+//
+// where every part is optional. Comments begin with ; or //.
+// A label is an identifier or, for a local label, a decimal number.
+// A local label n can be defined many times;
+// nb refers to the nearest definition of n before the reference,
+// and nf to the nearest definition after it.
+//
+// A line of the form
+//
+//	name = expr
+//
+// assigns expr to the symbol name. Assigning to . (the location counter)
+// reserves space by advancing it.
+//
+// Expressions are made of numbers, which are octal unless
+// followed by a decimal point; character constants 'c;
+// symbols; local label references; and . (the location counter),
+// combined with the Go operators + - * / % << >> & | ^ and unary - and ~,
+// using Go precedence, and grouped with [ ], since ( ) denotes a register.
+//
+// The directives are:
+//
+//	.text          assemble into the text segment (the default)
+//	.data          assemble into the data segment
+//	.bss           assemble into the bss segment, which may only reserve space
+//	.word e, ...   words
+//	.byte e, ...   bytes
+//	.ascii "s", ...   string bytes, written as Go string literals
+//	.even          align the location counter to a word boundary
+//
+// Symbols may be used before they are defined:
+// the first pass determines the address of every label,
+// and the second pass generates the code.
+// The size of each instruction depends only on its addressing modes,
+// so both passes agree about addresses.
+package asm
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"rsc.io/unix/pdp11"
+)
+
+// An Object is the result of assembling a source file.
+//
+// The segments are laid out the way V6 Unix lays out an 0407 executable:
+// the data segment follows the text segment in memory, and the bss segment
+// follows the data segment. Words whose values depend on that layout
+// are listed in TextReloc and DataReloc.
+type Object struct {
+	Text      []byte  // text segment, starting at the origin passed to Assemble
+	Data      []byte  // data segment
+	BSS       uint16  // size of bss segment
+	TextReloc []Reloc // relocation of text words
+	DataReloc []Reloc // relocation of data words
+	Syms      []Sym   // symbols, in order of definition
+}
+
+// A Section identifies the segment a value is relative to.
+type Section uint8
+
+const (
+	Abs  Section = iota // absolute value
+	Text                // text segment
+	Data                // data segment
+	BSS                 // bss segment
+)
+
+var sectNames = []string{"abs", "text", "data", "bss"}
+
+func (s Section) String() string {
+	if int(s) < len(sectNames) {
+		return sectNames[s]
+	}
+	return fmt.Sprintf("Section(%d)", uint8(s))
+}
+
+// A Sym is a symbol defined by the source.
+type Sym struct {
+	Name  string
+	Sect  Section
+	Value uint16 // address, or value for an Abs symbol
+}
+
+// A Reloc records that a word in a segment depends on
+// the address of a segment.
+type Reloc struct {
+	Off   uint16  // offset of the word in its segment
+	Sect  Section // segment the word's value is relative to
+	PCRel bool    // word is relative to the address following it
+}
+
+// Lookup returns the symbol with the given name.
+func (o *Object) Lookup(name string) (Sym, bool) {
+	for _, s := range o.Syms {
+		if s.Name == name {
+			return s, true
+		}
+	}
+	return Sym{}, false
+}
+
+// Assemble assembles src, read from the named file,
+// placing the text segment at the address org.
+func Assemble(file string, src []byte, org uint16) (*Object, error) {
+	a := &assembler{
+		file:   file,
+		lines:  strings.Split(string(src), "\n"),
+		syms:   make(map[string]*symbol),
+		locals: make(map[string][]local),
+	}
+	a.base[Text] = org
+	for a.pass = 1; a.pass <= 2; a.pass++ {
+		a.sect = Text
+		a.dot = [4]uint16{}
+		for i, line := range a.lines {
+			a.lineno = i + 1
+			if err := a.line(line); err != nil {
+				return nil, err
+			}
+		}
+		if a.pass == 1 {
+			a.size = a.dot
+			for s := Text; s <= BSS; s++ {
+				a.size[s] += a.size[s] & 1
+			}
+			a.base[Data] = a.base[Text] + a.size[Text]
+			a.base[BSS] = a.base[Data] + a.size[Data]
+		}
+	}
+
+	obj := &Object{
+		Text:      a.seg[Text],
+		Data:      a.seg[Data],
+		BSS:       a.size[BSS],
+		TextReloc: a.reloc[Text],
+		DataReloc: a.reloc[Data],
+	}
+	for len(obj.Text) < int(a.size[Text]) {
+		obj.Text = append(obj.Text, 0)
+	}
+	for len(obj.Data) < int(a.size[Data]) {
+		obj.Data = append(obj.Data, 0)
+	}
+	for _, name := range a.order {
+		s := a.syms[name]
+		obj.Syms = append(obj.Syms, Sym{name, s.sect, a.base[s.sect] + s.off})
+	}
+	return obj, nil
+}
+
+// An assembler holds the state of an assembly.
+type assembler struct {
+	file   string
+	lines  []string
+	pass   int
+	lineno int
+
+	sect  Section    // current section
+	dot   [4]uint16  // location counter (offset) for each section
+	size  [4]uint16  // size of each section, computed by pass 1
+	base  [4]uint16  // address of each section
+	seg   [4][]byte  // contents of text and data, generated by pass 2
+	reloc [4][]Reloc // relocation of text and data, generated by pass 2
+	syms  map[string]*symbol
+	order []string // symbol names, in order of definition
+
+	locals map[string][]local // local label definitions, recorded by pass 1
+}
+
+// A symbol is a named value.
+// Its address is the base of its section plus off.
+type symbol struct {
+	sect  Section
+	off   uint16
+	label bool
+}
+
+// A local is a definition of a local label.
+type local struct {
+	lineno int
+	sect   Section
+	off    uint16
+}
+
+// A value is the value of an expression.
+type value struct {
+	sect  Section
+	v     uint16 // address or absolute value
+	known bool   // value is known (may be false only during pass 1)
+}
+
+// errorf reports an error in the current line.
+func errorf(format string, args ...any) {
+	panic(asmError(fmt.Sprintf(format, args...)))
+}
+
+// An asmError is a panic value reporting an error in the current line.
+type asmError string
+
+// line assembles a single source line.
+func (a *assembler) line(text string) (err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			msg, ok := e.(asmError)
+			if !ok {
+				panic(e)
+			}
+			err = fmt.Errorf("%s:%d: %s", a.file, a.lineno, msg)
+		}
+	}()
+
+	text = strings.TrimSpace(stripComment(text))
+	for {
+		i := strings.Index(text, ":")
+		if i < 0 || !isLabel(strings.TrimSpace(text[:i])) {
+			break
+		}
+		a.label(strings.TrimSpace(text[:i]))
+		text = strings.TrimSpace(text[i+1:])
+	}
+	if text == "" {
+		return nil
+	}
+	if name, expr, ok := strings.Cut(text, "="); ok && isIdent(strings.TrimSpace(name)) {
+		a.assign(strings.TrimSpace(name), expr)
+		return nil
+	}
+
+	op, args := text, ""
+	if i := strings.IndexAny(text, " \t"); i >= 0 {
+		op, args = text[:i], strings.TrimSpace(text[i:])
+	}
+	if strings.HasPrefix(op, ".") {
+		a.directive(op, splitArgs(args))
+	} else {
+		a.inst(op, splitArgs(args))
+	}
+	return nil
+}
+
+// addr returns the address of the location counter.
+func (a *assembler) addr() uint16 {
+	return a.base[a.sect] + a.dot[a.sect]
+}
+
+// label defines the label name at the location counter.
+func (a *assembler) label(name string) {
+	if isDigits(name) {
+		if a.pass == 1 {
+			a.locals[name] = append(a.locals[name], local{a.lineno, a.sect, a.dot[a.sect]})
+		}
+		return
+	}
+	s := a.syms[name]
+	if a.pass == 2 {
+		if s.sect != a.sect || s.off != a.dot[a.sect] {
+			errorf("phase error: label %s moved", name)
+		}
+		return
+	}
+	if s != nil {
+		errorf("%s redefined", name)
+	}
+	a.define(name, &symbol{sect: a.sect, off: a.dot[a.sect], label: true})
+}
+
+func (a *assembler) define(name string, s *symbol) {
+	if _, ok := a.syms[name]; !ok {
+		a.order = append(a.order, name)
+	}
+	a.syms[name] = s
+}
+
+// assign handles name = expr.
+func (a *assembler) assign(name, expr string) {
+	v := a.eval(expr)
+	if name == "." {
+		if !v.known {
+			errorf("undefined symbol in assignment to .")
+		}
+		if v.sect != a.sect && !(v.sect == Abs && a.sect == Text) {
+			errorf("assignment to . must be relative to %s", a.sect)
+		}
+		off := v.v - a.base[a.sect]
+		if off < a.dot[a.sect] {
+			errorf("assignment moves . backward")
+		}
+		a.dot[a.sect] = off
+		return
+	}
+	if isReg(name) {
+		errorf("cannot assign to register %s", name)
+	}
+	if s := a.syms[name]; s != nil && s.label {
+		errorf("%s redefined", name)
+	}
+	if !v.known {
+		delete(a.syms, name)
+		return
+	}
+	a.define(name, &symbol{sect: v.sect, off: v.v - a.base[v.sect]})
+}
+
+// directive assembles the directive op.
+func (a *assembler) directive(op string, args []string) {
+	switch op {
+	default:
+		errorf("unknown directive %s", op)
+	case ".text", ".data", ".bss":
+		if len(args) != 0 {
+			errorf("%s takes no arguments", op)
+		}
+		a.sect = map[string]Section{".text": Text, ".data": Data, ".bss": BSS}[op]
+	case ".even":
+		if len(args) != 0 {
+			errorf("%s takes no arguments", op)
+		}
+		if a.dot[a.sect]&1 != 0 {
+			a.emitB(0)
+		}
+	case ".word":
+		for _, arg := range args {
+			v := a.eval(arg)
+			a.emitW(v.v, a.relocFor(v, false))
+		}
+	case ".byte":
+		for _, arg := range args {
+			v := a.eval(arg)
+			if v.known && v.sect != Abs {
+				errorf("relocatable byte %s", arg)
+			}
+			if v.v > 0o377 && v.v < 0o177600 {
+				errorf("byte %s out of range", arg)
+			}
+			a.emitB(uint8(v.v))
+		}
+	case ".ascii":
+		for _, arg := range args {
+			s, err := strconv.Unquote(arg)
+			if err != nil || !strings.HasPrefix(arg, `"`) {
+				errorf("invalid string %s", arg)
+			}
+			for i := 0; i < len(s); i++ {
+				a.emitB(s[i])
+			}
+		}
+	}
+}
+
+// inst assembles the instruction op.
+func (a *assembler) inst(op string, args []string) {
+	forms, ok := pdp11.Operands(op)
+	if !ok {
+		errorf("unknown instruction %s", op)
+	}
+	if len(args) != len(forms) {
+		errorf("%s takes %d arguments, have %d", op, len(forms), len(args))
+	}
+	if a.dot[a.sect]&1 != 0 {
+		errorf("instruction at odd address")
+	}
+	if a.sect == BSS {
+		errorf("instruction in bss")
+	}
+
+	pc := a.addr()
+	inst := pdp11.Inst{Op: op}
+	var words []*Reloc // relocation for each index or immediate word
+	for i, arg := range args {
+		f := forms[i]
+		switch f.Kind {
+		case pdp11.ArgReg:
+			f.Reg = parseReg(arg)
+		case pdp11.ArgAC:
+			f.Reg = parseAC(arg)
+		case pdp11.ArgNum:
+			v := a.eval(arg)
+			if v.known && v.sect != Abs {
+				errorf("relocatable value %s", arg)
+			}
+			f.Word = v.v
+		case pdp11.ArgBranch:
+			v := a.eval(arg)
+			if v.known && v.sect != a.sect && !(v.sect == Abs && a.sect == Text) {
+				errorf("branch target %s not in %s", arg, a.sect)
+			}
+			f.Target = v.v
+		case pdp11.ArgGen:
+			var v value
+			f, v = a.operand(arg, f.Float)
+			if f.HasWord() {
+				pcrel := f.Reg == pdp11.PC && f.Mode >= 6
+				words = append(words, a.relocFor(v, pcrel))
+			}
+		}
+		inst.Args = append(inst.Args, f)
+	}
+
+	if a.pass == 1 {
+		a.dot[a.sect] += 2 * uint16(1+len(words))
+		return
+	}
+	codes, err := pdp11.AsmInst(pc, inst)
+	if err != nil {
+		errorf("%v", err)
+	}
+	a.emitW(codes[0], nil)
+	for i, code := range codes[1:] {
+		a.emitW(code, words[i])
+	}
+}
+
+// operand parses the general operand arg, returning the operand
+// and the value of its index or immediate word, if any.
+// If fp is true, mode 0 operands name floating accumulators.
+func (a *assembler) operand(arg string, fp bool) (pdp11.Arg, value) {
+	x := pdp11.Arg{Kind: pdp11.ArgGen, Float: fp}
+	if arg == "" {
+		errorf("missing operand")
+	}
+	if fp && len(arg) == 2 && arg[0] == 'f' && '0' <= arg[1] && arg[1] <= '5' {
+		x.Reg = pdp11.RegNum(arg[1] - '0')
+		return x, value{}
+	}
+	if !fp && isReg(arg) {
+		x.Reg = parseReg(arg)
+		return x, value{}
+	}
+
+	if arg[0] == '@' {
+		x.Mode = 1 // indirect bit
+		arg = strings.TrimSpace(arg[1:])
+		if arg == "" {
+			errorf("missing operand")
+		}
+	}
+	switch {
+	case arg[0] == '#':
+		// constant loaded from instruction stream
+		v := a.eval(arg[1:])
+		x.Mode |= 2
+		x.Reg = pdp11.PC
+		x.Word = v.v
+		if x.Mode == 3 {
+			x.Target = v.v
+		}
+		return x, v
+	case strings.HasPrefix(arg, "-(") && strings.HasSuffix(arg, ")"):
+		x.Mode |= 4 // pre-decrement
+		x.Reg = parseReg(arg[2 : len(arg)-1])
+		return x, value{}
+	case strings.HasPrefix(arg, "(") && strings.HasSuffix(arg, ")+"):
+		x.Mode |= 2 // post-increment
+		x.Reg = parseReg(arg[1 : len(arg)-2])
+		return x, value{}
+	case strings.HasPrefix(arg, "(") && strings.HasSuffix(arg, ")"):
+		x.Mode = 1 // register deferred; @(r) is the same as (r)
+		x.Reg = parseReg(arg[1 : len(arg)-1])
+		return x, value{}
+	case strings.HasSuffix(arg, ")"):
+		// indexed
+		i := strings.LastIndex(arg, "(")
+		if i < 0 {
+			errorf("bad operand syntax %s", arg)
+		}
+		v := a.eval(arg[:i])
+		x.Mode |= 6
+		x.Reg = parseReg(arg[i+1 : len(arg)-1])
+		x.Word = v.v
+		return x, v
+	}
+
+	// pc-relative address, offset loaded from instruction stream
+	v := a.eval(arg)
+	x.Mode |= 6
+	x.Reg = pdp11.PC
+	x.Target = v.v
+	return x, v
+}
+
+// relocFor returns the relocation for a word holding v,
+// or nil if the word needs none.
+// If pcrel is true, the word holds v relative to the address following it.
+func (a *assembler) relocFor(v value, pcrel bool) *Reloc {
+	if pcrel {
+		if v.sect == a.sect {
+			return nil
+		}
+		return &Reloc{Sect: v.sect, PCRel: true}
+	}
+	if v.sect == Abs {
+		return nil
+	}
+	return &Reloc{Sect: v.sect}
+}
+
+// emitW emits the word w with relocation r (or nil).
+func (a *assembler) emitW(w uint16, r *Reloc) {
+	if a.dot[a.sect]&1 != 0 {
+		errorf("word at odd address")
+	}
+	if r != nil && a.pass == 2 {
+		r.Off = a.dot[a.sect]
+		a.reloc[a.sect] = append(a.reloc[a.sect], *r)
+	}
+	a.emitB(uint8(w))
+	a.emitB(uint8(w >> 8))
+}
+
+// emitB emits the byte b.
+func (a *assembler) emitB(b uint8) {
+	if a.sect == BSS {
+		if b != 0 {
+			errorf("data in bss")
+		}
+		a.dot[a.sect]++
+		return
+	}
+	if a.pass == 2 {
+		seg := a.seg[a.sect]
+		for len(seg) < int(a.dot[a.sect]) {
+			seg = append(seg, 0)
+		}
+		a.seg[a.sect] = append(seg, b)
+	}
+	a.dot[a.sect]++
+}
+
+func isReg(s string) bool {
+	switch s {
+	case "r0", "r1", "r2", "r3", "r4", "r5", "r6", "r7", "sp", "pc":
+		return true
+	}
+	return false
+}
+
+func parseReg(s string) pdp11.RegNum {
+	s = strings.TrimSpace(s)
+	switch s {
+	case "sp":
+		return pdp11.SP
+	case "pc":
+		return pdp11.PC
+	}
+	if !isReg(s) {
+		errorf("invalid register %s", s)
+	}
+	return pdp11.RegNum(s[1] - '0')
+}
+
+func parseAC(s string) pdp11.RegNum {
+	switch s {
+	case "f0", "f1", "f2", "f3":
+		return pdp11.RegNum(s[1] - '0')
+	}
+	errorf("invalid float accumulator %s", s)
+	panic("unreachable")
+}
+
+// stripComment removes a ; or // comment from line.
+func stripComment(line string) string {
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case '\'':
+			i++ // character constant
+		case '"':
+			for i++; i < len(line) && line[i] != '"'; i++ {
+				if line[i] == '\\' {
+					i++
+				}
+			}
+		case ';':
+			return line[:i]
+		case '/':
+			if i+1 < len(line) && line[i+1] == '/' {
+				return line[:i]
+			}
+		}
+	}
+	return line
+}
+
+// splitArgs splits a comma-separated argument list.
+func splitArgs(s string) []string {
+	var args []string
+	if strings.TrimSpace(s) == "" {
+		return nil
+	}
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\'':
+			i++
+		case '"':
+			for i++; i < len(s) && s[i] != '"'; i++ {
+				if s[i] == '\\' {
+					i++
+				}
+			}
+		case ',':
+			args = append(args, strings.TrimSpace(s[start:i]))
+			start = i + 1
+		}
+	}
+	return append(args, strings.TrimSpace(s[start:]))
+}
+
+func isLabel(s string) bool {
+	return isDigits(s) || isIdent(s) && s != "." && !isReg(s)
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || '9' < s[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func isIdent(s string) bool {
+	if s == "" || !isIdentStart(s[0]) {
+		return false
+	}
+	for i := 1; i < len(s); i++ {
+		if !isIdentByte(s[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func isIdentStart(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || c == '_' || c == '.' || c == '$'
+}
+
+func isIdentByte(c byte) bool {
+	return isIdentStart(c) || '0' <= c && c <= '9'
+}
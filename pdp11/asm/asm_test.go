@@ -0,0 +1,199 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package asm
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"rsc.io/unix/pdp11"
+)
+
+const sumProg = `
+; sum adds the words in table and stores the result in total.
+n = [tabend-table]/2
+
+start:	mov	#table, r1
+	mov	#n, r0
+	clr	r2
+1:	add	(r1)+, r2	// loop
+	sob	r0, 1b
+	mov	r2, total
+	cmp	r2, #10.+20.+'a
+	bne	1f
+	inc	ok
+1:	halt
+
+	.data
+table:	.word	10., 20., 'a
+tabend:
+msg:	.ascii	"hi; there", "\n"
+	.even
+ptr:	.word	msg, table+2
+	.byte	1, -1
+	.bss
+total:	. = .+2
+ok:	. = .+2
+`
+
+func TestAssemble(t *testing.T) {
+	const org = 0o1000
+	obj, err := Assemble("sum.s", []byte(sumProg), org)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sym := func(name string) uint16 {
+		s, ok := obj.Lookup(name)
+		if !ok {
+			t.Fatalf("missing symbol %s", name)
+		}
+		return s.Value
+	}
+	if n := sym("n"); n != 3 {
+		t.Errorf("n = %d, want 3", n)
+	}
+	if start := sym("start"); start != org {
+		t.Errorf("start = %06o, want %06o", start, org)
+	}
+	data := org + uint16(len(obj.Text))
+	if table := sym("table"); table != data {
+		t.Errorf("table = %06o, want %06o", table, data)
+	}
+	if total := sym("total"); total != data+uint16(len(obj.Data)) {
+		t.Errorf("total = %06o, want %06o", total, data+uint16(len(obj.Data)))
+	}
+	if obj.BSS != 4 {
+		t.Errorf("BSS = %d, want 4", obj.BSS)
+	}
+	if s := string(obj.Data[6:16]); s != "hi; there\n" {
+		t.Errorf("msg = %q, want %q", s, "hi; there\n")
+	}
+
+	// The text refers to table, total, and ok.
+	// The data refers to msg and table.
+	wantText := []Reloc{{Off: 2, Sect: Data}, {Off: 16, Sect: BSS, PCRel: true}, {Off: 26, Sect: BSS, PCRel: true}}
+	if !reflect.DeepEqual(obj.TextReloc, wantText) {
+		t.Errorf("TextReloc = %+v, want %+v", obj.TextReloc, wantText)
+	}
+	wantData := []Reloc{{Off: 16, Sect: Data}, {Off: 18, Sect: Data}}
+	if !reflect.DeepEqual(obj.DataReloc, wantData) {
+		t.Errorf("DataReloc = %+v, want %+v", obj.DataReloc, wantData)
+	}
+
+	mem := new(pdp11.ArrayMem)
+	copy(mem[org:], obj.Text)
+	copy(mem[data:], obj.Data)
+	cpu := &pdp11.CPU{Mem: mem, TrapMode: pdp11.TrapVector}
+	cpu.R[pdp11.PC] = org
+	if err := cpu.Step(100); !errors.Is(err, pdp11.ErrHalt) {
+		t.Fatalf("Step = %v, want ErrHalt", err)
+	}
+	if total, _ := mem.ReadW(sym("total")); total != 30+'a' {
+		t.Errorf("total = %d, want %d", total, 30+'a')
+	}
+	if ok, _ := mem.ReadW(sym("ok")); ok != 1 {
+		t.Errorf("ok = %d, want 1", ok)
+	}
+	if ptr, _ := mem.ReadW(sym("ptr") + 2); ptr != sym("table")+2 {
+		t.Errorf("ptr[1] = %06o, want %06o", ptr, sym("table")+2)
+	}
+}
+
+var exprTests = []struct {
+	expr string
+	want uint16
+}{
+	{"17", 0o17},
+	{"17.", 17},
+	{"1+2*3", 7},
+	{"[1+2]*3", 9},
+	{"-1", 0o177777},
+	{"~0 & 7", 7},
+	{"1 << 3 | 1", 0o11},
+	{"100/3%4", 1},
+	{"'A", 'A'},
+	{"x-2", 0o776},
+	{"lab2-lab1", 4},
+}
+
+func TestExpr(t *testing.T) {
+	for _, tt := range exprTests {
+		src := "x = 1000\nlab1: nop\n nop\nlab2: y = " + tt.expr + "\n"
+		obj, err := Assemble("expr.s", []byte(src), 0)
+		if err != nil {
+			t.Errorf("%s: %v", tt.expr, err)
+			continue
+		}
+		if y, _ := obj.Lookup("y"); y.Value != tt.want {
+			t.Errorf("%s = %06o, want %06o", tt.expr, y.Value, tt.want)
+		}
+	}
+}
+
+var errorTests = []struct {
+	src string
+	err string
+}{
+	{"foo r1", "x.s:1: unknown instruction foo"},
+	{"mov r1", "x.s:1: mov takes 2 arguments, have 1"},
+	{"a: nop\na: nop", "x.s:2: a redefined"},
+	{"jmp nowhere", "x.s:1: undefined symbol nowhere"},
+	{"br 1f", "x.s:1: undefined local label 1f"},
+	{"br 2000", "branch target out of range"},
+	{".data\nbr 2000", "x.s:2: branch target 2000 not in data"},
+	{".byte 400", "x.s:1: byte 400 out of range"},
+	{".bss\n.word 1", "x.s:2: data in bss"},
+	{".byte 1\n.word 2", "x.s:2: word at odd address"},
+	{"a: .data\nb: x = a+b", "x.s:2: cannot add relocatable values"},
+	{"mov #1+, r0", "x.s:1: missing operand in expression"},
+	{". = 4\n. = 2", "x.s:2: assignment moves . backward"},
+	{".foo", "x.s:1: unknown directive .foo"},
+}
+
+func TestErrors(t *testing.T) {
+	for _, tt := range errorTests {
+		_, err := Assemble("x.s", []byte(tt.src), 0)
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("Assemble(%q) = %v, want %q", tt.src, err, tt.err)
+		}
+	}
+}
+
+// Every instruction pdp11.Asm accepts assembles to the same code.
+func TestAsmCompat(t *testing.T) {
+	for _, line := range []string{
+		"mov #5, -(sp)",
+		"jsr pc, @#4000",
+		"movb 10(r1), @2(r2)",
+		"bne 1000",
+		"sob r1, 774",
+		"ldf @(r3)+, f1",
+		"stcfd f2, f4",
+		"jmp @2000",
+		"trap 12",
+		"spl 7",
+	} {
+		const pc = 0o1000
+		want, err := pdp11.Asm(pc, line)
+		if err != nil {
+			t.Fatal(err)
+		}
+		obj, err := Assemble("x.s", []byte(line), pc)
+		if err != nil {
+			t.Errorf("%s: %v", line, err)
+			continue
+		}
+		var got []uint16
+		for i := 0; i < len(obj.Text); i += 2 {
+			got = append(got, uint16(obj.Text[i])|uint16(obj.Text[i+1])<<8)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: %06o, want %06o", line, got, want)
+		}
+	}
+}
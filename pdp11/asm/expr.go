@@ -0,0 +1,250 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package asm
+
+import (
+	"strconv"
+	"strings"
+)
+
+// eval evaluates the expression s.
+// During pass 1, an expression using a symbol that is not yet defined
+// evaluates to an unknown value. During pass 2, it is an error.
+func (a *assembler) eval(s string) value {
+	p := &exprParser{a: a, s: s}
+	v := p.binary(0)
+	p.skipSpace()
+	if p.i < len(p.s) {
+		errorf("invalid expression %s", strings.TrimSpace(s))
+	}
+	return v
+}
+
+// An exprParser parses and evaluates an expression.
+type exprParser struct {
+	a *assembler
+	s string
+	i int
+}
+
+// Binary operators by precedence, as in Go.
+var binaryOps = [][]string{
+	{"+", "-", "|", "^"},
+	{"*", "/", "%", "<<", ">>", "&"},
+}
+
+// binary parses a binary expression with operators of precedence prec or higher.
+func (p *exprParser) binary(prec int) value {
+	if prec == len(binaryOps) {
+		return p.unary()
+	}
+	x := p.binary(prec + 1)
+	for {
+		op := p.op(binaryOps[prec])
+		if op == "" {
+			return x
+		}
+		x = combine(op, x, p.binary(prec+1))
+	}
+}
+
+// op consumes and returns the next token if it is one of ops.
+func (p *exprParser) op(ops []string) string {
+	p.skipSpace()
+	for _, op := range ops {
+		if strings.HasPrefix(p.s[p.i:], op) {
+			p.i += len(op)
+			return op
+		}
+	}
+	return ""
+}
+
+func (p *exprParser) unary() value {
+	switch p.op([]string{"-", "~", "+"}) {
+	case "-":
+		x := p.unary()
+		checkAbs("-", x)
+		x.v = -x.v
+		return x
+	case "~":
+		x := p.unary()
+		checkAbs("~", x)
+		x.v = ^x.v
+		return x
+	case "+":
+		return p.unary()
+	}
+	return p.primary()
+}
+
+func (p *exprParser) primary() value {
+	p.skipSpace()
+	if p.i >= len(p.s) {
+		errorf("missing operand in expression")
+	}
+	c := p.s[p.i]
+	switch {
+	case c == '[':
+		p.i++
+		x := p.binary(0)
+		if p.op([]string{"]"}) == "" {
+			errorf("missing ] in expression")
+		}
+		return x
+
+	case c == '\'':
+		if p.i+1 >= len(p.s) {
+			errorf("missing character constant")
+		}
+		p.i += 2
+		return value{Abs, uint16(p.s[p.i-1]), true}
+
+	case '0' <= c && c <= '9':
+		j := p.i
+		for j < len(p.s) && isIdentByte(p.s[j]) && p.s[j] != '.' {
+			j++
+		}
+		tok := p.s[p.i:j]
+		if j < len(p.s) && p.s[j] == '.' {
+			// decimal
+			n, err := strconv.ParseUint(tok, 10, 16)
+			if err != nil {
+				errorf("invalid decimal number %s.", tok)
+			}
+			p.i = j + 1
+			return value{Abs, uint16(n), true}
+		}
+		p.i = j
+		if n := len(tok); n > 1 && (tok[n-1] == 'b' || tok[n-1] == 'f') && isDigits(tok[:n-1]) {
+			return p.a.local(tok[:n-1], tok[n-1] == 'f')
+		}
+		n, err := strconv.ParseUint(tok, 8, 16)
+		if err != nil {
+			errorf("invalid number %s", tok)
+		}
+		return value{Abs, uint16(n), true}
+
+	case isIdentStart(c):
+		j := p.i
+		for j < len(p.s) && isIdentByte(p.s[j]) {
+			j++
+		}
+		name := p.s[p.i:j]
+		p.i = j
+		if name == "." {
+			return value{p.a.sect, p.a.addr(), true}
+		}
+		return p.a.lookup(name)
+	}
+	errorf("invalid expression %s", strings.TrimSpace(p.s))
+	panic("unreachable")
+}
+
+func (p *exprParser) skipSpace() {
+	for p.i < len(p.s) && (p.s[p.i] == ' ' || p.s[p.i] == '\t') {
+		p.i++
+	}
+}
+
+// lookup returns the value of the symbol name.
+func (a *assembler) lookup(name string) value {
+	if isReg(name) {
+		errorf("register %s in expression", name)
+	}
+	s := a.syms[name]
+	if s == nil {
+		if a.pass == 1 {
+			return value{}
+		}
+		errorf("undefined symbol %s", name)
+	}
+	return value{s.sect, a.base[s.sect] + s.off, true}
+}
+
+// local returns the value of the local label reference nb or nf.
+func (a *assembler) local(n string, forward bool) value {
+	defs := a.locals[n]
+	if forward {
+		for _, d := range defs {
+			if d.lineno > a.lineno {
+				return value{d.sect, a.base[d.sect] + d.off, true}
+			}
+		}
+	} else {
+		for i := len(defs) - 1; i >= 0; i-- {
+			if d := defs[i]; d.lineno <= a.lineno {
+				return value{d.sect, a.base[d.sect] + d.off, true}
+			}
+		}
+	}
+	if a.pass == 1 {
+		return value{}
+	}
+	errorf("undefined local label %s%s", n, map[bool]string{false: "b", true: "f"}[forward])
+	panic("unreachable")
+}
+
+// combine returns x op y.
+// Adding an absolute value to a relocatable one, or subtracting
+// two values relative to the same segment, is allowed;
+// any other arithmetic requires absolute values.
+func combine(op string, x, y value) value {
+	if !x.known || !y.known {
+		return value{}
+	}
+	z := value{Abs, 0, true}
+	switch op {
+	case "+":
+		if x.sect != Abs && y.sect != Abs {
+			errorf("cannot add relocatable values")
+		}
+		z.sect = max(x.sect, y.sect)
+		z.v = x.v + y.v
+		return z
+	case "-":
+		switch {
+		case y.sect == Abs:
+			z.sect = x.sect
+		case x.sect != y.sect:
+			errorf("cannot subtract %s value from %s value", y.sect, x.sect)
+		}
+		z.v = x.v - y.v
+		return z
+	}
+	checkAbs(op, x)
+	checkAbs(op, y)
+	switch op {
+	case "|":
+		z.v = x.v | y.v
+	case "^":
+		z.v = x.v ^ y.v
+	case "*":
+		z.v = x.v * y.v
+	case "/", "%":
+		if y.v == 0 {
+			errorf("division by zero")
+		}
+		if op == "/" {
+			z.v = x.v / y.v
+		} else {
+			z.v = x.v % y.v
+		}
+	case "<<":
+		z.v = x.v << y.v
+	case ">>":
+		z.v = x.v >> y.v
+	case "&":
+		z.v = x.v & y.v
+	}
+	return z
+}
+
+// checkAbs reports an error if x is relocatable.
+func checkAbs(op string, x value) {
+	if x.known && x.sect != Abs {
+		errorf("%s of relocatable value", op)
+	}
+}
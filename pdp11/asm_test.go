@@ -127,5 +127,107 @@ func TestDisasmAsm(t *testing.T) {
 				t.Fatalf("too many errors")
 			}
 		}
+		inst, err := Decode(basePC, cpu.ReadW)
+		if err != nil {
+			t.Fatalf("Disasm(%06o) succeeded, but Decode failed: %v", codes, err)
+		}
+		if acodes, err := AsmInst(basePC, inst); err != nil || !reflect.DeepEqual(acodes, codes) {
+			t.Errorf("Decode(%06o) = %v, but AsmInst = %06o, %v", codes, inst, acodes, err)
+			if errs++; errs >= 20 {
+				t.Fatalf("too many errors")
+			}
+		}
+	}
+}
+
+var decodeTests = []struct {
+	codes []uint16
+	inst  Inst
+}{
+	{[]uint16{0o010102}, Inst{"mov", []Arg{{Kind: ArgGen, Reg: 1}, {Kind: ArgGen, Reg: 2}}, 2}},
+	{[]uint16{0o012767, 5, 0o100}, Inst{"mov", []Arg{
+		{Kind: ArgGen, Mode: 2, Reg: PC, Word: 5},
+		{Kind: ArgGen, Mode: 6, Reg: PC, Word: 0o100, Target: 0o10106},
+	}, 6}},
+	{[]uint16{0o005037, 0o177560}, Inst{"clr", []Arg{{Kind: ArgGen, Mode: 3, Reg: PC, Word: 0o177560, Target: 0o177560}}, 4}},
+	{[]uint16{0o016162, 0o177776, 4}, Inst{"mov", []Arg{
+		{Kind: ArgGen, Mode: 6, Reg: 1, Word: 0o177776},
+		{Kind: ArgGen, Mode: 6, Reg: 2, Word: 4},
+	}, 6}},
+	{[]uint16{0o001375}, Inst{"bne", []Arg{{Kind: ArgBranch, Target: 0o7774}}, 2}},
+	{[]uint16{0o077203}, Inst{"sob", []Arg{{Kind: ArgReg, Reg: 2}, {Kind: ArgBranch, Target: 0o7774}}, 2}},
+	{[]uint16{0o104405}, Inst{"trap", []Arg{{Kind: ArgNum, Word: 5}}, 2}},
+	{[]uint16{0o004767, 0o200}, Inst{"jsr", []Arg{{Kind: ArgReg, Reg: PC}, {Kind: ArgGen, Mode: 6, Reg: PC, Word: 0o200, Target: 0o10204}}, 4}},
+	{[]uint16{0o172402}, Inst{"ldf", []Arg{{Kind: ArgGen, Reg: 2, Float: true}, {Kind: ArgAC, Reg: 0}}, 2}},
+	{[]uint16{0o172412}, Inst{"ldf", []Arg{{Kind: ArgGen, Mode: 1, Reg: 2, Float: true}, {Kind: ArgAC, Reg: 0}}, 2}},
+	{[]uint16{0o000240}, Inst{"nop", nil, 2}},
+}
+
+func TestDecode(t *testing.T) {
+	const basePC = 0o010000
+	for _, tt := range decodeTests {
+		read := func(addr uint16) (uint16, error) {
+			i := int(addr-basePC) / 2
+			if i >= len(tt.codes) {
+				return 0, ErrMem
+			}
+			return tt.codes[i], nil
+		}
+		inst, err := Decode(basePC, read)
+		if err != nil {
+			t.Errorf("Decode(%06o): %v", tt.codes, err)
+			continue
+		}
+		if !reflect.DeepEqual(inst, tt.inst) {
+			t.Errorf("Decode(%06o) = %+v, want %+v", tt.codes, inst, tt.inst)
+		}
+		codes, err := AsmInst(basePC, inst)
+		if err != nil || !reflect.DeepEqual(codes, tt.codes) {
+			t.Errorf("AsmInst(%v) = %06o, %v, want %06o", inst, codes, err, tt.codes)
+		}
+
+		// A truncated instruction cannot be decoded.
+		if len(tt.codes) > 1 {
+			_, err := Decode(basePC, func(addr uint16) (uint16, error) {
+				if addr != basePC {
+					return 0, ErrMem
+				}
+				return tt.codes[0], nil
+			})
+			if err != ErrMem {
+				t.Errorf("Decode(%06o) truncated: err = %v, want ErrMem", tt.codes, err)
+			}
+		}
+	}
+}
+
+// AsmInst must encode PC-relative operands using their targets,
+// so that decoded instructions can be moved.
+func TestAsmInstMove(t *testing.T) {
+	inst := Inst{"jmp", []Arg{{Kind: ArgGen, Mode: 6, Reg: PC, Target: 0o2000}}, 4}
+	codes, err := AsmInst(0o1000, inst)
+	if want := []uint16{0o000167, 0o774}; err != nil || !reflect.DeepEqual(codes, want) {
+		t.Errorf("AsmInst(%v) = %06o, %v, want %06o", inst, codes, err, want)
+	}
+	inst = Inst{"bne", []Arg{{Kind: ArgNum, Word: 3}}, 2}
+	if _, err := AsmInst(0o1000, inst); err == nil {
+		t.Errorf("AsmInst(%v) succeeded with wrong argument kind", inst)
+	}
+}
+
+func TestOperands(t *testing.T) {
+	args, ok := Operands("sob")
+	if want := []Arg{{Kind: ArgReg}, {Kind: ArgBranch}}; !ok || !reflect.DeepEqual(args, want) {
+		t.Errorf("Operands(sob) = %v, %v, want %v, true", args, ok, want)
+	}
+	args, ok = Operands("stcfd")
+	if want := []Arg{{Kind: ArgAC}, {Kind: ArgGen, Float: true}}; !ok || !reflect.DeepEqual(args, want) {
+		t.Errorf("Operands(stcfd) = %v, %v, want %v, true", args, ok, want)
+	}
+	if args, ok := Operands("nop"); !ok || len(args) != 0 {
+		t.Errorf("Operands(nop) = %v, %v, want [], true", args, ok)
+	}
+	if _, ok := Operands("xyzzy"); ok {
+		t.Errorf("Operands(xyzzy) succeeded")
 	}
 }
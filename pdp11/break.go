@@ -0,0 +1,154 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pdp11
+
+import "fmt"
+
+// An Access is a kind of memory access that can stop execution.
+type Access uint8
+
+const (
+	AccessExec  Access = 1 << iota // execute an instruction
+	AccessRead                     // read data
+	AccessWrite                    // write data
+)
+
+func (a Access) String() string {
+	switch a {
+	case AccessExec:
+		return "exec"
+	case AccessRead:
+		return "read"
+	case AccessWrite:
+		return "write"
+	}
+	return fmt.Sprintf("Access(%d)", uint8(a))
+}
+
+// A Break is the error Step returns when execution stops
+// at a breakpoint or watchpoint.
+//
+// For a breakpoint (Access == AccessExec), Step stops before
+// executing the instruction at the breakpoint, and Addr and PC
+// are both its address. Calling Step again executes the instruction
+// instead of stopping at the same breakpoint again.
+//
+// For a watchpoint, Step stops after the instruction making the access
+// completes; Addr is the accessed address and PC is the instruction's address.
+type Break struct {
+	Access Access
+	Addr   uint16
+	PC     uint16
+}
+
+func (b *Break) Error() string {
+	if b.Access == AccessExec {
+		return fmt.Sprintf("breakpoint at %06o", b.Addr)
+	}
+	return fmt.Sprintf("%v watchpoint at %06o (pc=%06o)", b.Access, b.Addr, b.PC)
+}
+
+// A watch is a watchpoint on addresses [lo, hi].
+type watch struct {
+	lo, hi uint16
+	access Access
+}
+
+// debug holds a CPU's breakpoints and watchpoints.
+type debug struct {
+	breaks   map[uint16]bool
+	watches  []watch
+	hit      *Break // watchpoint hit by current instruction
+	resume   bool   // skip breakpoint at resumePC
+	resumePC uint16
+}
+
+// SetBreakpoint sets a breakpoint at the instruction at pc
+// (a virtual address in the current mode's instruction space).
+func (cpu *CPU) SetBreakpoint(pc uint16) {
+	if cpu.debug == nil {
+		cpu.debug = new(debug)
+	}
+	if cpu.debug.breaks == nil {
+		cpu.debug.breaks = make(map[uint16]bool)
+	}
+	cpu.debug.breaks[pc] = true
+}
+
+// ClearBreakpoint clears the breakpoint at pc, if any.
+func (cpu *CPU) ClearBreakpoint(pc uint16) {
+	if cpu.debug != nil {
+		delete(cpu.debug.breaks, pc)
+	}
+}
+
+// SetWatchpoint sets a watchpoint on the n bytes of memory starting at addr,
+// for the kinds of access in the mask access (AccessRead, AccessWrite, or both).
+// Watchpoints match the virtual addresses of data references made
+// by instructions in the current mode. They do not match
+// instruction space references (instruction fetches, immediate operands,
+// and index words) or references to registers.
+func (cpu *CPU) SetWatchpoint(addr, n uint16, access Access) {
+	if n == 0 {
+		return
+	}
+	if cpu.debug == nil {
+		cpu.debug = new(debug)
+	}
+	cpu.debug.watches = append(cpu.debug.watches, watch{addr, addr + n - 1, access &^ AccessExec})
+}
+
+// ClearWatchpoint clears the watchpoints set by SetWatchpoint(addr, n, ...).
+func (cpu *CPU) ClearWatchpoint(addr, n uint16) {
+	if cpu.debug == nil {
+		return
+	}
+	w := cpu.debug.watches[:0]
+	for _, x := range cpu.debug.watches {
+		if x.lo != addr || x.hi != addr+n-1 {
+			w = append(w, x)
+		}
+	}
+	cpu.debug.watches = w
+}
+
+// checkBreak returns a Break if there is a breakpoint at pc,
+// the start of the next instruction.
+func (cpu *CPU) checkBreak(pc uint16) *Break {
+	d := cpu.debug
+	d.hit = nil
+	resume := d.resume && d.resumePC == pc
+	d.resume = false
+	if !d.breaks[pc] || resume {
+		return nil
+	}
+	d.resume = true
+	d.resumePC = pc
+	return &Break{AccessExec, pc, pc}
+}
+
+// checkWatch records a watchpoint hit if the size-byte
+// data access of the given kind at a matches a watchpoint.
+func (cpu *CPU) checkWatch(a addr, size uint16, access Access) {
+	d := cpu.debug
+	if a&(addrReg|addrI) != 0 || d.hit != nil {
+		return
+	}
+	lo := uint16(a)
+	hi := lo + size - 1
+	for _, w := range d.watches {
+		if w.access&access != 0 && lo <= w.hi && w.lo <= hi {
+			d.hit = &Break{access, lo, cpu.instPC}
+			return
+		}
+	}
+}
+
+// watchHit returns and clears the watchpoint hit by the last instruction, if any.
+func (cpu *CPU) watchHit() *Break {
+	b := cpu.debug.hit
+	cpu.debug.hit = nil
+	return b
+}
@@ -0,0 +1,68 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pdp11
+
+import "testing"
+
+func TestBreakpoint(t *testing.T) {
+	for _, mode := range []TrapMode{TrapError, TrapVector} {
+		cpu, _ := newTrapTest(t,
+			"inc r0",
+			"inc r0",
+			"inc r0",
+		)
+		cpu.TrapMode = mode
+		cpu.SetBreakpoint(0o1002)
+		err := cpu.Step(10)
+		if b, ok := err.(*Break); !ok || *b != (Break{AccessExec, 0o1002, 0o1002}) || cpu.R[0] != 1 {
+			t.Fatalf("mode %d: Step = %v, r0=%d, want breakpoint at 001002, r0=1", mode, err, cpu.R[0])
+		}
+
+		// Resuming executes the instruction at the breakpoint.
+		if err := cpu.Step(1); err != nil || cpu.R[0] != 2 || cpu.R[PC] != 0o1004 {
+			t.Fatalf("mode %d: Step = %v, r0=%d pc=%06o, want nil, 2, 001004", mode, err, cpu.R[0], cpu.R[PC])
+		}
+
+		cpu.ClearBreakpoint(0o1002)
+		cpu.R[PC] = 0o1002
+		if err := cpu.Step(1); err != nil || cpu.R[0] != 3 {
+			t.Fatalf("mode %d: after clear: Step = %v, r0=%d, want nil, 3", mode, err, cpu.R[0])
+		}
+	}
+}
+
+func TestWatchpoint(t *testing.T) {
+	cpu, mem := newTrapTest(t,
+		"mov #5, @#3000",
+		"mov @#3000, r1",
+		"mov r1, r2",
+	)
+	cpu.TrapMode = TrapError
+	cpu.SetWatchpoint(0o3000, 2, AccessWrite)
+	err := cpu.Step(10)
+	if b, ok := err.(*Break); !ok || *b != (Break{AccessWrite, 0o3000, 0o1000}) {
+		t.Fatalf("Step = %v, want write watchpoint at 003000 (pc=001000)", err)
+	}
+	if v, _ := mem.ReadW(0o3000); v != 5 || cpu.R[PC] != 0o1006 {
+		t.Fatalf("after write watchpoint: *003000=%06o pc=%06o, want 5, 001006", v, cpu.R[PC])
+	}
+	if err := cpu.Step(2); err != nil || cpu.R[2] != 5 {
+		t.Fatalf("Step = %v, r2=%d, want nil, 5", err, cpu.R[2])
+	}
+
+	// A read watchpoint on the high byte matches the word read.
+	cpu.SetWatchpoint(0o3001, 1, AccessRead)
+	cpu.R[PC] = 0o1006
+	err = cpu.Step(10)
+	if b, ok := err.(*Break); !ok || *b != (Break{AccessRead, 0o3000, 0o1006}) {
+		t.Fatalf("Step = %v, want read watchpoint at 003000 (pc=001006)", err)
+	}
+
+	cpu.ClearWatchpoint(0o3001, 1)
+	cpu.R[PC] = 0o1006
+	if err := cpu.Step(2); err != nil {
+		t.Fatalf("after clear: Step = %v, want nil", err)
+	}
+}
@@ -9,15 +9,54 @@ import (
 )
 
 // A CPU represents a single PDP-11 CPU, connected to a memory.
+//
+// If MMU is nil, the CPU addresses Mem directly.
+// If IMem is also set, instruction fetches, immediate operands,
+// and index words come from IMem (instruction space)
+// while all other operands use Mem (data space).
+// Otherwise every memory access is translated by the MMU
+// to the MMU's physical memory, and Mem is unused.
+//
+// If Bus is non-nil, the I/O page (the top 8 kB of the unmapped
+// address space, or the top 8 kB of physical memory when using the MMU)
+// holds the registers of the devices attached to the bus.
 type CPU struct {
-	R    [8]uint16  // registers
-	PS   PS         // processor status word
-	Inst uint16     // instruction being executed (actual instruction bits)
-	Mem  Memory     // attached memory
-	F    [6]float64 // floating-point registers
-	FPS  FPS        // floating point status word
-	FEC  uint8      // fp error code
-	FEA  uint8      // fp exception address
+	R      [8]uint16 // registers
+	PS     PS        // processor status word
+	Inst   uint16    // instruction being executed (actual instruction bits)
+	Mem    Memory    // attached memory
+	IMem   Memory    // separate instruction-space memory, or nil
+	MMU    *MMU      // memory management unit, or nil
+	MemMap *MemMap   // memory map for strict checking without an MMU, or nil
+	Stack  [4]uint16 // stack pointers (R6) for modes other than the current one
+	F      [6]Float  // floating-point accumulators
+	FPS    FPS       // floating point status word
+	FEC    uint8     // fp error code (FEC_OP and so on)
+	FEA    uint16    // fp exception address: address of the instruction that caused FEC
+
+	TrapMode TrapMode // how Step handles traps
+	Waiting  bool     // WAIT instruction is waiting for an interrupt
+	Bus      *Unibus  // I/O page devices, or nil
+
+	Model       *Model  // processor model, or nil for all instructions
+	Timing      *Timing // instruction timing model, or nil
+	Nanoseconds uint64  // simulated processor time used, including trapping instructions (only counted if Timing != nil)
+	Tracer      Tracer  // instruction tracer, or nil
+
+	psWritten bool         // instruction wrote PS explicitly
+	psValue   PS           // value written
+	instPC    uint16       // address of current instruction
+	fpTrap    bool         // instruction raised a floating point trap
+	debug     *debug       // breakpoints and watchpoints, or nil
+	trace     *TraceRecord // record of instruction being traced, or nil
+	traceBuf  *TraceRecord // reused trace record
+	hist      *history     // recorded execution history, or nil
+	irqs      []irq
+	fault     error     // fault in current instruction, or nil
+	faultRegs regState  // registers at time of fault
+	trapBuf   Trap      // details of the current fault, copied by trapError
+	imem      *ArrayMem // flat instruction memory for fast access, or nil
+	dmem      *ArrayMem // flat data memory for fast access, or nil
 }
 
 var (
@@ -28,6 +67,7 @@ var (
 	ErrIOT  = fmt.Errorf("iot instruction")
 	ErrEMT  = fmt.Errorf("emt instruction")
 	ErrFPT  = fmt.Errorf("floating point trap")
+	ErrMMU  = fmt.Errorf("memory management abort")
 )
 
 // A Memory represents a PDP-11 memory.
@@ -81,16 +121,49 @@ func (r RegNum) String() string {
 }
 
 // A PS is the processor status word.
-// Only the condition codes are used.
 type PS uint16
 
 const (
-	PS_C PS = 1 << 0 // C = 1 if result generated carry
-	PS_V PS = 1 << 1 // V = 1 if result overflowed
-	PS_Z PS = 1 << 2 // Z =1 if result was zero
-	PS_N PS = 1 << 3 // N = 1 if result was negative
+	PS_C    PS = 1 << 0  // C = 1 if result generated carry
+	PS_V    PS = 1 << 1  // V = 1 if result overflowed
+	PS_Z    PS = 1 << 2  // Z =1 if result was zero
+	PS_N    PS = 1 << 3  // N = 1 if result was negative
+	PS_T    PS = 1 << 4  // T = 1 to trap after each instruction
+	PS_PRI  PS = 7 << 5  // processor priority
+	PS_PREV PS = 3 << 12 // previous mode
+	PS_CUR  PS = 3 << 14 // current mode
+)
+
+// A Mode is a processor mode, as recorded in the PS.
+type Mode uint8
+
+const (
+	Kernel     Mode = 0
+	Supervisor Mode = 1
+	User       Mode = 3
 )
 
+func (m Mode) String() string {
+	switch m {
+	case Kernel:
+		return "kernel"
+	case Supervisor:
+		return "supervisor"
+	case User:
+		return "user"
+	}
+	return fmt.Sprintf("Mode(%d)", m)
+}
+
+// Mode returns the current processor mode.
+func (p PS) Mode() Mode { return Mode(p >> 14) }
+
+// PrevMode returns the previous processor mode.
+func (p PS) PrevMode() Mode { return Mode(p>>12) & 3 }
+
+// Priority returns the processor priority (0..7).
+func (p PS) Priority() int { return int(p>>5) & 7 }
+
 // C returns the carry bit as a uint16 that is 0 or 1.
 func (p PS) C() uint16 { return uint16(p) & 1 }
 
@@ -136,41 +209,283 @@ func (p *PS) setNZB(v uint8) {
 	p.set(v>>7 != 0, PS_N)
 }
 
-const psAddr = 0o177776 // PS is at special address 0o177776
+const (
+	ioPage = 0o160000 // start of I/O page in 16-bit address space
+	psAddr = 0o177776 // PS is at special address 0o177776
+)
+
+// SetPS sets the processor status word to ps.
+// If the current mode changes, SetPS also switches
+// to the stack pointer for the new mode.
+func (cpu *CPU) SetPS(ps PS) {
+	if old, new := cpu.PS.Mode(), ps.Mode(); old != new {
+		cpu.Stack[old] = cpu.R[SP]
+		cpu.R[SP] = cpu.Stack[new]
+	}
+	cpu.PS = ps
+}
+
+// modeSP returns the stack pointer for the given mode.
+func (cpu *CPU) modeSP(m Mode) uint16 {
+	if m == cpu.PS.Mode() {
+		return cpu.R[SP]
+	}
+	return cpu.Stack[m]
+}
+
+// setModeSP sets the stack pointer for the given mode.
+func (cpu *CPU) setModeSP(m Mode, sp uint16) {
+	if m == cpu.PS.Mode() {
+		cpu.R[SP] = sp
+	} else {
+		cpu.Stack[m] = sp
+	}
+}
 
 // ReadB reads and returns the byte at addr.
 func (cpu *CPU) ReadB(addr uint16) (uint8, error) {
-	if addr == psAddr {
+	if addr == psAddr && cpu.MMU == nil {
 		return uint8(cpu.PS), nil
 	}
-	return cpu.Mem.ReadB(addr)
+	return cpu.readMemB(addr, false, cpu.PS.Mode())
 }
 
 // ReadW reads and returns the word at addr.
 func (cpu *CPU) ReadW(addr uint16) (uint16, error) {
 	// PS is at special address 0o177776.
-	if addr == psAddr {
+	if addr == psAddr && cpu.MMU == nil {
+		return uint16(cpu.PS), nil
+	}
+	return cpu.readMemW(addr, false, cpu.PS.Mode())
+}
+
+// ReadIW reads and returns the word at addr in instruction space.
+// If the CPU has no separate instruction space, ReadIW is the same as ReadW.
+func (cpu *CPU) ReadIW(addr uint16) (uint16, error) {
+	if addr == psAddr && cpu.MMU == nil {
 		return uint16(cpu.PS), nil
 	}
-	return cpu.Mem.ReadW(addr)
+	return cpu.readMemW(addr, true, cpu.PS.Mode())
 }
 
 // WriteB writes the byte val to addr.
 func (cpu *CPU) WriteB(addr uint16, val uint8) error {
 	// PS is at special address 0o177776.
-	if addr == psAddr {
+	if addr == psAddr && cpu.MMU == nil {
 		cpu.PS = PS(val)
 		return nil
 	}
-	return cpu.Mem.WriteB(addr, val)
+	return cpu.writeMemB(addr, false, val, cpu.PS.Mode())
 }
 
 // WriteW writes the word val to addr.
 func (cpu *CPU) WriteW(addr uint16, val uint16) error {
 	// PS is at special address 0o177776.
-	if addr == psAddr {
+	if addr == psAddr && cpu.MMU == nil {
 		cpu.PS = PS(val)
 		return nil
 	}
-	return cpu.Mem.WriteW(addr, val)
+	return cpu.writeMemW(addr, false, val, cpu.PS.Mode())
+}
+
+// flatMem returns the memory for an unmapped access
+// to instruction space (if ispace is true) or data space.
+func (cpu *CPU) flatMem(ispace bool) Memory {
+	if ispace && cpu.IMem != nil {
+		return cpu.IMem
+	}
+	return cpu.Mem
+}
+
+// readMemB reads the byte at virtual address va in the given mode.
+// If ispace is true, va is an instruction-space address.
+func (cpu *CPU) readMemB(va uint16, ispace bool, mode Mode) (uint8, error) {
+	if cpu.MMU == nil {
+		if va >= ioPage && cpu.Bus != nil {
+			return cpu.ioReadB(va)
+		}
+		if cpu.MemMap != nil && !cpu.mapOK(va, 1, ispace, false) {
+			return 0, ErrMem
+		}
+		return cpu.flatMem(ispace).ReadB(va)
+	}
+	pa, err := cpu.MMU.translate(va, mode, ispace, false)
+	if err != nil {
+		return 0, err
+	}
+	if pa >= cpu.MMU.ioBase() {
+		return cpu.ioReadB(uint16(pa) | ioPage)
+	}
+	return cpu.MMU.Mem.ReadB(pa)
+}
+
+// readMemW reads the word at virtual address va in the given mode.
+// If ispace is true, va is an instruction-space address.
+// A word access at an odd address is a bus error,
+// checked before any address translation.
+func (cpu *CPU) readMemW(va uint16, ispace bool, mode Mode) (uint16, error) {
+	if va&1 != 0 {
+		return 0, ErrMem
+	}
+	if cpu.MMU == nil {
+		if va >= ioPage && cpu.Bus != nil {
+			return cpu.ioReadW(va)
+		}
+		if cpu.MemMap != nil && !cpu.mapOK(va, 2, ispace, false) {
+			return 0, ErrMem
+		}
+		return cpu.flatMem(ispace).ReadW(va)
+	}
+	pa, err := cpu.MMU.translate(va, mode, ispace, false)
+	if err != nil {
+		return 0, err
+	}
+	if pa >= cpu.MMU.ioBase() {
+		return cpu.ioReadW(uint16(pa) | ioPage)
+	}
+	return cpu.MMU.Mem.ReadW(pa)
+}
+
+// writeMemB writes the byte val to virtual address va in the given mode.
+// If ispace is true, va is an instruction-space address.
+func (cpu *CPU) writeMemB(va uint16, ispace bool, val uint8, mode Mode) error {
+	if cpu.MMU == nil {
+		if va >= ioPage && cpu.Bus != nil {
+			return cpu.ioWriteB(va, val)
+		}
+		if cpu.MemMap != nil && !cpu.mapOK(va, 1, ispace, true) {
+			return ErrMem
+		}
+		if cpu.hist != nil {
+			if ispace && cpu.IMem != nil {
+				return cpu.hist.writeB(cpu.IMem, chIMemB, va, val)
+			}
+			return cpu.hist.writeB(cpu.Mem, chMemB, va, val)
+		}
+		return cpu.flatMem(ispace).WriteB(va, val)
+	}
+	pa, err := cpu.MMU.translate(va, mode, ispace, true)
+	if err != nil {
+		return err
+	}
+	if pa >= cpu.MMU.ioBase() {
+		return cpu.ioWriteB(uint16(pa)|ioPage, val)
+	}
+	if cpu.hist != nil {
+		return cpu.hist.physWriteB(cpu.MMU.Mem, pa, val)
+	}
+	return cpu.MMU.Mem.WriteB(pa, val)
+}
+
+// writeMemW writes the word val to virtual address va in the given mode.
+// If ispace is true, va is an instruction-space address.
+// Like readMemW, it rejects an odd address before translation.
+func (cpu *CPU) writeMemW(va uint16, ispace bool, val uint16, mode Mode) error {
+	if va&1 != 0 {
+		return ErrMem
+	}
+	if cpu.MMU == nil {
+		if va >= ioPage && cpu.Bus != nil {
+			return cpu.ioWriteW(va, val)
+		}
+		if cpu.MemMap != nil && !cpu.mapOK(va, 2, ispace, true) {
+			return ErrMem
+		}
+		if cpu.hist != nil {
+			if ispace && cpu.IMem != nil {
+				return cpu.hist.writeW(cpu.IMem, chIMem, va, val)
+			}
+			return cpu.hist.writeW(cpu.Mem, chMem, va, val)
+		}
+		return cpu.flatMem(ispace).WriteW(va, val)
+	}
+	pa, err := cpu.MMU.translate(va, mode, ispace, true)
+	if err != nil {
+		return err
+	}
+	if pa >= cpu.MMU.ioBase() {
+		return cpu.ioWriteW(uint16(pa)|ioPage, val)
+	}
+	if cpu.hist != nil {
+		return cpu.hist.physWriteW(cpu.MMU.Mem, pa, val)
+	}
+	return cpu.MMU.Mem.WriteW(pa, val)
+}
+
+// ioReadW reads the I/O page register at address a.
+// The PS and the MMU registers are part of the CPU;
+// all other registers belong to devices on the Unibus.
+func (cpu *CPU) ioReadW(a uint16) (uint16, error) {
+	if a&1 != 0 {
+		return 0, ErrMem
+	}
+	if a == psAddr {
+		return uint16(cpu.PS), nil
+	}
+	if cpu.MMU != nil && cpu.mmuReg(a) {
+		if v, ok := cpu.MMU.readReg(a); ok {
+			return v, nil
+		}
+	}
+	if cpu.Bus != nil {
+		return cpu.Bus.readW(a)
+	}
+	return 0, ErrMem
+}
+
+// ioReadB reads the byte at I/O page address a.
+// The Unibus has no byte reads: a device sees a word read.
+func (cpu *CPU) ioReadB(a uint16) (uint8, error) {
+	w, err := cpu.ioReadW(a &^ 1)
+	return uint8(w >> (8 * (a & 1))), err
+}
+
+// ioWriteW writes val to the I/O page register at address a.
+func (cpu *CPU) ioWriteW(a, val uint16) error {
+	if a&1 != 0 {
+		return ErrMem
+	}
+	if a == psAddr {
+		// An explicit write to the PS takes precedence over
+		// the condition codes set by the instruction doing the write.
+		cpu.SetPS(PS(val) &^ (PS_T | 0o3400))
+		cpu.psWritten = true
+		cpu.psValue = cpu.PS
+		return nil
+	}
+	if cpu.MMU != nil && cpu.mmuReg(a) {
+		if a == sr3Addr && !cpu.has(Opt22) {
+			val &^= SR3_22
+		}
+		if cpu.MMU.writeReg(a, val) {
+			return nil
+		}
+	}
+	if cpu.Bus != nil {
+		return cpu.Bus.writeW(a, val)
+	}
+	return ErrMem
+}
+
+// ioWriteB writes the byte val to I/O page address a.
+// CPU registers are updated by rewriting the containing word;
+// devices see a byte write.
+func (cpu *CPU) ioWriteB(a uint16, val uint8) error {
+	w := a &^ 1
+	if w == psAddr || cpu.MMU != nil && cpu.mmuReg(w) && cpu.MMU.isReg(w) {
+		old, err := cpu.ioReadW(w)
+		if err != nil {
+			return err
+		}
+		if a&1 == 0 {
+			old = old&0xff00 | uint16(val)
+		} else {
+			old = old&0x00ff | uint16(val)<<8
+		}
+		return cpu.ioWriteW(w, old)
+	}
+	if cpu.Bus != nil {
+		return cpu.Bus.writeB(a, val)
+	}
+	return ErrMem
 }
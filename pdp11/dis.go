@@ -9,129 +9,216 @@ import (
 	"strings"
 )
 
-func (cpu *CPU) Disasm(pc uint16) (asm string, next uint16, err error) {
-	code, err := cpu.ReadW(pc)
+// An Inst is a decoded instruction.
+type Inst struct {
+	Op   string // mnemonic, such as "mov"
+	Args []Arg  // operands, in assembly order
+	Len  uint16 // length in bytes, including index and immediate words
+}
+
+// An Arg is a decoded instruction operand.
+type Arg struct {
+	Kind   ArgKind
+	Mode   uint8  // addressing mode 0..7 (ArgGen)
+	Reg    RegNum // register (ArgGen, ArgReg) or floating accumulator (ArgAC)
+	Float  bool   // mode 0 names a floating accumulator, not a register (ArgGen)
+	Word   uint16 // index or immediate word (ArgGen), or number (ArgNum)
+	Target uint16 // branch target (ArgBranch), or address of a PC-relative or absolute operand (ArgGen)
+}
+
+// An ArgKind is the kind of an instruction operand.
+type ArgKind uint8
+
+const (
+	ArgGen    ArgKind = iota // general operand: mode and register
+	ArgReg                   // register, as in jsr and sob
+	ArgAC                    // floating accumulator f0..f3
+	ArgBranch                // branch or sob target
+	ArgNum                   // emt or trap number, or spl level
+)
+
+// HasWord reports whether the operand has an index or immediate word
+// following the instruction.
+func (a *Arg) HasWord() bool {
+	return a.Kind == ArgGen && (a.Mode >= 6 || a.Reg == PC && (a.Mode == 2 || a.Mode == 3))
+}
+
+// An instForm is the parsed assembly template of an itab entry.
+type instForm struct {
+	op   string
+	args []string // argument templates: "%d", "%s", "%b", and so on
+}
+
+// forms holds the parsed templates of itab, in the same order.
+var forms []instForm
+
+func init() {
+	forms = make([]instForm, len(itab))
+	for i := range itab {
+		op, args := parseAsm(itab[i].text)
+		forms[i] = instForm{op, args}
+	}
+}
+
+// Decode decodes the instruction at pc, using read to read
+// the instruction and its immediate and index words.
+func Decode(pc uint16, read func(uint16) (uint16, error)) (Inst, error) {
+	code, err := read(pc)
 	if err != nil {
-		return "", pc, err
+		return Inst{}, err
 	}
-	inst := lookup(code)
-	if inst.text == "" {
-		return "", pc, fmt.Errorf("unknown instruction %06o", code)
+	i := xtab[code]
+	if itab[i].text == "" {
+		return Inst{}, fmt.Errorf("unknown instruction %06o", code)
 	}
-	next = pc + 2
-	op, args := parseAsm(inst.text)
-	var out []byte
-	out = append(out, op...)
-	for i, arg := range args {
-		if i > 0 {
-			out = append(out, ',')
-		}
-		out = append(out, ' ')
-
-		switch arg {
-		default:
-			asm += arg
+	f := &forms[i]
+	next := pc + 2
+	inst := Inst{Op: f.op}
+	if len(f.args) > 0 {
+		inst.Args = make([]Arg, 0, len(f.args))
+	}
+	for _, form := range f.args {
+		var a Arg
+		switch form {
 		case "%b": // branch offset
-			out = fmt.Appendf(out, "%o", next+2*uint16(int8(code)))
+			a = Arg{Kind: ArgBranch, Target: pc + 2 + 2*uint16(int8(code))}
 		case "%B": // sob offset
-			out = fmt.Appendf(out, "%o", next-2*(code&077))
+			a = Arg{Kind: ArgBranch, Target: pc + 2 - 2*(code&077)}
 		case "%n": // emt/trap number
-			out = fmt.Appendf(out, "%o", code&0377)
+			a = Arg{Kind: ArgNum, Word: code & 0377}
 		case "%N": // spl level
-			out = fmt.Appendf(out, "%o", code&07)
+			a = Arg{Kind: ArgNum, Word: code & 07}
+		case "%M": // mark count
+			a = Arg{Kind: ArgNum, Word: code & 077}
 		case "%r": // register number at bit 6
-			out = fmt.Appendf(out, "%s", RegNum((code>>6)&07))
+			a = Arg{Kind: ArgReg, Reg: RegNum((code >> 6) & 07)}
 		case "%R": // register number at bit 0
-			out = fmt.Appendf(out, "%s", RegNum((code & 07)))
-		case "%d", "%s": // dst, src
+			a = Arg{Kind: ArgReg, Reg: RegNum(code & 07)}
+		case "%a": // fp accumulator
+			a = Arg{Kind: ArgAC, Reg: RegNum((code >> 6) & 03)}
+		case "%d", "%s", "%f": // dst, src, fsrc/fdst
 			w := code
-			if arg == "%s" {
+			if form == "%s" {
 				w >>= 6
 			}
-			var err error
-			if out, next, err = fmtArg(out, cpu, w, next); err != nil {
-				return "", pc, err
+			a = Arg{Kind: ArgGen, Mode: uint8(w>>3) & 07, Reg: RegNum(w & 07), Float: form == "%f"}
+			if a.Float && a.Mode == 0 && a.Reg >= 6 {
+				return Inst{}, fmt.Errorf("unknown instruction %06o", code)
 			}
-		case "%a": // fp accumulator
-			out = fmt.Appendf(out, "f%d", (code>>6)&0o3)
-		case "%f": // fsrc/fdst
-			if code&0o70 == 0 {
-				if code&0o07 >= 6 {
-					return "", pc, fmt.Errorf("unknown instruction %06o", code)
+			if a.HasWord() {
+				if a.Word, err = read(next); err != nil {
+					return Inst{}, err
 				}
-				out = fmt.Appendf(out, "f%d", code&0o07)
-			} else {
-				if out, next, err = fmtArg(out, cpu, code, next); err != nil {
-					return "", pc, err
+				next += 2
+				switch {
+				case a.Reg == PC && a.Mode >= 6:
+					a.Target = next + a.Word
+				case a.Reg == PC && a.Mode == 3:
+					a.Target = a.Word
 				}
 			}
 		}
+		inst.Args = append(inst.Args, a)
 	}
-	return string(out), next, nil
+	inst.Len = next - pc
+	return inst, nil
 }
 
-func parseAsm(text string) (op string, args []string) {
-	op, argstr := strings.TrimSpace(text), ""
-	if i := strings.IndexAny(text, " \t"); i >= 0 {
-		op, argstr = text[:i], strings.TrimSpace(text[i:])
-	}
-	args = strings.Split(argstr, ",")
-	for i, arg := range args {
-		args[i] = strings.TrimSpace(arg)
-	}
-	for len(args) > 0 && args[len(args)-1] == "" {
-		args = args[:len(args)-1]
+// Disasm disassembles the instruction at pc, in DEC syntax,
+// returning the assembly text and the address of the next instruction.
+func (cpu *CPU) Disasm(pc uint16) (asm string, next uint16, err error) {
+	return DEC.Disasm(pc, cpu.ReadW)
+}
+
+// String returns the assembly text for the instruction, in DEC syntax.
+func (inst Inst) String() string {
+	out := []byte(inst.Op)
+	for i := range inst.Args {
+		if i > 0 {
+			out = append(out, ',')
+		}
+		out = append(out, ' ')
+		out = inst.Args[i].append(out)
 	}
-	return op, args
+	return string(out)
+}
+
+// String returns the assembly text for the operand, in DEC syntax.
+func (a Arg) String() string {
+	return string(a.append(nil))
 }
 
-func fmtArg(out []byte, cpu *CPU, w, next uint16) (out1 []byte, next1 uint16, err error) {
-	r := RegNum(w & 07)
-	mode := (w >> 3) & 07
+func (a *Arg) append(out []byte) []byte {
+	switch a.Kind {
+	case ArgReg:
+		return append(out, a.Reg.String()...)
+	case ArgAC:
+		return fmt.Appendf(out, "f%d", a.Reg)
+	case ArgBranch:
+		return fmt.Appendf(out, "%o", a.Target)
+	case ArgNum:
+		return fmt.Appendf(out, "%o", a.Word)
+	case ArgGen:
+		// handled below
+	default:
+		return append(out, '?')
+	}
+
+	if a.Float && a.Mode == 0 {
+		return fmt.Appendf(out, "f%d", a.Reg)
+	}
 
 	// Conveniences for PC-relative data and immediates.
-	if r == PC {
-		if imm, err := cpu.ReadW(next); err == nil {
-			switch mode {
-			case 2:
-				return fmt.Appendf(out, "#%o", int16(imm)), next + 2, nil
-			case 3:
-				return fmt.Appendf(out, "@#%o", imm), next + 2, nil
-			case 6:
-				return fmt.Appendf(out, "%o", next+2+imm), next + 2, nil
-			case 7:
-				return fmt.Appendf(out, "@%o", next+2+imm), next + 2, nil
-			}
+	if a.Reg == PC {
+		switch a.Mode {
+		case 2:
+			return fmt.Appendf(out, "#%o", int16(a.Word))
+		case 3:
+			return fmt.Appendf(out, "@#%o", a.Word)
+		case 6:
+			return fmt.Appendf(out, "%o", a.Target)
+		case 7:
+			return fmt.Appendf(out, "@%o", a.Target)
 		}
 	}
 
-	reg := r.String()
-	if mode == 0 { // register
-		return append(out, reg...), next, nil
+	reg := a.Reg.String()
+	if a.Mode == 0 { // register
+		return append(out, reg...)
 	}
 	reg = "(" + reg + ")"
-	if mode == 1 { // indirect register
-		return append(out, reg...), next, nil
+	if a.Mode == 1 { // indirect register
+		return append(out, reg...)
 	}
 
 	// General memory access.
 	indir := ""
-	if mode&1 != 0 { // extra indirect
+	if a.Mode&1 != 0 { // extra indirect
 		indir = "@"
 	}
 
-	switch mode &^ 1 {
+	switch a.Mode &^ 1 {
 	case 2: // post-increment
-		return fmt.Appendf(out, "%s%s+", indir, reg), next, nil
+		return fmt.Appendf(out, "%s%s+", indir, reg)
 	case 4: // pre-increment
-		return fmt.Appendf(out, "%s-%s", indir, reg), next, nil
+		return fmt.Appendf(out, "%s-%s", indir, reg)
 	case 6: // indexed
-		imm, err := cpu.ReadW(next)
-		if err != nil {
-			return nil, next, err
-		}
-		next += 2
-		return fmt.Appendf(out, "%s%o%s", indir, int16(imm), reg), next, nil
+		return fmt.Appendf(out, "%s%o%s", indir, int16(a.Word), reg)
+	}
+	return append(out, '?')
+}
+
+func parseAsm(text string) (op string, args []string) {
+	op, argstr := strings.TrimSpace(text), ""
+	if i := strings.IndexAny(text, " \t"); i >= 0 {
+		op, argstr = text[:i], strings.TrimSpace(text[i:])
 	}
-	panic("unreachable")
+	args = strings.Split(argstr, ",")
+	for i, arg := range args {
+		args[i] = strings.TrimSpace(arg)
+	}
+	for len(args) > 0 && args[len(args)-1] == "" {
+		args = args[:len(args)-1]
+	}
+	return op, args
 }
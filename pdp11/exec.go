@@ -4,48 +4,334 @@
 
 package pdp11
 
-import (
-	"fmt"
-	"runtime"
-)
+import "fmt"
+
+// Step executes n instructions.
+//
+// In TrapError mode, the default, Step stops at the first trap
+// and returns it as a *Trap error wrapping ErrTrap, ErrEMT, ErrMem,
+// and so on, which callers can test for using errors.Is,
+// leaving the CPU state as it was before the trapping instruction,
+// except that cpu.Inst holds the trapping instruction.
+// The exception is a floating point trap (ErrFPT), which,
+// as on the hardware, happens after the floating point unit
+// has finished or abandoned the instruction, leaving the PC past
+// the instruction (and, for the FP11, FEC and FEA describing the error).
+//
+// In TrapVector mode, Step handles traps the way the hardware does:
+// it pushes the PS and PC onto the stack and loads the new PC and PS
+// from the trap's vector in low (kernel data space) memory.
+// It also takes pending interrupts (see Interrupt) before each instruction.
+// In that mode Step returns an error only for a HALT in kernel mode (ErrHalt)
+// or a trap that could not be taken.
+// If the CPU is waiting for an interrupt after a WAIT instruction,
+// Step returns nil early, with cpu.Waiting set.
+func (cpu *CPU) Step(n int) error {
+	if cpu.TrapMode == TrapError {
+		return cpu.run(&n)
+	}
+	for n > 0 {
+		err := cpu.run(&n)
+		if err == nil {
+			return nil
+		}
+		t, ok := err.(*Trap)
+		if !ok || t.Vector == 0 {
+			return err
+		}
+		if err := cpu.trap(t.Vector); err != nil {
+			return err
+		}
+		n--
+	}
+	return nil
+}
+
+// run executes up to *n instructions, decrementing *n as it goes,
+// and stops at the first error.
+//
+// Instruction handlers do not panic on a fault.
+// Instead they call cpu.fail, which records the fault,
+// and then either return or finish without side effects
+// (see readW and writeW). After the handler returns,
+// run undoes the instruction's register changes and reports the fault.
+// In TrapError mode, the registers are restored to their values before
+// the instruction; in TrapVector mode, to their values at the fault,
+// as on the hardware, so that the MMU can report the partial instruction.
+// Saving the registers before each instruction is cheap:
+// the floating point registers are only saved for floating point instructions.
+func (cpu *CPU) run(n *int) error {
+	vector := cpu.TrapMode == TrapVector
+
+	// Accesses to a flat *ArrayMem can bypass the Memory interface
+	// when nothing needs to watch or translate them.
+	cpu.imem, cpu.dmem = nil, nil
+	if cpu.MMU == nil && cpu.MemMap == nil && cpu.Bus == nil && cpu.debug == nil && cpu.Tracer == nil && cpu.hist == nil {
+		cpu.imem, _ = cpu.flatMem(true).(*ArrayMem)
+		if cpu.imem != nil {
+			cpu.dmem, _ = cpu.Mem.(*ArrayMem)
+		}
+	}
+	if !vector && cpu.dmem != nil && cpu.Model == nil && cpu.Timing == nil {
+		return cpu.runFlat(n)
+	}
 
-func (cpu *CPU) Step(n int) (err error) {
-	var old CPU
-	defer func() {
-		if e := recover(); e != nil {
-			*cpu = old
-			if _, ok := e.(runtime.Error); ok {
-				panic(e)
+	var old regState // registers before the instruction, in TrapError mode
+	for ; *n > 0; *n-- {
+		if vector {
+			if len(cpu.irqs) > 0 {
+				if err := cpu.interrupt(); err != nil {
+					return err
+				}
+			}
+			if cpu.Waiting {
+				return nil
+			}
+		} else {
+			old.R, old.Stack, old.PS = cpu.R, cpu.Stack, cpu.PS
+		}
+		if cpu.debug != nil {
+			if b := cpu.checkBreak(cpu.R[PC]); b != nil {
+				return b
 			}
-			if e1, ok := e.(error); ok {
-				err = e1
+		}
+		pc := cpu.R[PC]
+		cpu.instPC = pc
+		if cpu.Tracer != nil {
+			cpu.traceStart(pc)
+		}
+		if cpu.hist != nil {
+			cpu.hist.begin(cpu, pc)
+		}
+		if pc&1 != 0 {
+			if vector {
+				cpu.memFail(ErrMem, addr(pc)|addrI, AccessExec, true)
 			} else {
-				err = fmt.Errorf("%v", e)
+				cpu.memFail(ErrInst, addr(pc)|addrI, AccessExec, true)
 			}
+			return cpu.rollback(&old)
 		}
-	}()
+		if cpu.MMU != nil {
+			cpu.MMU.startInst(pc)
+		}
+		var w uint16
+		if m := cpu.imem; m != nil {
+			w = uint16(m[pc]) | uint16(m[pc+1])<<8
+		} else {
+			w = cpu.readW(addr(pc) | addrI)
+			if cpu.fault != nil {
+				cpu.trapBuf.Access = AccessExec
+				return cpu.rollback(&old)
+			}
+		}
+		cpu.Inst = w
+		if cpu.trace != nil {
+			cpu.trace.Inst = w
+		}
+		cpu.R[PC] = pc + 2
+		trace := cpu.PS&PS_T != 0
+		p := &ptab[w]
+		if cpu.Model != nil && iopt[p.op]&^cpu.Model.Opts != 0 {
+			cpu.fail(ErrInst)
+			return cpu.rollback(&old)
+		}
+		fp := !vector && w >= 0o170000
+		if fp {
+			old.F, old.FPS, old.FEC, old.FEA = cpu.F, cpu.FPS, cpu.FEC, cpu.FEA
+		}
+		if cpu.trace != nil {
+			p.slow(cpu)
+		} else {
+			p.do(cpu)
+		}
+		if cpu.fault != nil {
+			if cpu.fault != errFPAbort {
+				if cpu.Timing != nil && !vector {
+					// The instruction ran until it trapped, and for TRAP and EMT
+					// the time includes the trap sequence that the caller
+					// emulates. (In TrapVector mode, trap counts the trap.)
+					cpu.Nanoseconds += uint64(cpu.Timing.time(cpu, w, pc))
+				}
+				if fp {
+					cpu.F, cpu.FPS, cpu.FEC, cpu.FEA = old.F, old.FPS, old.FEC, old.FEA
+				}
+				return cpu.rollback(&old)
+			}
+			// The FP11 abandoned the instruction,
+			// leaving the registers as they were at the abort.
+			cpu.fault = nil
+			cpu.setRegState(&cpu.faultRegs)
+			cpu.psWritten = false
+		}
+		if cpu.psWritten {
+			cpu.psWritten = false
+			cpu.PS = cpu.psValue
+		}
+		if cpu.Timing != nil {
+			cpu.Nanoseconds += uint64(cpu.Timing.time(cpu, w, pc))
+		}
+		if err := cpu.fpTrapped(); err != nil {
+			cpu.trapBuf = Trap{Err: err}
+			if cpu.trace != nil {
+				cpu.traceEnd(cpu.trace, err)
+			}
+			return cpu.trapError()
+		}
+		if cpu.trace != nil {
+			cpu.traceEnd(cpu.trace, nil)
+		}
+		// The T bit traps after each instruction that starts with it set,
+		// and immediately after an RTI that sets it (but not an RTT).
+		if vector && (trace || w == 0o000002 && cpu.PS&PS_T != 0) {
+			if err := cpu.trap(vecBPT); err != nil {
+				return err
+			}
+		}
+		if cpu.debug != nil && cpu.debug.hit != nil {
+			return cpu.watchHit()
+		}
+	}
+	return nil
+}
 
-	for ; n > 0; n-- {
-		old = *cpu
+// runFlat is run for the common case of a CPU in TrapError mode
+// with flat memory and no model, timing, tracer, breakpoints, or recording.
+// It executes instructions the same way but skips the checks
+// that only those features need.
+func (cpu *CPU) runFlat(n *int) error {
+	m := cpu.imem
+	var old regState
+	for ; *n > 0; *n-- {
+		old.R, old.Stack, old.PS = cpu.R, cpu.Stack, cpu.PS
 		pc := cpu.R[PC]
+		cpu.instPC = pc
 		if pc&1 != 0 {
-			panic(ErrInst)
-		}
-		w, err := cpu.ReadW(pc)
-		if err != nil {
-			panic(err)
+			cpu.memFail(ErrInst, addr(pc)|addrI, AccessExec, true)
+			return cpu.rollback(&old)
 		}
+		w := uint16(m[pc]) | uint16(m[pc+1])<<8
 		cpu.Inst = w
-		old.Inst = w
 		cpu.R[PC] = pc + 2
-		lookup(w).do(cpu)
+		fp := w >= 0o170000
+		if fp {
+			old.F, old.FPS, old.FEC, old.FEA = cpu.F, cpu.FPS, cpu.FEC, cpu.FEA
+		}
+		ptab[w].do(cpu)
+		if cpu.fault != nil {
+			if cpu.fault != errFPAbort {
+				if fp {
+					cpu.F, cpu.FPS, cpu.FEC, cpu.FEA = old.F, old.FPS, old.FEC, old.FEA
+				}
+				return cpu.rollback(&old)
+			}
+			cpu.fault = nil
+			cpu.setRegState(&cpu.faultRegs)
+			cpu.psWritten = false
+		}
+		if cpu.psWritten {
+			cpu.psWritten = false
+			cpu.PS = cpu.psValue
+		}
+		if err := cpu.fpTrapped(); err != nil {
+			cpu.trapBuf = Trap{Err: err}
+			return cpu.trapError()
+		}
 	}
 	return nil
 }
 
+// fail records a fault in the current instruction,
+// which abandons the instruction.
+// Only the first fault counts: after it, memory accesses
+// do nothing, so the handler can run to completion harmlessly,
+// although handlers return early where that is simpler.
+func (cpu *CPU) fail(err error) {
+	if cpu.fault == nil {
+		cpu.fault = err
+		cpu.faultRegs = cpu.regState()
+		cpu.trapBuf = Trap{Err: err}
+	}
+}
+
+// memFail records a fault accessing memory at a.
+// The word flag reports whether the access was a word access.
+func (cpu *CPU) memFail(err error, a addr, access Access, word bool) {
+	if cpu.fault == nil {
+		cpu.fail(err)
+		t := &cpu.trapBuf
+		t.Addr = uint16(a)
+		t.Access = access
+		t.Odd = word && a&1 != 0
+	}
+}
+
+// rollback undoes the register changes made by the instruction
+// that faulted and returns the fault.
+// In TrapError mode, old holds the registers from before the instruction.
+func (cpu *CPU) rollback(old *regState) error {
+	if cpu.TrapMode == TrapVector {
+		cpu.setRegState(&cpu.faultRegs)
+	} else {
+		cpu.R, cpu.Stack, cpu.PS = old.R, old.Stack, old.PS
+		cpu.Inst = cpu.faultRegs.Inst
+	}
+	err := cpu.fault
+	cpu.fault = nil
+	cpu.psWritten = false
+	cpu.fpTrap = false
+	if cpu.trace != nil {
+		cpu.traceEnd(cpu.trace, err)
+	}
+	return cpu.trapError()
+}
+
+// trapError returns a new Trap describing the trap ending
+// the current instruction, completing the details in cpu.trapBuf.
+func (cpu *CPU) trapError() *Trap {
+	t := new(Trap)
+	*t = cpu.trapBuf
+	t.Vector, _ = trapVector(t.Err)
+	t.PC = cpu.instPC
+	t.Inst = 0
+	if t.Access != AccessExec {
+		t.Inst = cpu.Inst
+	}
+	return t
+}
+
+// A regState is a saved copy of the CPU registers.
+type regState struct {
+	R     [8]uint16
+	Stack [4]uint16
+	F     [6]Float
+	PS    PS
+	FPS   FPS
+	FEC   uint8
+	FEA   uint16
+	Inst  uint16
+}
+
+func (cpu *CPU) regState() regState {
+	return regState{cpu.R, cpu.Stack, cpu.F, cpu.PS, cpu.FPS, cpu.FEC, cpu.FEA, cpu.Inst}
+}
+
+func (cpu *CPU) setRegState(s *regState) {
+	cpu.R, cpu.Stack, cpu.F, cpu.PS = s.R, s.Stack, s.F, s.PS
+	cpu.FPS, cpu.FEC, cpu.FEA, cpu.Inst = s.FPS, s.FEC, s.FEA, s.Inst
+}
+
+// An addr is an operand address: a register or a 16-bit virtual address.
 type addr uint32
 
-const addrReg addr = 1 << 16
+const (
+	addrReg addr = 1 << 16 // register number, not memory address
+	addrI   addr = 1 << 17 // instruction-space memory address
+)
+
+// add returns the address n bytes past a, in the same address space.
+func (a addr) add(n uint16) addr {
+	return a&^0xffff | addr(uint16(a)+n)
+}
 
 func (a addr) String() string {
 	if a&addrReg != 0 {
@@ -58,42 +344,76 @@ func (cpu *CPU) addr(enc, size uint16) addr {
 	reg := RegNum(enc & 07)
 	mode := (enc >> 3) & 07
 	if mode == 0 {
+		if cpu.trace != nil {
+			cpu.traceOperand(enc, addrReg|addr(reg))
+		}
 		return addrReg | addr(reg)
 	}
 	if mode&1 != 0 || reg == PC || reg == SP && size == 1 {
 		size = 2
 	}
 	a := cpu.R[reg]
+	space := addr(0)
 	switch mode &^ 1 {
 	case 2:
 		// post-increment
 		cpu.R[reg] = a + size
+		if cpu.MMU != nil && cpu.fault == nil {
+			cpu.MMU.noteReg(reg, size)
+		}
+		if reg == PC {
+			// immediate or absolute word is in the instruction stream
+			space = addrI
+		}
 	case 4:
 		// pre-decrement
 		a -= size
 		// fmt.Fprintf(os.Stderr, "WB %d %o\n", reg, a)
 		cpu.R[reg] = a
+		if cpu.MMU != nil && cpu.fault == nil {
+			cpu.MMU.noteReg(reg, -size)
+		}
 	case 6:
 		// index offset from PC
 		pc := cpu.R[PC]
-		imm := cpu.readW(addr(pc))
+		imm := cpu.readW(addr(pc) | addrI)
 		cpu.R[PC] = pc + 2
 		a = cpu.R[reg] + imm // reload reg in case reg is PC
 	}
 	if mode != 1 && mode&1 == 1 {
 		// extra dereference
-		a = cpu.readW(addr(a))
+		a = cpu.readW(addr(a) | space)
+		space = 0
+	}
+	if cpu.trace != nil {
+		cpu.traceOperand(enc, addr(a)|space)
 	}
-	return addr(a)
+	return addr(a) | space
 }
 
 func (cpu *CPU) readW(a addr) uint16 {
 	if a&addrReg != 0 {
 		return cpu.R[a&07]
 	}
-	val, err := cpu.Mem.ReadW(uint16(a))
+	if m := cpu.dmem; m != nil && a&1 == 0 {
+		if a&addrI != 0 {
+			m = cpu.imem
+		}
+		return uint16(m[uint16(a)]) | uint16(m[uint16(a)+1])<<8
+	}
+	if cpu.fault != nil {
+		return 0
+	}
+	val, err := cpu.readMemW(uint16(a), a&addrI != 0, cpu.PS.Mode())
 	if err != nil {
-		panic(err)
+		cpu.memFail(err, a, AccessRead, true)
+		return 0
+	}
+	if cpu.debug != nil {
+		cpu.checkWatch(a, 2, AccessRead)
+	}
+	if cpu.trace != nil {
+		cpu.traceRef(a, uint16(val), AccessRead, false)
 	}
 	// fmt.Fprintf(os.Stderr, "read *%06o = %06o\n", uint16(a), val)
 	return val
@@ -103,9 +423,25 @@ func (cpu *CPU) readB(a addr) uint8 {
 	if a&addrReg != 0 {
 		return uint8(cpu.R[a&07])
 	}
-	val, err := cpu.Mem.ReadB(uint16(a))
+	if m := cpu.dmem; m != nil {
+		if a&addrI != 0 {
+			m = cpu.imem
+		}
+		return m[uint16(a)]
+	}
+	if cpu.fault != nil {
+		return 0
+	}
+	val, err := cpu.readMemB(uint16(a), a&addrI != 0, cpu.PS.Mode())
 	if err != nil {
-		panic(err)
+		cpu.memFail(err, a, AccessRead, false)
+		return 0
+	}
+	if cpu.debug != nil {
+		cpu.checkWatch(a, 1, AccessRead)
+	}
+	if cpu.trace != nil {
+		cpu.traceRef(a, uint16(val), AccessRead, true)
 	}
 	// fmt.Fprintf(os.Stderr, "read *%06o = %03o\n", uint16(a), val)
 	return val
@@ -116,8 +452,26 @@ func (cpu *CPU) writeW(a addr, val uint16) {
 		cpu.R[a&07] = val
 		return
 	}
-	if err := cpu.Mem.WriteW(uint16(a), val); err != nil {
-		panic(err)
+	if cpu.fault != nil {
+		return
+	}
+	if m := cpu.dmem; m != nil && a&1 == 0 {
+		if a&addrI != 0 {
+			m = cpu.imem
+		}
+		m[uint16(a)] = uint8(val)
+		m[uint16(a)+1] = uint8(val >> 8)
+		return
+	}
+	if err := cpu.writeMemW(uint16(a), a&addrI != 0, val, cpu.PS.Mode()); err != nil {
+		cpu.memFail(err, a, AccessWrite, true)
+		return
+	}
+	if cpu.debug != nil {
+		cpu.checkWatch(a, 2, AccessWrite)
+	}
+	if cpu.trace != nil {
+		cpu.traceRef(a, uint16(val), AccessWrite, false)
 	}
 	// fmt.Fprintf(os.Stderr, "write *%06o = %06o\n", uint16(a), val)
 }
@@ -127,8 +481,25 @@ func (cpu *CPU) writeB(a addr, val uint8) {
 		cpu.R[a&07] = cpu.R[a&07]&0o177400 | uint16(val)
 		return
 	}
-	if err := cpu.Mem.WriteB(uint16(a), val); err != nil {
-		panic(err)
+	if cpu.fault != nil {
+		return
+	}
+	if m := cpu.dmem; m != nil {
+		if a&addrI != 0 {
+			m = cpu.imem
+		}
+		m[uint16(a)] = val
+		return
+	}
+	if err := cpu.writeMemB(uint16(a), a&addrI != 0, val, cpu.PS.Mode()); err != nil {
+		cpu.memFail(err, a, AccessWrite, false)
+		return
+	}
+	if cpu.debug != nil {
+		cpu.checkWatch(a, 1, AccessWrite)
+	}
+	if cpu.trace != nil {
+		cpu.traceRef(a, uint16(val), AccessWrite, true)
 	}
 	// fmt.Fprintf(os.Stderr, "write *%06o = %03o\n", uint16(a), val)
 }
@@ -158,20 +529,20 @@ func (cpu *CPU) regArg() RegNum { return RegNum((cpu.Inst >> 6) & 07) }
 func xclr(cpu *CPU) {
 	dp := cpu.dstAddrW()
 	cpu.writeW(dp, 0)
-	cpu.PS = PS_Z
+	cpu.PS = cpu.PS&^0o17 | PS_Z
 }
 
 func xclrb(cpu *CPU) {
 	dp := cpu.dstAddrB()
 	cpu.writeB(dp, 0)
-	cpu.PS = PS_Z
+	cpu.PS = cpu.PS&^0o17 | PS_Z
 }
 
 func xcom(cpu *CPU) {
 	dp := cpu.dstAddrW()
 	dst := ^cpu.readW(dp)
 	cpu.writeW(dp, dst)
-	cpu.PS = PS_C
+	cpu.PS = cpu.PS&^0o17 | PS_C
 	cpu.PS.setNZ(dst)
 }
 
@@ -179,7 +550,7 @@ func xcomb(cpu *CPU) {
 	dp := cpu.dstAddrB()
 	dst := ^cpu.readB(dp)
 	cpu.writeB(dp, dst)
-	cpu.PS = PS_C
+	cpu.PS = cpu.PS&^0o17 | PS_C
 	cpu.PS.setNZB(dst)
 }
 
@@ -421,9 +792,14 @@ func xcmp(cpu *CPU) {
 	dst := cpu.dstW()
 	out := uint32(src) - uint32(dst)
 	// fmt.Fprintf(os.Stderr, "cmp %06o %06o %07o %06o\n", src, dst, out, uint16(out))
+	cpu.subFlags(src, dst, out)
+}
+
+// subFlags sets the condition codes for out = a - b.
+func (cpu *CPU) subFlags(a, b uint16, out uint32) {
 	cpu.PS.setNZ(uint16(out))
 	cpu.PS.SetC(out>>16 != 0)
-	cpu.PS.SetV(src>>15 != dst>>15 && dst>>15 == uint16(out)>>15)
+	cpu.PS.SetV(a>>15 != b>>15 && b>>15 == uint16(out)>>15)
 }
 
 func xcmpb(cpu *CPU) {
@@ -442,9 +818,7 @@ func xsub(cpu *CPU) {
 	dst := cpu.readW(dp)
 	out := uint32(dst) - uint32(src)
 	cpu.writeW(dp, uint16(out))
-	cpu.PS.setNZ(uint16(out))
-	cpu.PS.SetC(out>>16 != 0)
-	cpu.PS.SetV(src>>15 != dst>>15 && src>>15 == uint16(out)>>15)
+	cpu.subFlags(dst, src, out)
 }
 
 func xadd(cpu *CPU) {
@@ -453,6 +827,11 @@ func xadd(cpu *CPU) {
 	dst := cpu.readW(dp)
 	out := uint32(src) + uint32(dst)
 	cpu.writeW(dp, uint16(out))
+	cpu.addFlags(src, dst, out)
+}
+
+// addFlags sets the condition codes for out = src + dst.
+func (cpu *CPU) addFlags(src, dst uint16, out uint32) {
 	cpu.PS.setNZ(uint16(out))
 	cpu.PS.SetC(out>>16 != 0)
 	cpu.PS.SetV(src>>15 == dst>>15 && src>>15 != uint16(out)>>15)
@@ -529,7 +908,8 @@ func xmul(cpu *CPU) {
 func xdiv(cpu *CPU) {
 	r := cpu.regArg()
 	if r&1 != 0 {
-		panic(ErrInst) // divide with odd register
+		cpu.fail(ErrInst) // divide with odd register
+		return
 	}
 	top := int32(cpu.R[r])<<16 | int32(cpu.R[r+1])
 	src := cpu.dstW() // dst because low bits
@@ -629,7 +1009,8 @@ func xblos(cpu *CPU) { cpu.br(cpu.PS.C()|cpu.PS.Z() == 1) }
 func xjmp(cpu *CPU) {
 	dp := cpu.dstAddrW()
 	if dp&addrReg != 0 {
-		panic(ErrInst)
+		cpu.illegal()
+		return
 	}
 	cpu.R[PC] = uint16(dp)
 }
@@ -638,7 +1019,8 @@ func xjsr(cpu *CPU) {
 	r := cpu.regArg()
 	dp := cpu.dstAddrW()
 	if dp&addrReg != 0 {
-		panic(ErrInst)
+		cpu.illegal()
+		return
 	}
 	sp := cpu.R[SP] - 2
 	cpu.R[SP] = sp
@@ -665,14 +1047,14 @@ func xsob(cpu *CPU) {
 // special
 
 func xtrap(cpu *CPU) {
-	panic(ErrTrap)
+	cpu.fail(ErrTrap)
 }
 
 func xbad(cpu *CPU) {
-	panic(ErrInst)
+	cpu.fail(ErrInst)
 }
 
-func xbpt(cpu *CPU) { panic(ErrBPT) }
+func xbpt(cpu *CPU) { cpu.fail(ErrBPT) }
 
 func xccc(cpu *CPU) {
 	cpu.PS &^= PS(cpu.Inst & 0o17)
@@ -682,17 +1064,136 @@ func xscc(cpu *CPU) {
 	cpu.PS |= PS(cpu.Inst & 0o17)
 }
 
-func xemt(cpu *CPU)  { panic(ErrEMT) }
-func xhalt(cpu *CPU) { panic(ErrInst) }
+func xemt(cpu *CPU) { cpu.fail(ErrEMT) }
+func xhalt(cpu *CPU) {
+	if cpu.TrapMode == TrapError {
+		cpu.fail(ErrInst)
+		return
+	}
+	if cpu.PS.Mode() != Kernel {
+		cpu.illegal()
+		return
+	}
+	cpu.fail(ErrHalt)
+}
+
+func xiot(cpu *CPU) { cpu.fail(ErrIOT) }
+
+func xmark(cpu *CPU) { cpu.fail(ErrInst) }
+
+// memory management
+
+func xmfpi(cpu *CPU) { cpu.mfp(addrI) }
+func xmfpd(cpu *CPU) { cpu.mfp(0) }
+func xmtpi(cpu *CPU) { cpu.mtp(addrI) }
+func xmtpd(cpu *CPU) { cpu.mtp(0) }
+
+// mfp implements mfpi and mfpd, which push a word
+// read from the previous mode's I or D space.
+// The operand address is computed in the current mode.
+func (cpu *CPU) mfp(space addr) {
+	prev := cpu.PS.PrevMode()
+	if prev == User && cpu.PS.Mode() == User {
+		space = 0 // user mode cannot read its own I-space this way
+	}
+	dp := cpu.dstAddrW()
+	var val uint16
+	if dp&addrReg != 0 {
+		val = cpu.R[dp&07]
+		if RegNum(dp&07) == SP {
+			val = cpu.modeSP(prev)
+		}
+	} else if cpu.fault == nil {
+		v, err := cpu.readMemW(uint16(dp), space != 0, prev)
+		if err != nil {
+			cpu.memFail(err, dp, AccessRead, true)
+			return
+		}
+		val = v
+	}
+	sp := cpu.R[SP] - 2
+	cpu.writeW(addr(sp), val)
+	cpu.R[SP] = sp
+	cpu.PS.setNZ(val)
+	cpu.PS.SetV(false)
+}
+
+// mtp implements mtpi and mtpd, which pop a word
+// and write it to the previous mode's I or D space.
+// The operand address is computed in the current mode.
+func (cpu *CPU) mtp(space addr) {
+	prev := cpu.PS.PrevMode()
+	sp := cpu.R[SP]
+	val := cpu.readW(addr(sp))
+	cpu.R[SP] = sp + 2
+	dp := cpu.dstAddrW()
+	if dp&addrReg != 0 {
+		if RegNum(dp&07) == SP {
+			cpu.setModeSP(prev, val)
+		} else {
+			cpu.R[dp&07] = val
+		}
+	} else if cpu.fault == nil {
+		if err := cpu.writeMemW(uint16(dp), space != 0, val, prev); err != nil {
+			cpu.memFail(err, dp, AccessWrite, true)
+			return
+		}
+	}
+	cpu.PS.setNZ(val)
+	cpu.PS.SetV(false)
+}
+
+func xreset(cpu *CPU) {
+	if cpu.TrapMode == TrapError {
+		cpu.fail(ErrInst)
+		return
+	}
+	if cpu.PS.Mode() != Kernel {
+		return // no-op outside kernel mode
+	}
+	cpu.irqs = cpu.irqs[:0]
+	if cpu.MMU != nil {
+		cpu.MMU.SR0 = 0
+		cpu.MMU.SR3 = 0
+	}
+	if cpu.Bus != nil {
+		cpu.Bus.reset()
+	}
+}
 
-func xiot(cpu *CPU) { panic(ErrIOT) }
+func xrti(cpu *CPU) { cpu.rti() }
+func xrtt(cpu *CPU) { cpu.rti() }
 
-func xmark(cpu *CPU) { panic(ErrInst) }
-func xmfpi(cpu *CPU) { panic(ErrInst) }
-func xmtpi(cpu *CPU) { panic(ErrInst) }
+// rti pops the PC and PS from the stack.
+// Outside kernel mode, the popped PS cannot lower the
+// current or previous mode or change the priority.
+func (cpu *CPU) rti() {
+	sp := cpu.R[SP]
+	pc := cpu.readW(addr(sp))
+	ps := PS(cpu.readW(addr(sp + 2)))
+	cpu.R[SP] = sp + 4
+	if cpu.MMU != nil {
+		cpu.MMU.noteReg(SP, 4)
+	}
+	if cpu.PS.Mode() != Kernel {
+		ps = ps&^PS_PRI | cpu.PS&(PS_CUR|PS_PREV|PS_PRI)
+	}
+	cpu.R[PC] = pc
+	cpu.SetPS(ps)
+}
 
-func xreset(cpu *CPU) { panic(ErrInst) }
-func xrti(cpu *CPU)   { panic(ErrInst) }
-func xrtt(cpu *CPU)   { panic(ErrInst) }
+func xspl(cpu *CPU) {
+	if cpu.PS.Mode() == Kernel {
+		cpu.PS = cpu.PS&^PS_PRI | PS(cpu.Inst&7)<<5
+	}
+}
 
-func xwait(cpu *CPU) { panic(ErrInst) }
+func xwait(cpu *CPU) {
+	if cpu.TrapMode == TrapError {
+		cpu.fail(ErrInst)
+		return
+	}
+	if cpu.PS.Mode() == Kernel {
+		cpu.Waiting = true
+	}
+}
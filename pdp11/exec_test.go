@@ -7,7 +7,7 @@ package pdp11
 import (
 	"encoding/binary"
 	"fmt"
-	"math"
+	"math/big"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -28,7 +28,7 @@ func TestExec(t *testing.T) {
 		})
 
 		switch name {
-		case "exec_br", "exec_jmp", "exec_jsr", "exec_sob":
+		case "exec_br", "exec_jmp", "exec_jsr", "exec_sob", "exec_fis":
 			// skip
 		default:
 			t.Run(name+"_apout", func(t *testing.T) {
@@ -62,6 +62,8 @@ func testExec(t *testing.T, file string) {
 		clear(cpu.F[:])
 		cpu.PS = 0
 		cpu.FPS = 0
+		cpu.FEC = 0
+		cpu.FEA = 0
 		codes = codes[:0]
 	}
 
@@ -77,13 +79,16 @@ func testExec(t *testing.T, file string) {
 			list = append(list, fmt.Sprintf("nzvc=%04b", int(cpu.PS)))
 		}
 		for i := range cpu.F {
-			if f := cpu.F[i]; math.Float64bits(f) != 0 {
+			if f := cpu.F[i]; f != 0 {
 				list = append(list, fmt.Sprintf("f%d=%v", i, f))
 			}
 		}
 		if cpu.FPS != 0 {
 			list = append(list, fmt.Sprintf("fps=%v", cpu.FPS))
 		}
+		if cpu.FEC != 0 {
+			list = append(list, fmt.Sprintf("fec=%d fea=%06o", cpu.FEC, cpu.FEA))
+		}
 		for i := 0; i < 1<<16; i += 2 {
 			want := uint16(070707)
 			if basePC <= i && i < basePC+2*int(len(codes)) {
@@ -103,9 +108,14 @@ func testExec(t *testing.T, file string) {
 	reset()
 	var broken bool
 	var last string
+	var stepErr error // error from last instruction, checked by "error" line
 	for i := 0; i < len(lines); i++ {
 		line := strings.TrimSpace(lines[i])
 		if line == "" {
+			if stepErr != nil && !broken {
+				t.Errorf("%s:%d: %s: %v", file, i, last, stepErr)
+			}
+			stepErr = nil
 			broken = false
 			last = "reset"
 			reset()
@@ -120,6 +130,28 @@ func testExec(t *testing.T, file string) {
 			continue
 		}
 		f := strings.Fields(line)
+		if f[0] == "error" {
+			// The last instruction trapped, leaving the CPU state unchanged.
+			// Continue after it, as a trap handler would.
+			have := "error"
+			if t, ok := stepErr.(*Trap); ok {
+				have += " " + t.Err.Error()
+			} else if stepErr != nil {
+				have += " " + stepErr.Error()
+			}
+			if have != line {
+				t.Errorf("%s:%d: after %s:\nhave %s\nwant %s", file, i+1, last, have, line)
+				broken = true
+			}
+			stepErr = nil
+			cpu.R[PC] = basePC + 2*uint16(len(codes))
+			continue
+		}
+		if stepErr != nil {
+			t.Errorf("%s:%d: %s: %v", file, i, last, stepErr)
+			broken = true
+			continue
+		}
 		if f[0] == "now" {
 			have := "now " + diff()
 			if have != line {
@@ -135,10 +167,11 @@ func testExec(t *testing.T, file string) {
 					ok = false
 					switch k {
 					case "f0", "f1", "f2", "f3", "f4", "f5":
-						f, err := strconv.ParseFloat(v, 64)
+						x, _, err := big.ParseFloat(v, 10, 56, big.ToNearestAway)
 						if err == nil {
-							cpu.F[k[1]-'0'] = f
-							ok = true
+							var ovf int
+							cpu.F[k[1]-'0'], ovf = pack(x)
+							ok = ovf == 0
 						}
 					}
 				}
@@ -169,11 +202,10 @@ func testExec(t *testing.T, file string) {
 		for i := old; i < len(codes); i++ {
 			mem.WriteW(basePC+2*uint16(i), codes[i])
 		}
-		if err := cpu.Step(1); err != nil {
-			t.Errorf("%s:%d: %s: %v", file, i+1, line, err)
-			broken = true
-			continue
-		}
+		stepErr = cpu.Step(1)
+	}
+	if stepErr != nil && !broken {
+		t.Errorf("%s: %s: %v", file, last, stepErr)
 	}
 }
 
@@ -309,3 +341,42 @@ func testApoutExec(t *testing.T, file string) {
 		t.Fatalf("did not see pc %06o", nows[0].pc)
 	}
 }
+
+func TestSplitID(t *testing.T) {
+	// Instructions, immediates, and index words come from I-space;
+	// operands come from D-space.
+	var cpu CPU
+	imem, dmem := new(ArrayMem), new(ArrayMem)
+	cpu.Mem, cpu.IMem = dmem, imem
+	pc := uint16(0o1000)
+	for _, line := range []string{
+		"mov #1234, r0",
+		"mov r0, 100",
+		"mov 100(r1), r2",
+	} {
+		codes, err := Asm(pc, line)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, code := range codes {
+			imem.WriteW(pc, code)
+			pc += 2
+		}
+	}
+	cpu.R[1] = 2
+	dmem.WriteW(0o102, 0o5555)
+	imem.WriteW(0o102, 0o7777)
+	cpu.R[PC] = 0o1000
+	if err := cpu.Step(3); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := dmem.ReadW(0o100); v != 0o1234 {
+		t.Errorf("D-space 100 = %06o, want %06o", v, 0o1234)
+	}
+	if v, _ := imem.ReadW(0o100); v != 0 {
+		t.Errorf("I-space 100 = %06o, want 0", v)
+	}
+	if cpu.R[2] != 0o5555 {
+		t.Errorf("r2 = %06o, want %06o", cpu.R[2], 0o5555)
+	}
+}
@@ -0,0 +1,56 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pdp11
+
+import "math/big"
+
+// The FIS (floating instruction set) is the 11/35 and 11/40 floating point option.
+// Each FIS instruction names a register r pointing at a stack of two
+// single-precision operands: B at (r) and A at 4(r).
+// The instruction replaces A with A op B and pops B, adding 4 to r.
+//
+// If the result overflows or underflows, or on division by zero,
+// the instruction leaves the operands and r unchanged
+// and traps (ErrFPT), with the condition codes describing the error:
+// V for overflow, N and V for underflow, and N, V, and C for division by zero.
+
+func xfadd(cpu *CPU) { cpu.fis((*big.Float).Add) }
+func xfsub(cpu *CPU) { cpu.fis((*big.Float).Sub) }
+func xfmul(cpu *CPU) { cpu.fis((*big.Float).Mul) }
+func xfdiv(cpu *CPU) { cpu.fis((*big.Float).Quo) }
+
+func (cpu *CPU) fis(op func(z, x, y *big.Float) *big.Float) {
+	r := RegNum(cpu.Inst & 07)
+	p := cpu.R[r]
+	b := Float(cpu.readW(addr(p)))<<48 | Float(cpu.readW(addr(p+2)))<<32
+	a := Float(cpu.readW(addr(p+4)))<<48 | Float(cpu.readW(addr(p+6)))<<32
+	if cpu.Inst&070 == 030 && b.exp() == 0 {
+		cpu.fisTrap(PS_N | PS_V | PS_C) // divide by zero
+		return
+	}
+
+	// Results are rounded to the 24-bit F format mantissa.
+	f, ovf := pack(op(new(big.Float).SetPrec(24).SetMode(big.ToNearestAway), a.big(), b.big()))
+	switch {
+	case ovf > 0:
+		cpu.fisTrap(PS_V)
+		return
+	case ovf < 0:
+		cpu.fisTrap(PS_N | PS_V)
+		return
+	}
+	cpu.writeW(addr(p+4), uint16(f>>48))
+	cpu.writeW(addr(p+6), uint16(f>>32))
+	cpu.R[r] = p + 4
+	cpu.PS &^= PS_N | PS_Z | PS_V | PS_C
+	cpu.PS.SetN(f.neg())
+	cpu.PS.SetZ(f == 0)
+}
+
+// fisTrap sets the condition codes to cc and raises a floating point trap.
+func (cpu *CPU) fisTrap(cc PS) {
+	cpu.PS = cpu.PS&^(PS_N|PS_Z|PS_V|PS_C) | cc
+	cpu.fpTrap = true
+}
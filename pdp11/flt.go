@@ -5,17 +5,12 @@
 package pdp11
 
 import (
-	"math"
+	"fmt"
+	"math/big"
 	"strings"
 )
 
-const (
-	minFloat = 0.5 / (1 << 0o177)
-	maxFloat = float64(1<<56-1) / (1 << 56) * (1 << 0o177)
-)
-
-// An FPS is the processor status word.
-// Only the condition codes are used.
+// An FPS is the floating point status word.
 type FPS uint16
 
 const (
@@ -27,16 +22,71 @@ const (
 	FT   // truncate bit
 	FL   // long-precision integer mode
 	FD   // double-precision mode
-	FIC  // floating interrupt on integer conversion error (TODO)
-	FIV  // floating interrupt on overflow (TODO)
-	FIU  // floating interrupt on underflow (TODO)
-	FIUV // floating interrupt on undefined variable (TODO)
+	FIC  // floating interrupt on integer conversion error
+	FIV  // floating interrupt on overflow
+	FIU  // floating interrupt on underflow
+	FIUV // floating interrupt on undefined variable
 	_
 	_
-	FID // floating interrupt disable (TODO)
-	FER // floating error condiiton present (TODO)
+	FID // floating interrupt disable
+	FER // floating error condition present
 )
 
+// Floating point error codes, stored in FEC.
+const (
+	FEC_OP   = 2  // floating op code error
+	FEC_DIV  = 4  // floating divide by zero
+	FEC_ICVT = 6  // floating to integer conversion error
+	FEC_OVF  = 8  // floating overflow
+	FEC_UNF  = 10 // floating underflow
+	FEC_UNDV = 12 // floating undefined variable
+)
+
+// errFPAbort is the fault that abandons a floating point instruction
+// after an error that prevents it from completing.
+var errFPAbort = fmt.Errorf("floating point abort")
+
+// fpError records a floating point error with the given error code.
+// Integer conversion, overflow, underflow, and undefined variable errors
+// are only recorded if enabled by FIC, FIV, FIU, or FIUV;
+// if not, fpError returns false and the instruction
+// produces its default result (usually zero).
+// Recording an error sets FER, FEC, and FEA, and unless FID is set,
+// it raises a floating point trap (ErrFPT) once the instruction finishes.
+func (cpu *CPU) fpError(code uint8) bool {
+	var enable FPS
+	switch code {
+	case FEC_ICVT:
+		enable = FIC
+	case FEC_OVF:
+		enable = FIV
+	case FEC_UNF:
+		enable = FIU
+	case FEC_UNDV:
+		enable = FIUV
+	}
+	if enable != 0 && cpu.FPS&enable == 0 || cpu.fault != nil {
+		return false
+	}
+	cpu.FPS |= FER
+	cpu.FEC = code
+	cpu.FEA = cpu.instPC
+	if cpu.FPS&FID == 0 {
+		cpu.fpTrap = true
+	}
+	return true
+}
+
+// fpTrapped returns ErrFPT if the last instruction raised
+// a floating point trap, and otherwise nil.
+func (cpu *CPU) fpTrapped() error {
+	if !cpu.fpTrap {
+		return nil
+	}
+	cpu.fpTrap = false
+	return ErrFPT
+}
+
 var ftab = []string{
 	"c",
 	"v",
@@ -102,171 +152,189 @@ func (p *FPS) SetZ(b bool) { p.set(b, FZ) }
 // SetN sets the sign (negative) bit according to the boolean value.
 func (p *FPS) SetN(b bool) { p.set(b, FN) }
 
-func (cpu *CPU) clamp(f float64) float64 {
-	return cpu.conv(f, cpu.FPS&FT == 0)
-}
+// A Float is a floating-point value in the PDP-11 D format,
+// which is also how the FP11 holds its accumulators:
+// a sign bit, an 8-bit excess-128 exponent, and a 55-bit fraction
+// with a hidden leading 1 bit, laid out as the four words in memory,
+// first word in the high bits.
+// An F format value is the high 32 bits of a D format value.
+//
+// A value with exponent 0 is zero. A zero with the sign bit set (-0)
+// is the "undefined variable", which traps when used as an operand
+// if FIUV is set.
+type Float uint64
 
-func (cpu *CPU) conv(f float64, round bool) float64 {
-	a := math.Abs(f)
-	if a < minFloat {
-		return 0
+const (
+	floatSign Float = 1 << 63
+	floatExp  Float = 0o377 << 55
+	floatLow  Float = 1<<32 - 1 // bits not in F format
+)
+
+// exp returns f's excess-128 exponent.
+func (f Float) exp() int { return int(f>>55) & 0o377 }
+
+// neg reports whether f's sign bit is set.
+func (f Float) neg() bool { return f&floatSign != 0 }
+
+// undef reports whether f is the undefined variable -0.
+func (f Float) undef() bool { return f&(floatSign|floatExp) == floatSign }
+
+// big returns f as an exact big.Float.
+func (f Float) big() *big.Float {
+	x := new(big.Float).SetPrec(56)
+	if f.exp() == 0 {
+		return x
 	}
-	if cpu.FPS&FD == 0 {
-		// ieee float64 has 64-11-1 = 52-bit mantissa, pdp11 float32 has 32-8-1 = 23-bit, clear 29
-		bits := math.Float64bits(f)
-		low := bits & (1<<29 - 1)
-		bits &^= 1<<29 - 1
-		if round {
-			if low > 1<<28 {
-				// round up
-				bits += 1 << 29
-			} else if low == 1<<28 {
-				// round to even
-				bits += bits & (1 << 29)
-			}
-		}
-		// TODO check for overflow
-		f = math.Float64frombits(bits)
+	x.SetUint64(uint64(f)&(1<<55-1) | 1<<55)
+	x.SetMantExp(x, f.exp()-0o200-56)
+	if f.neg() {
+		x.Neg(x)
 	}
-	return f
+	return x
 }
 
-func fromF32(w0, w1 uint16) float64 {
-	w := uint64(w0)<<16 | uint64(w1)
-	sign := w >> 31 << 63
-	exp := int64(w>>23)&0o377 - 0o200
-	if exp == -0o200 {
-		return 0
+func (f Float) String() string {
+	switch {
+	case f.exp() == 0 && f.neg():
+		return "-0"
+	case f.exp() == 0:
+		return "0"
 	}
-	mant := w & (1<<23 - 1) << (52 - 23)
-	return math.Float64frombits(sign | uint64(exp+1022)<<52 | mant)
+	return f.big().Text('g', -1)
 }
 
-func fromF64(w0, w1, w2, w3 uint16) float64 {
-	w := uint64(w0)<<48 | uint64(w1)<<32 | uint64(w2)<<16 | uint64(w3)
-	sign := w >> 63 << 63
-	exp := int64(w>>55)&0o377 - 0o200
-	if exp == -0o200 {
-		return 0
+// pack returns x, which must have at most 56 bits of precision, as a Float.
+// If x's exponent is out of range, pack returns the value with the
+// exponent wrapped to 8 bits and ovf > 0 for overflow or ovf < 0 for underflow.
+func pack(x *big.Float) (f Float, ovf int) {
+	if x.Sign() == 0 {
+		return 0, 0
 	}
-	mant := w & (1<<55 - 1)
-	// round to even before shift down 55-52 = 3
-	if mant&(1<<2) != 0 { // TODO wrong
-		mant += mant & (1 << 3)
+	var m big.Float
+	exp := x.MantExp(&m) + 0o200
+	if exp > 0o377 {
+		ovf = +1
+	} else if exp < 1 {
+		ovf = -1
 	}
-	if mant >= 1<<55 {
-		mant >>= 1
-		exp++
+	u, _ := m.SetMantExp(m.Abs(&m), 56).Uint64()
+	f = Float(exp&0o377)<<55 | Float(u)&^(1<<55)
+	if x.Signbit() {
+		f |= floatSign
 	}
-	mant >>= 3
-	return math.Float64frombits(sign | uint64(exp+1022)<<52 | mant)
+	return f, ovf
 }
 
-func toF32(f float64) (w0, w1 uint16) {
-	b := math.Float64bits(f)
-	sign := uint32(b >> 63 << 31)
-	exp := int(b>>52)&2047 - 1022
-	mant := b & (1<<52 - 1)
-	if exp < -0o200 {
-		return
-	}
-	if exp >= 0o200 {
-		return // TODO what
+// prec returns the precision of floating-point results, in bits.
+func (cpu *CPU) prec() uint {
+	if cpu.FPS&FD != 0 {
+		return 56
 	}
-	// round to even before shift down 52-23 = 29
-	if mant&(1<<28) != 0 { // TODO wrong
-		mant += mant & (1 << 29)
-	}
-	if mant >= 1<<52 {
-		mant >>= 1
-		exp++
+	return 24
+}
+
+// result returns a new big.Float to hold a result
+// rounded to the current precision and rounding mode (FT).
+// The FP11 rounds halfway cases away from zero.
+func (cpu *CPU) result() *big.Float {
+	mode := big.ToNearestAway
+	if cpu.FPS&FT != 0 {
+		mode = big.ToZero
 	}
-	mant >>= 29
-	w := sign | uint32(exp+0o200)<<23 | uint32(mant)
-	return uint16(w >> 16), uint16(w)
+	return new(big.Float).SetPrec(cpu.prec()).SetMode(mode)
 }
 
-func toF64(f float64) (w0, w1, w2, w3 uint16) {
-	b := math.Float64bits(f)
-	sign := b >> 63 << 63
-	exp := int(b>>52)&2047 - 1022
-	mant := b & (1<<52 - 1)
-	if exp < -0o200 {
-		return
+// setF sets accumulator ax to the rounded result x and sets the condition codes.
+// See checkRange for overflow and underflow.
+func (cpu *CPU) setF(ax int, x *big.Float) {
+	f, ovf := pack(x)
+	f = cpu.checkRange(f, ovf)
+	cpu.F[ax] = f
+	cpu.setCC(f)
+	cpu.FPS.SetV(ovf > 0)
+}
+
+// checkRange handles overflow (ovf > 0) or underflow (ovf < 0)
+// of the result f, which has its exponent wrapped to 8 bits.
+// If the error is enabled (FIV or FIU), the result is kept
+// and the instruction traps; otherwise the result is zero.
+func (cpu *CPU) checkRange(f Float, ovf int) Float {
+	switch {
+	case ovf > 0 && !cpu.fpError(FEC_OVF),
+		ovf < 0 && !cpu.fpError(FEC_UNF):
+		return 0
 	}
-	if exp >= 0o200 {
-		return // TODO what
+	return f
+}
+
+// setCC sets the floating condition codes for the result f.
+func (cpu *CPU) setCC(f Float) {
+	cpu.FPS.SetC(false)
+	cpu.FPS.SetV(false)
+	cpu.FPS.SetZ(f.exp() == 0)
+	cpu.FPS.SetN(f.neg())
+}
+
+// ac returns accumulator ax at the current precision.
+func (cpu *CPU) ac(ax int) Float {
+	f := cpu.F[ax]
+	if cpu.FPS&FD == 0 {
+		f &^= floatLow
 	}
-	mant <<= 3
-	w := sign | uint64(exp+0o200)<<55 | mant
-	return uint16(w >> 48), uint16(w >> 32), uint16(w >> 16), uint16(w)
+	return f
 }
 
-func (cpu *CPU) readF(a addr) float64 {
-	if a&addrReg != 0 {
+// readF reads a floating-point operand at the current precision.
+// An undefined variable traps if FIUV is set.
+func (cpu *CPU) readF(a addr) Float {
+	var f Float
+	switch {
+	case a&addrReg != 0:
 		if int(a&07) >= len(cpu.F) {
-			panic(1)
-			panic(ErrInst)
+			cpu.fpAbort(FEC_OP)
+			return 0
+		}
+		f = cpu.ac(int(a & 07))
+	case regOrImm(cpu):
+		f = Float(cpu.readW(a)) << 48
+	case cpu.FPS&FD == 0:
+		f = Float(cpu.readW(a))<<48 | Float(cpu.readW(a.add(2)))<<32
+	default:
+		for i := uint16(0); i < 8; i += 2 {
+			f = f<<16 | Float(cpu.readW(a.add(i)))
 		}
-		return cpu.conv(cpu.F[a&07], false)
-	}
-	w0, err := cpu.Mem.ReadW(uint16(a))
-	if err != nil {
-		panic(err)
-	}
-	if regOrImm(cpu) {
-		return fromF32(w0, 0)
-	}
-	w1, err := cpu.Mem.ReadW(uint16(a + 2))
-	if err != nil {
-		panic(err)
-	}
-	if cpu.FPS&FD == 0 {
-		return fromF32(w0, w1)
-	}
-	w2, err := cpu.Mem.ReadW(uint16(a + 4))
-	if err != nil {
-		panic(err)
 	}
-	w3, err := cpu.Mem.ReadW(uint16(a + 6))
-	if err != nil {
-		panic(err)
+	if f.undef() && cpu.fpError(FEC_UNDV) {
+		cpu.fail(errFPAbort)
 	}
-	return fromF64(w0, w1, w2, w3)
+	return f
+}
+
+// fpAbort records the floating point error code and abandons the instruction.
+func (cpu *CPU) fpAbort(code uint8) {
+	cpu.fpError(code)
+	cpu.fail(errFPAbort)
 }
 
-func (cpu *CPU) writeF(a addr, f float64) {
+// writeF writes a floating-point operand at the current precision.
+// In F mode, only the high 32 bits of f are written,
+// and an accumulator's low 32 bits are cleared.
+func (cpu *CPU) writeF(a addr, f Float) {
+	n := uint16(8)
+	if cpu.FPS&FD == 0 {
+		f &^= floatLow
+		n = 4
+	}
 	if a&addrReg != 0 {
 		if int(a&07) >= len(cpu.F) {
-			panic(2)
-			panic(ErrInst)
+			cpu.fpAbort(FEC_OP)
+			return
 		}
 		cpu.F[a&07] = f
 		return
 	}
-	if cpu.FPS&FD == 0 {
-		w0, w1 := toF32(f)
-		if err := cpu.Mem.WriteW(uint16(a), w0); err != nil {
-			panic(err)
-		}
-		if err := cpu.Mem.WriteW(uint16(a+2), w1); err != nil {
-			panic(err)
-		}
-		return
-	}
-
-	w0, w1, w2, w3 := toF64(f)
-	if err := cpu.Mem.WriteW(uint16(a), w0); err != nil {
-		panic(err)
-	}
-	if err := cpu.Mem.WriteW(uint16(a+2), w1); err != nil {
-		panic(err)
-	}
-	if err := cpu.Mem.WriteW(uint16(a+4), w2); err != nil {
-		panic(err)
-	}
-	if err := cpu.Mem.WriteW(uint16(a+6), w3); err != nil {
-		panic(err)
+	for i := uint16(0); i < n; i += 2 {
+		cpu.writeW(a.add(i), uint16(f>>(48-8*i)))
 	}
 }
 
@@ -274,7 +342,7 @@ func (cpu *CPU) ax() int {
 	return int(cpu.Inst>>6) & 03
 }
 
-func (cpu *CPU) srcF() float64 {
+func (cpu *CPU) srcF() Float {
 	return cpu.readF(cpu.dstAddrF())
 }
 
@@ -297,57 +365,38 @@ func xsetl(cpu *CPU) {
 func xabsf(cpu *CPU) {
 	fp := cpu.dstAddrF()
 	f := cpu.readF(fp)
-	if f < 0 {
-		f = -f
-	}
-	if f == 0 {
-		f = 0 // clear sign
+	if f.exp() == 0 {
+		f = 0
 	}
-	f = math.Abs(f)
+	f &^= floatSign
 	cpu.writeF(fp, f)
-	cpu.FPS.SetC(false)
-	cpu.FPS.SetV(false)
-	cpu.FPS.SetZ(f == 0)
-	cpu.FPS.SetN(false)
+	cpu.setCC(f)
 }
 
 func xaddf(cpu *CPU) {
 	f := cpu.srcF()
 	ax := cpu.ax()
-	a := cpu.F[ax] + f
-	a = cpu.clamp(a)
-	cpu.F[ax] = a
-	cpu.FPS.SetC(false)
-	cpu.FPS.SetV(math.Abs(a) >= maxFloat)
-	cpu.FPS.SetZ(a == 0)
-	cpu.FPS.SetN(a < 0)
+	cpu.setF(ax, cpu.result().Add(cpu.ac(ax).big(), f.big()))
 }
 
 func xclrf(cpu *CPU) {
 	fp := cpu.dstAddrF()
 	cpu.writeF(fp, 0)
-	cpu.FPS.SetC(false)
-	cpu.FPS.SetV(false)
-	cpu.FPS.SetZ(true)
-	cpu.FPS.SetN(false)
+	cpu.setCC(0)
 }
 
 func xcmpf(cpu *CPU) {
-	f := cpu.srcF() - cpu.F[cpu.ax()]
+	c := cpu.srcF().big().Cmp(cpu.ac(cpu.ax()).big())
 	cpu.FPS.SetC(false)
 	cpu.FPS.SetV(false)
-	cpu.FPS.SetZ(f == 0)
-	cpu.FPS.SetN(f < 0)
+	cpu.FPS.SetZ(c == 0)
+	cpu.FPS.SetN(c < 0)
 }
 
 func xsubf(cpu *CPU) {
+	f := cpu.srcF()
 	ax := cpu.ax()
-	f := cpu.F[ax] - cpu.srcF()
-	cpu.F[ax] = f
-	cpu.FPS.SetC(false)
-	cpu.FPS.SetV(math.Abs(f) >= maxFloat)
-	cpu.FPS.SetZ(f == 0)
-	cpu.FPS.SetN(f < 0)
+	cpu.setF(ax, cpu.result().Sub(cpu.ac(ax).big(), f.big()))
 }
 
 func xcfcc(cpu *CPU) {
@@ -355,35 +404,26 @@ func xcfcc(cpu *CPU) {
 }
 
 func xdivf(cpu *CPU) {
+	f := cpu.srcF()
+	if f.exp() == 0 {
+		cpu.fpAbort(FEC_DIV)
+		return
+	}
 	ax := cpu.ax()
-	f := cpu.F[ax] / cpu.srcF()
-	f = cpu.clamp(f)
-	cpu.F[ax] = f
-	cpu.FPS.SetC(false)
-	cpu.FPS.SetV(math.Abs(f) >= maxFloat)
-	cpu.FPS.SetZ(f == 0)
-	cpu.FPS.SetN(f < 0)
+	cpu.setF(ax, cpu.result().Quo(cpu.ac(ax).big(), f.big()))
 }
 
 func xldf(cpu *CPU) {
 	f := cpu.srcF()
-	cpu.F[cpu.ax()] = f
-	cpu.FPS.SetC(false)
-	cpu.FPS.SetV(false)
-	cpu.FPS.SetZ(f == 0)
-	cpu.FPS.SetN(f < 0)
+	cpu.writeF(addrReg|addr(cpu.ax()), f)
+	cpu.setCC(f)
 }
 
 func xldcdf(cpu *CPU) {
 	cpu.FPS ^= FD
 	f := cpu.srcF()
 	cpu.FPS ^= FD
-	f = cpu.clamp(f)
-	cpu.F[cpu.ax()] = f
-	cpu.FPS.SetC(false)
-	cpu.FPS.SetV(math.Abs(f) >= maxFloat)
-	cpu.FPS.SetZ(f == 0)
-	cpu.FPS.SetN(f < 0)
+	cpu.setF(cpu.ax(), cpu.result().Set(f.big()))
 }
 
 func regOrImm(cpu *CPU) bool {
@@ -391,159 +431,139 @@ func regOrImm(cpu *CPU) bool {
 }
 
 func xldcif(cpu *CPU) {
-	var f float64
+	var i int32
 	if cpu.FPS&FL == 0 {
 		// 16-bit value
-		f = float64(int16(cpu.dstW()))
+		i = int32(int16(cpu.dstW()))
 	} else {
 		// 32-bit value
-		var i int32
 		if regOrImm(cpu) {
 			w := cpu.dstW()
 			i = int32(w) << 16
 		} else {
 			dp := cpu.dstAddrF()
-			i = int32(cpu.readW(dp))<<16 | int32(cpu.readW(dp+2))
+			i = int32(cpu.readW(dp))<<16 | int32(cpu.readW(dp.add(2)))
 		}
-		f = float64(i)
 	}
-	f = cpu.clamp(f)
-	cpu.F[cpu.ax()] = f
-	cpu.FPS.SetC(false)
-	cpu.FPS.SetV(false)
-	cpu.FPS.SetZ(f == 0)
-	cpu.FPS.SetN(f < 0)
+	cpu.setF(cpu.ax(), cpu.result().SetInt64(int64(i)))
 }
 
+// xldexp replaces the exponent of the accumulator.
+// An exponent out of range is an overflow or underflow (see checkRange).
 func xldexp(cpu *CPU) {
 	ax := cpu.ax()
-	f := cpu.F[cpu.ax()]
 	src := int16(cpu.dstW())
-	if -0o177 <= src && src <= 0o177 {
-		f = math.Ldexp(f, int(src))
-		cpu.F[ax] = f
-		cpu.FPS.SetV(false)
-	} else {
-		cpu.FPS.SetV(true)
-	}
-	cpu.FPS.SetC(false)
-	cpu.FPS.SetZ(f == 0)
-	cpu.FPS.SetN(f < 0)
+	f := cpu.F[ax]&^floatExp | Float(src+0o200)&0o377<<55
+	ovf := 0
+	switch {
+	case src > 0o177:
+		ovf = +1
+	case src < -0o177:
+		ovf = -1
+	}
+	f = cpu.checkRange(f, ovf)
+	cpu.F[ax] = f
+	cpu.setCC(f)
+	cpu.FPS.SetV(ovf > 0)
 }
 
 func xldfps(cpu *CPU) {
 	cpu.FPS = FPS(cpu.dstW())
 }
 
+// xmodf multiplies the accumulator by the source,
+// storing the fraction part of the product in the accumulator
+// and the integer part in the accumulator with the low bit set
+// (unless the accumulator number is already odd).
 func xmodf(cpu *CPU) {
 	f := cpu.srcF()
 	ax := cpu.ax()
-	a := cpu.F[ax] * f
-	a = cpu.clamp(a)
-	int, frac := math.Modf(a)
-	cpu.F[ax|1] = int
-	frac = cpu.clamp(frac)
-	cpu.F[ax] = frac
-	cpu.FPS.SetC(false)
-	cpu.FPS.SetV(math.Abs(frac) >= maxFloat) // always false?
-	cpu.FPS.SetZ(frac == 0)
-	cpu.FPS.SetN(frac < 0)
+	prod := new(big.Float).SetPrec(112).Mul(cpu.ac(ax).big(), f.big()) // exact
+	i, _ := prod.Int(nil)
+	frac := new(big.Float).SetPrec(112).SetInt(i)
+	frac.Sub(prod, frac)
+	cpu.setF(ax|1, cpu.result().SetInt(i))
+	v := cpu.FPS & FV
+	cpu.setF(ax, cpu.result().Set(frac))
+	cpu.FPS |= v
 }
 
 func xmulf(cpu *CPU) {
 	f := cpu.srcF()
 	ax := cpu.ax()
-	a := cpu.F[ax] * f
-	a = cpu.clamp(a)
-	cpu.F[ax] = a
-	cpu.FPS.SetC(false)
-	cpu.FPS.SetV(math.Abs(a) >= maxFloat)
-	cpu.FPS.SetZ(a == 0)
-	cpu.FPS.SetN(a < 0)
+	cpu.setF(ax, cpu.result().Mul(cpu.ac(ax).big(), f.big()))
 }
 
 func xnegf(cpu *CPU) {
 	fp := cpu.dstAddrF()
 	f := cpu.readF(fp)
-	f = -f
-	if f == 0 {
-		f = 0 // clear sign
+	if f.exp() == 0 {
+		f = 0
+	} else {
+		f ^= floatSign
 	}
 	cpu.writeF(fp, f)
-	cpu.FPS.SetC(false)
-	cpu.FPS.SetV(false)
-	cpu.FPS.SetZ(f == 0)
-	cpu.FPS.SetN(f < 0)
+	cpu.setCC(f)
 }
 
 func xstf(cpu *CPU) {
-	fp := cpu.dstAddrF()
-	f := cpu.F[cpu.ax()]
-	cpu.writeF(fp, f)
-	/*
-		cpu.FPS.SetC(false)
-		cpu.FPS.SetV(false)
-		cpu.FPS.SetZ(f == 0)
-		cpu.FPS.SetN(f < 0)
-	*/
+	cpu.writeF(cpu.dstAddrF(), cpu.ac(cpu.ax()))
 }
 
+// xstcfd stores the accumulator converted to the other precision.
 func xstcfd(cpu *CPU) {
-	f := cpu.F[cpu.ax()]
-	f = cpu.clamp(f)
+	f := cpu.ac(cpu.ax())
 	cpu.FPS ^= FD
-	f = cpu.clamp(f)
-	cpu.writeF(cpu.dstAddrF(), f)
+	g, ovf := pack(cpu.result().Set(f.big()))
+	g = cpu.checkRange(g, ovf)
+	cpu.writeF(cpu.dstAddrF(), g)
 	cpu.FPS ^= FD
-
-	cpu.FPS.SetC(false)
-	cpu.FPS.SetV(math.Abs(f) >= maxFloat)
-	cpu.FPS.SetZ(f == 0)
-	cpu.FPS.SetN(f < 0)
+	cpu.setCC(g)
+	cpu.FPS.SetV(ovf > 0)
 }
 
+// xstcfi stores the accumulator converted to an integer,
+// truncating toward zero. If the integer does not fit,
+// it stores zero, sets the carry bit, and traps if FIC is set.
 func xstcfi(cpu *CPU) {
-	f := math.Round(cpu.F[cpu.ax()])
-	cpu.FPS.SetC(false)
-	cpu.PS.SetC(false)
+	i, _ := cpu.ac(cpu.ax()).big().Int64()
+	bad := false
 	if cpu.FPS&FL == 0 {
 		dp := cpu.dstAddrW()
-		if float64(int16(f)) != f {
-			f = 0
-			cpu.FPS.SetC(true)
-			cpu.PS.SetC(true)
+		if int64(int16(i)) != i {
+			i, bad = 0, true
 		}
-		cpu.writeW(dp, uint16(int16(f)))
+		cpu.writeW(dp, uint16(i))
 	} else {
 		dp := cpu.dstAddrL()
-		if float64(int32(f)) != f {
-			f = 0
-			cpu.FPS.SetC(true)
-			cpu.PS.SetC(true)
+		if int64(int32(i)) != i {
+			i, bad = 0, true
 		}
-		i := uint32(int32(f))
 		cpu.writeW(dp, uint16(i>>16))
 		if !regOrImm(cpu) {
-			cpu.writeW(dp+2, uint16(i))
+			cpu.writeW(dp.add(2), uint16(i))
 		}
 	}
+	cpu.setIntCC(i < 0, i == 0, bad)
+	if bad {
+		cpu.fpError(FEC_ICVT)
+	}
+}
+
+// setIntCC sets both the floating and the processor condition codes
+// for an integer result.
+func (cpu *CPU) setIntCC(n, z, c bool) {
+	cpu.FPS.SetC(c)
 	cpu.FPS.SetV(false)
-	cpu.PS.SetV(false)
-	cpu.FPS.SetZ(f == 0)
-	cpu.PS.SetZ(f == 0)
-	cpu.FPS.SetN(f < 0)
-	cpu.PS.SetN(f < 0)
+	cpu.FPS.SetZ(z)
+	cpu.FPS.SetN(n)
+	cpu.PS = cpu.PS&^0o17 | PS(cpu.FPS&0o17)
 }
 
 func xstexp(cpu *CPU) {
-	f := cpu.F[cpu.ax()]
-	_, exp := math.Frexp(f)
-	cpu.writeW(cpu.dstAddrF(), uint16(exp-1))
-	cpu.FPS.SetC(false)
-	cpu.FPS.SetV(false)
-	cpu.FPS.SetZ(exp == 0)
-	cpu.FPS.SetN(exp < 0)
-	cpu.FPS = cpu.FPS
+	exp := int16(cpu.ac(cpu.ax()).exp()) - 0o200
+	cpu.writeW(cpu.dstAddrW(), uint16(exp))
+	cpu.setIntCC(exp < 0, exp == 0, false)
 }
 
 func xstfps(cpu *CPU) {
@@ -551,18 +571,18 @@ func xstfps(cpu *CPU) {
 }
 
 func xstst(cpu *CPU) {
-	panic(ErrInst) // FEC and FEA not set yet
 	dp := cpu.dstAddrL()
 	cpu.writeW(dp, uint16(cpu.FEC))
 	if !regOrImm(cpu) {
-		cpu.writeW(dp+2, uint16(cpu.FEA))
+		cpu.writeW(dp.add(2), uint16(cpu.FEA))
 	}
 }
 
+// xfbad is an unassigned floating point instruction.
+func xfbad(cpu *CPU) {
+	cpu.fpAbort(FEC_OP)
+}
+
 func xtstf(cpu *CPU) {
-	f := cpu.srcF()
-	cpu.FPS.SetC(false)
-	cpu.FPS.SetV(false)
-	cpu.FPS.SetZ(f == 0)
-	cpu.FPS.SetN(f < 0)
+	cpu.setCC(cpu.srcF())
 }
@@ -4,35 +4,66 @@
 
 package pdp11
 
-import "testing"
+import (
+	"math/big"
+	"testing"
+)
 
 var convTests = []struct {
-	f    float64
+	s    string
 	bits uint64
 }{
-	{32.0, 0b0_10000110_000_0000 << 48},
-	{7 / 16.0, 0b0_11111111_10_0000 << 48},
-	{0, 0},
+	{"32", 0b0_10000110_000_0000 << 48},
+	{"0.4375", 0b0_01111111_110_0000 << 48},
+	{"0", 0},
+	{"-1", 0o140200 << 48},
+	{"0.1", 0o037314<<48 | 0o146314<<32 | 0o146314<<16 | 0o146315},
+	{"1.70141183460469213e+38", 0o077777<<48 | 0o177777<<32 | 0o177777<<16 | 0o177770},
+	{"1.7014118346046923e+38", 0o077777<<48 | 0o177777<<32 | 0o177777<<16 | 0o177777},
+	{"2.9387358770557188e-39", 0o000200 << 48},
 }
 
 func TestFloatConv(t *testing.T) {
 	for _, tt := range convTests {
-		b := tt.bits
-		if f := fromF64(uint16(b>>48), uint16(b>>32), uint16(b>>16), uint16(b)); f != tt.f {
-			t.Errorf("fromF64(%#016x) = %v, want %v", b, f, tt.f)
+		x, _, err := big.ParseFloat(tt.s, 10, 56, big.ToNearestAway)
+		if err != nil {
+			t.Fatal(err)
 		}
-		w0, w1, w2, w3 := toF64(tt.f)
-		if b := uint64(w0)<<48 | uint64(w1)<<32 | uint64(w2)<<16 | uint64(w3); b != tt.bits {
-			t.Errorf("toF64(%v) = %#016x, want %#016x", tt.f, b, tt.bits)
+		if f, ovf := pack(x); f != Float(tt.bits) || ovf != 0 {
+			t.Errorf("pack(%s) = %#o, %d, want %#o, 0", tt.s, uint64(f), ovf, tt.bits)
 		}
-		if b>>32<<32 == b {
-			if f := fromF32(uint16(b>>48), uint16(b>>32)); f != tt.f {
-				t.Errorf("fromF32(%#08x) = %v, want %v", b>>32, f, tt.f)
-			}
-			w0, w1 := toF32(tt.f)
-			if b := uint64(w0)<<48 | uint64(w1)<<32; b != tt.bits {
-				t.Errorf("toF32(%v) = %#08x, want %#08x", tt.f, b>>32, tt.bits>>32)
-			}
+		if s := Float(tt.bits).String(); s != tt.s {
+			t.Errorf("Float(%#o).String() = %s, want %s", tt.bits, s, tt.s)
+		}
+	}
+}
+
+func TestFloatRange(t *testing.T) {
+	max := Float(1<<63 - 1)
+	x := new(big.Float).SetPrec(56).SetMode(big.ToNearestAway)
+	if f, ovf := pack(x.Add(max.big(), max.big())); ovf <= 0 || f.exp() != 0 {
+		t.Errorf("max+max = %#o, %d, want exponent wrapped to 0, overflow", uint64(f), ovf)
+	}
+	min := Float(0o000200 << 48)
+	if f, ovf := pack(x.Quo(min.big(), Float(0o040600<<48).big())); ovf >= 0 || f.exp() != 0o377 {
+		t.Errorf("min/4 = %#o, %d, want exponent wrapped to 0o377, underflow", uint64(f), ovf)
+	}
+}
+
+func TestFloatOpError(t *testing.T) {
+	// Unassigned instructions and accumulators 6 and 7 are op code errors,
+	// which always trap through vector 244.
+	for _, inst := range []uint16{0o170003, 0o170077, 0o172406} { // ldf f6, f0
+		cpu, mem := newTrapTest(t)
+		mem.WriteW(0o1000, inst)
+		if err := cpu.Step(1); err != nil {
+			t.Fatal(err)
+		}
+		if cpu.R[PC] != 0o2244 || cpu.FEC != FEC_OP || cpu.FEA != 0o1000 || cpu.FPS&FER == 0 {
+			t.Errorf("%06o: pc=%06o fec=%d fea=%06o fps=%v, want %06o, %d, %06o, er", inst, cpu.R[PC], cpu.FEC, cpu.FEA, cpu.FPS, 0o2244, FEC_OP, 0o1000)
+		}
+		if pc, _ := mem.ReadW(0o674); pc != 0o1002 {
+			t.Errorf("%06o: pushed pc=%06o, want %06o", inst, pc, 0o1002)
 		}
 	}
 }
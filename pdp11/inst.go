@@ -4,10 +4,6 @@
 
 package pdp11
 
-import (
-	"strings"
-)
-
 type instr struct {
 	code uint16
 	do   func(cpu *CPU)
@@ -26,7 +22,7 @@ var itab = []instr{
 	{0o000100, xjmp, "jmp %d"},
 	{0o000200, xrts, "rts %R"},
 	{0o000210, xbad, ""},
-	{0o000230, xbad, "spl %N"}, // untested
+	{0o000230, xspl, "spl %N"}, // untested
 	{0o000240, xccc, "nop"},
 	{0o000241, xccc, "clc"},
 	{0o000242, xccc, "clv"},
@@ -80,7 +76,7 @@ var itab = []instr{
 	{0o006100, xrol, "rol %d"},
 	{0o006200, xasr, "asr %d"},
 	{0o006300, xasl, "asl %d"},
-	{0o006400, xmark, "mark %d"}, // untested
+	{0o006400, xmark, "mark %M"}, // untested
 	{0o006500, xmfpi, "mfpi %d"}, // untested
 	{0o006600, xmtpi, "mtpi %d"}, // untested
 	{0o006700, xsxt, "sxt %d"},
@@ -96,7 +92,11 @@ var itab = []instr{
 	{0o072000, xash, "ash %d, %r"},
 	{0o073000, xashc, "ashc %d, %r"},
 	{0o074000, xxor, "xor %r, %d"},
-	{0o075000, xbad, ""},
+	{0o075000, xfadd, "fadd %R"},
+	{0o075010, xfsub, "fsub %R"},
+	{0o075020, xfmul, "fmul %R"},
+	{0o075030, xfdiv, "fdiv %R"},
+	{0o075040, xbad, ""},
 	{0o077000, xsob, "sob %r, %B"},
 	{0o100000, xbpl, "bpl %b"},
 	{0o100400, xbmi, "bmi %b"},
@@ -121,6 +121,9 @@ var itab = []instr{
 	{0o106200, xasrb, "asrb %d"},
 	{0o106300, xaslb, "aslb %d"},
 	{0o106400, xbad, ""},
+	{0o106500, xmfpd, "mfpd %d"}, // untested
+	{0o106600, xmtpd, "mtpd %d"}, // untested
+	{0o106700, xbad, ""},
 	{0o110000, xmovb, "movb %s, %d"},
 	{0o120000, xcmpb, "cmpb %s, %d"},
 	{0o130000, xbitb, "bitb %s, %d"},
@@ -130,10 +133,10 @@ var itab = []instr{
 	{0o170000, xcfcc, "cfcc"}, // untested
 	{0o170001, xsetf, "setf"},
 	{0o170002, xseti, "seti"},
-	{0o170003, xbad, ""},
+	{0o170003, xfbad, ""},
 	{0o170011, xsetd, "setd"},
 	{0o170012, xsetl, "setl"},
-	{0o170013, xbad, ""},
+	{0o170013, xfbad, ""},
 	{0o170100, xldfps, "ldfps %f"},
 	{0o170200, xstfps, "stfps %d"},
 	{0o170300, xstst, "stst %d"}, // untested
@@ -167,18 +170,20 @@ func init() {
 		}
 		xtab[inst] = uint8(i)
 	}
+	predecode()
 }
 
 func lookup(inst uint16) *instr {
 	return &itab[xtab[inst]]
 }
 
-func lookupAsm(op string) *instr {
-	for i := range itab {
-		inst := &itab[i]
-		if iop, _, _ := strings.Cut(inst.text, " "); iop == op {
-			return inst
+// lookupAsm returns the index in itab of the instruction
+// with mnemonic op, or -1 if there is none.
+func lookupAsm(op string) int {
+	for i := range forms {
+		if forms[i].op == op && itab[i].text != "" {
+			return i
 		}
 	}
-	return nil
+	return -1
 }
@@ -0,0 +1,52 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pdp11
+
+// A MemMap is a memory map for strict memory checking
+// on a CPU without an MMU (see CPU.MemMap).
+// It lists the address ranges a program may access:
+// its text, data, and stack segments.
+//
+// Under a MemMap, as on the hardware, a word access
+// at an odd address is a bus error (ErrMem),
+// and so is any access outside the map.
+// If the CPU has a separate instruction space (IMem),
+// instruction space accesses must be in Text,
+// and data space accesses must be in Data or Stack.
+// Otherwise, reads may be in any segment,
+// and writes must be in Data or Stack, or in Text if TextWrite is set.
+type MemMap struct {
+	Text      Extent
+	Data      Extent
+	Stack     Extent
+	TextWrite bool // text is writable
+}
+
+// An Extent is the range of addresses [Start, End).
+type Extent struct {
+	Start, End uint32
+}
+
+// Contains reports whether e contains the n bytes at addr.
+func (e Extent) Contains(addr uint16, n int) bool {
+	return e.Start <= uint32(addr) && uint32(addr)+uint32(n) <= e.End
+}
+
+// mapOK reports whether cpu.MemMap allows an access of n bytes at va.
+// If ispace is true, va is an instruction-space address.
+func (cpu *CPU) mapOK(va uint16, n int, ispace, write bool) bool {
+	if n == 2 && va&1 != 0 {
+		return false
+	}
+	m := cpu.MemMap
+	if cpu.IMem != nil {
+		if ispace {
+			return m.Text.Contains(va, n) && (!write || m.TextWrite)
+		}
+		return m.Data.Contains(va, n) || m.Stack.Contains(va, n)
+	}
+	return m.Data.Contains(va, n) || m.Stack.Contains(va, n) ||
+		m.Text.Contains(va, n) && (!write || m.TextWrite)
+}
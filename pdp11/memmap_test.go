@@ -0,0 +1,75 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pdp11
+
+import (
+	"errors"
+	"testing"
+)
+
+var memMapTests = []struct {
+	inst string
+	r1   uint16
+	sep  bool   // separate I space
+	err  error  // expected error
+	odd  bool   // expected Trap.Odd
+	addr uint16 // expected Trap.Addr
+}{
+	{inst: "tst (r1)", r1: 0o4000},
+	{inst: "tst (r1)", r1: 0o4001, err: ErrMem, odd: true, addr: 0o4001},
+	{inst: "tstb (r1)", r1: 0o4001},
+	{inst: "inc (r1)", r1: 0o4000},
+	{inst: "inc (r1)", r1: 0o170000},
+	{inst: "tst (r1)", r1: 0o1000},
+	{inst: "inc (r1)", r1: 0o1000, err: ErrMem, addr: 0o1000},
+	{inst: "tst (r1)", r1: 0o10000, err: ErrMem, addr: 0o10000},
+	{inst: "tst (r1)", r1: 0o167776, err: ErrMem, addr: 0o167776},
+	{inst: "tst (r1)", r1: 0o1000, sep: true, err: ErrMem, addr: 0o1000},
+	{inst: "mfpi (r1)", r1: 0o1000, sep: true},
+	{inst: "mfpi (r1)", r1: 0o4000, sep: true, err: ErrMem, addr: 0o4000},
+}
+
+func TestMemMap(t *testing.T) {
+	for _, tt := range memMapTests {
+		cpu, mem := newTrapTest(t, tt.inst)
+		cpu.TrapMode = TrapError
+		cpu.MemMap = &MemMap{
+			Text:  Extent{0, 0o2000},
+			Data:  Extent{0o4000, 0o10000},
+			Stack: Extent{0o170000, 1 << 16},
+		}
+		if tt.sep {
+			imem := *mem
+			cpu.IMem = &imem
+		}
+		cpu.R[1] = tt.r1
+		cpu.R[SP] = 0o177000
+		err := cpu.Step(1)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("%s with r1=%06o sep=%v: Step = %v, want %v", tt.inst, tt.r1, tt.sep, err, tt.err)
+			continue
+		}
+		if tr, ok := err.(*Trap); ok && (tr.Odd != tt.odd || tr.Addr != tt.addr) {
+			t.Errorf("%s with r1=%06o sep=%v: odd=%v addr=%06o, want %v, %06o", tt.inst, tt.r1, tt.sep, tr.Odd, tr.Addr, tt.odd, tt.addr)
+		}
+	}
+}
+
+// Instructions outside the text segment cannot be executed
+// when the CPU has separate instruction space.
+func TestMemMapFetch(t *testing.T) {
+	cpu, mem := newTrapTest(t, "jmp 4000")
+	cpu.TrapMode = TrapError
+	imem := *mem
+	cpu.IMem = &imem
+	cpu.MemMap = &MemMap{Text: Extent{0, 0o2000}, Data: Extent{0, 1 << 16}}
+	if err := cpu.Step(1); err != nil {
+		t.Fatal(err)
+	}
+	err := cpu.Step(1)
+	if tr, ok := err.(*Trap); !ok || tr.Err != ErrMem || tr.Access != AccessExec || tr.PC != 0o4000 {
+		t.Fatalf("Step = %v, want ErrMem fetching 004000", err)
+	}
+}
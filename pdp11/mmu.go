@@ -0,0 +1,273 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pdp11
+
+// A PhysMemory represents a PDP-11 physical memory,
+// addressed by 18-bit or 22-bit physical addresses.
+type PhysMemory interface {
+	ReadB(addr uint32) (uint8, error)
+	ReadW(addr uint32) (uint16, error)
+	WriteB(addr uint32, val uint8) error
+	WriteW(addr uint32, val uint16) error
+}
+
+// A CoreMem is a PhysMemory implementation backed by a byte slice.
+// Addresses past the end of the slice are nonexistent memory.
+type CoreMem []byte
+
+func (m CoreMem) ReadB(addr uint32) (uint8, error) {
+	if addr >= uint32(len(m)) {
+		return 0, ErrMem
+	}
+	return m[addr], nil
+}
+
+func (m CoreMem) ReadW(addr uint32) (uint16, error) {
+	if addr+1 >= uint32(len(m)) {
+		return 0, ErrMem
+	}
+	return uint16(m[addr]) | uint16(m[addr+1])<<8, nil
+}
+
+func (m CoreMem) WriteB(addr uint32, val uint8) error {
+	if addr >= uint32(len(m)) {
+		return ErrMem
+	}
+	m[addr] = val
+	return nil
+}
+
+func (m CoreMem) WriteW(addr uint32, val uint16) error {
+	if addr+1 >= uint32(len(m)) {
+		return ErrMem
+	}
+	m[addr] = uint8(val)
+	m[addr+1] = uint8(val >> 8)
+	return nil
+}
+
+// An MMU is a KT11 memory management unit.
+// It translates the CPU's 16-bit virtual addresses to 18-bit physical addresses,
+// or 22-bit physical addresses when SR3_22 is set,
+// using a separate set of page registers for each processor mode.
+//
+// When SR0_EN is clear, virtual addresses map directly to the
+// low 56 kB of physical memory, and the top 8 kB map to the I/O page.
+type MMU struct {
+	Mem PhysMemory // physical memory
+
+	SR0 uint16 // status register 0: abort flags, faulting page, enable
+	SR1 uint16 // status register 1: register changes made by aborted instruction
+	SR2 uint16 // status register 2: virtual address of current instruction
+	SR3 uint16 // status register 3: D-space and 22-bit enables
+
+	// PAR and PDR are the page address and page descriptor registers,
+	// indexed by mode and then page number.
+	// Pages 0-7 map I-space, and pages 8-15 map D-space.
+	PAR [4][16]uint16
+	PDR [4][16]uint16
+}
+
+// SR0 bits.
+const (
+	SR0_EN uint16 = 1 << 0  // enable relocation
+	SR0_RO uint16 = 1 << 13 // abort: write to read-only page
+	SR0_PL uint16 = 1 << 14 // abort: page length error
+	SR0_NR uint16 = 1 << 15 // abort: nonresident page
+
+	sr0Abort = SR0_NR | SR0_PL | SR0_RO
+)
+
+// SR3 bits.
+const (
+	SR3_UD uint16 = 1 << 0 // enable user D-space
+	SR3_SD uint16 = 1 << 1 // enable supervisor D-space
+	SR3_KD uint16 = 1 << 2 // enable kernel D-space
+	SR3_22 uint16 = 1 << 4 // enable 22-bit mapping
+)
+
+// PDR bits.
+const (
+	PDR_NR  uint16 = 0o0     // access control: nonresident
+	PDR_RO  uint16 = 0o2     // access control: read-only
+	PDR_RW  uint16 = 0o6     // access control: read/write
+	PDR_ACF uint16 = 0o7     // access control field
+	PDR_ED  uint16 = 0o10    // expansion direction: page grows down
+	PDR_W   uint16 = 0o100   // page has been written
+	PDR_PLF uint16 = 0o77400 // page length field, in 64-byte blocks
+	pdrMask uint16 = 0o77416 | PDR_W
+)
+
+var dspaceBit = [4]uint16{SR3_KD, SR3_SD, 0, SR3_UD}
+
+// ioBase returns the physical address of the start of the I/O page.
+func (m *MMU) ioBase() uint32 {
+	if m.SR3&SR3_22 != 0 {
+		return 0o17760000
+	}
+	return 0o760000
+}
+
+// translate returns the physical address for the virtual address va,
+// accessed in the given mode.
+// If ispace is true, the reference is to instruction space.
+// If the access is not allowed, translate records the details in SR0
+// and returns ErrMMU.
+func (m *MMU) translate(va uint16, mode Mode, ispace, write bool) (uint32, error) {
+	if m.SR0&SR0_EN == 0 {
+		pa := uint32(va)
+		if va >= ioPage {
+			pa += m.ioBase() - ioPage
+		}
+		return pa, nil
+	}
+
+	page := int(va >> 13)
+	if !ispace && m.SR3&dspaceBit[mode] != 0 {
+		page += 8
+	}
+	pdr := m.PDR[mode][page]
+	block := (va >> 6) & 0o177
+	plf := (pdr & PDR_PLF) >> 8
+
+	var abort uint16
+	switch pdr & PDR_ACF {
+	case 0, 3, 7:
+		abort = SR0_NR
+	case 1, 2:
+		// Read-only. The KT11-C "trap on read" variant (1)
+		// is treated the same as plain read-only.
+		if write {
+			abort = SR0_RO
+		}
+	}
+	if mode == 2 {
+		abort = SR0_NR
+	}
+	if pdr&PDR_ED == 0 && block > plf || pdr&PDR_ED != 0 && block < plf {
+		abort |= SR0_PL
+	}
+	if abort != 0 {
+		if m.SR0&sr0Abort == 0 {
+			m.SR0 = m.SR0&SR0_EN | abort | uint16(mode)<<5 | uint16(page)<<1
+		}
+		return 0, ErrMMU
+	}
+
+	if write {
+		m.PDR[mode][page] |= PDR_W
+	}
+	pa := uint32(m.PAR[mode][page])<<6 + uint32(va&0o17777)
+	if m.SR3&SR3_22 == 0 {
+		pa &= 1<<18 - 1
+	} else {
+		pa &= 1<<22 - 1
+	}
+	return pa, nil
+}
+
+// startInst records the start of a new instruction at pc,
+// unless the status registers are frozen by an earlier abort.
+func (m *MMU) startInst(pc uint16) {
+	if m.SR0&sr0Abort == 0 {
+		m.SR1 = 0
+		m.SR2 = pc
+	}
+}
+
+// noteReg records in SR1 that the instruction changed register r by delta.
+func (m *MMU) noteReg(r RegNum, delta uint16) {
+	if m.SR0&sr0Abort != 0 {
+		return
+	}
+	b := (delta&0o37)<<3 | uint16(r)
+	if m.SR1&0o377 == 0 {
+		m.SR1 = b
+	} else {
+		m.SR1 |= b << 8
+	}
+}
+
+// The MMU's registers live in the I/O page.
+const (
+	sr0Addr     = 0o177572
+	sr1Addr     = 0o177574
+	sr2Addr     = 0o177576
+	sr3Addr     = 0o172516
+	userRegs    = 0o177600 // user PDRs, then PARs
+	superRegs   = 0o172200 // supervisor PDRs, then PARs
+	kernelRegs  = 0o172300 // kernel PDRs, then PARs
+	pageRegSize = 0o100    // size of one mode's register block
+)
+
+// pageReg decodes the page register at I/O page address a,
+// returning its mode, its page number, and whether it is a PAR (not a PDR).
+func pageReg(a uint16) (mode Mode, page int, par, ok bool) {
+	switch {
+	case userRegs <= a && a < userRegs+pageRegSize:
+		mode, a = User, a-userRegs
+	case superRegs <= a && a < superRegs+pageRegSize:
+		mode, a = Supervisor, a-superRegs
+	case kernelRegs <= a && a < kernelRegs+pageRegSize:
+		mode, a = Kernel, a-kernelRegs
+	default:
+		return 0, 0, false, false
+	}
+	return mode, int(a>>1) & 0o17, a >= 0o40, true
+}
+
+// readReg returns the value of the MMU register at I/O page address a.
+func (m *MMU) readReg(a uint16) (uint16, bool) {
+	switch a {
+	case sr0Addr:
+		return m.SR0, true
+	case sr1Addr:
+		return m.SR1, true
+	case sr2Addr:
+		return m.SR2, true
+	case sr3Addr:
+		return m.SR3, true
+	}
+	mode, page, par, ok := pageReg(a)
+	if !ok {
+		return 0, false
+	}
+	if par {
+		return m.PAR[mode][page], true
+	}
+	return m.PDR[mode][page], true
+}
+
+// isReg reports whether I/O page address a is an MMU register.
+func (m *MMU) isReg(a uint16) bool {
+	_, ok := m.readReg(a)
+	return ok
+}
+
+// writeReg sets the MMU register at I/O page address a to val.
+// Writing either page register clears the page's W bit.
+func (m *MMU) writeReg(a, val uint16) bool {
+	switch a {
+	case sr0Addr:
+		m.SR0 = val & (sr0Abort | 0o177)
+		return true
+	case sr1Addr, sr2Addr:
+		return true // read-only
+	case sr3Addr:
+		m.SR3 = val & (SR3_UD | SR3_SD | SR3_KD | SR3_22)
+		return true
+	}
+	mode, page, par, ok := pageReg(a)
+	if !ok {
+		return false
+	}
+	if par {
+		m.PAR[mode][page] = val
+		m.PDR[mode][page] &^= PDR_W
+	} else {
+		m.PDR[mode][page] = val & pdrMask &^ PDR_W
+	}
+	return true
+}
@@ -0,0 +1,226 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pdp11
+
+import (
+	"errors"
+	"testing"
+)
+
+// newMMUTest returns a CPU with an MMU and 256 kB of physical memory.
+// Kernel pages 0-6 map the low 56 kB, kernel page 7 maps the I/O page,
+// and user page 0 maps 8 kB at physical 0o200000 read/write,
+// user page 1 maps 8 kB at physical 0o220000 read-only.
+func newMMUTest(t *testing.T) (*CPU, CoreMem) {
+	mem := make(CoreMem, 256<<10)
+	cpu := &CPU{MMU: &MMU{Mem: mem}}
+	m := cpu.MMU
+	for i := 0; i < 7; i++ {
+		m.PAR[Kernel][i] = uint16(i) * 0o200
+		m.PDR[Kernel][i] = 0o77400 | PDR_RW
+	}
+	m.PAR[Kernel][7] = 0o7600
+	m.PDR[Kernel][7] = 0o77400 | PDR_RW
+	m.PAR[User][0] = 0o2000
+	m.PDR[User][0] = 0o77400 | PDR_RW
+	m.PAR[User][1] = 0o2200
+	m.PDR[User][1] = 0o77400 | PDR_RO
+	m.SR0 = SR0_EN
+	return cpu, mem
+}
+
+// load assembles the instructions in text starting at pc,
+// storing them in physical memory at pa.
+func load(t *testing.T, mem CoreMem, pa uint32, pc uint16, text ...string) {
+	for _, line := range text {
+		codes, err := Asm(pc, line)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, code := range codes {
+			mem.WriteW(pa, code)
+			pa += 2
+			pc += 2
+		}
+	}
+}
+
+func TestMMUTranslate(t *testing.T) {
+	cpu, mem := newMMUTest(t)
+	cpu.PS = PS(User) << 14
+	mem.WriteW(0o200100, 0o1234)
+	mem.WriteW(0o220100, 0o4321)
+	if v, err := cpu.ReadW(0o100); v != 0o1234 || err != nil {
+		t.Errorf("ReadW(0o100) = %06o, %v, want %06o, nil", v, err, 0o1234)
+	}
+	if v, err := cpu.ReadW(0o20100); v != 0o4321 || err != nil {
+		t.Errorf("ReadW(0o20100) = %06o, %v, want %06o, nil", v, err, 0o4321)
+	}
+	if cpu.MMU.PDR[User][0]&PDR_W != 0 {
+		t.Errorf("page 0 W bit set after read")
+	}
+	if err := cpu.WriteW(0o102, 1); err != nil {
+		t.Errorf("WriteW(0o102) = %v", err)
+	}
+	if cpu.MMU.PDR[User][0]&PDR_W == 0 {
+		t.Errorf("page 0 W bit not set after write")
+	}
+
+	// Write to read-only page.
+	if err := cpu.WriteW(0o20100, 1); err != ErrMMU {
+		t.Errorf("WriteW(0o20100) = %v, want ErrMMU", err)
+	}
+	if want := SR0_RO | SR0_EN | 3<<5 | 1<<1; cpu.MMU.SR0 != want {
+		t.Errorf("SR0 = %06o, want %06o", cpu.MMU.SR0, want)
+	}
+
+	// SR0 stays frozen until the abort bits are cleared.
+	if _, err := cpu.ReadW(0o40000); err != ErrMMU {
+		t.Errorf("ReadW(0o40000) = %v, want ErrMMU", err)
+	}
+	if want := SR0_RO | SR0_EN | 3<<5 | 1<<1; cpu.MMU.SR0 != want {
+		t.Errorf("SR0 = %06o, want %06o", cpu.MMU.SR0, want)
+	}
+	cpu.MMU.SR0 = SR0_EN
+	if _, err := cpu.ReadW(0o40000); err != ErrMMU {
+		t.Errorf("ReadW(0o40000) = %v, want ErrMMU", err)
+	}
+	if want := SR0_NR | SR0_EN | 3<<5 | 2<<1; cpu.MMU.SR0 != want {
+		t.Errorf("SR0 = %06o, want %06o", cpu.MMU.SR0, want)
+	}
+
+	// Page length: 0o100 bytes (2 blocks) upward, and downward.
+	cpu.MMU.SR0 = SR0_EN
+	cpu.MMU.PDR[User][0] = 1<<8 | PDR_RW
+	if _, err := cpu.ReadW(0o176); err != nil {
+		t.Errorf("ReadW(0o176) = %v, want nil", err)
+	}
+	if _, err := cpu.ReadW(0o200); err != ErrMMU || cpu.MMU.SR0&SR0_PL == 0 {
+		t.Errorf("ReadW(0o200) = %v, SR0=%06o, want page length abort", err, cpu.MMU.SR0)
+	}
+	cpu.MMU.SR0 = SR0_EN
+	cpu.MMU.PDR[User][0] = 0o176<<8 | PDR_RW | PDR_ED
+	if _, err := cpu.ReadW(0o17576); err != ErrMMU || cpu.MMU.SR0&SR0_PL == 0 {
+		t.Errorf("ReadW(0o17576) = %v, SR0=%06o, want page length abort", err, cpu.MMU.SR0)
+	}
+	cpu.MMU.SR0 = SR0_EN
+	if _, err := cpu.ReadW(0o17600); err != nil {
+		t.Errorf("ReadW(0o17600) = %v, want nil", err)
+	}
+}
+
+func TestMMURegisters(t *testing.T) {
+	cpu, _ := newMMUTest(t)
+
+	// Kernel page 7 maps the I/O page, so the kernel can see the MMU registers.
+	if err := cpu.WriteW(0o177640, 0o3000); err != nil { // user PAR 0
+		t.Fatal(err)
+	}
+	if cpu.MMU.PAR[User][0] != 0o3000 {
+		t.Errorf("user PAR 0 = %06o, want %06o", cpu.MMU.PAR[User][0], 0o3000)
+	}
+	if err := cpu.WriteW(0o172306, 0o1406); err != nil { // kernel PDR 3
+		t.Fatal(err)
+	}
+	if cpu.MMU.PDR[Kernel][3] != 0o1406 {
+		t.Errorf("kernel PDR 3 = %06o, want %06o", cpu.MMU.PDR[Kernel][3], 0o1406)
+	}
+	if v, err := cpu.ReadW(0o177572); v != SR0_EN || err != nil {
+		t.Errorf("read SR0 = %06o, %v, want %06o, nil", v, err, SR0_EN)
+	}
+	if _, err := cpu.ReadW(0o177000); err != ErrMem {
+		t.Errorf("read nonexistent register = %v, want ErrMem", err)
+	}
+
+	// Writing the PS switches stacks.
+	cpu.R[SP] = 0o1000
+	cpu.Stack[User] = 0o2000
+	if err := cpu.WriteW(psAddr, uint16(PS_CUR)); err != nil {
+		t.Fatal(err)
+	}
+	if cpu.PS.Mode() != User || cpu.R[SP] != 0o2000 || cpu.Stack[Kernel] != 0o1000 {
+		t.Errorf("after PS write: mode=%v sp=%06o kernel sp=%06o", cpu.PS.Mode(), cpu.R[SP], cpu.Stack[Kernel])
+	}
+}
+
+func TestMMUExec(t *testing.T) {
+	cpu, mem := newMMUTest(t)
+
+	// User program at user virtual 0 (physical 0o200000).
+	load(t, mem, 0o200000, 0,
+		"mov #123, r0",
+		"mov r0, 20000", // read-only page
+	)
+	cpu.SetPS(PS_CUR | PS_PREV)
+	cpu.R[SP] = 0o17000
+	cpu.R[PC] = 0
+	if err := cpu.Step(1); err != nil {
+		t.Fatal(err)
+	}
+	if cpu.R[0] != 0o123 || cpu.MMU.SR2 != 0 {
+		t.Errorf("r0=%06o SR2=%06o, want r0=%06o SR2=0", cpu.R[0], cpu.MMU.SR2, 0o123)
+	}
+	if err := cpu.Step(1); !errors.Is(err, ErrMMU) {
+		t.Fatalf("Step = %v, want ErrMMU", err)
+	}
+	if cpu.R[PC] != 4 || cpu.MMU.SR2 != 4 || cpu.MMU.SR0&SR0_RO == 0 {
+		t.Errorf("pc=%06o SR0=%06o SR2=%06o, want pc=4 SR0 read-only abort SR2=4", cpu.R[PC], cpu.MMU.SR0, cpu.MMU.SR2)
+	}
+	cpu.MMU.SR0 = SR0_EN
+
+	// Kernel code moving words to and from user space.
+	load(t, mem, 0o10000, 0o10000,
+		"mov #100, r1",
+		"mfpi (r1)",
+		"mov #4444, -(sp)",
+		"mtpd 2(r1)",
+		"mfpd sp",
+	)
+	mem.WriteW(0o200100, 0o7777)
+	cpu.SetPS(PS_PREV) // kernel mode, previous user
+	cpu.R[SP] = 0o1000
+	cpu.R[PC] = 0o10000
+	if err := cpu.Step(5); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := mem.ReadW(0o776); v != 0o7777 {
+		t.Errorf("mfpi pushed %06o, want %06o", v, 0o7777)
+	}
+	if v, _ := mem.ReadW(0o200102); v != 0o4444 {
+		t.Errorf("mtpd wrote %06o, want %06o", v, 0o4444)
+	}
+	if v, _ := mem.ReadW(0o774); cpu.R[SP] != 0o774 || v != 0o17000 {
+		t.Errorf("mfpd sp: sp=%06o pushed %06o, want sp=%06o pushed %06o", cpu.R[SP], v, 0o774, 0o17000)
+	}
+}
+
+// A word access at an odd address is a bus error even with the MMU,
+// and it is reported before translation, leaving SR0 alone.
+func TestMMUOdd(t *testing.T) {
+	cpu, mem := newMMUTest(t)
+	load(t, mem, 0o200000, 0,
+		"mov #101, r1",
+		"tst (r1)",
+	)
+	cpu.SetPS(PS_CUR | PS_PREV)
+	cpu.TrapMode = TrapError
+	if _, err := cpu.ReadW(0o101); !errors.Is(err, ErrMem) {
+		t.Errorf("ReadW(0o101) = %v, want ErrMem", err)
+	}
+	if err := cpu.WriteW(0o101, 1); !errors.Is(err, ErrMem) {
+		t.Errorf("WriteW(0o101) = %v, want ErrMem", err)
+	}
+	if err := cpu.WriteW(0o20101, 1); !errors.Is(err, ErrMem) {
+		t.Errorf("WriteW(0o20101) = %v, want ErrMem", err)
+	}
+	err := cpu.Step(2)
+	tr, ok := err.(*Trap)
+	if !ok || tr.Err != ErrMem || !tr.Odd || tr.Addr != 0o101 || tr.Vector != 0o4 {
+		t.Fatalf("Step = %v, want odd address ErrMem at 000101", err)
+	}
+	if cpu.MMU.SR0 != SR0_EN {
+		t.Errorf("SR0 = %06o, want %06o", cpu.MMU.SR0, SR0_EN)
+	}
+}
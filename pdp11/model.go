@@ -0,0 +1,99 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pdp11
+
+import "strings"
+
+// A Model is a PDP-11 processor model,
+// which determines the instructions the CPU implements.
+// Executing an instruction the model does not implement
+// is a reserved instruction trap (ErrInst), as on the real machine.
+//
+// A CPU with a nil Model implements every instruction the simulator knows.
+type Model struct {
+	Name string
+	Opts Option
+}
+
+// An Option is an instruction set option.
+// Some options were standard on one model and optional on another;
+// for example, the EIS was optional on the 11/40 but standard on the 11/45.
+type Option uint16
+
+const (
+	// Opt40 is the set of instructions added to the 11/20 instruction set
+	// by the 11/35 and 11/40: sob, xor, sxt, mark, rtt, mfpi, and mtpi.
+	Opt40 Option = 1 << iota
+
+	// Opt45 is the set of instructions added by the 11/45: spl, mfpd, and mtpd.
+	// It also enables the full MMU, with supervisor mode and
+	// separate instruction and data space (see SR3).
+	Opt45
+
+	// Opt22 enables 22-bit physical addressing in the MMU.
+	Opt22
+
+	// OptEIS is the extended instruction set: mul, div, ash, and ashc.
+	OptEIS
+
+	// OptFIS is the floating instruction set: fadd, fsub, fmul, and fdiv.
+	OptFIS
+
+	// OptFP11 is the FP11 floating point processor.
+	OptFP11
+)
+
+// Standard models. The 11/40 is configured with the EIS,
+// as it must be to run Unix, and the FIS.
+var (
+	PDP1120 = &Model{"PDP-11/20", 0}
+	PDP1140 = &Model{"PDP-11/40", Opt40 | OptEIS | OptFIS}
+	PDP1145 = &Model{"PDP-11/45", Opt40 | Opt45 | OptEIS | OptFP11}
+	PDP1170 = &Model{"PDP-11/70", Opt40 | Opt45 | Opt22 | OptEIS | OptFP11}
+)
+
+// opOption maps instruction mnemonics to the option they require.
+var opOption = map[string]Option{
+	"sob": Opt40, "xor": Opt40, "sxt": Opt40, "mark": Opt40, "rtt": Opt40,
+	"mfpi": Opt40, "mtpi": Opt40,
+	"spl": Opt45, "mfpd": Opt45, "mtpd": Opt45,
+	"mul": OptEIS, "div": OptEIS, "ash": OptEIS, "ashc": OptEIS,
+	"fadd": OptFIS, "fsub": OptFIS, "fmul": OptFIS, "fdiv": OptFIS,
+}
+
+// iopt is the option required by each itab entry.
+var iopt []Option
+
+func init() {
+	iopt = make([]Option, len(itab))
+	for i, inst := range itab {
+		op, _, _ := strings.Cut(inst.text, " ")
+		iopt[i] = opOption[op]
+		if inst.code >= 0o170000 {
+			iopt[i] = OptFP11
+		}
+	}
+}
+
+// has reports whether the CPU's model implements the options opts.
+func (cpu *CPU) has(opts Option) bool {
+	return cpu.Model == nil || cpu.Model.Opts&opts == opts
+}
+
+// mmuReg reports whether the MMU register at I/O page address a
+// exists on the CPU's model. Before the 11/45, the MMU had
+// no SR3, no supervisor mode, and no separate data space.
+func (cpu *CPU) mmuReg(a uint16) bool {
+	if cpu.has(Opt45) {
+		return true
+	}
+	if a == sr3Addr {
+		return false
+	}
+	if mode, page, _, ok := pageReg(a); ok && (mode == Supervisor || page >= 8) {
+		return false
+	}
+	return true
+}
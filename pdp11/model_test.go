@@ -0,0 +1,81 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pdp11
+
+import (
+	"errors"
+	"testing"
+)
+
+var modelTests = []struct {
+	inst   string
+	models []*Model // models implementing inst
+}{
+	{"mov r1, r0", []*Model{PDP1120, PDP1140, PDP1145, PDP1170}},
+	{"sxt r0", []*Model{PDP1140, PDP1145, PDP1170}},
+	{"xor r1, r0", []*Model{PDP1140, PDP1145, PDP1170}},
+	{"mul r1, r0", []*Model{PDP1140, PDP1145, PDP1170}},
+	{"mfpd r0", []*Model{PDP1145, PDP1170}},
+	{"setd", []*Model{PDP1145, PDP1170}},
+}
+
+func TestModel(t *testing.T) {
+	for _, tt := range modelTests {
+		for _, m := range []*Model{PDP1120, PDP1140, PDP1145, PDP1170} {
+			want := error(ErrInst)
+			for _, mm := range tt.models {
+				if m == mm {
+					want = nil
+				}
+			}
+			cpu, _ := newTrapTest(t, tt.inst)
+			cpu.TrapMode = TrapError
+			cpu.Model = m
+			if err := cpu.Step(1); !errors.Is(err, want) {
+				t.Errorf("%s: %s: Step = %v, want %v", m.Name, tt.inst, err, want)
+			}
+		}
+	}
+}
+
+// In TrapVector mode, an instruction the model lacks traps
+// through vector 10 with the PC past the instruction,
+// so that the trap handler can find and emulate it.
+func TestModelTrapVector(t *testing.T) {
+	cpu, mem := newTrapTest(t, "mul r1, r0")
+	cpu.Model = PDP1120
+	if err := cpu.Step(1); err != nil {
+		t.Fatal(err)
+	}
+	if pc, _ := mem.ReadW(0o674); cpu.R[PC] != 0o2010 || pc != 0o1002 {
+		t.Errorf("pc=%06o pushed pc=%06o, want %06o, %06o", cpu.R[PC], pc, 0o2010, 0o1002)
+	}
+}
+
+func TestModelMMU(t *testing.T) {
+	cpu, _ := newMMUTest(t)
+	cpu.Model = PDP1140
+	if _, err := cpu.ReadW(sr3Addr); err != ErrMem {
+		t.Errorf("11/40: read SR3 = %v, want ErrMem", err)
+	}
+	if _, err := cpu.ReadW(superRegs); err != ErrMem {
+		t.Errorf("11/40: read supervisor PDR 0 = %v, want ErrMem", err)
+	}
+	if _, err := cpu.ReadW(userRegs + 0o20); err != ErrMem {
+		t.Errorf("11/40: read user D-space PDR 0 = %v, want ErrMem", err)
+	}
+	if _, err := cpu.ReadW(userRegs); err != nil {
+		t.Errorf("11/40: read user PDR 0 = %v, want nil", err)
+	}
+
+	cpu.Model = PDP1145
+	if err := cpu.WriteW(sr3Addr, SR3_22|SR3_UD); err != nil || cpu.MMU.SR3 != SR3_UD {
+		t.Errorf("11/45: write SR3: %v, SR3=%06o, want nil, %06o", err, cpu.MMU.SR3, SR3_UD)
+	}
+	cpu.Model = PDP1170
+	if err := cpu.WriteW(sr3Addr, SR3_UD); err != nil || cpu.MMU.SR3 != SR3_UD {
+		t.Errorf("11/70: write SR3: %v, SR3=%06o, want nil, %06o", err, cpu.MMU.SR3, SR3_UD)
+	}
+}
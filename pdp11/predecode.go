@@ -0,0 +1,278 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pdp11
+
+// A predecoded instruction is an instruction word decoded for execution.
+type predecoded struct {
+	do   func(cpu *CPU) // handler specialized for the word's addressing modes
+	slow func(cpu *CPU) // general handler, used when tracing
+	op   uint8          // index of instruction in itab
+}
+
+// ptab holds the predecoded form of every instruction word.
+//
+// Decoding depends only on the instruction word, so ptab is indexed
+// by the word rather than by the address it was fetched from.
+// That way the table can never go stale: self-modifying code,
+// exec, and overlays need no invalidation, because changing the
+// code at an address changes the word fetched from it.
+var ptab [1 << 16]predecoded
+
+// predecode fills in ptab. It must run after xtab is initialized.
+func predecode() {
+	for w := range ptab {
+		i := xtab[w]
+		p := &ptab[w]
+		p.op = i
+		p.slow = itab[i].do
+		p.do = specialize(uint16(w))
+		if p.do == nil {
+			p.do = p.slow
+		}
+	}
+}
+
+// specialize returns a handler for the instruction w
+// specialized for its addressing modes, or nil if there is none.
+//
+// The specialized handlers access register and immediate operands
+// directly instead of going through addr, readW, and writeW,
+// so that the operand decoding is done once, here, instead of
+// every time the instruction runs.
+// They do not record operands for the tracer,
+// so run uses the general handler when tracing.
+func specialize(w uint16) func(cpu *CPU) {
+	sreg := w&0o7000 == 0      // source is mode 0 (double operand instructions)
+	simm := w&0o7700 == 0o2700 // source is immediate (mode 2 on the PC)
+	dreg := w&0o70 == 0        // destination is mode 0
+	if dreg {
+		// pick returns the handler for source mode 0,
+		// immediate source, or any other source.
+		pick := func(r, i, x func(*CPU)) func(*CPU) {
+			switch {
+			case sreg:
+				return r
+			case simm:
+				return i
+			}
+			return x
+		}
+		switch itab[xtab[w]].code {
+		case 0o010000:
+			return pick(xmovRR, xmovIR, xmovR)
+		case 0o110000:
+			// An immediate byte source is a byte read,
+			// which the general handler does.
+			return pick(xmovbRR, xmovbR, xmovbR)
+		case 0o020000:
+			return pick(xcmpRR, xcmpIR, xcmpR)
+		case 0o030000:
+			return pick(xbitRR, xbitIR, xbitR)
+		case 0o040000:
+			return pick(xbicRR, xbicIR, xbicR)
+		case 0o050000:
+			return pick(xbisRR, xbisIR, xbisR)
+		case 0o060000:
+			return pick(xaddRR, xaddIR, xaddR)
+		case 0o160000:
+			return pick(xsubRR, xsubIR, xsubR)
+		}
+	} else if sreg && itab[xtab[w]].code == 0o010000 {
+		return xmovS
+	}
+	if !dreg {
+		return nil
+	}
+	switch itab[xtab[w]].code {
+	case 0o005000:
+		return xclrR
+	case 0o005100:
+		return xcomR
+	case 0o005200:
+		return xincR
+	case 0o005300:
+		return xdecR
+	case 0o005400:
+		return xnegR
+	case 0o005700:
+		return xtstR
+	case 0o006200:
+		return xasrR
+	case 0o006300:
+		return xaslR
+	}
+	return nil
+}
+
+// dstR returns the destination register of a mode 0 destination operand.
+func (cpu *CPU) dstR() *uint16 { return &cpu.R[cpu.Inst&07] }
+
+// srcR returns the value of a mode 0 source operand.
+func (cpu *CPU) srcR() uint16 { return cpu.R[cpu.Inst>>6&07] }
+
+// srcI returns the value of an immediate source operand,
+// the word following the instruction.
+func (cpu *CPU) srcI() uint16 {
+	pc := cpu.R[PC]
+	cpu.R[PC] = pc + 2
+	if cpu.MMU != nil && cpu.fault == nil {
+		cpu.MMU.noteReg(PC, 2)
+	}
+	return cpu.readW(addr(pc) | addrI)
+}
+
+// Operations on a mode 0 destination.
+// The source operand must be read before calling them:
+// reading it may update the destination register.
+
+func opMov(cpu *CPU, src uint16, r *uint16) {
+	*r = src
+	cpu.PS.SetV(false)
+	cpu.PS.setNZ(src)
+}
+
+func opMovb(cpu *CPU, src uint8, r *uint16) {
+	*r = uint16(int8(src)) // sign-extend
+	cpu.PS.SetV(false)
+	cpu.PS.setNZB(src)
+}
+
+func opCmp(cpu *CPU, src uint16, r *uint16) {
+	dst := *r
+	cpu.subFlags(src, dst, uint32(src)-uint32(dst))
+}
+
+func opBit(cpu *CPU, src uint16, r *uint16) {
+	cpu.PS.setNZ(*r & src)
+	cpu.PS.SetV(false)
+}
+
+func opBic(cpu *CPU, src uint16, r *uint16) {
+	*r &^= src
+	cpu.PS.setNZ(*r)
+	cpu.PS.SetV(false)
+}
+
+func opBis(cpu *CPU, src uint16, r *uint16) {
+	*r |= src
+	cpu.PS.setNZ(*r)
+	cpu.PS.SetV(false)
+}
+
+func opAdd(cpu *CPU, src uint16, r *uint16) {
+	dst := *r
+	out := uint32(src) + uint32(dst)
+	*r = uint16(out)
+	cpu.addFlags(src, dst, out)
+}
+
+func opSub(cpu *CPU, src uint16, r *uint16) {
+	dst := *r
+	out := uint32(dst) - uint32(src)
+	*r = uint16(out)
+	cpu.subFlags(dst, src, out)
+}
+
+// Handlers for double operand instructions with a mode 0 destination
+// and a general (xopR), mode 0 (xopRR), or immediate (xopIR) source.
+
+func xmovR(cpu *CPU)  { opMov(cpu, cpu.srcW(), cpu.dstR()) }
+func xmovRR(cpu *CPU) { opMov(cpu, cpu.srcR(), cpu.dstR()) }
+func xmovIR(cpu *CPU) { opMov(cpu, cpu.srcI(), cpu.dstR()) }
+
+func xmovbR(cpu *CPU)  { opMovb(cpu, cpu.srcB(), cpu.dstR()) }
+func xmovbRR(cpu *CPU) { opMovb(cpu, uint8(cpu.srcR()), cpu.dstR()) }
+
+func xcmpR(cpu *CPU)  { opCmp(cpu, cpu.srcW(), cpu.dstR()) }
+func xcmpRR(cpu *CPU) { opCmp(cpu, cpu.srcR(), cpu.dstR()) }
+func xcmpIR(cpu *CPU) { opCmp(cpu, cpu.srcI(), cpu.dstR()) }
+
+func xbitR(cpu *CPU)  { opBit(cpu, cpu.srcW(), cpu.dstR()) }
+func xbitRR(cpu *CPU) { opBit(cpu, cpu.srcR(), cpu.dstR()) }
+func xbitIR(cpu *CPU) { opBit(cpu, cpu.srcI(), cpu.dstR()) }
+
+func xbicR(cpu *CPU)  { opBic(cpu, cpu.srcW(), cpu.dstR()) }
+func xbicRR(cpu *CPU) { opBic(cpu, cpu.srcR(), cpu.dstR()) }
+func xbicIR(cpu *CPU) { opBic(cpu, cpu.srcI(), cpu.dstR()) }
+
+func xbisR(cpu *CPU)  { opBis(cpu, cpu.srcW(), cpu.dstR()) }
+func xbisRR(cpu *CPU) { opBis(cpu, cpu.srcR(), cpu.dstR()) }
+func xbisIR(cpu *CPU) { opBis(cpu, cpu.srcI(), cpu.dstR()) }
+
+func xaddR(cpu *CPU)  { opAdd(cpu, cpu.srcW(), cpu.dstR()) }
+func xaddRR(cpu *CPU) { opAdd(cpu, cpu.srcR(), cpu.dstR()) }
+func xaddIR(cpu *CPU) { opAdd(cpu, cpu.srcI(), cpu.dstR()) }
+
+func xsubR(cpu *CPU)  { opSub(cpu, cpu.srcW(), cpu.dstR()) }
+func xsubRR(cpu *CPU) { opSub(cpu, cpu.srcR(), cpu.dstR()) }
+func xsubIR(cpu *CPU) { opSub(cpu, cpu.srcI(), cpu.dstR()) }
+
+// Handlers for single operand instructions with a mode 0 destination.
+
+func xclrR(cpu *CPU) {
+	*cpu.dstR() = 0
+	cpu.PS = cpu.PS&^0o17 | PS_Z
+}
+
+func xcomR(cpu *CPU) {
+	r := cpu.dstR()
+	*r = ^*r
+	cpu.PS = cpu.PS&^0o17 | PS_C
+	cpu.PS.setNZ(*r)
+}
+
+func xincR(cpu *CPU) {
+	r := cpu.dstR()
+	*r++
+	cpu.PS.SetV(*r == 0o100000)
+	cpu.PS.setNZ(*r)
+}
+
+func xdecR(cpu *CPU) {
+	r := cpu.dstR()
+	*r--
+	cpu.PS.SetV(*r == 0o077777)
+	cpu.PS.setNZ(*r)
+}
+
+func xnegR(cpu *CPU) {
+	r := cpu.dstR()
+	*r = -*r
+	cpu.PS.SetC(*r != 0)
+	cpu.PS.SetV(*r == 0o100000)
+	cpu.PS.setNZ(*r)
+}
+
+func xtstR(cpu *CPU) {
+	cpu.PS.SetC(false)
+	cpu.PS.SetV(false)
+	cpu.PS.setNZ(*cpu.dstR())
+}
+
+func xasrR(cpu *CPU) {
+	r := cpu.dstR()
+	old := *r
+	*r = uint16(int16(old) >> 1)
+	cpu.PS.SetC(old&1 != 0)
+	cpu.PS.setNZ(*r)
+	setVxor(cpu)
+}
+
+func xaslR(cpu *CPU) {
+	r := cpu.dstR()
+	old := *r
+	*r = old << 1
+	cpu.PS.SetC(old>>15 != 0)
+	cpu.PS.setNZ(*r)
+	setVxor(cpu)
+}
+
+// xmovS is mov with a mode 0 source.
+func xmovS(cpu *CPU) {
+	src := cpu.R[cpu.regArg()]
+	cpu.writeW(cpu.dstAddrW(), src)
+	cpu.PS.SetV(false)
+	cpu.PS.setNZ(src)
+}
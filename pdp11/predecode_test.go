@@ -0,0 +1,123 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pdp11
+
+import (
+	"errors"
+	"maps"
+	"math/rand"
+	"testing"
+)
+
+// A sparseMem is a Memory that records writes
+// over fixed pseudo-random contents.
+type sparseMem map[uint16]uint8
+
+func (m sparseMem) ReadB(a uint16) (uint8, error) {
+	if v, ok := m[a]; ok {
+		return v, nil
+	}
+	return uint8(a*0o123 + a>>8), nil
+}
+
+func (m sparseMem) ReadW(a uint16) (uint16, error) {
+	lo, _ := m.ReadB(a)
+	hi, _ := m.ReadB(a + 1)
+	return uint16(lo) | uint16(hi)<<8, nil
+}
+
+func (m sparseMem) WriteB(a uint16, v uint8) error {
+	m[a] = v
+	return nil
+}
+
+func (m sparseMem) WriteW(a uint16, v uint16) error {
+	m[a] = uint8(v)
+	m[a+1] = uint8(v >> 8)
+	return nil
+}
+
+// The specialized handlers must behave like the general ones.
+func TestPredecode(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	n := 0
+	for w := 0; w < 1<<16; w++ {
+		p := &ptab[w]
+		if p.op != xtab[w] {
+			t.Fatalf("ptab[%06o].op = %d, want %d", w, p.op, xtab[w])
+		}
+		if specialize(uint16(w)) == nil {
+			continue
+		}
+		n++
+		for i := 0; i < 4; i++ {
+			mem1, mem2 := make(sparseMem), make(sparseMem)
+			cpu1 := &CPU{Mem: mem1, Inst: uint16(w)}
+			for j := range cpu1.R {
+				cpu1.R[j] = uint16(r.Intn(1 << 16))
+			}
+			cpu1.PS = PS(r.Intn(0o20))
+			cpu2 := *cpu1
+			cpu2.Mem = mem2
+			p.do(cpu1)
+			p.slow(&cpu2)
+			if cpu1.R != cpu2.R || cpu1.PS != cpu2.PS || !maps.Equal(mem1, mem2) {
+				t.Fatalf("%06o: specialized handler: R=%06o PS=%06o, want R=%06o PS=%06o", w, cpu1.R, cpu1.PS, cpu2.R, cpu2.PS)
+			}
+		}
+	}
+	if n == 0 {
+		t.Fatalf("no specialized handlers")
+	}
+}
+
+// Code that modifies itself executes the new instructions.
+func TestSelfModify(t *testing.T) {
+	cpu, mem := newTrapTest(t,
+		"mov #5200, 1010", // 1000: change the clr r0 at 1010 to inc r0
+		"clr r1",          // 1006
+		"clr r0",          // 1010
+		"inc r1",          // 1012
+		"cmp r1, #3",      // 1014
+		"bne 1010",        // 1020
+		"halt",            // 1022
+	)
+	cpu.TrapMode = TrapError
+	cpu.Step(100)
+	if cpu.R[0] != 3 || cpu.R[1] != 3 {
+		t.Fatalf("r0, r1 = %d, %d, want 3, 3", cpu.R[0], cpu.R[1])
+	}
+
+	// Rewrite the code from outside the CPU and run it again.
+	mem.WriteW(0o1010, 0o005300) // dec r0
+	cpu.R[PC] = 0o1006
+	cpu.Step(100)
+	if cpu.R[0] != 0 || cpu.R[1] != 3 {
+		t.Fatalf("after rewrite: r0, r1 = %d, %d, want 0, 3", cpu.R[0], cpu.R[1])
+	}
+}
+
+// BenchmarkStep measures the execution of a loop of common instructions.
+func BenchmarkStep(b *testing.B) {
+	cpu, _ := newTrapTest(b,
+		"mov #1750, r1",     // 1000: 1000 iterations
+		"clr r0",            // 1004
+		"add r1, r0",        // 1006
+		"mov r0, r2",        // 1010
+		"asl r2",            // 1012
+		"bic #177400, r2",   // 1014
+		"movb r2, 4000(r1)", // 1020
+		"cmp r2, r0",        // 1024
+		"dec r1",            // 1026
+		"bne 1006",          // 1030
+		"halt",              // 1032
+	)
+	for i := 0; i < b.N; i++ {
+		cpu.R[PC] = 0o1000
+		if err := cpu.Step(1e6); !errors.Is(err, ErrHalt) {
+			b.Fatalf("Step: %v", err)
+		}
+	}
+}
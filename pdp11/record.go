@@ -0,0 +1,342 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pdp11
+
+import "fmt"
+
+// Recording keeps a history of the changes each instruction makes
+// to the CPU registers and to memory, so that execution can be
+// run backward (StepBack) and then forward again (Seek).
+//
+// The history divides execution into steps, one per instruction,
+// each running from the start of one instruction to the start of the next.
+// A step includes any trap or interrupt taken after its instruction
+// and any changes the caller makes to the CPU registers between calls to Step.
+// It does not include changes the caller makes to memory directly
+// (not through the CPU), unless the caller reports them with NoteWrite,
+// and it does not include the state of
+// the MMU or of I/O devices, which stepping backward leaves unchanged.
+
+// ErrHistory is returned by StepBack and Seek for a request
+// to move outside the recorded history.
+var ErrHistory = fmt.Errorf("instruction not in recorded history")
+
+// A history is a CPU's recorded execution history.
+type history struct {
+	steps   []histStep
+	changes []change
+	pos     int      // current position in steps; < len(steps) after moving backward
+	base    uint64   // instruction count of steps[0]
+	limit   int      // maximum number of steps to keep, or 0 for no limit
+	regs    regState // register state at the start of the current step
+}
+
+// A histStep is a single recorded step.
+type histStep struct {
+	start uint32 // index of first change in history.changes
+	pc    uint16 // address of instruction
+}
+
+// A change is a single recorded change to a register or memory location.
+type change struct {
+	kind     changeKind
+	addr     uint32 // memory address or register index
+	old, new uint16
+}
+
+type changeKind uint8
+
+const (
+	chR     changeKind = iota // R[addr]
+	chStack                   // Stack[addr]
+	chF                       // word addr%4 of F[addr/4]
+	chPS
+	chFPS
+	chFEC
+	chFEA
+	chInst
+	chMem   // word in Mem
+	chIMem  // word in IMem
+	chPhys  // word in MMU.Mem
+	chMemB  // byte in Mem
+	chIMemB // byte in IMem
+	chPhysB // byte in MMU.Mem
+)
+
+// Record starts recording the CPU's execution history,
+// discarding any previous history.
+// The history keeps at least the most recent limit instructions,
+// or every instruction if limit is 0.
+func (cpu *CPU) Record(limit int) {
+	cpu.hist = &history{limit: limit, regs: cpu.regState()}
+}
+
+// StopRecording stops recording and discards the history.
+func (cpu *CPU) StopRecording() {
+	cpu.hist = nil
+}
+
+// NoteWrite records in the history that the caller changed
+// the byte at addr in Mem from old to new directly, not through the CPU,
+// making the change part of the current step.
+// For example, an operating system emulator can report the bytes
+// a system call stores into user memory, so that stepping backward
+// across the system call undoes them.
+// NoteWrite does nothing if the CPU is not recording.
+func (cpu *CPU) NoteWrite(addr uint16, old, new uint8) {
+	if cpu.hist != nil && old != new {
+		cpu.hist.write(chMemB, uint32(addr), uint16(old), uint16(new))
+	}
+}
+
+// InstCount returns the number of instructions executed since recording started,
+// or, after StepBack or Seek, the count at the restored point.
+// It returns 0 if the CPU is not recording.
+func (cpu *CPU) InstCount() uint64 {
+	h := cpu.hist
+	if h == nil {
+		return 0
+	}
+	return h.base + uint64(h.pos)
+}
+
+// StepBack undoes the last n recorded instructions,
+// restoring the registers and memory to their state before the first of them.
+// If the history does not go back that far, StepBack undoes nothing
+// and returns ErrHistory.
+func (cpu *CPU) StepBack(n int) error {
+	if cpu.hist == nil || n < 0 || n > cpu.hist.pos {
+		return ErrHistory
+	}
+	return cpu.Seek(cpu.InstCount() - uint64(n))
+}
+
+// Seek moves the CPU to its recorded state just before executing
+// the instruction with the given count (see InstCount),
+// undoing or replaying recorded instructions as needed.
+// Replaying reapplies the recorded changes instead of executing
+// the instructions again, so it reproduces the recorded execution exactly.
+// Calling Step after moving backward discards the history after
+// the current point and records the new execution in its place.
+func (cpu *CPU) Seek(count uint64) error {
+	h := cpu.hist
+	if h == nil || count < h.base || count-h.base > uint64(len(h.steps)) {
+		return ErrHistory
+	}
+	h.flush(cpu)
+	target := int(count - h.base)
+	for h.pos > target {
+		h.pos--
+		for i := h.end(h.pos) - 1; i >= int(h.steps[h.pos].start); i-- {
+			cpu.apply(&h.changes[i], h.changes[i].old)
+		}
+	}
+	for h.pos < target {
+		for i := int(h.steps[h.pos].start); i < h.end(h.pos); i++ {
+			cpu.apply(&h.changes[i], h.changes[i].new)
+		}
+		h.pos++
+	}
+	h.regs = cpu.regState()
+	return nil
+}
+
+// LastWrite returns the count (see InstCount) and PC of the
+// most recent recorded instruction before the current point
+// that wrote to the memory byte at addr, an address in Mem,
+// or in MMU.Mem when using the MMU.
+// If there is no such instruction, LastWrite returns ok == false.
+func (cpu *CPU) LastWrite(addr uint32) (count uint64, pc uint16, ok bool) {
+	h := cpu.hist
+	if h == nil {
+		return 0, 0, false
+	}
+	h.flush(cpu)
+	for s := h.pos - 1; s >= 0; s-- {
+		for i := h.end(s) - 1; i >= int(h.steps[s].start); i-- {
+			c := &h.changes[i]
+			switch c.kind {
+			case chMem, chPhys:
+				ok = c.addr == addr || c.addr+1 == addr
+			case chMemB, chPhysB:
+				ok = c.addr == addr
+			}
+			if ok {
+				return h.base + uint64(s), h.steps[s].pc, true
+			}
+		}
+	}
+	return 0, 0, false
+}
+
+// end returns the index just past the changes for step s.
+func (h *history) end(s int) int {
+	if s+1 < len(h.steps) {
+		return int(h.steps[s+1].start)
+	}
+	return len(h.changes)
+}
+
+// apply sets the register or memory location changed by c to val.
+func (cpu *CPU) apply(c *change, val uint16) {
+	switch c.kind {
+	case chR:
+		cpu.R[c.addr] = val
+	case chStack:
+		cpu.Stack[c.addr] = val
+	case chF:
+		shift := 48 - 16*(c.addr%4)
+		f := &cpu.F[c.addr/4]
+		*f = *f&^(0xffff<<shift) | Float(val)<<shift
+	case chPS:
+		cpu.PS = PS(val)
+	case chFPS:
+		cpu.FPS = FPS(val)
+	case chFEC:
+		cpu.FEC = uint8(val)
+	case chFEA:
+		cpu.FEA = val
+	case chInst:
+		cpu.Inst = val
+	case chMem:
+		cpu.Mem.WriteW(uint16(c.addr), val)
+	case chIMem:
+		cpu.IMem.WriteW(uint16(c.addr), val)
+	case chPhys:
+		cpu.MMU.Mem.WriteW(c.addr, val)
+	case chMemB:
+		cpu.Mem.WriteB(uint16(c.addr), uint8(val))
+	case chIMemB:
+		cpu.IMem.WriteB(uint16(c.addr), uint8(val))
+	case chPhysB:
+		cpu.MMU.Mem.WriteB(c.addr, uint8(val))
+	}
+}
+
+// begin starts recording a new step for the instruction at pc.
+func (h *history) begin(cpu *CPU, pc uint16) {
+	h.truncate()
+	h.flush(cpu)
+	if h.limit > 0 && len(h.steps) >= 2*h.limit {
+		h.trim(len(h.steps) - h.limit)
+	}
+	h.steps = append(h.steps, histStep{uint32(len(h.changes)), pc})
+	h.pos = len(h.steps)
+}
+
+// trim discards the first n steps of the history.
+func (h *history) trim(n int) {
+	start := h.steps[n].start
+	h.changes = h.changes[:copy(h.changes, h.changes[start:])]
+	h.steps = h.steps[:copy(h.steps, h.steps[n:])]
+	for i := range h.steps {
+		h.steps[i].start -= start
+	}
+	h.base += uint64(n)
+	h.pos -= n
+}
+
+// truncate discards the history after the current point,
+// before recording new changes there.
+func (h *history) truncate() {
+	if h.pos < len(h.steps) {
+		h.changes = h.changes[:h.steps[h.pos].start]
+		h.steps = h.steps[:h.pos]
+	}
+}
+
+// flush records the register changes since the start of the current step.
+// After moving backward, there is no current step, and flush does nothing.
+func (h *history) flush(cpu *CPU) {
+	if h.pos < len(h.steps) {
+		return
+	}
+	regs := cpu.regState()
+	if len(h.steps) > 0 && regs != h.regs {
+		old := &h.regs
+		for i := range regs.R {
+			h.reg(chR, uint32(i), old.R[i], regs.R[i])
+		}
+		for i := range regs.Stack {
+			h.reg(chStack, uint32(i), old.Stack[i], regs.Stack[i])
+		}
+		for i := range regs.F {
+			for w := uint32(0); w < 4; w++ {
+				shift := 48 - 16*w
+				h.reg(chF, uint32(i)*4+w, uint16(old.F[i]>>shift), uint16(regs.F[i]>>shift))
+			}
+		}
+		h.reg(chPS, 0, uint16(old.PS), uint16(regs.PS))
+		h.reg(chFPS, 0, uint16(old.FPS), uint16(regs.FPS))
+		h.reg(chFEC, 0, uint16(old.FEC), uint16(regs.FEC))
+		h.reg(chFEA, 0, old.FEA, regs.FEA)
+		h.reg(chInst, 0, old.Inst, regs.Inst)
+	}
+	h.regs = regs
+}
+
+// reg records a register change, if old != new.
+func (h *history) reg(kind changeKind, addr uint32, old, new uint16) {
+	if old != new {
+		h.changes = append(h.changes, change{kind, addr, old, new})
+	}
+}
+
+// write records a memory write.
+// Writes before the first recorded instruction are not recorded.
+func (h *history) write(kind changeKind, addr uint32, old, new uint16) {
+	h.truncate()
+	if len(h.steps) > 0 {
+		h.changes = append(h.changes, change{kind, addr, old, new})
+	}
+}
+
+// writeW writes the word val to m at a, recording the change as kind.
+func (h *history) writeW(m Memory, kind changeKind, a, val uint16) error {
+	old, err := m.ReadW(a)
+	if err == nil {
+		err = m.WriteW(a, val)
+	}
+	if err == nil {
+		h.write(kind, uint32(a), old, val)
+	}
+	return err
+}
+
+// writeB writes the byte val to m at a, recording the change as kind.
+func (h *history) writeB(m Memory, kind changeKind, a uint16, val uint8) error {
+	old, err := m.ReadB(a)
+	if err == nil {
+		err = m.WriteB(a, val)
+	}
+	if err == nil {
+		h.write(kind, uint32(a), uint16(old), uint16(val))
+	}
+	return err
+}
+
+// physWriteW writes the word val to physical memory m at a, recording the change.
+func (h *history) physWriteW(m PhysMemory, a uint32, val uint16) error {
+	old, err := m.ReadW(a)
+	if err == nil {
+		err = m.WriteW(a, val)
+	}
+	if err == nil {
+		h.write(chPhys, a, old, val)
+	}
+	return err
+}
+
+// physWriteB writes the byte val to physical memory m at a, recording the change.
+func (h *history) physWriteB(m PhysMemory, a uint32, val uint8) error {
+	old, err := m.ReadB(a)
+	if err == nil {
+		err = m.WriteB(a, val)
+	}
+	if err == nil {
+		h.write(chPhysB, a, uint16(old), uint16(val))
+	}
+	return err
+}
@@ -0,0 +1,113 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pdp11
+
+import (
+	"errors"
+	"testing"
+)
+
+// recordProg copies a counter into a table, with an emt each time around.
+// In TrapVector mode, the emt handler (at 2030) is an rti;
+// in TrapError mode, the test skips over the emt.
+var recordProg = []string{
+	"mov #3000, r1",
+	"mov #10, r2",
+	"mov r2, (r1)+", // 1010
+	"movb r2, 100(r1)",
+	"emt 0",
+	"sob r2, 1010",
+	"halt",
+}
+
+type recordState struct {
+	regs regState
+	mem  ArrayMem
+}
+
+func TestRecord(t *testing.T) {
+	for _, mode := range []TrapMode{TrapError, TrapVector} {
+		cpu, mem := newTrapTest(t, recordProg...)
+		mem.WriteW(0o2030, 0o000002) // rti
+		cpu.TrapMode = mode
+		cpu.Record(0)
+
+		// Run, saving the state before each instruction.
+		var states []recordState
+		for {
+			states = append(states, recordState{cpu.regState(), *mem})
+			err := cpu.Step(1)
+			if errors.Is(err, ErrEMT) {
+				cpu.R[PC] += 2 // skip over emt
+				continue
+			}
+			if err != nil {
+				break
+			}
+		}
+		if n := cpu.InstCount(); n != uint64(len(states)) {
+			t.Fatalf("mode %d: InstCount = %d, want %d", mode, n, len(states))
+		}
+		end := recordState{cpu.regState(), *mem}
+
+		check := func(what string, want *recordState) {
+			t.Helper()
+			if cpu.regState() != want.regs {
+				t.Fatalf("mode %d: %s: regs = %+v, want %+v", mode, what, cpu.regState(), want.regs)
+			}
+			if *mem != want.mem {
+				t.Fatalf("mode %d: %s: memory differs", mode, what)
+			}
+		}
+
+		for i := len(states) - 1; i >= 0; i-- {
+			if err := cpu.StepBack(1); err != nil {
+				t.Fatal(err)
+			}
+			check("step back", &states[i])
+		}
+		if err := cpu.StepBack(1); err != ErrHistory {
+			t.Fatalf("mode %d: StepBack at start = %v, want ErrHistory", mode, err)
+		}
+		if err := cpu.Seek(uint64(len(states))); err != nil {
+			t.Fatal(err)
+		}
+		check("replay", &end)
+
+		// Find the last write to the table entry at 3002 and run from there.
+		count, pc, ok := cpu.LastWrite(0o3003)
+		if !ok || pc != 0o1010 {
+			t.Fatalf("mode %d: LastWrite = %d, %06o, %v, want pc 001010", mode, count, pc, ok)
+		}
+		cpu.Seek(count)
+		check("seek", &states[count])
+		cpu.R[2] = 1
+		cpu.Step(1)
+		if v, _ := mem.ReadW(0o3002); v != 1 {
+			t.Fatalf("mode %d: after changing history: *003002 = %d, want 1", mode, v)
+		}
+		if err := cpu.Seek(count + 2); err != ErrHistory {
+			t.Fatalf("mode %d: Seek to discarded history = %v, want ErrHistory", mode, err)
+		}
+		cpu.StepBack(1)
+		states[count].regs.R[2] = 1
+		check("step back after changing history", &states[count])
+	}
+}
+
+func TestRecordLimit(t *testing.T) {
+	cpu, _ := newTrapTest(t, "inc r0", "br 1000")
+	cpu.Record(10)
+	cpu.Step(100)
+	if n := cpu.InstCount(); n != 100 {
+		t.Fatalf("InstCount = %d, want 100", n)
+	}
+	if err := cpu.StepBack(10); err != nil || cpu.R[0] != 45 {
+		t.Fatalf("StepBack(10) = %v, r0=%d, want nil, 45", err, cpu.R[0])
+	}
+	if err := cpu.Seek(50); err != ErrHistory {
+		t.Fatalf("Seek(50) = %v, want ErrHistory", err)
+	}
+}
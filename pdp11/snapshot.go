@@ -0,0 +1,225 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pdp11
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// A snapshot holds the state of a CPU and its memory.
+// The encoding begins with snapMagic and a 16-bit version number,
+// followed by snapState and then a sequence of memory sections,
+// each a tag byte followed by the section data, ending with snapEnd.
+// All values are little-endian.
+//
+// A snapshot does not include the CPU's configuration
+// (Model, Timing, TrapMode, Bus, Tracer) or its breakpoints,
+// watchpoints, and recorded history, nor the state of Unibus devices.
+
+const (
+	snapMagic   = "PDP11SNP"
+	snapVersion = 1
+)
+
+// Memory section tags.
+const (
+	snapEnd  = 'E' // end of snapshot
+	snapMem  = 'M' // Mem: 64 kB
+	snapIMem = 'I' // IMem: 64 kB
+	snapMMU  = 'U' // MMU: snapMMUState, 32-bit memory size, memory
+)
+
+// snapState is the fixed-size CPU register state in a snapshot.
+type snapState struct {
+	R           [8]uint16
+	PS          uint16
+	Inst        uint16
+	Stack       [4]uint16
+	F           [6]uint64
+	FPS         uint16
+	FEC         uint8
+	FEA         uint16
+	Waiting     bool
+	Nanoseconds uint64
+	NIRQ        uint8 // number of pending interrupts that follow
+}
+
+// snapIRQ is a pending interrupt in a snapshot.
+type snapIRQ struct {
+	Pri    uint8
+	Vector uint16
+}
+
+// snapMMUState is the MMU register state in a snapshot.
+type snapMMUState struct {
+	SR  [4]uint16
+	PAR [4][16]uint16
+	PDR [4][16]uint16
+}
+
+// SaveSnapshot writes a snapshot of the CPU and its memory to w.
+// The CPU's memory must be an *ArrayMem (and IMem, if set, another *ArrayMem),
+// or, when using the MMU, its physical memory must be a CoreMem.
+func (cpu *CPU) SaveSnapshot(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	bw.WriteString(snapMagic)
+	binary.Write(bw, binary.LittleEndian, uint16(snapVersion))
+
+	st := snapState{
+		R:           cpu.R,
+		PS:          uint16(cpu.PS),
+		Inst:        cpu.Inst,
+		Stack:       cpu.Stack,
+		FPS:         uint16(cpu.FPS),
+		FEC:         cpu.FEC,
+		FEA:         cpu.FEA,
+		Waiting:     cpu.Waiting,
+		Nanoseconds: cpu.Nanoseconds,
+		NIRQ:        uint8(len(cpu.irqs)),
+	}
+	for i, f := range cpu.F {
+		st.F[i] = uint64(f)
+	}
+	binary.Write(bw, binary.LittleEndian, &st)
+	for _, q := range cpu.irqs {
+		binary.Write(bw, binary.LittleEndian, snapIRQ{uint8(q.pri), q.vector})
+	}
+
+	if cpu.MMU != nil {
+		mem, ok := cpu.MMU.Mem.(CoreMem)
+		if !ok {
+			return fmt.Errorf("snapshot: unsupported physical memory %T", cpu.MMU.Mem)
+		}
+		m := cpu.MMU
+		bw.WriteByte(snapMMU)
+		binary.Write(bw, binary.LittleEndian, &snapMMUState{[4]uint16{m.SR0, m.SR1, m.SR2, m.SR3}, m.PAR, m.PDR})
+		binary.Write(bw, binary.LittleEndian, uint32(len(mem)))
+		bw.Write(mem)
+	} else {
+		mem, ok := cpu.Mem.(*ArrayMem)
+		if !ok {
+			return fmt.Errorf("snapshot: unsupported memory %T", cpu.Mem)
+		}
+		bw.WriteByte(snapMem)
+		bw.Write(mem[:])
+		if cpu.IMem != nil {
+			imem, ok := cpu.IMem.(*ArrayMem)
+			if !ok {
+				return fmt.Errorf("snapshot: unsupported instruction memory %T", cpu.IMem)
+			}
+			bw.WriteByte(snapIMem)
+			bw.Write(imem[:])
+		}
+	}
+	bw.WriteByte(snapEnd)
+	return bw.Flush()
+}
+
+// LoadSnapshot restores the CPU and its memory from a snapshot
+// written by SaveSnapshot.
+// The CPU must have the same kind of memory as the one that was saved:
+// an *ArrayMem Mem (and IMem, if the snapshot has one),
+// or an MMU with a CoreMem of the same size.
+// LoadSnapshot leaves the CPU's configuration unchanged (see SaveSnapshot),
+// and it discards any recorded history.
+func (cpu *CPU) LoadSnapshot(r io.Reader) error {
+	br := bufio.NewReader(r)
+	var hdr [len(snapMagic) + 2]byte
+	if _, err := io.ReadFull(br, hdr[:]); err != nil || string(hdr[:len(snapMagic)]) != snapMagic {
+		return fmt.Errorf("snapshot: not a pdp11 snapshot")
+	}
+	if v := binary.LittleEndian.Uint16(hdr[len(snapMagic):]); v != snapVersion {
+		return fmt.Errorf("snapshot: unsupported version %d", v)
+	}
+	var st snapState
+	if err := binary.Read(br, binary.LittleEndian, &st); err != nil {
+		return snapReadErr(err)
+	}
+	irqs := make([]irq, st.NIRQ)
+	for i := range irqs {
+		var q snapIRQ
+		if err := binary.Read(br, binary.LittleEndian, &q); err != nil {
+			return snapReadErr(err)
+		}
+		irqs[i] = irq{int(q.Pri), q.Vector}
+	}
+
+	// Memory sections are read directly into the CPU's memory,
+	// so an invalid snapshot can leave memory partially loaded,
+	// but the registers change only once the whole snapshot has been read.
+	var mmu *snapMMUState
+	for {
+		tag, err := br.ReadByte()
+		if err != nil {
+			return snapReadErr(err)
+		}
+		switch tag {
+		default:
+			return fmt.Errorf("snapshot: unknown section %q", tag)
+		case snapEnd:
+			cpu.R = st.R
+			cpu.PS = PS(st.PS)
+			cpu.Inst = st.Inst
+			cpu.Stack = st.Stack
+			for i, f := range st.F {
+				cpu.F[i] = Float(f)
+			}
+			cpu.FPS = FPS(st.FPS)
+			cpu.FEC = st.FEC
+			cpu.FEA = st.FEA
+			cpu.Waiting = st.Waiting
+			cpu.Nanoseconds = st.Nanoseconds
+			cpu.irqs = irqs
+			cpu.hist = nil
+			if m := cpu.MMU; mmu != nil {
+				m.SR0, m.SR1, m.SR2, m.SR3 = mmu.SR[0], mmu.SR[1], mmu.SR[2], mmu.SR[3]
+				m.PAR = mmu.PAR
+				m.PDR = mmu.PDR
+			}
+			return nil
+		case snapMem, snapIMem:
+			m := cpu.Mem
+			if tag == snapIMem {
+				m = cpu.IMem
+			}
+			mem, ok := m.(*ArrayMem)
+			if !ok || cpu.MMU != nil {
+				return fmt.Errorf("snapshot: CPU memory does not match snapshot")
+			}
+			if _, err := io.ReadFull(br, mem[:]); err != nil {
+				return snapReadErr(err)
+			}
+		case snapMMU:
+			mmu = new(snapMMUState)
+			var n uint32
+			if err := binary.Read(br, binary.LittleEndian, mmu); err != nil {
+				return snapReadErr(err)
+			}
+			if err := binary.Read(br, binary.LittleEndian, &n); err != nil {
+				return snapReadErr(err)
+			}
+			if cpu.MMU == nil {
+				return fmt.Errorf("snapshot: CPU memory does not match snapshot")
+			}
+			mem, ok := cpu.MMU.Mem.(CoreMem)
+			if !ok || uint32(len(mem)) != n {
+				return fmt.Errorf("snapshot: CPU memory does not match snapshot")
+			}
+			if _, err := io.ReadFull(br, mem); err != nil {
+				return snapReadErr(err)
+			}
+		}
+	}
+}
+
+func snapReadErr(err error) error {
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return fmt.Errorf("snapshot: %v", err)
+}
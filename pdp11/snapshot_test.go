@@ -0,0 +1,74 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pdp11
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSnapshot(t *testing.T) {
+	cpu, mem := newTrapTest(t, recordProg...)
+	mem.WriteW(0o2030, 0o000002) // rti
+	cpu.IMem = new(ArrayMem)
+	*cpu.IMem.(*ArrayMem) = *mem
+	cpu.F[3] = 0o040200 << 48
+	cpu.FEA = 0o1234
+	cpu.Interrupt(5, 0o60)
+	cpu.Step(7)
+
+	var buf bytes.Buffer
+	if err := cpu.SaveSnapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	cpu2 := &CPU{Mem: new(ArrayMem), IMem: new(ArrayMem), TrapMode: TrapVector}
+	if err := cpu2.LoadSnapshot(bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 20; i++ {
+		cpu.Step(1)
+		cpu2.Step(1)
+		if cpu.regState() != cpu2.regState() || *cpu.Mem.(*ArrayMem) != *cpu2.Mem.(*ArrayMem) {
+			t.Fatalf("after %d steps: restored CPU differs:\nhave %+v\nwant %+v", i+1, cpu2.regState(), cpu.regState())
+		}
+	}
+
+	// Resaving the restored CPU at the same point gives the same snapshot.
+	cpu2.LoadSnapshot(bytes.NewReader(data))
+	var buf2 bytes.Buffer
+	cpu2.SaveSnapshot(&buf2)
+	if !bytes.Equal(buf2.Bytes(), data) {
+		t.Errorf("snapshot did not round-trip")
+	}
+
+	if err := (&CPU{Mem: new(ArrayMem)}).LoadSnapshot(bytes.NewReader(data)); err == nil {
+		t.Errorf("LoadSnapshot without IMem succeeded")
+	}
+	if err := cpu2.LoadSnapshot(bytes.NewReader(data[:100])); err == nil {
+		t.Errorf("LoadSnapshot of truncated snapshot succeeded")
+	}
+}
+
+func TestSnapshotMMU(t *testing.T) {
+	cpu := &CPU{MMU: &MMU{Mem: make(CoreMem, 0o200000)}}
+	cpu.MMU.SR0 = SR0_EN
+	cpu.MMU.PAR[3][5] = 0o1234
+	cpu.MMU.PDR[0][9] = PDR_RW | PDR_W
+	cpu.MMU.Mem.WriteW(0o123456, 0o154321)
+	var buf bytes.Buffer
+	if err := cpu.SaveSnapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+	cpu2 := &CPU{MMU: &MMU{Mem: make(CoreMem, 0o200000)}}
+	if err := cpu2.LoadSnapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+	m, m2 := cpu.MMU, cpu2.MMU
+	if m2.SR0 != m.SR0 || m2.PAR != m.PAR || m2.PDR != m.PDR || !bytes.Equal(m2.Mem.(CoreMem), m.Mem.(CoreMem)) {
+		t.Errorf("restored MMU differs")
+	}
+}
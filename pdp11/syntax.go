@@ -0,0 +1,485 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pdp11
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// A Syntax is an assembly language syntax, for Asm and Disasm.
+//
+// DEC syntax is that of DEC's MACRO-11: mov #5, -(sp); jmp @#4000; br 1006.
+//
+// Unix syntax is that of the Sixth Edition Unix assembler, as:
+// mov $5,-(sp); jmp *$4000; br .+6.
+// Immediates are written with $, deferred modes with *,
+// and branch targets relative to the instruction address, which is named dot (.).
+// The instructions have the names as predefines:
+// trap is sys, and the floating point loads and stores are
+// movf, movif, movfi, movof, movfo, movie, and movei.
+// Instructions that as does not predefine, such as halt and rti,
+// keep their DEC names, which V6 sources define as needed (rti = 2).
+// In Unix syntax, Asm also accepts the pseudo-branches jbr, jeq, jne, and so on,
+// which assemble to a branch when the target is in range and otherwise
+// to a jmp, preceded for a conditional jump by a branch around it;
+// the aliases bhis, bec, blo, bes, mpy, dvd, als, and alsc;
+// and the system call names, such as exit and write, that as predefines.
+type Syntax uint8
+
+const (
+	DEC  Syntax = iota // DEC MACRO-11 syntax
+	Unix               // V6 Unix as syntax
+)
+
+// Asm assembles the instruction text at pc,
+// returning the instruction and its immediate and index words.
+func (s Syntax) Asm(pc uint16, text string) (codes []uint16, err error) {
+	defer catchAsm(&err, text)
+	if s == Unix {
+		return asmUnix(pc, text), nil
+	}
+	inst := parseInst(text)
+	return encode(pc, &inst), nil
+}
+
+// Disasm disassembles the instruction at pc, using read to read
+// the instruction and its immediate and index words.
+// It returns the assembly text and the address of the next instruction.
+func (s Syntax) Disasm(pc uint16, read func(uint16) (uint16, error)) (asm string, next uint16, err error) {
+	inst, err := Decode(pc, read)
+	if err != nil {
+		return "", pc, err
+	}
+	return inst.Format(pc, s), pc + inst.Len, nil
+}
+
+// Format returns the assembly text for the instruction at pc,
+// in the given syntax.
+func (inst Inst) Format(pc uint16, syntax Syntax) string {
+	if syntax != Unix {
+		return inst.String()
+	}
+	op := inst.Op
+	if u, ok := unixOps[op]; ok && !isUnixLdfFR(&inst) {
+		op = u
+	}
+	out := []byte(op)
+	if op == "sys" && len(inst.Args) == 1 {
+		if name, ok := unixSysNames[inst.Args[0].Word]; ok {
+			return string(append(out, " "+name...))
+		}
+	}
+	for i := range inst.Args {
+		if i > 0 {
+			out = append(out, ',')
+		} else {
+			out = append(out, ' ')
+		}
+		out = inst.Args[i].appendUnix(out, pc)
+	}
+	return string(out)
+}
+
+// isUnixLdfFR reports whether inst is an ldf from fr0 to fr3.
+// In Unix syntax, movf from those registers is stf,
+// so the instruction keeps its DEC name.
+func isUnixLdfFR(inst *Inst) bool {
+	if inst.Op != "ldf" || len(inst.Args) != 2 {
+		return false
+	}
+	a := &inst.Args[0]
+	return a.Kind == ArgGen && a.Mode == 0 && a.Reg <= 3
+}
+
+// appendUnix appends the operand in Unix syntax,
+// for an instruction at pc.
+func (a *Arg) appendUnix(out []byte, pc uint16) []byte {
+	switch a.Kind {
+	case ArgReg:
+		return append(out, a.Reg.String()...)
+	case ArgAC:
+		return fmt.Appendf(out, "fr%d", a.Reg)
+	case ArgBranch:
+		switch d := int16(a.Target - pc); {
+		case d == 0:
+			return append(out, '.')
+		case d > 0:
+			return fmt.Appendf(out, ".+%o", d)
+		default:
+			return fmt.Appendf(out, ".-%o", -int32(d))
+		}
+	case ArgNum:
+		return fmt.Appendf(out, "%o", a.Word)
+	case ArgGen:
+		// handled below
+	default:
+		return append(out, '?')
+	}
+
+	if a.Float && a.Mode == 0 {
+		return fmt.Appendf(out, "fr%d", a.Reg)
+	}
+
+	indir := ""
+	if a.Mode&1 != 0 && a.Mode != 1 { // extra indirect
+		indir = "*"
+	}
+
+	// Conveniences for PC-relative data and immediates.
+	if a.Reg == PC {
+		switch a.Mode {
+		case 2:
+			return fmt.Appendf(out, "$%o", int16(a.Word))
+		case 3:
+			return fmt.Appendf(out, "*$%o", a.Word)
+		case 6, 7:
+			return fmt.Appendf(out, "%s%o", indir, a.Target)
+		}
+	}
+
+	reg := a.Reg.String()
+	switch a.Mode &^ 1 {
+	case 0:
+		if a.Mode == 0 { // register
+			return append(out, reg...)
+		}
+		return fmt.Appendf(out, "(%s)", reg) // indirect register
+	case 2: // post-increment
+		return fmt.Appendf(out, "%s(%s)+", indir, reg)
+	case 4: // pre-increment
+		return fmt.Appendf(out, "%s-(%s)", indir, reg)
+	case 6: // indexed
+		return fmt.Appendf(out, "%s%o(%s)", indir, int16(a.Word), reg)
+	}
+	return append(out, '?')
+}
+
+// unixOps maps DEC instruction names to V6 as names.
+var unixOps = map[string]string{
+	"trap":  "sys",
+	"ldf":   "movf",
+	"stf":   "movf",
+	"ldcif": "movif",
+	"stcfi": "movfi",
+	"ldcdf": "movof",
+	"stcfd": "movfo",
+	"ldexp": "movie",
+	"stexp": "movei",
+}
+
+// unixAliases maps V6 as instruction names to DEC names.
+// The ambiguous movf is handled separately.
+var unixAliases = map[string]string{
+	"sys":   "trap",
+	"bhis":  "bcc",
+	"bec":   "bcc",
+	"blo":   "bcs",
+	"bes":   "bcs",
+	"mpy":   "mul",
+	"dvd":   "div",
+	"als":   "ash",
+	"alsc":  "ashc",
+	"movif": "ldcif",
+	"movfi": "stcfi",
+	"movof": "ldcdf",
+	"movfo": "stcfd",
+	"movie": "ldexp",
+	"movei": "stexp",
+}
+
+// unixJumps maps the V6 as pseudo-branches to their branches.
+var unixJumps = map[string]string{
+	"jbr":  "br",
+	"jne":  "bne",
+	"jeq":  "beq",
+	"jge":  "bge",
+	"jlt":  "blt",
+	"jgt":  "bgt",
+	"jle":  "ble",
+	"jpl":  "bpl",
+	"jmi":  "bmi",
+	"jhi":  "bhi",
+	"jlos": "blos",
+	"jvc":  "bvc",
+	"jvs":  "bvs",
+	"jhis": "bcc",
+	"jec":  "bcc",
+	"jcc":  "bcc",
+	"jlo":  "bcs",
+	"jcs":  "bcs",
+	"jes":  "bcs",
+}
+
+// unixSys holds the system call names V6 as predefines.
+var unixSys = map[string]uint16{
+	"exit":   1,
+	"fork":   2,
+	"read":   3,
+	"write":  4,
+	"open":   5,
+	"close":  6,
+	"wait":   7,
+	"creat":  8,
+	"link":   9,
+	"unlink": 10,
+	"exec":   11,
+	"chdir":  12,
+	"time":   13,
+	"makdir": 14,
+	"chmod":  15,
+	"chown":  16,
+	"break":  17,
+	"stat":   18,
+	"seek":   19,
+	"tell":   20,
+	"mount":  21,
+	"umount": 22,
+	"setuid": 23,
+	"getuid": 24,
+	"stime":  25,
+	"fstat":  28,
+	"mdate":  30,
+	"stty":   31,
+	"gtty":   32,
+	"nice":   34,
+	"signal": 48,
+}
+
+// unixSysNames is the inverse of unixSys.
+var unixSysNames = make(map[uint16]string)
+
+func init() {
+	for name, n := range unixSys {
+		unixSysNames[n] = name
+	}
+}
+
+// asmUnix assembles the Unix syntax instruction text at pc.
+func asmUnix(pc uint16, text string) []uint16 {
+	op, args := parseAsm(text)
+	if b, ok := unixJumps[op]; ok {
+		if len(args) != 1 {
+			panic(fmt.Sprintf("invalid argument count %d != 1", len(args)))
+		}
+		// Branch if possible, as as does.
+		target := unixExpr(pc, args[0])
+		if d := int16(target - pc); d&1 == 0 && -254 <= d && d <= 256 {
+			inst := Inst{Op: b, Args: []Arg{{Kind: ArgBranch, Target: target}}}
+			return encode(pc, &inst)
+		}
+		jmp := []uint16{0o000137, target} // jmp *$target
+		if b == "br" {
+			return jmp
+		}
+		// Branch around the jmp on the opposite condition.
+		return append([]uint16{itab[lookupAsm(b)].code ^ 0o402}, jmp...)
+	}
+
+	inst := Inst{Op: op}
+	switch op {
+	case "movf":
+		// movf fsrc,frN is ldf; movf frN,fdst is stf.
+		inst.Op = "ldf"
+		if len(args) == 2 && len(args[0]) == 3 && strings.HasPrefix(args[0], "fr") && '0' <= args[0][2] && args[0][2] <= '3' {
+			inst.Op = "stf"
+		}
+	default:
+		if d, ok := unixAliases[op]; ok {
+			inst.Op = d
+		}
+	}
+
+	forms, ok := Operands(inst.Op)
+	if !ok {
+		panic("unknown instruction")
+	}
+	if len(args) != len(forms) {
+		panic(fmt.Sprintf("invalid argument count %d != %d", len(args), len(forms)))
+	}
+	for i, arg := range args {
+		a := forms[i]
+		switch a.Kind {
+		case ArgBranch:
+			a.Target = unixExpr(pc, arg)
+		case ArgNum:
+			a.Word = unixExpr(pc, arg)
+		case ArgReg:
+			a.Reg = parseUnixReg(arg)
+		case ArgAC:
+			a.Reg = parseUnixFR(arg)
+			if a.Reg > 3 {
+				panic("invalid float accumulator")
+			}
+		case ArgGen:
+			a = parseUnixArg(pc, arg, a.Float)
+		}
+		inst.Args = append(inst.Args, a)
+	}
+	return encode(pc, &inst)
+}
+
+// parseUnixArg parses a general operand in Unix syntax
+// for an instruction at pc.
+// If fp is true, mode 0 operands name floating point registers.
+func parseUnixArg(pc uint16, arg string, fp bool) Arg {
+	a := Arg{Kind: ArgGen, Float: fp}
+	if arg == "" {
+		panic("empty arg")
+	}
+	if fp && strings.HasPrefix(arg, "fr") {
+		a.Reg = parseUnixFR(arg)
+		return a
+	}
+	if isUnixReg(arg) {
+		a.Reg = parseUnixReg(arg)
+		return a
+	}
+
+	star := false
+	if arg[0] == '*' {
+		star = true
+		arg = arg[1:]
+		if arg == "" {
+			panic("invalid indirect")
+		}
+		if isUnixReg(arg) {
+			// *r is (r)
+			a.Mode = 1
+			a.Reg = parseUnixReg(arg)
+			return a
+		}
+	}
+	if star {
+		a.Mode = 1 // indirect bit
+	}
+
+	switch {
+	case arg[0] == '$':
+		// constant loaded from instruction stream
+		a.Mode |= 2
+		a.Reg = PC
+		a.Word = unixExpr(pc, arg[1:])
+		if star {
+			a.Target = a.Word
+		}
+		return a
+	case strings.HasPrefix(arg, "-(") && strings.HasSuffix(arg, ")"):
+		a.Mode |= 4 // pre-decrement
+		a.Reg = parseUnixReg(arg[2 : len(arg)-1])
+		return a
+	case strings.HasPrefix(arg, "(") && strings.HasSuffix(arg, ")+"):
+		a.Mode |= 2 // post-increment
+		a.Reg = parseUnixReg(arg[1 : len(arg)-2])
+		return a
+	case strings.HasPrefix(arg, "(") && strings.HasSuffix(arg, ")"):
+		// (r) is register deferred; *(r) is *0(r)
+		a.Reg = parseUnixReg(arg[1 : len(arg)-1])
+		a.Mode = 1
+		if star {
+			a.Mode = 7
+		}
+		return a
+	case strings.HasSuffix(arg, ")"):
+		// indexed
+		i := strings.LastIndex(arg, "(")
+		if i < 0 {
+			panic("bad argument syntax")
+		}
+		a.Mode |= 6
+		a.Reg = parseUnixReg(arg[i+1 : len(arg)-1])
+		a.Word = unixExpr(pc, arg[:i])
+		return a
+	}
+
+	// pc-relative address, offset loaded from instruction stream
+	a.Mode |= 6
+	a.Reg = PC
+	a.Target = unixExpr(pc, arg)
+	return a
+}
+
+func isUnixReg(arg string) bool {
+	switch arg {
+	case "r0", "r1", "r2", "r3", "r4", "r5", "sp", "pc":
+		return true
+	}
+	return false
+}
+
+func parseUnixReg(arg string) RegNum {
+	if !isUnixReg(arg) {
+		panic("invalid register")
+	}
+	return parseReg(arg)
+}
+
+func parseUnixFR(arg string) RegNum {
+	if len(arg) != 3 || !strings.HasPrefix(arg, "fr") || arg[2] < '0' || '5' < arg[2] {
+		panic("invalid floating point register")
+	}
+	return RegNum(arg[2] - '0')
+}
+
+// unixExpr evaluates the Unix syntax expression s for an instruction at pc.
+// The expression is a sequence of terms joined by + and -,
+// evaluated left to right. A term is a number,
+// which is octal unless followed by a decimal point;
+// a character constant 'c; a system call name;
+// or dot (.), the address of the instruction.
+func unixExpr(pc uint16, s string) uint16 {
+	var v uint16
+	op := byte('+')
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, "-") {
+		op, s = '-', s[1:]
+	}
+	for {
+		s = strings.TrimSpace(s)
+		i := strings.IndexAny(s, "+-")
+		if strings.HasPrefix(s, "'") && len(s) >= 2 {
+			i = strings.IndexAny(s[2:], "+-")
+			if i >= 0 {
+				i += 2
+			}
+		}
+		term := s
+		if i >= 0 {
+			term = strings.TrimSpace(s[:i])
+		}
+		t := unixTerm(pc, term)
+		if op == '+' {
+			v += t
+		} else {
+			v -= t
+		}
+		if i < 0 {
+			return v
+		}
+		op, s = s[i], s[i+1:]
+	}
+}
+
+func unixTerm(pc uint16, term string) uint16 {
+	switch {
+	case term == ".":
+		return pc
+	case len(term) == 2 && term[0] == '\'':
+		return uint16(term[1])
+	case strings.HasSuffix(term, "."):
+		if n, err := strconv.ParseUint(term[:len(term)-1], 10, 16); err == nil {
+			return uint16(n)
+		}
+	default:
+		if n, err := strconv.ParseUint(term, 8, 16); err == nil {
+			return uint16(n)
+		}
+		if n, ok := unixSys[term]; ok {
+			return n
+		}
+	}
+	panic(fmt.Sprintf("invalid expression %q", term))
+}
@@ -0,0 +1,149 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pdp11
+
+import (
+	"reflect"
+	"testing"
+)
+
+var unixTests = []struct {
+	codes []uint16
+	text  string
+}{
+	{[]uint16{0o012700, 5}, "mov $5,r0"},
+	{[]uint16{0o012746, 0o177777}, "mov $-1,-(sp)"},
+	{[]uint16{0o004737, 0o4000}, "jsr pc,*$4000"},
+	{[]uint16{0o004767, 0o100}, "jsr pc,10104"},
+	{[]uint16{0o000177, 0o100}, "jmp *10104"},
+	{[]uint16{0o016561, 0o177766, 4}, "mov -12(r5),4(r1)"},
+	{[]uint16{0o017561, 0, 2}, "mov *0(r5),2(r1)"},
+	{[]uint16{0o013125}, "mov *(r1)+,(r5)+"},
+	{[]uint16{0o015142}, "mov *-(r1),-(r2)"},
+	{[]uint16{0o011102}, "mov (r1),r2"},
+	{[]uint16{0o000402}, "br .+6"},
+	{[]uint16{0o000777}, "br ."},
+	{[]uint16{0o001375}, "bne .-4"},
+	{[]uint16{0o103002}, "bcc .+6"},
+	{[]uint16{0o077203}, "sob r2,.-4"},
+	{[]uint16{0o104401}, "sys exit"},
+	{[]uint16{0o104400}, "sys 0"},
+	{[]uint16{0o104477}, "sys 77"},
+	{[]uint16{0o006403}, "mark 3"},
+	{[]uint16{0o070261, 2}, "mul 2(r1),r2"},
+	{[]uint16{0o174100}, "movf fr1,fr0"},
+	{[]uint16{0o172401}, "ldf fr1,fr0"},
+	{[]uint16{0o172404}, "movf fr4,fr0"},
+	{[]uint16{0o174104}, "movf fr1,fr4"},
+	{[]uint16{0o172561, 4}, "movf 4(r1),fr1"},
+	{[]uint16{0o177001}, "movif r1,fr0"},
+	{[]uint16{0o175401}, "movfi fr0,r1"},
+	{[]uint16{0o176001}, "movfo fr0,fr1"},
+	{[]uint16{0o000002}, "rti"},
+	{[]uint16{0o000240}, "nop"},
+}
+
+func TestUnixSyntax(t *testing.T) {
+	const basePC = 0o010000
+	for _, tt := range unixTests {
+		read := func(addr uint16) (uint16, error) {
+			i := int(addr-basePC) / 2
+			if i >= len(tt.codes) {
+				return 0, ErrMem
+			}
+			return tt.codes[i], nil
+		}
+		text, next, err := Unix.Disasm(basePC, read)
+		if err != nil || text != tt.text || next != basePC+2*uint16(len(tt.codes)) {
+			t.Errorf("Unix.Disasm(%06o) = %q, %06o, %v, want %q, %06o", tt.codes, text, next, err, tt.text, basePC+2*uint16(len(tt.codes)))
+		}
+		codes, err := Unix.Asm(basePC, tt.text)
+		if err != nil || !reflect.DeepEqual(codes, tt.codes) {
+			t.Errorf("Unix.Asm(%q) = %06o, %v, want %06o", tt.text, codes, err, tt.codes)
+		}
+	}
+}
+
+var unixAsmTests = []struct {
+	pc    uint16
+	text  string
+	codes []uint16
+}{
+	{0o1000, "sys write", []uint16{0o104404}},
+	{0o1000, "sys signal", []uint16{0o104460}},
+	{0o1000, "trap 4", []uint16{0o104404}},
+	{0o1000, "mov $10.,r0", []uint16{0o012700, 10}},
+	{0o1000, "mov $'a,r0", []uint16{0o012700, 'a'}},
+	{0o1000, "mov *r1,r0", []uint16{0o011100}},
+	{0o1000, "mov *(r1),r0", []uint16{0o017100, 0}},
+	{0o1000, "cmp r0,$exit+1", []uint16{0o020027, 2}},
+	{0o1000, "bhis .+4", []uint16{0o103001}},
+	{0o1000, "blo .-2", []uint16{0o103776}},
+	{0o1000, "bes .", []uint16{0o103777}},
+	{0o1000, "mpy r1,r2", []uint16{0o070201}},
+	{0o1000, "dvd r1,r2", []uint16{0o071201}},
+	{0o1000, "als $3,r2", []uint16{0o072227, 3}},
+	{0o1000, "alsc $3,r2", []uint16{0o073227, 3}},
+	{0o1000, "movei fr1,r2", []uint16{0o175102}},
+	{0o1000, "movie r2,fr1", []uint16{0o176502}},
+	{0o1000, "movof fr4,fr1", []uint16{0o177504}},
+
+	// Pseudo-branches become branches when the target is in range.
+	{0o1000, "jbr .+400", []uint16{0o000577}},
+	{0o1000, "jbr .-376", []uint16{0o000600}},
+	{0o1000, "jeq 1100", []uint16{0o001437}},
+	{0o1000, "jbr .+402", []uint16{0o000137, 0o1402}},
+	{0o1000, "jbr 400", []uint16{0o000137, 0o400}},
+	{0o1000, "jeq 400", []uint16{0o001002, 0o000137, 0o400}},
+	{0o1000, "jne 4000", []uint16{0o001402, 0o000137, 0o4000}},
+	{0o1000, "jhis 4000", []uint16{0o103402, 0o000137, 0o4000}},
+	{0o1000, "jlo 4000", []uint16{0o103002, 0o000137, 0o4000}},
+	{0o1000, "jle 4000", []uint16{0o003002, 0o000137, 0o4000}},
+}
+
+func TestUnixAsm(t *testing.T) {
+	for _, tt := range unixAsmTests {
+		codes, err := Unix.Asm(tt.pc, tt.text)
+		if err != nil || !reflect.DeepEqual(codes, tt.codes) {
+			t.Errorf("Unix.Asm(%06o, %q) = %06o, %v, want %06o", tt.pc, tt.text, codes, err, tt.codes)
+		}
+	}
+	for _, text := range []string{
+		"mov #5, r0",
+		"mov r6,r0",
+		"movf fr6,fr0",
+		"jmp @2000",
+		"sys nosuch",
+		"mark 100",
+	} {
+		if codes, err := Unix.Asm(0o1000, text); err == nil {
+			t.Errorf("Unix.Asm(%q) = %06o, want error", text, codes)
+		}
+	}
+}
+
+// Every instruction disassembles to Unix syntax that assembles back to it.
+func TestUnixDisasmAsm(t *testing.T) {
+	errs := 0
+	const basePC = 0o010000
+	for i := 0; i < 1<<16; i++ {
+		codes := []uint16{uint16(i), 0o100, 0o200, 0o300}
+		read := func(addr uint16) (uint16, error) {
+			return codes[(addr-basePC)/2], nil
+		}
+		asm, next, err := Unix.Disasm(basePC, read)
+		if err != nil {
+			continue
+		}
+		codes = codes[:(next-basePC)/2]
+		acodes, err := Unix.Asm(basePC, asm)
+		if err != nil || !reflect.DeepEqual(acodes, codes) {
+			t.Errorf("Unix.Disasm(%06o) = %q, but Unix.Asm = %06o, %v", codes, asm, acodes, err)
+			if errs++; errs >= 20 {
+				t.Fatalf("too many errors")
+			}
+		}
+	}
+}
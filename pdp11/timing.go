@@ -0,0 +1,266 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pdp11
+
+import (
+	"strings"
+	"time"
+)
+
+// A Timing is an instruction timing model for a particular processor and memory,
+// used to count the simulated time each instruction takes (see CPU.Nanoseconds).
+// All times are in nanoseconds.
+//
+// The model counts time rather than cycles because the PDP-11/40 and 11/45
+// have no fixed instruction cycle: their microcycles vary in length,
+// the processor handbooks give instruction times in microseconds,
+// and memory reference times depend on the memory, not the processor.
+//
+// An instruction's time is the sum of its fetch time,
+// its execution time (which depends on the kind of instruction),
+// the addressing overhead for each of its operands,
+// and the time for each memory reference it makes.
+// Keeping memory references separate lets a processor
+// be paired with faster or slower memory by changing Read and Write.
+type Timing struct {
+	Name  string
+	Read  uint32    // memory read (DATI) time
+	Write uint32    // memory write (DATO) time
+	Fetch uint32    // instruction fetch and decode, excluding memory
+	Mode  [8]uint32 // operand addressing overhead by mode, excluding memory
+
+	exec [numClasses]uint32 // execution time by instruction class
+}
+
+// Timing40 and Timing45 are approximate timing models for the PDP-11/40
+// with core memory and the PDP-11/45 with bipolar memory,
+// after the instruction timing tables in their processor handbooks.
+var (
+	Timing40 = &Timing{
+		Name:  "PDP-11/40",
+		Read:  500,
+		Write: 500,
+		Fetch: 150,
+		Mode:  [8]uint32{0, 280, 340, 740, 340, 740, 460, 860},
+		exec: [numClasses]uint32{
+			clsMove:        250,
+			clsDouble:      340,
+			clsSingle:      340,
+			clsShift:       450,
+			clsBranch:      250,
+			clsBranchTaken: 600,
+			clsSOB:         750,
+			clsJump:        250,
+			clsJSR:         900,
+			clsRTS:         900,
+			clsRTI:         800,
+			clsTrap:        1800,
+			clsCC:          250,
+			clsWait:        1300,
+			clsReset:       80000,
+			clsMul:         8300,
+			clsDiv:         10600,
+			clsAsh:         1800,
+			clsMFP:         1000,
+			clsFloat:       2000,
+			clsFloatAdd:    25000,
+			clsFloatMul:    35000,
+			clsFloatDiv:    45000,
+		},
+	}
+	Timing45 = &Timing{
+		Name:  "PDP-11/45",
+		Read:  300,
+		Write: 300,
+		Fetch: 0,
+		Mode:  [8]uint32{0, 150, 150, 300, 300, 450, 300, 450},
+		exec: [numClasses]uint32{
+			clsMove:        0,
+			clsDouble:      0,
+			clsSingle:      0,
+			clsShift:       150,
+			clsBranch:      150,
+			clsBranchTaken: 300,
+			clsSOB:         450,
+			clsJump:        300,
+			clsJSR:         600,
+			clsRTS:         450,
+			clsRTI:         600,
+			clsTrap:        1200,
+			clsCC:          150,
+			clsWait:        600,
+			clsReset:       80000,
+			clsMul:         3000,
+			clsDiv:         7000,
+			clsAsh:         1200,
+			clsMFP:         600,
+			clsFloat:       900,
+			clsFloatAdd:    3900,
+			clsFloatMul:    6000,
+			clsFloatDiv:    8400,
+		},
+	}
+)
+
+// A timeClass is a class of instructions with the same execution time.
+type timeClass uint8
+
+const (
+	clsNone        timeClass = iota // invalid instructions
+	clsMove                         // mov, clr
+	clsDouble                       // add, cmp, bit, and so on
+	clsSingle                       // inc, tst, swab, and so on
+	clsShift                        // ror, rol, asr, asl
+	clsBranch                       // conditional branch not taken
+	clsBranchTaken                  // branch taken
+	clsSOB                          // sob
+	clsJump                         // jmp
+	clsJSR                          // jsr
+	clsRTS                          // rts
+	clsRTI                          // rti, rtt
+	clsTrap                         // trap sequence (traps, interrupts, trap instructions)
+	clsCC                           // condition code operations, spl
+	clsWait                         // halt, wait
+	clsReset                        // reset
+	clsMul                          // mul
+	clsDiv                          // div
+	clsAsh                          // ash, ashc
+	clsMFP                          // mfpi, mtpi, mfpd, mtpd
+	clsFloat                        // floating point moves, tests, and status
+	clsFloatAdd                     // floating point add, subtract, compare, and conversions
+	clsFloatMul                     // floating point multiply
+	clsFloatDiv                     // floating point divide and modf
+	numClasses
+)
+
+// opClass maps instruction mnemonics to timing classes.
+var opClass = map[string]timeClass{
+	"mov": clsMove, "movb": clsMove, "clr": clsMove, "clrb": clsMove,
+	"add": clsDouble, "sub": clsDouble, "cmp": clsDouble, "cmpb": clsDouble,
+	"bit": clsDouble, "bitb": clsDouble, "bic": clsDouble, "bicb": clsDouble,
+	"bis": clsDouble, "bisb": clsDouble, "xor": clsDouble,
+	"com": clsSingle, "comb": clsSingle, "inc": clsSingle, "incb": clsSingle,
+	"dec": clsSingle, "decb": clsSingle, "neg": clsSingle, "negb": clsSingle,
+	"adc": clsSingle, "adcb": clsSingle, "sbc": clsSingle, "sbcb": clsSingle,
+	"tst": clsSingle, "tstb": clsSingle, "swab": clsSingle, "sxt": clsSingle,
+	"ror": clsShift, "rorb": clsShift, "rol": clsShift, "rolb": clsShift,
+	"asr": clsShift, "asrb": clsShift, "asl": clsShift, "aslb": clsShift,
+	"br": clsBranch, "bne": clsBranch, "beq": clsBranch, "bge": clsBranch,
+	"blt": clsBranch, "bgt": clsBranch, "ble": clsBranch, "bpl": clsBranch,
+	"bmi": clsBranch, "bhi": clsBranch, "blos": clsBranch, "bvc": clsBranch,
+	"bvs": clsBranch, "bcc": clsBranch, "bcs": clsBranch,
+	"sob": clsSOB, "jmp": clsJump, "jsr": clsJSR, "rts": clsRTS, "mark": clsRTS,
+	"rti": clsRTI, "rtt": clsRTI,
+	"bpt": clsTrap, "iot": clsTrap, "emt": clsTrap, "trap": clsTrap,
+	"nop": clsCC, "spl": clsCC, "halt": clsWait, "wait": clsWait, "reset": clsReset,
+	"mul": clsMul, "div": clsDiv, "ash": clsAsh, "ashc": clsAsh,
+	"mfpi": clsMFP, "mtpi": clsMFP, "mfpd": clsMFP, "mtpd": clsMFP,
+	"cfcc": clsFloat, "setf": clsFloat, "seti": clsFloat, "setd": clsFloat,
+	"setl": clsFloat, "ldfps": clsFloat, "stfps": clsFloat, "stst": clsFloat,
+	"clrf": clsFloat, "tstf": clsFloat, "absf": clsFloat, "negf": clsFloat,
+	"ldf": clsFloat, "stf": clsFloat, "stexp": clsFloat, "ldexp": clsFloat,
+	"addf": clsFloatAdd, "subf": clsFloatAdd, "cmpf": clsFloatAdd,
+	"stcfi": clsFloatAdd, "stcfd": clsFloatAdd, "ldcif": clsFloatAdd, "ldcdf": clsFloatAdd,
+	"mulf": clsFloatMul, "divf": clsFloatDiv, "modf": clsFloatDiv,
+	"fadd": clsFloatAdd, "fsub": clsFloatAdd, "fmul": clsFloatMul, "fdiv": clsFloatDiv,
+}
+
+// iclass is the timing class for each itab entry.
+var iclass []timeClass
+
+func init() {
+	iclass = make([]timeClass, len(itab))
+	for i, inst := range itab {
+		op, _, _ := strings.Cut(inst.text, " ")
+		if cls, ok := opClass[op]; ok {
+			iclass[i] = cls
+		} else if inst.text == "" {
+			iclass[i] = clsNone
+		} else {
+			iclass[i] = clsCC // ccc, scc and their aliases
+		}
+	}
+}
+
+// modeRefs is the number of memory references made
+// to compute an operand address in each addressing mode,
+// not counting the reference to the operand itself.
+var modeRefs = [8]uint32{0, 0, 0, 1, 0, 1, 1, 2}
+
+// operand returns the time to address the operand encoded in enc (mode and register),
+// including reading (if read) and writing (if write) the operand,
+// which is words words long in memory.
+func (t *Timing) operand(enc uint16, words uint32, read, write bool) uint32 {
+	mode := (enc >> 3) & 7
+	if mode == 0 {
+		return 0
+	}
+	d := t.Mode[mode] + modeRefs[mode]*t.Read
+	if read {
+		d += words * t.Read
+	}
+	if write {
+		d += words * t.Write
+	}
+	return d
+}
+
+// time returns the time taken by the instruction w,
+// which has just executed starting at pc.
+func (t *Timing) time(cpu *CPU, w, pc uint16) uint32 {
+	cls := iclass[xtab[w]]
+	d := t.Fetch + t.Read
+	src, dst := w>>6&0o77, w&0o77
+	switch cls {
+	case clsMove:
+		if w&0o070000 != 0 { // mov, not clr
+			d += t.operand(src, 1, true, false)
+		}
+		d += t.operand(dst, 1, false, true)
+	case clsDouble:
+		if w&0o170000 != 0o070000 { // not xor
+			d += t.operand(src, 1, true, false)
+		}
+		write := w&0o070000 >= 0o040000 // bic, bis, add, sub, xor
+		d += t.operand(dst, 1, true, write)
+	case clsSingle, clsShift:
+		write := w&0o177700 != 0o005700 && w&0o177700 != 0o105700 // not tst
+		d += t.operand(dst, 1, true, write)
+	case clsBranch, clsSOB:
+		if cpu.R[PC] != pc+2 {
+			if cls == clsBranch {
+				cls = clsBranchTaken
+			}
+		} else if cls == clsSOB {
+			cls = clsBranch // sob falling through
+		}
+	case clsJump:
+		d += t.operand(dst, 0, false, false)
+	case clsJSR:
+		d += t.operand(dst, 0, false, false) + t.Write
+	case clsRTS:
+		d += t.Read
+	case clsRTI:
+		d += 2 * t.Read
+	case clsTrap:
+		d += 2*t.Read + 2*t.Write
+	case clsMul, clsDiv, clsAsh:
+		d += t.operand(dst, 1, true, false)
+	case clsMFP:
+		d += t.operand(dst, 1, true, true) // one for the operand, one for the stack
+	case clsFloat, clsFloatAdd, clsFloatMul, clsFloatDiv:
+		words := uint32(2)
+		if cpu.FPS&FD != 0 {
+			words = 4
+		}
+		d += t.operand(dst, words, true, false)
+	}
+	return d + t.exec[cls]
+}
+
+// Elapsed returns the simulated processor time counted in cpu.Nanoseconds.
+func (cpu *CPU) Elapsed() time.Duration {
+	return time.Duration(cpu.Nanoseconds)
+}
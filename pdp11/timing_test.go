@@ -0,0 +1,61 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pdp11
+
+import "testing"
+
+var timingTests = []struct {
+	text string
+	ns   uint64
+}{
+	{"mov r0, r1", 900},
+	{"mov #1, r1", 900 + 340 + 500},
+	{"mov r0, (r1)", 900 + 280 + 500},
+	{"add (r1)+, r2", 990 + 340 + 500},
+	{"inc @(r1)+", 990 + 740 + 500 + 500 + 500},
+	{"tst 2(r1)", 990 + 460 + 500 + 500},
+	{"br 1000", 1250},
+	{"bne 1000", 900}, // not taken: Z is set
+	{"jsr pc, 2000", 650 + 900 + 460 + 500 + 500},
+	{"mul r2, r0", 650 + 8300},
+}
+
+func TestTiming(t *testing.T) {
+	for _, tt := range timingTests {
+		codes, err := Asm(0o1000, tt.text)
+		if err != nil {
+			t.Fatal(err)
+		}
+		mem := new(ArrayMem)
+		for i, code := range codes {
+			mem.WriteW(0o1000+2*uint16(i), code)
+		}
+		cpu := &CPU{Mem: mem, Timing: Timing40, PS: PS_Z}
+		cpu.R[1] = 0o4000
+		cpu.R[SP] = 0o700
+		cpu.R[PC] = 0o1000
+		if err := cpu.Step(1); err != nil {
+			t.Errorf("%s: %v", tt.text, err)
+			continue
+		}
+		if cpu.Nanoseconds != tt.ns {
+			t.Errorf("%s: %d ns, want %d", tt.text, cpu.Nanoseconds, tt.ns)
+		}
+	}
+}
+
+// In TrapError mode, a trapping instruction is charged for its time,
+// including the trap sequence that the caller emulates.
+func TestTimingTrap(t *testing.T) {
+	cpu, _ := newTrapTest(t, "trap 0")
+	cpu.TrapMode = TrapError
+	cpu.Timing = Timing40
+	if err := cpu.Step(1); err == nil {
+		t.Fatal("Step did not trap")
+	}
+	if want := uint64(650 + 1800 + 2*500 + 2*500); cpu.Nanoseconds != want {
+		t.Errorf("trap 0: %d ns, want %d", cpu.Nanoseconds, want)
+	}
+}
@@ -0,0 +1,372 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pdp11
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// A Tracer receives a record of each instruction the CPU executes.
+// If cpu.Tracer is non-nil, Step calls its Trace method after each instruction,
+// including instructions that end in a trap.
+type Tracer interface {
+	Trace(r *TraceRecord)
+}
+
+// A TracerFunc is a Tracer implemented by a function.
+type TracerFunc func(r *TraceRecord)
+
+func (f TracerFunc) Trace(r *TraceRecord) { f(r) }
+
+// A TraceRecord describes the execution of a single instruction.
+//
+// The CPU reuses the record, including its slices, for each instruction:
+// a Tracer must copy anything it needs after Trace returns.
+type TraceRecord struct {
+	PC       uint16    // address of instruction
+	Inst     uint16    // instruction word
+	PS       PS        // processor status before instruction
+	NewPS    PS        // processor status after instruction
+	R        [8]uint16 // registers after instruction
+	Operands []Operand // operands, in the order the instruction decoded them
+	Refs     []Ref     // memory references, in order, starting with the instruction fetch
+	Err      error     // trap ending the instruction, or nil
+}
+
+// An Operand is an instruction's general register operand.
+type Operand struct {
+	Spec uint16 // 6-bit mode and register field
+	Addr uint16 // effective address, or register number for mode 0
+	Reg  bool   // operand is a register (mode 0)
+}
+
+// A Ref is a memory reference made by an instruction.
+type Ref struct {
+	Addr   uint16 // virtual address
+	Val    uint16 // value read or written
+	Access Access // AccessRead or AccessWrite
+	Byte   bool   // byte (not word) reference
+	ISpace bool   // instruction space reference (instruction, immediate, or index word)
+}
+
+// Disasm returns the disassembly of the instruction,
+// using the instruction space words in r.Refs.
+// It returns "?" if the instruction could not be disassembled,
+// which happens when it trapped before reading all its words.
+func (r *TraceRecord) Disasm() string {
+	inst, err := r.Decode()
+	if err != nil {
+		return "?"
+	}
+	return inst.String()
+}
+
+// Decode decodes the instruction,
+// using the instruction space words in r.Refs.
+func (r *TraceRecord) Decode() (Inst, error) {
+	return Decode(r.PC, r.readI)
+}
+
+// readI returns the instruction space word the instruction read at addr.
+func (r *TraceRecord) readI(addr uint16) (uint16, error) {
+	for _, ref := range r.Refs {
+		if ref.ISpace && ref.Access == AccessRead && !ref.Byte && ref.Addr == addr {
+			return ref.Val, nil
+		}
+	}
+	return 0, ErrMem
+}
+
+// traceStart starts a trace record for the instruction at pc.
+func (cpu *CPU) traceStart(pc uint16) {
+	r := cpu.traceBuf
+	if r == nil {
+		r = new(TraceRecord)
+		cpu.traceBuf = r
+	}
+	r.PC = pc
+	r.Inst = 0
+	r.PS = cpu.PS
+	r.Operands = r.Operands[:0]
+	r.Refs = r.Refs[:0]
+	r.Err = nil
+	cpu.trace = r
+}
+
+// traceEnd completes the trace record for the current instruction
+// and sends it to cpu.Tracer.
+func (cpu *CPU) traceEnd(r *TraceRecord, err error) {
+	cpu.trace = nil
+	r.NewPS = cpu.PS
+	r.R = cpu.R
+	r.Err = err
+	cpu.Tracer.Trace(r)
+}
+
+// traceOperand records the operand with the given mode and register at a.
+func (cpu *CPU) traceOperand(enc uint16, a addr) {
+	cpu.trace.Operands = append(cpu.trace.Operands, Operand{enc & 077, uint16(a), a&addrReg != 0})
+}
+
+// traceRef records a memory reference.
+func (cpu *CPU) traceRef(a addr, val uint16, access Access, byte bool) {
+	cpu.trace.Refs = append(cpu.trace.Refs, Ref{uint16(a), val, access, byte, a&addrI != 0})
+}
+
+// TracePC returns a Tracer that passes to t
+// only the records for instructions at addresses in [lo, hi].
+func TracePC(t Tracer, lo, hi uint16) Tracer {
+	return TracerFunc(func(r *TraceRecord) {
+		if lo <= r.PC && r.PC <= hi {
+			t.Trace(r)
+		}
+	})
+}
+
+// A TextTracer is a Tracer that writes a line of text to W for each instruction,
+// showing the instruction, the registers and PS after it executes,
+// its data references, and its trap, if any.
+// Errors writing to W are ignored.
+type TextTracer struct {
+	W      io.Writer
+	Prefix string // prefix for each line
+	Syntax Syntax // syntax for disassembly
+}
+
+func (t *TextTracer) Trace(r *TraceRecord) {
+	asm := "?"
+	if inst, err := r.Decode(); err == nil {
+		asm = inst.Format(r.PC, t.Syntax)
+	}
+	b := fmt.Appendf(nil, "%s%06o %06o %-24s", t.Prefix, r.PC, r.Inst, asm)
+	for i := RegNum(0); i <= PC; i++ {
+		b = fmt.Appendf(b, " %06o", r.R[i])
+	}
+	b = fmt.Appendf(b, " ps=%06o", uint16(r.NewPS))
+	for _, ref := range r.Refs {
+		if ref.ISpace {
+			continue
+		}
+		op := "="
+		if ref.Access == AccessWrite {
+			op = ":="
+		}
+		if ref.Byte {
+			b = fmt.Appendf(b, " *%06o%s%03o", ref.Addr, op, ref.Val)
+		} else {
+			b = fmt.Appendf(b, " *%06o%s%06o", ref.Addr, op, ref.Val)
+		}
+	}
+	if r.Err != nil {
+		b = fmt.Appendf(b, " [%v]", r.Err)
+	}
+	b = append(b, '\n')
+	t.W.Write(b)
+}
+
+// A BinaryTracer is a Tracer that writes a compact binary encoding
+// of each trace record to an underlying writer.
+// A TraceReader decodes the records.
+//
+// Each record is written as little-endian 16-bit words:
+// PC, Inst, PS, NewPS, and R[0] through R[7],
+// followed by a count byte for Operands, a count byte for Refs,
+// an error code byte, and the operands and references themselves.
+// An operand is its Spec and Reg packed into one byte, followed by Addr.
+// A reference is a flags byte (Access, Byte, and ISpace) followed by Addr and Val.
+// An error code is 0 for no error, the index of the error in traceErrs plus 1,
+// or 255 followed by a length byte and the error text.
+type BinaryTracer struct {
+	w   *bufio.Writer
+	buf []byte
+	err error
+}
+
+// NewBinaryTracer returns a BinaryTracer writing to w.
+// The caller must call Flush after the last instruction.
+func NewBinaryTracer(w io.Writer) *BinaryTracer {
+	return &BinaryTracer{w: bufio.NewWriter(w)}
+}
+
+// traceErrs are the errors with single-byte binary trace codes.
+var traceErrs = []error{ErrMem, ErrTrap, ErrInst, ErrBPT, ErrIOT, ErrEMT, ErrFPT, ErrMMU, ErrHalt}
+
+const (
+	traceRefWrite  = 1 << 0
+	traceRefByte   = 1 << 1
+	traceRefISpace = 1 << 2
+	traceOpReg     = 1 << 7
+	traceErrText   = 255
+)
+
+func (t *BinaryTracer) Trace(r *TraceRecord) {
+	if t.err != nil {
+		return
+	}
+	b := t.buf[:0]
+	b = binary.LittleEndian.AppendUint16(b, r.PC)
+	b = binary.LittleEndian.AppendUint16(b, r.Inst)
+	b = binary.LittleEndian.AppendUint16(b, uint16(r.PS))
+	b = binary.LittleEndian.AppendUint16(b, uint16(r.NewPS))
+	for _, v := range r.R {
+		b = binary.LittleEndian.AppendUint16(b, v)
+	}
+	b = append(b, uint8(len(r.Operands)), uint8(len(r.Refs)))
+	switch code := traceErrCode(r.Err); code {
+	case traceErrText:
+		msg := r.Err.Error()
+		if len(msg) > 255 {
+			msg = msg[:255]
+		}
+		b = append(b, code, uint8(len(msg)))
+		b = append(b, msg...)
+	default:
+		b = append(b, code)
+	}
+	for _, op := range r.Operands {
+		flags := uint8(op.Spec & 077)
+		if op.Reg {
+			flags |= traceOpReg
+		}
+		b = append(b, flags)
+		b = binary.LittleEndian.AppendUint16(b, op.Addr)
+	}
+	for _, ref := range r.Refs {
+		var flags uint8
+		if ref.Access == AccessWrite {
+			flags |= traceRefWrite
+		}
+		if ref.Byte {
+			flags |= traceRefByte
+		}
+		if ref.ISpace {
+			flags |= traceRefISpace
+		}
+		b = append(b, flags)
+		b = binary.LittleEndian.AppendUint16(b, ref.Addr)
+		b = binary.LittleEndian.AppendUint16(b, ref.Val)
+	}
+	t.buf = b
+	_, t.err = t.w.Write(b)
+}
+
+func traceErrCode(err error) uint8 {
+	if err == nil {
+		return 0
+	}
+	for i, e := range traceErrs {
+		if err == e {
+			return uint8(i + 1)
+		}
+	}
+	return traceErrText
+}
+
+// Flush writes any buffered records to the underlying writer.
+// It returns the first error encountered writing the trace.
+func (t *BinaryTracer) Flush() error {
+	if t.err != nil {
+		return t.err
+	}
+	t.err = t.w.Flush()
+	return t.err
+}
+
+// A TraceReader reads trace records written by a BinaryTracer.
+type TraceReader struct {
+	r   *bufio.Reader
+	rec TraceRecord
+	buf [24]byte
+}
+
+// NewTraceReader returns a TraceReader reading from r.
+func NewTraceReader(r io.Reader) *TraceReader {
+	return &TraceReader{r: bufio.NewReader(r)}
+}
+
+var errTraceFormat = errors.New("malformed binary trace")
+
+// Next returns the next record in the trace, or io.EOF at the end of the trace.
+// The record is overwritten by the next call to Next.
+func (t *TraceReader) Next() (*TraceRecord, error) {
+	r := &t.rec
+	b := t.buf[:]
+	if _, err := io.ReadFull(t.r, b[:1]); err != nil {
+		return nil, err
+	}
+	if err := t.read(b[1:24]); err != nil {
+		return nil, err
+	}
+	r.PC = binary.LittleEndian.Uint16(b[0:])
+	r.Inst = binary.LittleEndian.Uint16(b[2:])
+	r.PS = PS(binary.LittleEndian.Uint16(b[4:]))
+	r.NewPS = PS(binary.LittleEndian.Uint16(b[6:]))
+	for i := range r.R {
+		r.R[i] = binary.LittleEndian.Uint16(b[8+2*i:])
+	}
+	if err := t.read(b[:3]); err != nil {
+		return nil, err
+	}
+	nop, nref, code := int(b[0]), int(b[1]), b[2]
+	switch {
+	case code == 0:
+		r.Err = nil
+	case int(code) <= len(traceErrs):
+		r.Err = traceErrs[code-1]
+	case code == traceErrText:
+		if err := t.read(b[:1]); err != nil {
+			return nil, err
+		}
+		msg := make([]byte, b[0])
+		if err := t.read(msg); err != nil {
+			return nil, err
+		}
+		r.Err = errors.New(string(msg))
+	default:
+		return nil, errTraceFormat
+	}
+	r.Operands = r.Operands[:0]
+	for i := 0; i < nop; i++ {
+		if err := t.read(b[:3]); err != nil {
+			return nil, err
+		}
+		r.Operands = append(r.Operands, Operand{
+			Spec: uint16(b[0] & 077),
+			Addr: binary.LittleEndian.Uint16(b[1:]),
+			Reg:  b[0]&traceOpReg != 0,
+		})
+	}
+	r.Refs = r.Refs[:0]
+	for i := 0; i < nref; i++ {
+		if err := t.read(b[:5]); err != nil {
+			return nil, err
+		}
+		access := AccessRead
+		if b[0]&traceRefWrite != 0 {
+			access = AccessWrite
+		}
+		r.Refs = append(r.Refs, Ref{
+			Addr:   binary.LittleEndian.Uint16(b[1:]),
+			Val:    binary.LittleEndian.Uint16(b[3:]),
+			Access: access,
+			Byte:   b[0]&traceRefByte != 0,
+			ISpace: b[0]&traceRefISpace != 0,
+		})
+	}
+	return r, nil
+}
+
+// read reads len(b) bytes into b, treating EOF as a truncated trace.
+func (t *TraceReader) read(b []byte) error {
+	_, err := io.ReadFull(t.r, b)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,109 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pdp11
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var traceProg = []string{
+	"mov #5, @#3000",
+	"movb @#3000, r1",
+	"add r1, 3000(r2)",
+	"emt 1",
+}
+
+var traceText = `001000 012737 mov #5, @#3000           000000 000000 000000 000000 000000 000000 000700 001006 ps=000000 *003000:=000005
+001006 113701 movb @#3000, r1          000000 000005 000000 000000 000000 000000 000700 001012 ps=000000 *003000=005
+001012 060162 add r1, 3000(r2)         000000 000005 000000 000000 000000 000000 000700 001016 ps=000000 *003000=000005 *003000:=000012
+`
+
+func TestTextTracer(t *testing.T) {
+	cpu, _ := newTrapTest(t, traceProg...)
+	cpu.TrapMode = TrapError
+	var buf bytes.Buffer
+	cpu.Tracer = &TextTracer{W: &buf}
+	if err := cpu.Step(10); !errors.Is(err, ErrEMT) {
+		t.Fatalf("Step = %v, want ErrEMT", err)
+	}
+	want := traceText + "001016 104001 emt 1                    000000 000005 000000 000000 000000 000000 000700 001016 ps=000000 [emt instruction]\n"
+	if buf.String() != want {
+		t.Errorf("trace:\n%s\nwant:\n%s", buf.String(), want)
+	}
+}
+
+func TestTracePC(t *testing.T) {
+	cpu, _ := newTrapTest(t, traceProg...)
+	cpu.TrapMode = TrapError
+	var buf bytes.Buffer
+	cpu.Tracer = TracePC(&TextTracer{W: &buf}, 0o1006, 0o1012)
+	cpu.Step(10)
+	want := strings.Join(strings.SplitAfter(traceText, "\n")[1:], "")
+	if buf.String() != want {
+		t.Errorf("trace:\n%s\nwant:\n%s", buf.String(), want)
+	}
+}
+
+func TestTextTracerUnix(t *testing.T) {
+	cpu, _ := newTrapTest(t, traceProg...)
+	cpu.TrapMode = TrapError
+	var buf bytes.Buffer
+	cpu.Tracer = TracePC(&TextTracer{W: &buf, Syntax: Unix}, 0o1000, 0o1012)
+	cpu.Step(10)
+	var asm []string
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		asm = append(asm, strings.TrimSpace(line[14:38]))
+	}
+	want := []string{"mov $5,*$3000", "movb *$3000,r1", "add r1,3000(r2)"}
+	if !reflect.DeepEqual(asm, want) {
+		t.Errorf("trace disassembly = %q, want %q", asm, want)
+	}
+}
+
+func TestBinaryTracer(t *testing.T) {
+	cpu, _ := newTrapTest(t, traceProg...)
+	var recs []TraceRecord
+	var buf bytes.Buffer
+	bt := NewBinaryTracer(&buf)
+	cpu.Tracer = TracerFunc(func(r *TraceRecord) {
+		c := *r
+		c.Operands = append([]Operand(nil), r.Operands...)
+		c.Refs = append([]Ref(nil), r.Refs...)
+		recs = append(recs, c)
+		bt.Trace(r)
+	})
+	if err := cpu.Step(4); err != nil {
+		t.Fatal(err)
+	}
+	if err := bt.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if len(recs) != 4 || recs[3].Err != ErrEMT {
+		t.Fatalf("traced %d instructions, want 4 ending in ErrEMT", len(recs))
+	}
+	if want := []Operand{{037, 0o3000, false}, {001, 1, true}}; !reflect.DeepEqual(recs[1].Operands, want) {
+		t.Errorf("movb operands = %+v, want %+v", recs[1].Operands, want)
+	}
+
+	r := NewTraceReader(&buf)
+	for i := range recs {
+		rec, err := r.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if have, want := fmt.Sprintf("%+v", *rec), fmt.Sprintf("%+v", recs[i]); have != want {
+			t.Errorf("record %d:\nhave %s\nwant %s", i, have, want)
+		}
+	}
+	if _, err := r.Next(); err != io.EOF {
+		t.Errorf("Next at end of trace = %v, want EOF", err)
+	}
+}
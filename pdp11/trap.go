@@ -0,0 +1,190 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pdp11
+
+import "fmt"
+
+// A TrapMode specifies how the CPU handles traps.
+type TrapMode uint8
+
+const (
+	// TrapError makes Step return traps as errors,
+	// for use when the CPU runs a single program
+	// and its caller plays the role of the operating system.
+	TrapError TrapMode = iota
+
+	// TrapVector makes Step take traps and interrupts
+	// through the low-memory vectors, as the hardware does,
+	// for running stand-alone programs and operating system kernels.
+	TrapVector
+)
+
+// A Trap is the error Step returns for a trap or fault,
+// describing where and how it happened.
+// Err is the underlying error (ErrTrap, ErrMem, and so on),
+// so errors.Is(err, ErrTrap) reports whether err is a TRAP instruction.
+// Each trap returns a new Trap, so callers can keep it.
+type Trap struct {
+	Err    error  // underlying error
+	Vector uint16 // trap vector, or 0 for errors that do not trap (ErrHalt)
+	PC     uint16 // address of the trapping instruction
+	Inst   uint16 // instruction word; for TRAP and EMT, the low byte is the trap number
+	Addr   uint16 // virtual address of the failed memory access, if any
+	Access Access // kind of failed memory access (AccessExec for an instruction fetch), or 0
+	Odd    bool   // failed access was a word access at an odd address
+}
+
+func (t *Trap) Error() string {
+	if t.Access == 0 {
+		return fmt.Sprintf("%v (pc=%06o inst=%06o)", t.Err, t.PC, t.Inst)
+	}
+	odd := ""
+	if t.Odd {
+		odd = " odd address"
+	}
+	return fmt.Sprintf("%v: %v%s %06o (pc=%06o inst=%06o)", t.Err, t.Access, odd, t.Addr, t.PC, t.Inst)
+}
+
+func (t *Trap) Unwrap() error { return t.Err }
+
+// ErrHalt is returned by Step in TrapVector mode
+// when the CPU executes a HALT instruction in kernel mode.
+var ErrHalt = fmt.Errorf("halt instruction")
+
+// errIllegal is an illegal instruction that traps through vector 4
+// in TrapVector mode, such as HALT in user mode.
+var errIllegal = fmt.Errorf("illegal instruction")
+
+// illegal reports an illegal instruction, such as JMP to a register.
+// In TrapVector mode it traps through vector 4;
+// otherwise it is a reserved instruction error (ErrInst).
+func (cpu *CPU) illegal() {
+	if cpu.TrapMode == TrapVector {
+		cpu.fail(errIllegal)
+		return
+	}
+	cpu.fail(ErrInst)
+}
+
+// Trap vectors.
+const (
+	vecBus   = 0o004 // bus error, odd address, illegal instruction
+	vecInst  = 0o010 // reserved instruction
+	vecBPT   = 0o014 // BPT instruction and T bit
+	vecIOT   = 0o020 // IOT instruction
+	vecPower = 0o024 // power fail
+	vecEMT   = 0o030 // EMT instruction
+	vecTrap  = 0o034 // TRAP instruction
+	vecFPT   = 0o244 // floating point exception
+	vecMMU   = 0o250 // memory management abort
+)
+
+// trapVector returns the vector for the trap reported by err.
+func trapVector(err error) (uint16, bool) {
+	switch err {
+	case ErrMem, errIllegal:
+		return vecBus, true
+	case ErrInst:
+		return vecInst, true
+	case ErrBPT:
+		return vecBPT, true
+	case ErrIOT:
+		return vecIOT, true
+	case ErrEMT:
+		return vecEMT, true
+	case ErrTrap:
+		return vecTrap, true
+	case ErrFPT:
+		return vecFPT, true
+	case ErrMMU:
+		return vecMMU, true
+	}
+	return 0, false
+}
+
+// trap takes a trap or interrupt through vector:
+// it loads the new PC and PS from the vector,
+// records the old mode as the new previous mode,
+// and pushes the old PS and PC onto the new mode's stack.
+func (cpu *CPU) trap(vector uint16) error {
+	pc, err := cpu.readMemW(vector, false, Kernel)
+	if err != nil {
+		return fmt.Errorf("trap to %03o: %w", vector, err)
+	}
+	ps, err := cpu.readMemW(vector+2, false, Kernel)
+	if err != nil {
+		return fmt.Errorf("trap to %03o: %w", vector, err)
+	}
+	// Push onto the new mode's stack before switching to it,
+	// so that a failed push leaves the PS and stack pointers unchanged.
+	oldPS, oldPC := cpu.PS, cpu.R[PC]
+	newPS := PS(ps)&^PS_PREV | PS(oldPS.Mode())<<12
+	sp := cpu.modeSP(newPS.Mode())
+	if err := cpu.writeMemW(sp-2, false, uint16(oldPS), newPS.Mode()); err != nil {
+		return fmt.Errorf("trap to %03o: %w", vector, err)
+	}
+	if err := cpu.writeMemW(sp-4, false, oldPC, newPS.Mode()); err != nil {
+		return fmt.Errorf("trap to %03o: %w", vector, err)
+	}
+	cpu.SetPS(newPS)
+	cpu.R[SP] = sp - 4
+	cpu.R[PC] = pc
+	if t := cpu.Timing; t != nil {
+		cpu.Nanoseconds += uint64(t.exec[clsTrap] + 2*t.Read + 2*t.Write)
+	}
+	return nil
+}
+
+// An irq is a pending interrupt request.
+type irq struct {
+	pri    int
+	vector uint16
+}
+
+// Interrupt posts an interrupt request at priority pri (4..7) through vector.
+// In TrapVector mode, Step takes the interrupt before the next instruction
+// once the PS priority drops below pri.
+// A request stays pending until it is taken or canceled;
+// posting the same vector twice has no additional effect.
+// Interrupt must not be called concurrently with Step.
+func (cpu *CPU) Interrupt(pri int, vector uint16) {
+	for _, r := range cpu.irqs {
+		if r.vector == vector {
+			return
+		}
+	}
+	cpu.irqs = append(cpu.irqs, irq{pri, vector})
+}
+
+// CancelInterrupt cancels a pending interrupt request through vector,
+// as when a device's interrupt enable is cleared before the request is taken.
+func (cpu *CPU) CancelInterrupt(vector uint16) {
+	for i, r := range cpu.irqs {
+		if r.vector == vector {
+			cpu.irqs = append(cpu.irqs[:i], cpu.irqs[i+1:]...)
+			return
+		}
+	}
+}
+
+// interrupt takes the highest priority pending interrupt request
+// above the current processor priority, if any.
+// Among requests at the same priority, the earliest posted wins.
+// It returns an error if the interrupt cannot be taken.
+func (cpu *CPU) interrupt() error {
+	best := -1
+	for i, r := range cpu.irqs {
+		if r.pri > cpu.PS.Priority() && (best < 0 || r.pri > cpu.irqs[best].pri) {
+			best = i
+		}
+	}
+	if best < 0 {
+		return nil
+	}
+	vector := cpu.irqs[best].vector
+	cpu.irqs = append(cpu.irqs[:best], cpu.irqs[best+1:]...)
+	cpu.Waiting = false
+	return cpu.trap(vector)
+}
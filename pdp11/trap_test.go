@@ -0,0 +1,300 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pdp11
+
+import (
+	"errors"
+	"testing"
+)
+
+// newTrapTest returns a CPU in TrapVector mode running text at 0o1000,
+// with every vector pointing at a handler at 0o2000 + vector
+// that runs at priority 7.
+func newTrapTest(t testing.TB, text ...string) (*CPU, *ArrayMem) {
+	mem := new(ArrayMem)
+	cpu := &CPU{Mem: mem, TrapMode: TrapVector}
+	for v := uint16(0); v < 0o400; v += 4 {
+		mem.WriteW(v, 0o2000+v)
+		mem.WriteW(v+2, uint16(PS_PRI))
+	}
+	pc := uint16(0o1000)
+	for _, line := range text {
+		codes, err := Asm(pc, line)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, code := range codes {
+			mem.WriteW(pc, code)
+			pc += 2
+		}
+	}
+	cpu.R[PC] = 0o1000
+	cpu.R[SP] = 0o700
+	return cpu, mem
+}
+
+func TestTrapVector(t *testing.T) {
+	tests := []struct {
+		inst   string
+		vector uint16
+	}{
+		{"trap 1", 0o34},
+		{"emt 1", 0o30},
+		{"iot", 0o20},
+		{"bpt", 0o14},
+		{"mov @#1, r0", 0o4},
+		{"mov @#170000, r0", 0o4},
+		{"halt", 0o4}, // in user mode
+		{"mark 1", 0o10},
+	}
+	for _, tt := range tests {
+		cpu, mem := newTrapTest(t, tt.inst)
+		cpu.Mem = &busMem{mem}
+		cpu.SetPS(PS_CUR | PS_PREV | PS_N)
+		cpu.Stack[Kernel] = 0o700
+		cpu.R[SP] = 0o600
+		if err := cpu.Step(1); err != nil {
+			t.Errorf("%s: Step: %v", tt.inst, err)
+			continue
+		}
+		if cpu.R[PC] != 0o2000+tt.vector {
+			t.Errorf("%s: pc=%06o, want %06o", tt.inst, cpu.R[PC], 0o2000+tt.vector)
+		}
+		if want := PS_PRI | PS_PREV; cpu.PS != want {
+			t.Errorf("%s: ps=%06o, want %06o", tt.inst, cpu.PS, want)
+		}
+		if cpu.R[SP] != 0o674 || cpu.Stack[User] != 0o600 {
+			t.Errorf("%s: sp=%06o user sp=%06o, want %06o, %06o", tt.inst, cpu.R[SP], cpu.Stack[User], 0o674, 0o600)
+		}
+		if ps, _ := mem.ReadW(0o676); ps != uint16(PS_CUR|PS_PREV|PS_N) {
+			t.Errorf("%s: pushed ps=%06o, want %06o", tt.inst, ps, PS_CUR|PS_PREV|PS_N)
+		}
+	}
+}
+
+// busMem is an ArrayMem with nonexistent memory above 0o160000.
+type busMem struct{ *ArrayMem }
+
+func (m *busMem) ReadW(addr uint16) (uint16, error) {
+	if addr&1 != 0 || addr >= ioPage {
+		return 0, ErrMem
+	}
+	return m.ArrayMem.ReadW(addr)
+}
+
+func TestInterrupt(t *testing.T) {
+	cpu, mem := newTrapTest(t,
+		"spl 5",
+		"wait",
+		"inc r0",
+	)
+	mem.WriteW(0o2100, 0o000002) // rti in clock handler
+	if err := cpu.Step(10); err != nil {
+		t.Fatal(err)
+	}
+	if !cpu.Waiting || cpu.R[PC] != 0o1004 || cpu.PS.Priority() != 5 {
+		t.Fatalf("waiting=%v pc=%06o pri=%d, want true, %06o, 5", cpu.Waiting, cpu.R[PC], cpu.PS.Priority(), 0o1004)
+	}
+
+	// A priority 4 request is masked.
+	cpu.Interrupt(4, 0o60)
+	if err := cpu.Step(10); err != nil || !cpu.Waiting || cpu.R[PC] != 0o1004 {
+		t.Fatalf("Step = %v, waiting=%v pc=%06o, want nil, true, %06o", err, cpu.Waiting, cpu.R[PC], 0o1004)
+	}
+
+	// A priority 6 request ends the wait; its handler returns immediately.
+	cpu.Interrupt(6, 0o100)
+	if err := cpu.Step(1); err != nil {
+		t.Fatal(err)
+	}
+	if cpu.Waiting || cpu.R[PC] != 0o1004 || cpu.PS.Priority() != 5 || cpu.R[SP] != 0o700 {
+		t.Fatalf("waiting=%v pc=%06o pri=%d sp=%06o, want false, %06o, 5, %06o", cpu.Waiting, cpu.R[PC], cpu.PS.Priority(), cpu.R[SP], 0o1004, 0o700)
+	}
+	cpu.CancelInterrupt(0o60)
+	cpu.PS = 0
+	if err := cpu.Step(1); err != nil || cpu.R[0] != 1 {
+		t.Errorf("Step = %v, r0=%d, want nil, 1", err, cpu.R[0])
+	}
+}
+
+func TestTraceTrap(t *testing.T) {
+	cpu, mem := newTrapTest(t,
+		"inc r0",
+		"inc r0",
+	)
+	mem.WriteW(0o2014, 0o000006) // rtt in trace handler
+	cpu.PS = PS_T
+	if err := cpu.Step(1); err != nil {
+		t.Fatal(err)
+	}
+	if cpu.R[0] != 1 || cpu.R[PC] != 0o2014 {
+		t.Fatalf("r0=%d pc=%06o, want 1, %06o", cpu.R[0], cpu.R[PC], 0o2014)
+	}
+	if err := cpu.Step(2); err != nil {
+		t.Fatal(err)
+	}
+	if cpu.R[0] != 2 || cpu.R[PC] != 0o2014 || cpu.PS&PS_T != 0 {
+		t.Fatalf("r0=%d pc=%06o ps=%06o, want 2, %06o, T clear", cpu.R[0], cpu.R[PC], cpu.PS, 0o2014)
+	}
+}
+
+func TestHalt(t *testing.T) {
+	cpu, _ := newTrapTest(t, "halt")
+	if err := cpu.Step(1); !errors.Is(err, ErrHalt) {
+		t.Errorf("Step = %v, want ErrHalt", err)
+	}
+	cpu, _ = newTrapTest(t, "halt")
+	cpu.TrapMode = TrapError
+	if err := cpu.Step(1); !errors.Is(err, ErrInst) || cpu.R[PC] != 0o1000 {
+		t.Errorf("Step = %v, pc=%06o, want ErrInst, %06o", err, cpu.R[PC], 0o1000)
+	}
+}
+
+func TestFISTrap(t *testing.T) {
+	tests := []struct {
+		inst string
+		b, a uint16 // high words of operands
+		cc   PS
+	}{
+		{"fdiv r1", 0, 0o040200, PS_N | PS_V | PS_C},
+		{"fmul r1", 0o077600, 0o040600, PS_V},
+		{"fdiv r1", 0o040600, 0o000200, PS_N | PS_V},
+	}
+	for _, tt := range tests {
+		cpu, mem := newTrapTest(t, tt.inst)
+		mem.WriteW(0o500, tt.b)
+		mem.WriteW(0o504, tt.a)
+		cpu.R[1] = 0o500
+		cpu.PS = PS_Z
+		if err := cpu.Step(1); err != nil {
+			t.Fatal(err)
+		}
+		ps, _ := mem.ReadW(0o676)
+		if cpu.R[PC] != 0o2244 || cpu.R[1] != 0o500 || PS(ps) != tt.cc {
+			t.Errorf("%s: pc=%06o r1=%06o pushed ps=%06o, want %06o, %06o, %06o", tt.inst, cpu.R[PC], cpu.R[1], ps, 0o2244, 0o500, tt.cc)
+		}
+	}
+}
+
+// A memory fault in the middle of an instruction leaves the registers
+// as they were before the instruction in TrapError mode,
+// and as they were at the fault in TrapVector mode.
+func TestFaultRegs(t *testing.T) {
+	for _, mode := range []TrapMode{TrapError, TrapVector} {
+		cpu, _ := newTrapTest(t, "mov (r1)+, (r2)+")
+		NewUnibus(cpu)
+		cpu.TrapMode = mode
+		cpu.R[1] = 0o500
+		cpu.R[2] = 0o170000 // no device
+		wantErr := ErrMem
+		want := [8]uint16{1: 0o500, 2: 0o170000, SP: 0o700, PC: 0o1000}
+		if mode == TrapVector {
+			wantErr = nil
+			want = [8]uint16{1: 0o502, 2: 0o170002, SP: 0o674, PC: 0o2004}
+		}
+		if err := cpu.Step(1); !errors.Is(err, wantErr) {
+			t.Fatalf("mode %d: Step = %v, want %v", mode, err, wantErr)
+		}
+		if cpu.R != want {
+			t.Errorf("mode %d: R = %06o, want %06o", mode, cpu.R, want)
+		}
+	}
+}
+
+func TestTrapDetails(t *testing.T) {
+	tests := []struct {
+		inst string
+		r1   uint16
+		want Trap
+	}{
+		{"emt 5", 0, Trap{Err: ErrEMT, Vector: 0o30, PC: 0o1000, Inst: 0o104005}},
+		{"trap 1", 0, Trap{Err: ErrTrap, Vector: 0o34, PC: 0o1000, Inst: 0o104401}},
+		{"clr (r1)", 0o170000, Trap{Err: ErrMem, Vector: 0o4, PC: 0o1000, Inst: 0o005011, Addr: 0o170000, Access: AccessWrite}},
+		{"tst (r1)", 0o170001, Trap{Err: ErrMem, Vector: 0o4, PC: 0o1000, Inst: 0o005711, Addr: 0o170001, Access: AccessRead, Odd: true}},
+		{"tstb (r1)", 0o170001, Trap{Err: ErrMem, Vector: 0o4, PC: 0o1000, Inst: 0o105711, Addr: 0o170001, Access: AccessRead}},
+		{"jmp (r1)", 0o170000, Trap{Err: ErrMem, Vector: 0o4, PC: 0o1000, Inst: 0o000111, Addr: 0o170000, Access: AccessExec}},
+	}
+	for _, tt := range tests {
+		cpu, _ := newTrapTest(t, tt.inst)
+		NewUnibus(cpu)
+		cpu.TrapMode = TrapError
+		cpu.R[1] = tt.r1
+		if tt.want.Access == AccessExec {
+			// The jump succeeds; the next fetch fails.
+			if err := cpu.Step(1); err != nil {
+				t.Fatal(err)
+			}
+			tt.want.PC = tt.r1
+			tt.want.Inst = 0
+		}
+		err := cpu.Step(1)
+		tr, ok := err.(*Trap)
+		if !ok || *tr != tt.want {
+			t.Errorf("%s: Step = %#v, want %#v", tt.inst, err, &tt.want)
+			continue
+		}
+		if !errors.Is(err, tt.want.Err) {
+			t.Errorf("%s: errors.Is(%v, %v) = false", tt.inst, err, tt.want.Err)
+		}
+	}
+}
+
+// After a fault, an instruction makes no further memory accesses.
+func TestFaultNoWrite(t *testing.T) {
+	cpu, mem := newTrapTest(t, "mtpi (r1)")
+	NewUnibus(cpu)
+	cpu.TrapMode = TrapError
+	cpu.R[SP] = 0o170000 // no device
+	cpu.R[1] = 0o500
+	mem.WriteW(0o500, 0o1234)
+	if err := cpu.Step(1); !errors.Is(err, ErrMem) {
+		t.Fatalf("Step = %v, want ErrMem", err)
+	}
+	if v, _ := mem.ReadW(0o500); v != 0o1234 {
+		t.Errorf("after fault, *000500 = %06o, want %06o", v, 0o1234)
+	}
+}
+
+// A Trap returned by Step is not changed by later traps,
+// and it is the only allocation a trapping instruction makes.
+func TestTrapAllocs(t *testing.T) {
+	cpu, _ := newTrapTest(t, "trap 0", "emt 1")
+	cpu.TrapMode = TrapError
+	err1 := cpu.Step(1)
+	cpu.R[PC] += 2
+	err2 := cpu.Step(1)
+	if !errors.Is(err1, ErrTrap) || !errors.Is(err2, ErrEMT) {
+		t.Fatalf("Step = %v, %v, want ErrTrap, ErrEMT", err1, err2)
+	}
+	if tr := err1.(*Trap); tr.PC != 0o1000 || tr.Inst != 0o104400 {
+		t.Errorf("first trap changed to %v", tr)
+	}
+	n := testing.AllocsPerRun(100, func() {
+		cpu.R[PC] = 0o1000
+		if err := cpu.Step(1); !errors.Is(err, ErrTrap) {
+			t.Fatalf("Step = %v, want ErrTrap", err)
+		}
+	})
+	if n != 1 {
+		t.Errorf("Step allocated %v times, want 1", n)
+	}
+}
+
+// A trap whose push fails leaves the CPU as it was,
+// not half switched to the new mode.
+func TestTrapPushFault(t *testing.T) {
+	cpu, _ := newTrapTest(t, "trap 0")
+	NewUnibus(cpu)
+	cpu.SetPS(PS_CUR) // user mode
+	cpu.R[SP] = 0o700
+	cpu.Stack[Kernel] = 0o170000 // no device
+	if err := cpu.Step(1); !errors.Is(err, ErrMem) {
+		t.Fatalf("Step = %v, want ErrMem", err)
+	}
+	if cpu.PS != PS_CUR || cpu.R[SP] != 0o700 || cpu.Stack[Kernel] != 0o170000 {
+		t.Errorf("after failed trap: PS=%06o SP=%06o kernel SP=%06o, want %06o %06o %06o",
+			cpu.PS, cpu.R[SP], cpu.Stack[Kernel], PS_CUR, 0o700, 0o170000)
+	}
+}
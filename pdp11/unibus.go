@@ -0,0 +1,118 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pdp11
+
+import "fmt"
+
+// A Device is a Unibus device with registers in the I/O page.
+// The addresses passed to its methods are 16-bit I/O page addresses
+// (0o160000 to 0o177777) within the range the device was attached at.
+// ReadW and WriteW addresses are always even.
+//
+// Returning an error, typically ErrMem, reports a bus error to the CPU.
+type Device interface {
+	ReadW(addr uint16) (uint16, error)
+	WriteW(addr uint16, val uint16) error
+	WriteB(addr uint16, val uint8) error
+}
+
+// A Resetter is a Device that can be reset to its power-up state
+// by the bus INIT signal, which the RESET instruction asserts.
+type Resetter interface {
+	Reset()
+}
+
+// A Unibus connects devices to a CPU's I/O page.
+// Accesses to I/O page addresses where no device is attached
+// get a bus error (ErrMem).
+type Unibus struct {
+	cpu  *CPU
+	devs []busDev
+}
+
+type busDev struct {
+	addr, size uint16 // register addresses [addr, addr+size)
+	dev        Device
+}
+
+// NewUnibus returns a new, empty Unibus connected to cpu
+// and sets cpu.Bus to it.
+func NewUnibus(cpu *CPU) *Unibus {
+	b := &Unibus{cpu: cpu}
+	cpu.Bus = b
+	return b
+}
+
+// Attach attaches dev to the bus, giving it the
+// size bytes of registers starting at I/O page address addr.
+// Attach panics if the range is outside the I/O page
+// or overlaps a device already attached.
+func (b *Unibus) Attach(addr, size uint16, dev Device) {
+	end := uint32(addr) + uint32(size)
+	if addr < ioPage || addr&1 != 0 || size == 0 || end > 1<<16 {
+		panic(fmt.Sprintf("pdp11: invalid device registers %06o+%o", addr, size))
+	}
+	for _, d := range b.devs {
+		if uint32(d.addr) < end && uint32(addr) < uint32(d.addr)+uint32(d.size) {
+			panic(fmt.Sprintf("pdp11: device registers %06o+%o overlap %06o+%o", addr, size, d.addr, d.size))
+		}
+	}
+	b.devs = append(b.devs, busDev{addr, size, dev})
+}
+
+// Interrupt posts an interrupt request from a device
+// at priority pri (4..7) through vector.
+// See CPU.Interrupt.
+func (b *Unibus) Interrupt(pri int, vector uint16) {
+	b.cpu.Interrupt(pri, vector)
+}
+
+// CancelInterrupt cancels a pending interrupt request through vector.
+func (b *Unibus) CancelInterrupt(vector uint16) {
+	b.cpu.CancelInterrupt(vector)
+}
+
+// lookup returns the device with a register at address a.
+func (b *Unibus) lookup(a uint16) Device {
+	for _, d := range b.devs {
+		if d.addr <= a && a-d.addr < d.size {
+			return d.dev
+		}
+	}
+	return nil
+}
+
+func (b *Unibus) readW(a uint16) (uint16, error) {
+	dev := b.lookup(a)
+	if dev == nil {
+		return 0, ErrMem
+	}
+	return dev.ReadW(a)
+}
+
+func (b *Unibus) writeW(a, val uint16) error {
+	dev := b.lookup(a)
+	if dev == nil {
+		return ErrMem
+	}
+	return dev.WriteW(a, val)
+}
+
+func (b *Unibus) writeB(a uint16, val uint8) error {
+	dev := b.lookup(a)
+	if dev == nil {
+		return ErrMem
+	}
+	return dev.WriteB(a, val)
+}
+
+// reset resets every device that implements Resetter.
+func (b *Unibus) reset() {
+	for _, d := range b.devs {
+		if r, ok := d.dev.(Resetter); ok {
+			r.Reset()
+		}
+	}
+}
@@ -0,0 +1,129 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pdp11
+
+import "testing"
+
+// testDev is a device with two word registers.
+// Writing the second register posts an interrupt.
+type testDev struct {
+	bus   *Unibus
+	reg   [2]uint16
+	bytes int
+	reset bool
+}
+
+const testDevAddr = 0o177560
+
+func (d *testDev) ReadW(addr uint16) (uint16, error) {
+	return d.reg[(addr-testDevAddr)/2], nil
+}
+
+func (d *testDev) WriteW(addr uint16, val uint16) error {
+	d.reg[(addr-testDevAddr)/2] = val
+	if addr == testDevAddr+2 {
+		d.bus.Interrupt(4, 0o60)
+	}
+	return nil
+}
+
+func (d *testDev) WriteB(addr uint16, val uint8) error {
+	d.bytes++
+	r := &d.reg[(addr-testDevAddr)/2]
+	if addr&1 == 0 {
+		*r = *r&0xff00 | uint16(val)
+	} else {
+		*r = *r&0x00ff | uint16(val)<<8
+	}
+	return nil
+}
+
+func (d *testDev) Reset() {
+	d.reset = true
+	d.reg = [2]uint16{}
+}
+
+func TestUnibus(t *testing.T) {
+	cpu, mem := newTrapTest(t,
+		"mov #1234, @#177560",
+		"movb #77, @#177561",
+		"mov @#177560, r1",
+		"movb @#177561, r2",
+		"mov r1, @#177562",
+		"inc r0",
+		"mov @#177564, r3",
+		"reset",
+	)
+	dev := &testDev{bus: NewUnibus(cpu)}
+	dev.bus.Attach(testDevAddr, 4, dev)
+	mem.WriteW(0o177560, 0o7777) // hidden by the I/O page
+	mem.WriteW(0o2060, 0o240)    // nop in interrupt handler
+
+	if err := cpu.Step(4); err != nil {
+		t.Fatal(err)
+	}
+	if dev.reg[0] != 0o37634 || dev.bytes != 1 || cpu.R[1] != 0o37634 || cpu.R[2] != 0o77 {
+		t.Errorf("reg=%06o bytes=%d r1=%06o r2=%06o, want %06o 1 %06o %06o", dev.reg[0], dev.bytes, cpu.R[1], cpu.R[2], 0o37634, 0o37634, 0o77)
+	}
+
+	// Interrupt is taken before the next instruction.
+	if err := cpu.Step(1); err != nil {
+		t.Fatal(err)
+	}
+	if cpu.R[PC] != 0o1030 {
+		t.Fatalf("pc=%06o, want %06o", cpu.R[PC], 0o1030)
+	}
+	if err := cpu.Step(1); err != nil {
+		t.Fatal(err)
+	}
+	if cpu.R[PC] != 0o2062 || cpu.R[0] != 0 {
+		t.Fatalf("pc=%06o r0=%d, want %06o, 0 (interrupt)", cpu.R[PC], cpu.R[0], 0o2062)
+	}
+
+	// Unattached register is a bus error.
+	cpu.R[PC] = 0o1032
+	cpu.PS = 0
+	if err := cpu.Step(1); err != nil {
+		t.Fatal(err)
+	}
+	if cpu.R[PC] != 0o2004 {
+		t.Fatalf("pc=%06o, want %06o (bus error)", cpu.R[PC], 0o2004)
+	}
+
+	cpu.R[PC] = 0o1036
+	cpu.PS = 0
+	if err := cpu.Step(1); err != nil {
+		t.Fatal(err)
+	}
+	if !dev.reset {
+		t.Errorf("reset did not reset device")
+	}
+}
+
+func TestUnibusMMU(t *testing.T) {
+	cpu, _ := newMMUTest(t)
+	dev := &testDev{bus: NewUnibus(cpu)}
+	dev.bus.Attach(testDevAddr, 4, dev)
+	if err := cpu.WriteW(testDevAddr, 0o123); err != nil {
+		t.Fatal(err)
+	}
+	if dev.reg[0] != 0o123 {
+		t.Errorf("reg=%06o, want %06o", dev.reg[0], 0o123)
+	}
+	if _, err := cpu.ReadW(testDevAddr + 4); err != ErrMem {
+		t.Errorf("ReadW(unattached) = %v, want ErrMem", err)
+	}
+}
+
+func TestUnibusAttachOverlap(t *testing.T) {
+	b := NewUnibus(new(CPU))
+	b.Attach(0o177560, 8, new(testDev))
+	defer func() {
+		if recover() == nil {
+			t.Errorf("overlapping Attach did not panic")
+		}
+	}()
+	b.Attach(0o177566, 2, new(testDev))
+}
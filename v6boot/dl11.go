@@ -0,0 +1,135 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io"
+
+	"rsc.io/unix/pdp11"
+)
+
+// A dl11 is a DL11 serial line interface, used as the console terminal.
+// Output characters are written to out.
+// Input characters are queued by the machine loop calling receive.
+type dl11 struct {
+	bus *pdp11.Unibus
+	out io.Writer
+
+	rcsr uint16 // receiver status
+	rbuf uint16 // receiver buffer
+	xcsr uint16 // transmitter status
+	xbuf uint16 // transmitter buffer
+
+	input []byte // characters typed but not yet received
+	xbusy bool   // transmitter is sending xbuf
+}
+
+const (
+	dlAddr     = 0o177560
+	dlSize     = 0o10
+	dlRxVector = 0o60
+	dlTxVector = 0o64
+	dlPri      = 4
+
+	dlDone = 1 << 7 // RCSR done, XCSR ready
+	dlIE   = 1 << 6 // interrupt enable
+)
+
+func newDL11(bus *pdp11.Unibus, out io.Writer) *dl11 {
+	dl := &dl11{bus: bus, out: out}
+	dl.Reset()
+	bus.Attach(dlAddr, dlSize, dl)
+	return dl
+}
+
+func (dl *dl11) Reset() {
+	dl.rcsr = 0
+	dl.xcsr = dlDone
+	dl.xbusy = false
+	dl.bus.CancelInterrupt(dlRxVector)
+	dl.bus.CancelInterrupt(dlTxVector)
+}
+
+func (dl *dl11) ReadW(addr uint16) (uint16, error) {
+	switch addr - dlAddr {
+	case 0:
+		return dl.rcsr, nil
+	case 2:
+		dl.rcsr &^= dlDone
+		dl.bus.CancelInterrupt(dlRxVector)
+		return dl.rbuf, nil
+	case 4:
+		return dl.xcsr, nil
+	}
+	return 0, nil
+}
+
+func (dl *dl11) WriteW(addr, val uint16) error {
+	switch addr - dlAddr {
+	case 0:
+		dl.rcsr = setIE(dl.bus, dl.rcsr, val, dlRxVector)
+	case 4:
+		dl.xcsr = setIE(dl.bus, dl.xcsr, val, dlTxVector)
+	case 6:
+		if c := byte(val) & 0o177; c != 0 {
+			dl.out.Write([]byte{c})
+		}
+		dl.xcsr &^= dlDone
+		dl.xbusy = true
+		dl.bus.CancelInterrupt(dlTxVector)
+	}
+	return nil
+}
+
+func (dl *dl11) WriteB(addr uint16, val uint8) error {
+	if addr&1 != 0 {
+		return nil // high bytes are read-only
+	}
+	return dl.WriteW(addr, uint16(val))
+}
+
+// setIE returns the status register csr updated by a write of val,
+// which can only change the interrupt enable bit.
+// Enabling interrupts while the done bit is set requests an interrupt;
+// disabling them cancels any pending request.
+func setIE(bus *pdp11.Unibus, csr, val, vector uint16) uint16 {
+	csr = csr&^dlIE | val&dlIE
+	if csr&dlIE == 0 {
+		bus.CancelInterrupt(vector)
+	} else if csr&dlDone != 0 {
+		bus.Interrupt(dlPri, vector)
+	}
+	return csr
+}
+
+// poll completes a pending transmission and
+// delivers the next input character if the receiver is empty.
+func (dl *dl11) poll() {
+	if dl.xbusy {
+		dl.xbusy = false
+		dl.xcsr |= dlDone
+		if dl.xcsr&dlIE != 0 {
+			dl.bus.Interrupt(dlPri, dlTxVector)
+		}
+	}
+	if len(dl.input) > 0 && dl.rcsr&dlDone == 0 {
+		dl.rbuf = uint16(dl.input[0])
+		dl.input = dl.input[1:]
+		dl.rcsr |= dlDone
+		if dl.rcsr&dlIE != 0 {
+			dl.bus.Interrupt(dlPri, dlRxVector)
+		}
+	}
+}
+
+// receive queues the input character c.
+func (dl *dl11) receive(c byte) {
+	dl.input = append(dl.input, c)
+}
+
+// idle reports whether the console has nothing to do until more input arrives.
+func (dl *dl11) idle() bool {
+	return !dl.xbusy && (len(dl.input) == 0 || dl.rcsr&dlDone != 0)
+}
@@ -0,0 +1,74 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"rsc.io/unix/pdp11"
+)
+
+func newDLTest() (*dl11, *bytes.Buffer) {
+	var out bytes.Buffer
+	cpu := &pdp11.CPU{Mem: new(pdp11.ArrayMem)}
+	return newDL11(pdp11.NewUnibus(cpu), &out), &out
+}
+
+func TestDLOutput(t *testing.T) {
+	dl, out := newDLTest()
+	if xcsr, _ := dl.ReadW(dlAddr + 4); xcsr&dlDone == 0 {
+		t.Fatalf("transmitter not ready at reset")
+	}
+	dl.WriteB(dlAddr+6, 'h'|0o200) // parity bit is dropped
+	if xcsr, _ := dl.ReadW(dlAddr + 4); xcsr&dlDone != 0 || dl.idle() {
+		t.Errorf("transmitter ready while sending")
+	}
+	dl.poll()
+	if xcsr, _ := dl.ReadW(dlAddr + 4); xcsr&dlDone == 0 || !dl.idle() {
+		t.Errorf("transmitter not ready after sending")
+	}
+	dl.WriteW(dlAddr+6, 'i')
+	if out.String() != "hi" {
+		t.Errorf("output %q, want %q", out.String(), "hi")
+	}
+}
+
+func TestDLInput(t *testing.T) {
+	dl, _ := newDLTest()
+	dl.receive('a')
+	dl.receive('b')
+	if dl.idle() {
+		t.Errorf("idle with input queued")
+	}
+	for _, want := range "ab" {
+		dl.poll()
+		if rcsr, _ := dl.ReadW(dlAddr); rcsr&dlDone == 0 {
+			t.Fatalf("receiver not done with input queued")
+		}
+		dl.poll() // does not overwrite an unread character
+		if c, _ := dl.ReadW(dlAddr + 2); c != uint16(want) {
+			t.Errorf("RBUF = %q, want %q", rune(c), want)
+		}
+		if rcsr, _ := dl.ReadW(dlAddr); rcsr&dlDone != 0 {
+			t.Errorf("receiver still done after reading RBUF")
+		}
+	}
+	if !dl.idle() {
+		t.Errorf("not idle after reading all input")
+	}
+}
+
+func TestDLStatusWrite(t *testing.T) {
+	dl, _ := newDLTest()
+	dl.WriteW(dlAddr, 0o177777)
+	if rcsr, _ := dl.ReadW(dlAddr); rcsr != dlIE {
+		t.Errorf("RCSR = %06o after writing all ones, want %06o", rcsr, dlIE)
+	}
+	dl.WriteB(dlAddr+5, 0o377) // high byte is read-only
+	if xcsr, _ := dl.ReadW(dlAddr + 4); xcsr != dlDone {
+		t.Errorf("XCSR = %06o, want %06o", xcsr, dlDone)
+	}
+}
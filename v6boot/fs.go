@@ -0,0 +1,131 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// The V6 disk images have no bootstrap in block 0.
+// Before booting, v6boot copies one into the in-memory disk,
+// as an operator would have done with
+//
+//	dd if=/usr/mdec/rkuboot of=/dev/rk0 count=1
+//
+// To find the bootstrap, v6boot reads just enough of the
+// V6 file system format to look up a small file by name.
+
+const (
+	blockSize = 512
+	inodeSize = 32
+	rootIno   = 1
+	_ILARG    = 0o10000 // large file: addresses are indirect blocks
+	_IFDIR    = 0o40000
+	_IFMT     = 0o60000
+)
+
+// installBoot copies the bootstrap program /usr/mdec/rkuboot
+// into block 0 of disk, unless block 0 already holds a bootstrap.
+func installBoot(disk []byte) error {
+	if len(disk) < blockSize {
+		return fmt.Errorf("disk too small")
+	}
+	if binary.LittleEndian.Uint16(disk) == 0o407 {
+		// A bootstrap starts with an a.out header,
+		// whose 0407 magic number is a branch over the header.
+		return nil
+	}
+	boot, err := readFile(disk, "/usr/mdec/rkuboot")
+	if err != nil {
+		return fmt.Errorf("installing bootstrap: %v", err)
+	}
+	if len(boot) > blockSize {
+		boot = boot[:blockSize]
+	}
+	clear(disk[:blockSize])
+	copy(disk, boot)
+	return nil
+}
+
+// readFile returns the content of the named file in the V6 file system on disk.
+func readFile(disk []byte, name string) ([]byte, error) {
+	ino := rootIno
+	for _, elem := range strings.Split(strings.Trim(name, "/"), "/") {
+		dir, mode, err := readInode(disk, ino)
+		if err != nil {
+			return nil, err
+		}
+		if mode&_IFMT != _IFDIR {
+			return nil, fmt.Errorf("%s: not a directory", name)
+		}
+		ino = 0
+		for i := 0; i+16 <= len(dir); i += 16 {
+			n := strings.TrimRight(string(dir[i+2:i+16]), "\x00")
+			if n == elem {
+				ino = int(binary.LittleEndian.Uint16(dir[i:]))
+				break
+			}
+		}
+		if ino == 0 {
+			return nil, fmt.Errorf("%s: file not found", name)
+		}
+	}
+	data, _, err := readInode(disk, ino)
+	return data, err
+}
+
+// readInode returns the content and mode of inode ino on disk.
+func readInode(disk []byte, ino int) ([]byte, uint16, error) {
+	off := 2*blockSize + (ino-1)*inodeSize
+	if ino <= 0 || off+inodeSize > len(disk) {
+		return nil, 0, fmt.Errorf("invalid inode %d", ino)
+	}
+	ip := disk[off : off+inodeSize]
+	mode := binary.LittleEndian.Uint16(ip[0:])
+	size := int(ip[5])<<16 | int(binary.LittleEndian.Uint16(ip[6:]))
+	var addrs []int
+	for i := 0; i < 8; i++ {
+		addrs = append(addrs, int(binary.LittleEndian.Uint16(ip[8+2*i:])))
+	}
+	if mode&_ILARG != 0 {
+		// Only single indirect blocks: files up to 7*256 blocks.
+		var blocks []int
+		for _, b := range addrs[:7] {
+			ind, err := block(disk, b)
+			if err != nil {
+				return nil, 0, err
+			}
+			for i := 0; i < blockSize; i += 2 {
+				blocks = append(blocks, int(binary.LittleEndian.Uint16(ind[i:])))
+			}
+		}
+		addrs = blocks
+	}
+	var data []byte
+	for _, b := range addrs {
+		if len(data) >= size {
+			break
+		}
+		buf, err := block(disk, b)
+		if err != nil {
+			return nil, 0, err
+		}
+		data = append(data, buf...)
+	}
+	if len(data) < size {
+		return nil, 0, fmt.Errorf("inode %d: file too large", ino)
+	}
+	return data[:size], mode, nil
+}
+
+// block returns block b of disk.
+func block(disk []byte, b int) ([]byte, error) {
+	if (b+1)*blockSize > len(disk) {
+		return nil, fmt.Errorf("invalid block %d", b)
+	}
+	return disk[b*blockSize : (b+1)*blockSize], nil
+}
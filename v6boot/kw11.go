@@ -0,0 +1,77 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"time"
+
+	"rsc.io/unix/pdp11"
+)
+
+// A kw11 is a KW11-L line time clock, ticking at 60 Hz of host time.
+type kw11 struct {
+	bus  *pdp11.Unibus
+	lks  uint16    // clock status register
+	next time.Time // time of next tick
+}
+
+const (
+	kwAddr   = 0o177546
+	kwVector = 0o100
+	kwPri    = 6
+	kwTick   = time.Second / 60
+
+	kwMon = 1 << 7 // monitor: set at each tick
+	kwIE  = 1 << 6 // interrupt enable
+)
+
+func newKW11(bus *pdp11.Unibus) *kw11 {
+	kw := &kw11{bus: bus}
+	kw.Reset()
+	bus.Attach(kwAddr, 2, kw)
+	return kw
+}
+
+func (kw *kw11) Reset() {
+	kw.lks = kwMon
+	kw.next = time.Now().Add(kwTick)
+	kw.bus.CancelInterrupt(kwVector)
+}
+
+func (kw *kw11) ReadW(addr uint16) (uint16, error) {
+	return kw.lks, nil
+}
+
+func (kw *kw11) WriteW(addr, val uint16) error {
+	kw.lks = val & (kwMon | kwIE)
+	if kw.lks&kwIE == 0 {
+		kw.bus.CancelInterrupt(kwVector)
+	}
+	return nil
+}
+
+func (kw *kw11) WriteB(addr uint16, val uint8) error {
+	if addr&1 != 0 {
+		return nil
+	}
+	return kw.WriteW(addr, uint16(val))
+}
+
+// poll ticks the clock if a tick is due at time now.
+// If the host has fallen behind, poll ticks only once,
+// so that the clock slips instead of flooding the CPU with interrupts.
+func (kw *kw11) poll(now time.Time) {
+	if now.Before(kw.next) {
+		return
+	}
+	kw.next = kw.next.Add(kwTick)
+	if kw.next.Before(now) {
+		kw.next = now.Add(kwTick)
+	}
+	kw.lks |= kwMon
+	if kw.lks&kwIE != 0 {
+		kw.bus.Interrupt(kwPri, kwVector)
+	}
+}
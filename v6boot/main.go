@@ -0,0 +1,220 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// V6boot boots Research Unix Sixth Edition disk images
+// on a simulated PDP-11/40.
+//
+// Usage:
+//
+//	v6boot [-mem kb] [-sr octal] [-trace] [-unixasm] [-w] [disk ...]
+//
+// The simulated machine has a KT11-D memory management unit,
+// an RK11 disk controller with the disk image files attached as drives 0, 1, and so on
+// (default v6/v6root), a DL11 console connected to the terminal, a KW11-L line clock,
+// and a bootstrap ROM that reads block 0 of drive 0 and starts it.
+// The V6 disk images have no bootstrap in block 0, so v6boot
+// installs /usr/mdec/rkuboot there, in memory, before starting.
+// The bootstrap prints an @ prompt; type rkunix to boot the kernel.
+//
+// The -mem flag sets the size of memory in kilobytes (default 248).
+//
+// The -sr flag sets the console switch register (default 0).
+// Setting it to 173030 makes V6 come up single-user.
+//
+// The -trace flag prints every instruction executed to standard error,
+// in the format of pdp11.TextTracer.
+// The -unixasm flag prints the instructions in V6 as syntax
+// instead of DEC MACRO-11 syntax.
+//
+// The -w flag writes changes back to the disk image files.
+// By default, changes are discarded when v6boot exits.
+//
+// Typing Control-Backslash exits the simulator.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"golang.org/x/term"
+	"rsc.io/unix/pdp11"
+)
+
+var (
+	memKB   = flag.Int("mem", 248, "memory size in `kilobytes`")
+	srFlag  = flag.String("sr", "0", "set switch register to `octal` value")
+	trace   = flag.Bool("trace", false, "trace every instruction")
+	unixasm = flag.Bool("unixasm", false, "trace in V6 as syntax")
+	wflag   = flag.Bool("w", false, "write changes back to disk images")
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: v6boot [-mem kb] [-sr octal] [-trace] [-unixasm] [-w] [disk ...]\n")
+	os.Exit(2)
+}
+
+// A machine is a PDP-11/40 and its devices.
+type machine struct {
+	cpu   *pdp11.CPU
+	cons  *dl11
+	clock *kw11
+	disk  *rk11
+}
+
+func main() {
+	log.SetPrefix("v6boot: ")
+	log.SetFlags(0)
+	flag.Usage = usage
+	flag.Parse()
+
+	sr, err := strconv.ParseUint(*srFlag, 8, 16)
+	if err != nil {
+		log.Fatalf("invalid -sr: %v", err)
+	}
+	if *memKB <= 0 || *memKB > 248 {
+		log.Fatalf("invalid -mem: must be between 1 and 248")
+	}
+	disks := flag.Args()
+	if len(disks) == 0 {
+		disks = []string{"v6/v6root"}
+	}
+	if len(disks) > 8 {
+		log.Fatalf("too many disks")
+	}
+
+	m, err := newMachine(*memKB<<10, uint16(sr), os.Stdout)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for i, file := range disks {
+		if err := m.disk.attach(i, file, *wflag); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err := installBoot(m.disk.drive[0].data); err != nil {
+		log.Fatal(err)
+	}
+
+	fixup := func() {}
+	if term.IsTerminal(int(os.Stdin.Fd())) {
+		oldState, err := term.MakeRaw(int(os.Stdin.Fd()))
+		if err != nil {
+			log.Fatal(err)
+		}
+		fixup = func() { term.Restore(int(os.Stdin.Fd()), oldState) }
+		defer fixup()
+	}
+	if *trace {
+		var w io.Writer = os.Stderr
+		if term.IsTerminal(int(os.Stderr.Fd())) && term.IsTerminal(int(os.Stdin.Fd())) {
+			w = crlfWriter{w}
+		}
+		t := &pdp11.TextTracer{W: w}
+		if *unixasm {
+			t.Syntax = pdp11.Unix
+		}
+		m.cpu.Tracer = t
+	}
+
+	input := make(chan byte, 1000)
+	go func() {
+		buf := make([]byte, 100)
+		for {
+			n, err := os.Stdin.Read(buf)
+			for _, c := range buf[:n] {
+				if c == 0x1c {
+					fixup()
+					os.Exit(0)
+				}
+				input <- c
+			}
+			if err == io.EOF {
+				input <- 0o004
+				return
+			}
+			if err != nil {
+				fixup()
+				log.Fatalf("reading stdin: %v", err)
+			}
+		}
+	}()
+
+	err = m.run(input)
+	fixup()
+	fmt.Fprintf(os.Stderr, "\n")
+	log.Fatalf("%06o: %v", m.cpu.R[pdp11.PC], err)
+}
+
+// newMachine returns a new machine with size bytes of memory
+// and the switch register set to sr, with console output going to out.
+// The CPU is ready to start the bootstrap ROM.
+func newMachine(size int, sr uint16, out io.Writer) (*machine, error) {
+	mem := make(pdp11.CoreMem, size)
+	cpu := &pdp11.CPU{
+		MMU:      &pdp11.MMU{Mem: mem},
+		TrapMode: pdp11.TrapVector,
+		Model:    pdp11.PDP1140,
+	}
+	bus := pdp11.NewUnibus(cpu)
+	m := &machine{
+		cpu:   cpu,
+		cons:  newDL11(bus, out),
+		clock: newKW11(bus),
+		disk:  newRK11(bus, mem),
+	}
+	newSwitches(bus, sr)
+	if _, err := newROM(bus, bootAddr, bootRK); err != nil {
+		return nil, err
+	}
+	cpu.R[pdp11.PC] = bootAddr
+	cpu.PS = pdp11.PS_PRI
+	return m, nil
+}
+
+// run runs the machine until it halts,
+// reading console input from input.
+func (m *machine) run(input <-chan byte) error {
+	for {
+		m.cons.poll()
+		m.clock.poll(time.Now())
+		if err := m.cpu.Step(1000); err != nil {
+			return err
+		}
+
+		if m.cpu.Waiting && m.cons.idle() {
+			// Nothing to do until the next clock tick or input character.
+			select {
+			case c := <-input:
+				m.cons.receive(c)
+			case <-time.After(time.Until(m.clock.next)):
+			}
+			continue
+		}
+		select {
+		case c := <-input:
+			m.cons.receive(c)
+		default:
+		}
+	}
+}
+
+// A crlfWriter is a writer that translates \n to \r\n,
+// for writing to a terminal in raw mode.
+type crlfWriter struct {
+	w io.Writer
+}
+
+func (c crlfWriter) Write(b []byte) (int, error) {
+	_, err := c.w.Write(bytes.ReplaceAll(b, []byte("\n"), []byte("\r\n")))
+	if err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
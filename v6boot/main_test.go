@@ -0,0 +1,49 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+// TestBoot boots the V6 root disk to the login prompt.
+// Host time is simulated: a millisecond passes every thousand
+// instructions, and none while the CPU waits for the next clock tick.
+func TestBoot(t *testing.T) {
+	var out bytes.Buffer
+	m, err := newMachine(248<<10, 0, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.disk.attach(0, "../v6/v6root", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := installBoot(m.disk.drive[0].data); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []byte("rkunix\n") {
+		m.cons.receive(c)
+	}
+	now := time.Now()
+	for i := 0; i < 20000 && !bytes.Contains(out.Bytes(), []byte("login: ")); i++ {
+		m.cons.poll()
+		m.clock.poll(now)
+		if err := m.cpu.Step(1000); err != nil {
+			t.Fatalf("Step: %v\noutput:\n%s", err, out.Bytes())
+		}
+		now = now.Add(time.Millisecond)
+		if m.cpu.Waiting && m.cons.idle() {
+			now = m.clock.next
+		}
+	}
+	if want := "@rkunix\r\n"; !bytes.HasPrefix(out.Bytes(), []byte(want)) {
+		t.Errorf("output does not start with %q:\n%s", want, out.Bytes())
+	}
+	if !bytes.Contains(out.Bytes(), []byte("login: ")) {
+		t.Fatalf("no login prompt; output:\n%s", out.Bytes())
+	}
+}
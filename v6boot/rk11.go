@@ -0,0 +1,266 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"log"
+	"os"
+
+	"rsc.io/unix/pdp11"
+)
+
+// An rk11 is an RK11 disk controller with up to eight RK05 drives.
+// Each drive is backed by an in-memory copy of a disk image file.
+// Transfers complete immediately, as soon as the GO bit is set.
+type rk11 struct {
+	bus   *pdp11.Unibus
+	mem   pdp11.PhysMemory
+	drive [8]*rk05
+
+	er uint16 // RKER, error register
+	cs uint16 // RKCS, control status register
+	wc uint16 // RKWC, word count register (negative)
+	ba uint16 // RKBA, current bus address
+	da uint16 // RKDA, disk address
+}
+
+// An rk05 is a single disk drive.
+type rk05 struct {
+	data []byte
+	file *os.File // file to write through to, or nil
+}
+
+const (
+	rkAddr   = 0o177400 // RKDS, first register
+	rkSize   = 0o20
+	rkVector = 0o220
+	rkPri    = 5
+
+	rkSectors = 12                  // sectors per track
+	rkBlocks  = 203 * 2 * rkSectors // blocks per RK05 pack
+)
+
+// RKCS bits.
+const (
+	rkGO   = 1 << 0
+	rkFUNC = 7 << 1
+	rkMEX  = 3 << 4
+	rkIDE  = 1 << 6
+	rkRDY  = 1 << 7
+	rkERR  = 1 << 15
+
+	rkWritable = rkGO | rkFUNC | rkMEX | rkIDE | 0o6400 // plus SSE, FMT, IBA
+)
+
+// RK functions (RKCS bits 3-1).
+const (
+	rkReset = iota
+	rkWrite
+	rkRead
+	rkWriteCheck
+	rkSeek
+	rkReadCheck
+	rkDriveReset
+	rkWriteLock
+)
+
+// RKER bits.
+const (
+	rkNXS = 1 << 6  // nonexistent sector
+	rkNXD = 1 << 7  // nonexistent drive
+	rkNXM = 1 << 10 // nonexistent memory
+)
+
+// RKDS bits for a ready RK05.
+const rkDSReady = 0o4000 | 0o200 | 0o100 | 0o20 // RK05, drive ready, R/W/S ready, sector counter OK
+
+func newRK11(bus *pdp11.Unibus, mem pdp11.PhysMemory) *rk11 {
+	rk := &rk11{bus: bus, mem: mem}
+	rk.Reset()
+	bus.Attach(rkAddr, rkSize, rk)
+	return rk
+}
+
+// attach attaches the disk image file to drive n.
+// If write is true, writes to the drive are written back to the file.
+// Otherwise they are kept in memory only.
+func (rk *rk11) attach(n int, file string, write bool) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	d := &rk05{data: data}
+	if write {
+		f, err := os.OpenFile(file, os.O_WRONLY, 0)
+		if err != nil {
+			return err
+		}
+		d.file = f
+	}
+	rk.drive[n] = d
+	return nil
+}
+
+func (rk *rk11) Reset() {
+	rk.er = 0
+	rk.cs = rkRDY
+	rk.wc = 0
+	rk.ba = 0
+	rk.da = 0
+	rk.bus.CancelInterrupt(rkVector)
+}
+
+func (rk *rk11) ReadW(addr uint16) (uint16, error) {
+	switch addr - rkAddr {
+	case 0o0: // RKDS
+		if rk.drive[rk.da>>13] == nil {
+			return 0, nil
+		}
+		return rkDSReady | rk.da&0o160000 | rk.da&0o17, nil
+	case 0o2:
+		return rk.er, nil
+	case 0o4:
+		cs := rk.cs
+		if rk.er != 0 {
+			cs |= rkERR
+		}
+		return cs, nil
+	case 0o6:
+		return rk.wc, nil
+	case 0o10:
+		return rk.ba, nil
+	case 0o12:
+		return rk.da, nil
+	}
+	return 0, nil // RKMR, RKDB
+}
+
+func (rk *rk11) WriteW(addr, val uint16) error {
+	if rk.cs&rkRDY == 0 {
+		return nil // controller busy; registers are read-only
+	}
+	switch addr - rkAddr {
+	case 0o4:
+		ide := rk.cs & rkIDE
+		rk.cs = rk.cs&^rkWritable | val&rkWritable
+		if rk.cs&rkGO != 0 {
+			rk.do()
+		} else if ide == 0 && rk.cs&rkIDE != 0 {
+			// Setting IDE while ready requests an interrupt.
+			rk.bus.Interrupt(rkPri, rkVector)
+		} else if rk.cs&rkIDE == 0 {
+			rk.bus.CancelInterrupt(rkVector)
+		}
+	case 0o6:
+		rk.wc = val
+	case 0o10:
+		rk.ba = val
+	case 0o12:
+		rk.da = val
+	}
+	return nil
+}
+
+func (rk *rk11) WriteB(addr uint16, val uint8) error {
+	w, _ := rk.ReadW(addr &^ 1)
+	if addr&1 == 0 {
+		w = w&0xff00 | uint16(val)
+	} else {
+		w = w&0x00ff | uint16(val)<<8
+	}
+	return rk.WriteW(addr&^1, w)
+}
+
+// do executes the function in RKCS.
+func (rk *rk11) do() {
+	rk.cs &^= rkGO
+	rk.er = 0
+	switch (rk.cs & rkFUNC) >> 1 {
+	case rkReset:
+		rk.cs &^= rkFUNC | rkMEX
+		rk.da = 0
+		rk.ba = 0
+		rk.wc = 0
+	case rkWrite, rkRead, rkWriteCheck, rkReadCheck:
+		rk.transfer()
+	}
+	rk.cs |= rkRDY
+	if rk.cs&rkIDE != 0 {
+		rk.bus.Interrupt(rkPri, rkVector)
+	}
+}
+
+// transfer executes a read or write.
+func (rk *rk11) transfer() {
+	fn := (rk.cs & rkFUNC) >> 1
+	d := rk.drive[rk.da>>13]
+	if d == nil {
+		rk.er |= rkNXD
+		return
+	}
+	sector := int(rk.da & 0o17)
+	if sector >= rkSectors {
+		rk.er |= rkNXS
+		return
+	}
+	block := int(rk.da>>4&0o777)*rkSectors + sector
+	ba := uint32(rk.cs&rkMEX)<<12 | uint32(rk.ba)
+	start := block * 512
+	off := start
+	for rk.wc != 0 && off < rkBlocks*512 {
+		switch fn {
+		case rkRead:
+			var w uint16
+			if off+1 < len(d.data) {
+				w = uint16(d.data[off]) | uint16(d.data[off+1])<<8
+			}
+			if rk.mem.WriteW(ba, w) != nil {
+				rk.er |= rkNXM
+			}
+		case rkWrite:
+			w, err := rk.mem.ReadW(ba)
+			if err != nil {
+				rk.er |= rkNXM
+			}
+			d.write(off, w)
+		}
+		if rk.er != 0 {
+			break
+		}
+		off += 2
+		ba = (ba + 2) & (1<<18 - 1)
+		rk.wc++
+	}
+	if fn == rkWrite {
+		// Writes always fill out the last sector with zeros.
+		for off%512 != 0 {
+			d.write(off, 0)
+			off += 2
+		}
+		d.flush(start, off)
+	}
+	rk.ba = uint16(ba)
+	rk.cs = rk.cs&^rkMEX | uint16(ba>>12)&rkMEX
+	block = (off + 511) / 512
+	rk.da = rk.da&0o160000 | uint16(block/rkSectors)<<4 | uint16(block%rkSectors)
+}
+
+// write writes the word w to the drive at byte offset off.
+func (d *rk05) write(off int, w uint16) {
+	if off+2 > len(d.data) {
+		d.data = append(d.data, make([]byte, off+2-len(d.data))...)
+	}
+	d.data[off] = uint8(w)
+	d.data[off+1] = uint8(w >> 8)
+}
+
+// flush writes bytes [start, end) back to the drive's file, if any.
+func (d *rk05) flush(start, end int) {
+	if d.file != nil {
+		if _, err := d.file.WriteAt(d.data[start:end], int64(start)); err != nil {
+			log.Printf("rk: %v", err)
+		}
+	}
+}
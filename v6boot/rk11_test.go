@@ -0,0 +1,118 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"testing"
+
+	"rsc.io/unix/pdp11"
+)
+
+// newRKTest returns an RK11 with a single drive
+// whose every word holds its own byte offset divided by 2.
+func newRKTest(t *testing.T) (*rk11, pdp11.CoreMem) {
+	mem := make(pdp11.CoreMem, 256<<10)
+	cpu := &pdp11.CPU{MMU: &pdp11.MMU{Mem: mem}}
+	rk := newRK11(pdp11.NewUnibus(cpu), mem)
+	d := &rk05{data: make([]byte, rkBlocks*512)}
+	for i := 0; i < len(d.data); i += 2 {
+		d.write(i, uint16(i/2))
+	}
+	rk.drive[0] = d
+	return rk, mem
+}
+
+// rkda returns the RKDA value for block b of drive n.
+func rkda(n, b int) uint16 {
+	return uint16(n)<<13 | uint16(b/rkSectors)<<4 | uint16(b%rkSectors)
+}
+
+func TestRKRead(t *testing.T) {
+	rk, mem := newRKTest(t)
+	const block = 100
+	rk.WriteW(rkAddr+0o12, rkda(0, block))
+	rk.WriteW(rkAddr+0o10, 0o1000)
+	rk.WriteW(rkAddr+0o6, 1<<16-300)           // one block and part of the next
+	rk.WriteW(rkAddr+0o4, 0o20|rkRead<<1|rkGO) // memory extension bits 17-16 = 01
+	for i := uint32(0); i < 300; i++ {
+		if w, _ := mem.ReadW(0o201000 + 2*i); w != uint16(block*256+i) {
+			t.Fatalf("word %d = %06o, want %06o", i, w, uint16(block*256+i))
+		}
+	}
+	if w, _ := mem.ReadW(0o201000 + 2*300); w != 0 {
+		t.Errorf("read past word count: %06o", w)
+	}
+	cs, _ := rk.ReadW(rkAddr + 0o4)
+	if cs&(rkRDY|rkERR|rkGO) != rkRDY {
+		t.Errorf("RKCS = %06o, want ready, no error", cs)
+	}
+	if rk.wc != 0 || rk.ba != 0o1000+600 || cs&rkMEX != 0o20 {
+		t.Errorf("RKWC = %06o RKBA = %06o RKCS = %06o after transfer", rk.wc, rk.ba, cs)
+	}
+	if want := rkda(0, block+2); rk.da != want {
+		t.Errorf("RKDA = %06o, want %06o", rk.da, want)
+	}
+}
+
+func TestRKWrite(t *testing.T) {
+	rk, mem := newRKTest(t)
+	const block = 7
+	for i := uint32(0); i < 10; i++ {
+		mem.WriteW(0o1000+2*i, 0o100+uint16(i))
+	}
+	rk.WriteW(rkAddr+0o12, rkda(0, block))
+	rk.WriteW(rkAddr+0o10, 0o1000)
+	rk.WriteW(rkAddr+0o6, 1<<16-10)
+	rk.WriteW(rkAddr+0o4, rkWrite<<1|rkGO)
+	data := rk.drive[0].data
+	for i := 0; i < 256; i++ {
+		want := uint16(0)
+		if i < 10 {
+			want = 0o100 + uint16(i)
+		}
+		off := block*512 + 2*i
+		if w := uint16(data[off]) | uint16(data[off+1])<<8; w != want {
+			t.Fatalf("word %d = %06o, want %06o", i, w, want)
+		}
+	}
+	if off := (block + 1) * 512; uint16(data[off])|uint16(data[off+1])<<8 != (block+1)*256 {
+		t.Errorf("write changed the next block")
+	}
+	if want := rkda(0, block+1); rk.da != want {
+		t.Errorf("RKDA = %06o, want %06o", rk.da, want)
+	}
+}
+
+func TestRKErrors(t *testing.T) {
+	for _, tt := range []struct {
+		da uint16
+		er uint16
+	}{
+		{rkda(1, 0), rkNXD},
+		{rkda(0, 0) | 0o14, rkNXS},
+	} {
+		rk, _ := newRKTest(t)
+		rk.WriteW(rkAddr+0o12, tt.da)
+		rk.WriteW(rkAddr+0o6, 0o177777)
+		rk.WriteW(rkAddr+0o4, rkRead<<1|rkGO)
+		er, _ := rk.ReadW(rkAddr + 0o2)
+		cs, _ := rk.ReadW(rkAddr + 0o4)
+		if er != tt.er || cs&(rkERR|rkRDY) != rkERR|rkRDY {
+			t.Errorf("RKDA %06o: RKER = %06o RKCS = %06o, want RKER = %06o, error and ready", tt.da, er, cs, tt.er)
+		}
+	}
+}
+
+func TestRKStatus(t *testing.T) {
+	rk, _ := newRKTest(t)
+	rk.WriteW(rkAddr+0o12, rkda(0, 5))
+	if ds, _ := rk.ReadW(rkAddr); ds != rkDSReady|5 {
+		t.Errorf("RKDS = %06o, want %06o", ds, rkDSReady|5)
+	}
+	rk.WriteW(rkAddr+0o12, rkda(1, 5))
+	if ds, _ := rk.ReadW(rkAddr); ds != 0 {
+		t.Errorf("RKDS for missing drive = %06o, want 0", ds)
+	}
+}
@@ -0,0 +1,87 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"rsc.io/unix/pdp11"
+	"rsc.io/unix/pdp11/asm"
+)
+
+// A rom is a read-only memory in the I/O page.
+type rom struct {
+	addr uint16
+	code []uint16
+}
+
+// bootAddr is the address of the bootstrap ROM,
+// where the BM792 and M9301 bootstraps live.
+const bootAddr = 0o173000
+
+// bootRK is the RK11 bootstrap.
+// It reads block 0 of drive 0 into memory at address 0
+// and then jumps to address 0.
+const bootRK = `
+	mov	#177412, r1	; RKDA
+	clr	(r1)		; disk address 0
+	clr	-(r1)		; RKBA = 0
+	mov	#177400, -(r1)	; RKWC = -256 words
+	mov	#5, -(r1)	; RKCS = read + go
+1:	tstb	(r1)		; wait for ready
+	bpl	1b
+	clr	pc		; jump to block 0
+`
+
+// newROM assembles src starting at addr and attaches the result to bus.
+func newROM(bus *pdp11.Unibus, addr uint16, src string) (*rom, error) {
+	obj, err := asm.Assemble("rom", []byte(src), addr)
+	if err != nil {
+		return nil, err
+	}
+	r := &rom{addr: addr}
+	for i := 0; i+1 < len(obj.Text); i += 2 {
+		r.code = append(r.code, uint16(obj.Text[i])|uint16(obj.Text[i+1])<<8)
+	}
+	bus.Attach(addr, 2*uint16(len(r.code)), r)
+	return r, nil
+}
+
+func (r *rom) ReadW(addr uint16) (uint16, error) {
+	return r.code[(addr-r.addr)/2], nil
+}
+
+func (r *rom) WriteW(addr, val uint16) error       { return nil }
+func (r *rom) WriteB(addr uint16, val uint8) error { return nil }
+
+// A switches is the console switch register and display register.
+type switches struct {
+	sr      uint16 // switch register, read
+	display uint16 // display register, written
+}
+
+const swAddr = 0o177570
+
+func newSwitches(bus *pdp11.Unibus, sr uint16) *switches {
+	sw := &switches{sr: sr}
+	bus.Attach(swAddr, 2, sw)
+	return sw
+}
+
+func (sw *switches) ReadW(addr uint16) (uint16, error) {
+	return sw.sr, nil
+}
+
+func (sw *switches) WriteW(addr, val uint16) error {
+	sw.display = val
+	return nil
+}
+
+func (sw *switches) WriteB(addr uint16, val uint8) error {
+	if addr&1 == 0 {
+		sw.display = sw.display&0xff00 | uint16(val)
+	} else {
+		sw.display = sw.display&0x00ff | uint16(val)<<8
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// V6ld is the Research Unix Sixth Edition link editor, running on the host.
+// It links V6 object files and archives into an a.out file
+// identical to the one the V6 /bin/ld would write (see package ld).
+//
+// Usage:
+//
+//	v6ld [-o out] [-disk disk.txtar] [ld arguments]
+//
+// The ld arguments are those of the V6 ld command, such as
+//
+//	v6ld -o hello /lib/crt0.o hello.o -lc
+//
+// The -o flag names the output file (default a.out),
+// and the -disk flag names the txtar disk to read files from
+// (default the v6unix disk; see v6disk).
+// Because ld's arguments are processed in order,
+// these flags must come first.
+//
+// Each input file, including libraries such as /lib/libc.a (-lc),
+// is read from the disk if it exists there, and otherwise from the host.
+//
+// As with ld, undefined symbols are listed and the output is written anyway,
+// with its relocation kept, but it is not made executable.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+
+	"rsc.io/unix/ld"
+	"rsc.io/unix/v6unix"
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: v6ld [-o out] [-disk disk.txtar] [ld arguments]\n")
+	os.Exit(2)
+}
+
+func main() {
+	log.SetPrefix("v6ld: ")
+	log.SetFlags(0)
+
+	outfile := "a.out"
+	diskfile := ""
+	args := os.Args[1:]
+	for len(args) > 0 && (args[0] == "-o" || args[0] == "-disk") {
+		if len(args) < 2 {
+			usage()
+		}
+		if args[0] == "-o" {
+			outfile = args[1]
+		} else {
+			diskfile = args[1]
+		}
+		args = args[2:]
+	}
+	if len(args) == 0 {
+		usage()
+	}
+
+	disk := v6unix.FS
+	if diskfile != "" {
+		var err error
+		if disk, err = os.ReadFile(diskfile); err != nil {
+			log.Fatal(err)
+		}
+	}
+	sys, err := v6unix.NewSystem(disk)
+	if err != nil {
+		log.Fatal(err)
+	}
+	read := func(name string) ([]byte, error) {
+		if data, err := sys.ReadFile(name); err == nil {
+			return data, nil
+		}
+		return os.ReadFile(name)
+	}
+
+	out, err := ld.Link(args, read)
+	var lerr *ld.Error
+	if err != nil && !errors.As(err, &lerr) {
+		log.Fatal(err)
+	}
+	status := 0
+	mode := os.FileMode(0o777)
+	if lerr != nil {
+		if len(lerr.Undefined) > 0 {
+			fmt.Fprintf(os.Stderr, "Undefined:\n")
+			for _, name := range lerr.Undefined {
+				fmt.Fprintf(os.Stderr, "%s\n", name)
+			}
+			status = 1
+		}
+		for _, msg := range lerr.Msgs {
+			fmt.Fprintf(os.Stderr, "%s\n", msg)
+			status = 2
+		}
+		mode = 0o666
+	}
+	os.Remove(outfile)
+	if err := os.WriteFile(outfile, out, mode); err != nil {
+		log.Fatal(err)
+	}
+	os.Exit(status)
+}
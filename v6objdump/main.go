@@ -0,0 +1,455 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// V6objdump prints the contents of a Research Unix Sixth Edition
+// a.out executable or object file.
+//
+// Usage:
+//
+//	v6objdump [-disk disk.txtar] [-host] [-h] [-t] [-d] [-unixasm] file
+//
+// By default, file names a file on the disk used by v6unix.
+// The -disk flag reads the named txtar disk instead (see v6disk),
+// and the -host flag reads file from the host file system.
+//
+// V6objdump prints the file header (-h), the symbol table (-t),
+// and a disassembly of the text segment (-d).
+// If none of those flags are given, it prints all three.
+// The symbol table is listed in the format of nm -n.
+//
+// The disassembly separates instructions from data by following
+// control flow from the entry point and from every text symbol.
+// Words that are never reached, such as jump tables and strings,
+// are printed as .word directives.
+// The words following a sys instruction are its inline arguments.
+// The disassembly labels addresses with the symbol table, when there is one,
+// and otherwise with generated labels of the form L1234 (in octal).
+//
+// The -unixasm flag prints the disassembly in V6 as syntax
+// instead of DEC syntax.
+package main
+
+import (
+	"cmp"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"slices"
+	"strings"
+
+	"rsc.io/unix/aout"
+	"rsc.io/unix/pdp11"
+	"rsc.io/unix/v6unix"
+)
+
+var (
+	diskfile = flag.String("disk", "", "read file from the txtar disk `file` (default the v6unix disk)")
+	hostfile = flag.Bool("host", false, "read file from the host file system")
+	hflag    = flag.Bool("h", false, "print the file header")
+	tflag    = flag.Bool("t", false, "print the symbol table")
+	dflag    = flag.Bool("d", false, "disassemble the text segment")
+	unixasm  = flag.Bool("unixasm", false, "disassemble in V6 as syntax")
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: v6objdump [-disk disk.txtar] [-host] [-h] [-t] [-d] [-unixasm] file\n")
+	os.Exit(2)
+}
+
+func main() {
+	log.SetPrefix("v6objdump: ")
+	log.SetFlags(0)
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() != 1 {
+		usage()
+	}
+	name := flag.Arg(0)
+
+	data, err := readFile(name)
+	if err != nil {
+		log.Fatal(err)
+	}
+	f, err := aout.Parse(data)
+	if err != nil {
+		log.Fatalf("%s: %v", name, err)
+	}
+
+	if !*hflag && !*tflag && !*dflag {
+		*hflag, *tflag, *dflag = true, true, true
+	}
+	syntax := pdp11.DEC
+	if *unixasm {
+		syntax = pdp11.Unix
+	}
+	var sections []func()
+	if *hflag {
+		sections = append(sections, func() { printHeader(os.Stdout, f) })
+	}
+	if *tflag {
+		sections = append(sections, func() { printSyms(os.Stdout, f) })
+	}
+	if *dflag {
+		sections = append(sections, func() { newDisasm(f, syntax).print(os.Stdout) })
+	}
+	for i, sect := range sections {
+		if i > 0 {
+			fmt.Printf("\n")
+		}
+		sect()
+	}
+}
+
+// readFile reads the named file from the host or from the V6 disk.
+func readFile(name string) ([]byte, error) {
+	if *hostfile {
+		return os.ReadFile(name)
+	}
+	disk := v6unix.FS
+	if *diskfile != "" {
+		var err error
+		if disk, err = os.ReadFile(*diskfile); err != nil {
+			return nil, err
+		}
+	}
+	sys, err := v6unix.NewSystem(disk)
+	if err != nil {
+		return nil, err
+	}
+	data, err := sys.ReadFile(name)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	return data, nil
+}
+
+var magicNames = map[uint16]string{
+	aout.MagicOverlay: "overlay",
+	aout.MagicImpure:  "impure text",
+	aout.MagicPure:    "pure text",
+	aout.MagicSep:     "separate I&D",
+}
+
+func printHeader(w io.Writer, f *aout.File) {
+	fmt.Fprintf(w, "magic  %06o (%s)\n", f.Magic, magicNames[f.Magic])
+	fmt.Fprintf(w, "text   %06o at 0\n", f.TextSize)
+	fmt.Fprintf(w, "data   %06o at %06o\n", f.DataSize, f.DataAddr())
+	fmt.Fprintf(w, "bss    %06o at %06o\n", f.BSSSize, f.DataAddr()+f.DataSize)
+	fmt.Fprintf(w, "syms   %06o (%d symbols)\n", f.SymSize, len(f.Syms))
+	fmt.Fprintf(w, "entry  %06o\n", f.Entry)
+	if f.HasReloc() {
+		fmt.Fprintf(w, "reloc  present\n")
+	} else {
+		fmt.Fprintf(w, "reloc  suppressed\n")
+	}
+}
+
+// printSyms prints the symbol table sorted by value, like nm -n.
+func printSyms(w io.Writer, f *aout.File) {
+	if len(f.Syms) == 0 {
+		fmt.Fprintf(w, "no symbols\n")
+		return
+	}
+	syms := slices.Clone(f.Syms)
+	slices.SortStableFunc(syms, func(x, y aout.Sym) int {
+		return cmp.Compare(x.Value, y.Value)
+	})
+	for _, s := range syms {
+		c := symLetter(s)
+		if c == 'u' || c == 'U' {
+			fmt.Fprintf(w, "       %c %s\n", c, s.Name)
+		} else {
+			fmt.Fprintf(w, "%06o %c %s\n", s.Value, c, s.Name)
+		}
+	}
+}
+
+// symLetter returns the letter nm uses for the type of s:
+// u, a, t, d, b, or c (common), capitalized for external symbols.
+func symLetter(s aout.Sym) byte {
+	j := s.Type &^ aout.SymExt
+	if j > aout.SymBSS {
+		j = aout.SymAbs
+	}
+	if j == aout.SymUndef && s.Value != 0 {
+		j = 5
+	}
+	if s.Type&aout.SymExt != 0 {
+		return "UATDBC"[j]
+	}
+	return "uatdbc"[j]
+}
+
+// sysArgs is the number of inline argument words
+// following each V6 system call instruction (see v6unix's sysent).
+// The indirect system call (sys 0) takes the address of the real one.
+var sysArgs = [64]uint8{
+	1, 0, 0, 2, 2, 2, 0, 0, 2, 2, 1, 2, 1, 0, 3, 2,
+	2, 1, 2, 2, 0, 3, 1, 0, 0, 0, 3, 0, 1, 0, 1, 1,
+	1, 0, 0, 0, 0, 1, 0, 0, 0, 0, 0, 1, 4, 0, 0, 0,
+	2,
+}
+
+const sysExit = 1
+
+// A wordKind classifies a word of text.
+type wordKind uint8
+
+const (
+	unknown wordKind = iota // not reached: data
+	code                    // start of an instruction
+	operand                 // index or immediate word of an instruction
+	sysArg                  // inline system call argument
+)
+
+// A disasm holds the state for disassembling an a.out file.
+type disasm struct {
+	f      *aout.File
+	syntax pdp11.Syntax
+	kind   []wordKind            // kind of each text word
+	labels map[uint16]string     // labels for text addresses
+	syms   []aout.Sym            // defined symbols, sorted by value
+	reloc  map[uint16]aout.Reloc // relocation for text addresses, if any
+}
+
+func newDisasm(f *aout.File, syntax pdp11.Syntax) *disasm {
+	d := &disasm{
+		f:      f,
+		syntax: syntax,
+		kind:   make([]wordKind, len(f.Text)/2),
+		labels: make(map[uint16]string),
+		reloc:  make(map[uint16]aout.Reloc),
+	}
+	for _, s := range f.Syms {
+		switch s.Type &^ aout.SymExt {
+		case aout.SymText:
+			if _, ok := d.labels[s.Value]; !ok {
+				d.labels[s.Value] = s.Name
+			}
+			fallthrough
+		case aout.SymData, aout.SymBSS:
+			d.syms = append(d.syms, s)
+		}
+	}
+	slices.SortStableFunc(d.syms, func(x, y aout.Sym) int {
+		return cmp.Compare(x.Value, y.Value)
+	})
+	for i, r := range f.TextReloc {
+		if r.Kind != aout.RelAbs {
+			d.reloc[uint16(2*i)] = r
+		}
+	}
+	d.trace()
+	return d
+}
+
+func (d *disasm) read(addr uint16) (uint16, error) {
+	if int(addr)+2 > len(d.f.Text) || addr&1 != 0 {
+		return 0, pdp11.ErrMem
+	}
+	return uint16(d.f.Text[addr]) | uint16(d.f.Text[addr+1])<<8, nil
+}
+
+// trace marks the text words reachable by control flow
+// from the entry point and the text symbols.
+func (d *disasm) trace() {
+	work := []uint16{d.f.Entry}
+	for _, s := range d.syms {
+		if s.Type&^aout.SymExt == aout.SymText {
+			work = append(work, s.Value)
+		}
+	}
+	for len(work) > 0 {
+		pc := work[len(work)-1]
+		work = work[:len(work)-1]
+		for {
+			if int(pc)/2 >= len(d.kind) || pc&1 != 0 || d.kind[pc/2] != unknown {
+				break
+			}
+			inst, err := pdp11.Decode(pc, d.read)
+			if err != nil {
+				break
+			}
+			next := pc + inst.Len
+			if !d.claim(pc, next) {
+				break
+			}
+			d.kind[pc/2] = code
+			for a := pc + 2; a < next; a += 2 {
+				d.kind[a/2] = operand
+			}
+
+			// Find where control goes next.
+			fall := true
+			switch inst.Op {
+			case "br", "jmp", "rts", "rti", "rtt", "halt":
+				fall = false
+			case "trap":
+				n := inst.Args[0].Word & 077
+				if n == sysExit {
+					fall = false
+					break
+				}
+				end := next + 2*uint16(sysArgs[n])
+				if !d.claim(next, end) {
+					fall = false
+					break
+				}
+				for a := next; a < end; a += 2 {
+					d.kind[a/2] = sysArg
+				}
+				next = end
+			}
+			for _, a := range inst.Args {
+				if t, ok := jumpTarget(&inst, &a); ok {
+					work = append(work, t)
+					if _, ok := d.labels[t]; !ok && int(t) < len(d.f.Text) {
+						d.labels[t] = fmt.Sprintf("L%o", t)
+					}
+				}
+			}
+			if !fall {
+				break
+			}
+			pc = next
+		}
+	}
+}
+
+// claim reports whether the text words in [pc, end) are all unclaimed.
+func (d *disasm) claim(pc, end uint16) bool {
+	if end < pc || int(end) > len(d.f.Text) {
+		return false
+	}
+	for a := pc; a < end; a += 2 {
+		if d.kind[a/2] != unknown {
+			return false
+		}
+	}
+	return true
+}
+
+// jumpTarget returns the address to which inst can transfer control
+// through its operand a, if it is known statically.
+func jumpTarget(inst *pdp11.Inst, a *pdp11.Arg) (uint16, bool) {
+	switch {
+	case a.Kind == pdp11.ArgBranch:
+		return a.Target, true
+	case a.Kind == pdp11.ArgGen && (inst.Op == "jmp" || inst.Op == "jsr") &&
+		a.Reg == pdp11.PC && (a.Mode == 3 || a.Mode == 6):
+		return a.Target, true
+	}
+	return 0, false
+}
+
+// symbolize returns a symbolic name for addr, or "" if there is none.
+func (d *disasm) symbolize(addr uint16) string {
+	if l, ok := d.labels[addr]; ok {
+		return l
+	}
+	i, found := slices.BinarySearchFunc(d.syms, addr, func(s aout.Sym, addr uint16) int {
+		return cmp.Compare(s.Value, addr)
+	})
+	if found {
+		return d.syms[i].Name
+	}
+	end := d.f.DataAddr() + d.f.DataSize + d.f.BSSSize
+	if i == 0 || addr >= end && end != 0 {
+		return ""
+	}
+	s := d.syms[i-1]
+	return fmt.Sprintf("%s+%o", s.Name, addr-s.Value)
+}
+
+// relocName returns a symbolic name for the word w at addr
+// using the relocation for addr, or "" if there is none.
+func (d *disasm) relocName(addr, w uint16) string {
+	r, ok := d.reloc[addr]
+	if !ok {
+		return ""
+	}
+	if r.PCRel {
+		// The word is relative to the PC after it.
+		w += addr + 2
+	}
+	if r.Kind == aout.RelExt {
+		name := d.f.Syms[r.Sym].Name
+		if w != 0 {
+			name += fmt.Sprintf("+%o", w)
+		}
+		return name
+	}
+	return d.symbolize(w)
+}
+
+func (d *disasm) print(w io.Writer) {
+	comment := ";"
+	if d.syntax == pdp11.Unix {
+		comment = "/"
+	}
+	for pc := uint16(0); int(pc)+1 < len(d.f.Text); {
+		if l, ok := d.labels[pc]; ok {
+			fmt.Fprintf(w, "%s:\n", l)
+		}
+		var words []uint16
+		var text string
+		var notes []string
+		if d.kind[pc/2] == code {
+			inst, _ := pdp11.Decode(pc, d.read)
+			text = inst.Format(pc, d.syntax)
+			for i := uint16(0); i < inst.Len; i += 2 {
+				word, _ := d.read(pc + i)
+				words = append(words, word)
+			}
+			wordAddr := pc + 2
+			for _, a := range inst.Args {
+				if a.HasWord() {
+					notes = append(notes, d.argNote(&a, wordAddr))
+					wordAddr += 2
+				} else if a.Kind == pdp11.ArgBranch {
+					notes = append(notes, d.symbolize(a.Target))
+				}
+			}
+		} else {
+			word, _ := d.read(pc)
+			words = []uint16{word}
+			text = fmt.Sprintf(".word %o", word)
+			if n := d.relocName(pc, word); n != "" {
+				notes = append(notes, n)
+			} else if d.kind[pc/2] == sysArg {
+				notes = append(notes, d.symbolize(word))
+			}
+		}
+
+		var hex []string
+		for _, word := range words {
+			hex = append(hex, fmt.Sprintf("%06o", word))
+		}
+		line := fmt.Sprintf("  %06o: %-20s %s", pc, strings.Join(hex, " "), text)
+		notes = slices.DeleteFunc(notes, func(s string) bool { return s == "" })
+		if len(notes) > 0 {
+			line = fmt.Sprintf("%-52s %s %s", line, comment, strings.Join(notes, ", "))
+		}
+		fmt.Fprintf(w, "%s\n", line)
+		pc += 2 * uint16(len(words))
+	}
+}
+
+// argNote returns a symbolic note for the operand a,
+// whose index or immediate word is at addr.
+func (d *disasm) argNote(a *pdp11.Arg, addr uint16) string {
+	if n := d.relocName(addr, a.Word); n != "" {
+		return n
+	}
+	if a.Reg != pdp11.PC {
+		return ""
+	}
+	switch a.Mode {
+	case 3, 6, 7:
+		return d.symbolize(a.Target)
+	}
+	return ""
+}
@@ -0,0 +1,70 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"rsc.io/unix/aout"
+	"rsc.io/unix/pdp11"
+)
+
+// A program with a system call, a jump table, and a string in its text.
+// The jump table targets are reached only through their symbols,
+// and the table and the string have none.
+var testText = []uint16{
+	0o012700, 0o1, // 0: mov $1, r0
+	0o104404, 0o34, 0o6, // 4: sys write; str; 6
+	0o006300,       // 12: asl r0
+	0o000170, 0o20, // 14: jmp *tab(r0)
+	0o24, 0o30, // 20: tab: a; b
+	0o005000,                                  // 24: a: clr r0
+	0o104401,                                  // 26: sys exit
+	0o005200,                                  // 30: b: inc r0
+	0o000774,                                  // 32: br a
+	'h' | 'e'<<8, 'l' | 'l'<<8, 'o' | '\n'<<8, // 34: str: <hello\n>
+}
+
+var testSyms = []aout.Sym{
+	{Name: "start", Type: aout.SymText | aout.SymExt, Value: 0},
+	{Name: "a", Type: aout.SymText, Value: 0o24},
+	{Name: "b", Type: aout.SymText, Value: 0o30},
+}
+
+const testDisasm = `start:
+  000000: 012700 000001        mov $1,r0
+  000004: 104404               sys write
+  000006: 000034               .word 34              / b+4
+  000010: 000006               .word 6               / start+6
+  000012: 006300               asl r0
+  000014: 000170 000020        jmp *20(r0)
+  000020: 000024               .word 24
+  000022: 000030               .word 30
+a:
+  000024: 005000               clr r0
+  000026: 104401               sys exit
+b:
+  000030: 005200               inc r0
+  000032: 000774               br .-6                / a
+  000034: 062550               .word 62550
+  000036: 066154               .word 66154
+  000040: 005157               .word 5157
+`
+
+// The disassembly follows control flow to separate instructions
+// from the system call arguments, the jump table, and the string.
+func TestDisasm(t *testing.T) {
+	f := &aout.File{Header: aout.Header{Magic: aout.MagicImpure}, Syms: testSyms}
+	for _, w := range testText {
+		f.Text = binary.LittleEndian.AppendUint16(f.Text, w)
+	}
+	var buf bytes.Buffer
+	newDisasm(f, pdp11.Unix).print(&buf)
+	if buf.String() != testDisasm {
+		t.Errorf("disassembly:\n%s\nwant:\n%s", buf.String(), testDisasm)
+	}
+}
@@ -5,20 +5,34 @@
 package main
 
 import (
+	"bufio"
 	"flag"
 	"io"
 	"log"
 	"os"
 	"runtime/pprof"
+	"strconv"
+	"strings"
 	"time"
 
 	"golang.org/x/term"
+	"rsc.io/unix/pdp11"
 	"rsc.io/unix/v6unix"
 )
 
 var (
-	trace      = flag.Bool("trace", false, "trace every instruction")
+	trace      = flag.Bool("trace", false, "trace every instruction and system call")
+	systrace   = flag.Bool("systrace", false, "trace system calls")
+	itrace     = flag.String("itrace", "", "write a text trace of every instruction to `file` (- for standard error)")
+	btrace     = flag.String("btrace", "", "write a binary trace of every instruction to `file`")
+	tracepid   = flag.Int("tracepid", 0, "trace instructions only in process `pid`")
+	tracepc    = flag.String("tracepc", "", "trace instructions only at addresses in the octal range `lo-hi`")
+	checkpoint = flag.String("checkpoint", "", "restore the system from `file` if it exists, and save it there on exit")
 	cpuprofile = flag.String("cpuprofile", "", "write cpuprofile to `file`")
+	throttle   = flag.String("throttle", "", "run at the speed of a real PDP-11/`model` (40 or 45)")
+	model      = flag.String("model", "", "simulate the instruction set of a PDP-11/`model` (20, 40, 45, or 70)")
+	strict     = flag.Bool("strict", false, "trap memory accesses outside each process's segments and odd word accesses")
+	unixasm    = flag.Bool("unixasm", false, "disassemble instructions in text traces in V6 as syntax")
 )
 
 func main() {
@@ -49,25 +63,61 @@ func main() {
 		log.Fatal(err)
 	}
 	sys.Trace = *trace
-
-	aout, err := sys.ReadFile("/etc/init")
-	if err != nil {
-		log.Fatal(err)
+	sys.TraceSys = *systrace
+	sys.Strict = *strict
+	flushTrace := setTracer(sys)
+	defer flushTrace()
+	switch *model {
+	case "":
+	case "20":
+		sys.Model = pdp11.PDP1120
+	case "40":
+		sys.Model = pdp11.PDP1140
+	case "45":
+		sys.Model = pdp11.PDP1145
+	case "70":
+		sys.Model = pdp11.PDP1170
+	default:
+		log.Fatalf("unknown -model %q", *model)
+	}
+	switch *throttle {
+	case "":
+	case "40":
+		sys.Timing, sys.Throttle = pdp11.Timing40, true
+	case "45":
+		sys.Timing, sys.Throttle = pdp11.Timing45, true
+	default:
+		log.Fatalf("unknown -throttle model %q", *throttle)
 	}
 
-	_, err = sys.Start(aout, []string{"/etc/init"}, os.Stdout)
-	if err != nil {
-		log.Fatal(err)
+	if !restore(sys) {
+		aout, err := sys.ReadFile("/etc/init")
+		if err != nil {
+			log.Fatal(err)
+		}
+		_, err = sys.Start(aout, []string{"/etc/init"}, os.Stdout)
+		if err != nil {
+			log.Fatal(err)
+		}
 	}
+
 	input := make(chan byte, 1000)
+	quit := make(chan bool)
 	go func() {
 		buf := make([]byte, 100)
-		defer close(input)
 		for {
 			n, err := os.Stdin.Read(buf)
 			for _, c := range buf[:n] {
+				if c == 0x1c && *checkpoint != "" {
+					// Let the main loop save the checkpoint
+					// once the system is idle. Input stays open:
+					// reading a closed input would look like EOF.
+					close(quit)
+					return
+				}
 				if c == 0x1c {
 					pprof.StopCPUProfile()
+					flushTrace()
 					fixup()
 					os.Exit(0)
 				}
@@ -83,6 +133,12 @@ func main() {
 
 	for {
 		sys.Wait()
+		select {
+		case <-quit:
+			save(sys)
+			return
+		default:
+		}
 		var c1 chan byte
 		if sys.TTYRead != 0 {
 			c1 = input
@@ -117,6 +173,115 @@ func main() {
 			}
 		case <-c2:
 			// timer went off; sys.Wait will notice
+		case <-quit:
+			save(sys)
+			return
+		}
+	}
+}
+
+// restore restores sys from the -checkpoint file, if any.
+// It reports whether sys was restored.
+func restore(sys *v6unix.System) bool {
+	if *checkpoint == "" {
+		return false
+	}
+	f, err := os.Open(*checkpoint)
+	if os.IsNotExist(err) {
+		return false
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+	if err := sys.Restore(bufio.NewReader(f), os.Stdout); err != nil {
+		log.Fatalf("%s: %v", *checkpoint, err)
+	}
+	return true
+}
+
+// save writes a checkpoint of sys to the -checkpoint file.
+func save(sys *v6unix.System) {
+	f, err := os.Create(*checkpoint)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	w := bufio.NewWriter(f)
+	err = sys.Checkpoint(w)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err1 := f.Close(); err == nil {
+		err = err1
+	}
+	if err != nil {
+		log.Printf("%s: %v", *checkpoint, err)
+	}
+}
+
+// setTracer sets sys.Tracer according to the instruction tracing flags.
+// It returns a function to flush the traces at exit.
+func setTracer(sys *v6unix.System) (flush func()) {
+	var tracers []pdp11.Tracer
+	var flushes []func() error
+	if *itrace != "" {
+		w := bufio.NewWriter(os.Stderr)
+		if *itrace != "-" {
+			f, err := os.Create(*itrace)
+			if err != nil {
+				log.Fatal(err)
+			}
+			w = bufio.NewWriter(f)
+		}
+		tt := &pdp11.TextTracer{W: w}
+		if *unixasm {
+			tt.Syntax = pdp11.Unix
+		}
+		tracers = append(tracers, tt)
+		flushes = append(flushes, w.Flush)
+	}
+	if *btrace != "" {
+		f, err := os.Create(*btrace)
+		if err != nil {
+			log.Fatal(err)
+		}
+		t := pdp11.NewBinaryTracer(f)
+		tracers = append(tracers, t)
+		flushes = append(flushes, t.Flush)
+	}
+	if len(tracers) == 0 {
+		return func() {}
+	}
+
+	t := tracers[0]
+	if len(tracers) > 1 {
+		t = pdp11.TracerFunc(func(r *pdp11.TraceRecord) {
+			for _, t := range tracers {
+				t.Trace(r)
+			}
+		})
+	}
+	if *tracepc != "" {
+		lo, hi, ok := strings.Cut(*tracepc, "-")
+		l, err1 := strconv.ParseUint(lo, 8, 16)
+		h, err2 := strconv.ParseUint(hi, 8, 16)
+		if !ok || err1 != nil || err2 != nil {
+			log.Fatalf("invalid -tracepc %q", *tracepc)
+		}
+		t = pdp11.TracePC(t, uint16(l), uint16(h))
+	}
+	sys.Tracer = func(pid int) pdp11.Tracer {
+		if *tracepid != 0 && pid != *tracepid {
+			return nil
+		}
+		return t
+	}
+	return func() {
+		for _, flush := range flushes {
+			if err := flush(); err != nil {
+				log.Print(err)
+			}
 		}
 	}
 }
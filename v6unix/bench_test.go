@@ -0,0 +1,55 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package v6unix
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// login starts a system and logs in as root on the console.
+func login(tb testing.TB) (*System, *bytes.Buffer) {
+	sys, err := NewSystem(FS)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	aout, err := sys.ReadFile("/etc/init")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	var stdout bytes.Buffer
+	if _, err := sys.Start(aout, []string{"/etc/init"}, &stdout); err != nil {
+		tb.Fatal(err)
+	}
+	sys.Wait()
+	typeString(sys, "root\r")
+	typeString(sys, "root\r")
+	return sys, &stdout
+}
+
+// typeString types s on the console and waits for the system to go idle.
+func typeString(sys *System, s string) {
+	for _, c := range []byte(s) {
+		sys.TTY[8].WriteByte(c)
+	}
+	sys.Wait()
+}
+
+// BenchmarkLs measures a system call heavy command:
+// ls -l makes a stat call for every file it lists.
+func BenchmarkLs(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		sys, stdout := login(b)
+		stdout.Reset()
+		b.StartTimer()
+		typeString(sys, "ls -l /usr/source/*\r")
+		b.StopTimer()
+		if !strings.Contains(stdout.String(), "ls.c") {
+			b.Fatalf("ls output missing ls.c:\n%s", stdout)
+		}
+	}
+}
@@ -0,0 +1,257 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package v6unix
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"time"
+)
+
+// A checkpoint is a gob-encoded checkpoint struct.
+// It records the file system as the inodes that differ from
+// the file system archive the system was created from,
+// so it can only be restored into a system created from the same archive.
+// Processes are saved as in process snapshots (see Proc.Snapshot):
+// a process blocked in a system call restarts the call after a restore.
+
+const checkpointVersion = 1
+
+type checkpoint struct {
+	Version  int
+	Archive  [sha256.Size]byte // hash of the file system archive
+	NInodes  int               // number of inode slots
+	Inodes   []inodeSnap       // inodes that differ from the archive
+	Procs    []procSnap
+	Files    []fileSnap
+	Pipes    []pipeSnap
+	TTY      [len(System{}.TTY)]ttySnap
+	Timer    time.Time
+	NextPid  int16
+	TTYRead  uint16
+	CurPri   int8
+	RunRun   int8
+	SwtchPos int
+}
+
+// An inodeSnap is the saved state of an inode.
+type inodeSnap struct {
+	Inum  uint16
+	Free  bool // inode is not allocated
+	Dev   uint16
+	Mode  uint16
+	Nlink int8
+	Uid   int8
+	Gid   int8
+	Minor uint8
+	Major uint8
+	Addr  [7]uint16
+	Atime [2]uint16
+	Mtime [2]uint16
+	Data  []byte
+}
+
+// A ttySnap is the saved state of a TTY.
+type ttySnap struct {
+	Flags  uint16
+	Delct  int8
+	Col    int8
+	Erase  uint8
+	Kill   uint8
+	State  uint8
+	Speeds uint16
+	Minor  uint8
+	Major  uint8
+	XState uint16 // TTY.State
+	Raw    []byte
+	Canon  []byte
+	EOF    bool
+	XDelct int // TTY.Delct
+}
+
+// Checkpoint writes a checkpoint of the entire system to w:
+// the file system, the processes and their open files and pipes,
+// and the TTYs.
+// It must be called only while the system is idle,
+// such as after Wait returns: if processes are running,
+// Checkpoint returns an error instead.
+func (sys *System) Checkpoint(w io.Writer) error {
+	if sys.running.Load() {
+		return fmt.Errorf("checkpoint: system is running")
+	}
+	base, err := newDisk(sys.archive)
+	if err != nil {
+		return err
+	}
+	c := &checkpoint{
+		Version:  checkpointVersion,
+		Archive:  sha256.Sum256(sys.archive),
+		NInodes:  len(sys.Disk.inodes),
+		Timer:    sys.Timer,
+		NextPid:  sys.NextPid,
+		TTYRead:  sys.TTYRead,
+		CurPri:   sys.curpri,
+		RunRun:   sys.runrun,
+		SwtchPos: sys.swtchpos,
+	}
+	for i, ip := range sys.Disk.inodes {
+		var old *inode
+		if i < len(base.inodes) {
+			old = base.inodes[i]
+		}
+		if ip == nil {
+			if old != nil {
+				c.Inodes = append(c.Inodes, inodeSnap{Inum: uint16(i), Free: true})
+			}
+			continue
+		}
+		if old != nil && old.stat == ip.stat && bytes.Equal(old.data, ip.data) {
+			continue
+		}
+		c.Inodes = append(c.Inodes, inodeSnap{
+			Inum:  uint16(i),
+			Dev:   ip.dev,
+			Mode:  ip.mode,
+			Nlink: ip.nlink,
+			Uid:   ip.uid,
+			Gid:   ip.gid,
+			Minor: ip.minor,
+			Major: ip.major,
+			Addr:  ip.addr,
+			Atime: ip.atime,
+			Mtime: ip.mtime,
+			Data:  ip.data,
+		})
+	}
+
+	var e snapEncoder
+	for _, p := range sys.Procs {
+		s, err := e.proc(p)
+		if err != nil {
+			return err
+		}
+		c.Procs = append(c.Procs, *s)
+	}
+	c.Files = e.Files
+	c.Pipes = e.Pipes
+
+	for i := range sys.TTY {
+		t := &sys.TTY[i]
+		c.TTY[i] = ttySnap{
+			Flags:  t.flags,
+			Delct:  t.delct,
+			Col:    t.col,
+			Erase:  t.erase,
+			Kill:   t.kill,
+			State:  t.state,
+			Speeds: t.speeds,
+			Minor:  t.minor,
+			Major:  t.major,
+			XState: t.State,
+			Raw:    t.Raw.Bytes(),
+			Canon:  t.Canon.Bytes(),
+			EOF:    t.EOF,
+			XDelct: t.Delct,
+		}
+	}
+	return gob.NewEncoder(w).Encode(c)
+}
+
+// Restore restores a checkpoint written by Checkpoint into sys,
+// which must have been created by NewSystem with the same
+// file system archive and not yet started.
+// Like Start, it connects the console TTY to stdout.
+// If Restore returns an error, sys is left partially restored
+// and should not be used.
+func (sys *System) Restore(r io.Reader, stdout io.Writer) error {
+	if len(sys.Procs) > 0 {
+		return fmt.Errorf("restore: system already started")
+	}
+	var c checkpoint
+	if err := gob.NewDecoder(r).Decode(&c); err != nil {
+		return fmt.Errorf("restore: %v", err)
+	}
+	if c.Version != checkpointVersion {
+		return fmt.Errorf("restore: unsupported version %d", c.Version)
+	}
+	if c.Archive != sha256.Sum256(sys.archive) {
+		return fmt.Errorf("restore: checkpoint is for a different file system")
+	}
+
+	d := sys.Disk
+	for len(d.inodes) < c.NInodes {
+		d.inodes = append(d.inodes, nil)
+	}
+	d.inodes = d.inodes[:c.NInodes]
+	for _, s := range c.Inodes {
+		if int(s.Inum) >= len(d.inodes) {
+			return fmt.Errorf("restore: invalid inode %d", s.Inum)
+		}
+		if s.Free {
+			d.inodes[s.Inum] = nil
+			continue
+		}
+		ip := &inode{stat: stat{
+			dev:   s.Dev,
+			inum:  s.Inum,
+			mode:  s.Mode,
+			nlink: s.Nlink,
+			uid:   s.Uid,
+			gid:   s.Gid,
+			minor: s.Minor,
+			major: s.Major,
+			addr:  s.Addr,
+			atime: s.Atime,
+			mtime: s.Mtime,
+		}}
+		ip.data = s.Data
+		ip.writeSize()
+		d.inodes[s.Inum] = ip
+	}
+
+	for i := range sys.TTY {
+		t, s := &sys.TTY[i], &c.TTY[i]
+		t.flags = s.Flags
+		t.delct = s.Delct
+		t.col = s.Col
+		t.erase = s.Erase
+		t.kill = s.Kill
+		t.state = s.State
+		t.speeds = s.Speeds
+		t.minor = s.Minor
+		t.major = s.Major
+		t.State = s.XState
+		t.Raw.Reset()
+		t.Raw.Write(s.Raw)
+		t.Canon.Reset()
+		t.Canon.Write(s.Canon)
+		t.EOF = s.EOF
+		t.Delct = s.XDelct
+	}
+	sys.TTY[8].Print = consolePrint(stdout)
+
+	dec := &snapDecoder{sys: sys, Files: c.Files, Pipes: c.Pipes, linkPipes: true}
+	for i := range c.Procs {
+		p, err := dec.proc(&c.Procs[i])
+		if err != nil {
+			return fmt.Errorf("restore: %v", err)
+		}
+		sys.Procs = append(sys.Procs, p)
+		if p.status != _SZOMB {
+			sys.setrun(p)
+		}
+	}
+
+	sys.Timer = c.Timer
+	sys.NextPid = c.NextPid
+	sys.TTYRead = c.TTYRead
+	sys.curpri = c.CurPri
+	sys.runrun = c.RunRun
+	sys.swtchpos = c.SwtchPos
+	return nil
+}
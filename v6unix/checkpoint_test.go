@@ -0,0 +1,118 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package v6unix
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"rsc.io/unix/pdp11"
+)
+
+// A logged-in shell session survives a checkpoint and restore.
+func TestCheckpoint(t *testing.T) {
+	sys, err := NewSystem(FS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aout, err := sys.ReadFile("/etc/init")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stdout bytes.Buffer
+	if _, err := sys.Start(aout, []string{"/etc/init"}, &stdout); err != nil {
+		t.Fatal(err)
+	}
+	sys.Wait()
+	typ := func(sys *System, s string) {
+		for _, c := range []byte(s) {
+			sys.TTY[8].WriteByte(c)
+		}
+		sys.Wait()
+	}
+	typ(sys, "root\r")
+	typ(sys, "root\r")
+	typ(sys, "chdir /tmp\r")
+	typ(sys, "(sleep 2; echo done) &\r")
+	typ(sys, "echo hello >x\r")
+	typ(sys, "cat\r")
+	want := "\n\rlogin: root\r\nPassword: \r\n# chdir /tmp\n# (sleep 2; echo done) &\n11\r\n# echo hello >x\n# cat\n"
+	if stdout.String() != want {
+		t.Fatalf("before checkpoint: have stdout=%q, want %q", stdout.String(), want)
+	}
+
+	var buf bytes.Buffer
+	if err := sys.Checkpoint(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	sys2, err := NewSystem(FS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stdout2 bytes.Buffer
+	if err := sys2.Restore(&buf, &stdout2); err != nil {
+		t.Fatal(err)
+	}
+	sys2.Wait()
+	typ(sys2, "typed\r")
+	typ(sys2, "\004")
+	typ(sys2, "cat x\r")
+	want = "typed\n\x04typed\r\n# cat x\nhello\r\n# "
+	if stdout2.String() != want {
+		t.Fatalf("after restore: have stdout=%q, want %q", stdout2.String(), want)
+	}
+
+	// The background job, sleeping during the checkpoint, finishes.
+	for start := time.Now(); time.Since(start) < 10*time.Second; {
+		time.Sleep(100 * time.Millisecond)
+		sys2.Wait()
+		if strings.HasSuffix(stdout2.String(), "done\r\n") {
+			break
+		}
+	}
+	if want += "done\r\n"; stdout2.String() != want {
+		t.Fatalf("after background job: have stdout=%q, want %q", stdout2.String(), want)
+	}
+
+	sys3, err := NewSystem(FS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sys3.Restore(&buf, &stdout2); err == nil {
+		t.Fatalf("Restore of empty checkpoint succeeded")
+	}
+}
+
+// Checkpoint fails while processes are running.
+func TestCheckpointRunning(t *testing.T) {
+	sys, err := NewSystem(FS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cerr error
+	traced := false
+	sys.Tracer = func(pid int) pdp11.Tracer {
+		return pdp11.TracerFunc(func(*pdp11.TraceRecord) {
+			if !traced {
+				traced = true
+				cerr = sys.Checkpoint(io.Discard)
+			}
+		})
+	}
+	if _, err := sys.Start(exe(t, "clr r0", "trap 1"), []string{"exit"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	sys.Wait()
+	if !traced || cerr == nil {
+		t.Fatalf("Checkpoint while running: traced=%v err=%v, want error", traced, cerr)
+	}
+	if err := sys.Checkpoint(io.Discard); err != nil {
+		t.Fatalf("Checkpoint after Wait: %v", err)
+	}
+}
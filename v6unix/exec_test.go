@@ -0,0 +1,106 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package v6unix
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"testing"
+
+	"rsc.io/unix/aout"
+)
+
+// sysArgsProg returns a program making system calls with inline arguments,
+// with its data at address d.
+// The direct arguments follow the sys instructions in text,
+// while the indirect system call and its arguments are in data.
+func sysArgsProg(d uint16) (text []string, data []byte) {
+	word := func(n uint16) string { return fmt.Sprintf(".word %o", n) }
+	text = []string{
+		"trap 5", word(d), ".word 1", // 0: sys open; tty; 1
+		"mov r0, r1",
+		"trap 4", word(d + 0o12), ".word 6", // 10: sys write; hello; 6
+		"mov r1, r0",
+		"trap 0", word(d + 0o20), // 20: sys 0; indir
+		"clr r0",
+		"trap 1", // 26: sys exit
+	}
+	data = []byte("/dev/tty8\x00" + // 0: tty
+		"HELLO\n") // 12: hello
+	data = binary.LittleEndian.AppendUint16(data, 0o104404) // 20: indir: sys write; world; 6
+	data = binary.LittleEndian.AppendUint16(data, d+0o26)
+	data = binary.LittleEndian.AppendUint16(data, 6)
+	data = append(data, "WORLD\n"...) // 26: world
+	return text, data
+}
+
+// TestExecSysArgs checks that inline system call arguments are read
+// from instruction space in a separate I/D (0411) program.
+func TestExecSysArgs(t *testing.T) {
+	for _, magic := range []uint16{aout.MagicImpure, aout.MagicSep} {
+		// A 0407 program's data follows its 12-word text.
+		d := uint16(0)
+		if magic == aout.MagicImpure {
+			d = 0o30
+		}
+		text, data := sysArgsProg(d)
+		exe := exeFile(t, &aout.File{Header: aout.Header{Magic: magic}, Data: data}, text...)
+
+		sys, err := NewSystem(FS)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var stdout bytes.Buffer
+		p, err := sys.Start(exe, []string{"sysargs"}, &stdout)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sys.Wait()
+		if p.status != _SZOMB || p.Args[0] != 0 {
+			t.Errorf("%06o: process did not exit normally", magic)
+		}
+		if out := stdout.String(); out != "HELLO\r\nWORLD\r\n" {
+			t.Errorf("%06o: output %q, want %q", magic, out, "HELLO\r\nWORLD\r\n")
+		}
+	}
+}
+
+// TestExecTooBig checks that exec refuses a program whose data and bss
+// do not fit in memory, or, on a strict system, do not leave room for the stack.
+func TestExecTooBig(t *testing.T) {
+	for _, tt := range []struct {
+		bss    uint16
+		strict bool
+		ok     bool
+	}{
+		{0o177776, false, false}, // wraps around the address space
+		{0o160000, false, true},
+		{0o160000, true, false}, // no page left for the stack
+		{0o140000, true, true},
+	} {
+		prog := exe(t, "clr r0", "trap 1")
+		binary.LittleEndian.PutUint16(prog[6:], tt.bss)
+		sys, err := NewSystem(FS)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sys.Strict = tt.strict
+		p, err := sys.Start(prog, []string{"big"}, nil)
+		if !tt.ok {
+			if err == nil || p != nil {
+				t.Errorf("bss %06o strict=%v: Start succeeded, want ENOMEM", tt.bss, tt.strict)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("bss %06o strict=%v: %v", tt.bss, tt.strict, err)
+			continue
+		}
+		if want := 4 + tt.bss; p.DataSize != want {
+			t.Errorf("bss %06o strict=%v: DataSize = %06o, want %06o", tt.bss, tt.strict, p.DataSize, want)
+		}
+	}
+}
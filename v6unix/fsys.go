@@ -134,7 +134,9 @@ func newDisk(archive []byte) (*Disk, error) {
 					}
 					ip.data = dec
 				} else {
-					ip.data = file.Data
+					// Copy the data so that writes to the file
+					// do not modify the archive.
+					ip.data = bytes.Clone(file.Data)
 				}
 			}
 			ip.writeSize()
@@ -7,13 +7,14 @@ package v6unix
 import (
 	"bytes"
 	_ "embed"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"os"
 	"runtime"
-	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"rsc.io/unix/pdp11"
@@ -26,26 +27,32 @@ type Proc struct {
 	procState
 
 	Sys     *System
-	CPU     pdp11.CPU      // cpu state
-	Mem     pdp11.ArrayMem // process memory
-	Args    [4]uint16      // syscall args
-	Error   Errno          // syscall error
-	Gid     int8           // effective group id
-	RUid    int8           // real user id
-	RGid    int8           // real group id
-	Sig     int8           // pending signal
-	Dir     *inode         // directory
-	Files   [NOFILE]*File  // fd table
-	Signals [NSIG]uint16   // signal handlers
+	CPU     pdp11.CPU       // cpu state
+	Mem     pdp11.ArrayMem  // process memory
+	Text    *pdp11.ArrayMem // separate text (I-space) memory for 0411 programs, or nil
+	Args    [4]uint16       // syscall args
+	Error   Errno           // syscall error
+	Gid     int8            // effective group id
+	RUid    int8            // real user id
+	RGid    int8            // real group id
+	Sig     int8            // pending signal
+	Dir     *inode          // directory
+	Files   [NOFILE]*File   // fd table
+	Signals [NSIG]uint16    // signal handlers
 	Prof    [4]uint16
 	Times
 	Nice      int16
-	TextSize  uint16
-	DataStart uint16
-	DataSize  uint16
+	TextSize  uint16 // size of text segment
+	DataStart uint16 // address of data segment
+	DataSize  uint16 // size of data segment, including bss and memory added by break
+	StackSize uint16 // size of stack segment, which ends at the top of memory
+	memMap    pdp11.MemMap
 	wkey      any
 	sched     chan bool
 	TTY       *TTY
+	inTrap    bool      // executing a system call
+	trapR     [8]uint16 // registers at start of system call
+	trapPS    pdp11.PS  // PS at start of system call
 }
 
 type procState struct {
@@ -79,8 +86,51 @@ type System struct {
 	TTYRead  uint16     // 1<<X bit means ttyX has a pending read
 	TTY      [1 + 8]TTY // TTY[1]..TTY[8] is /dev/tty1..tty8
 
-	idle  chan bool
+	idle    chan bool
+	running atomic.Bool // processes are running, in Wait
+	archive []byte      // file system archive passed to NewSystem
+
+	// Trace, if set, traces every instruction and system call
+	// to standard error. Instructions are traced with a pdp11.TextTracer,
+	// unless Tracer is set, in which case Tracer is used instead.
 	Trace bool
+
+	// TraceSys, if set, traces only system calls to standard error.
+	TraceSys bool
+
+	// Tracer, if non-nil, returns the instruction tracer
+	// for the process with the given pid, or nil to leave it untraced.
+	Tracer func(pid int) pdp11.Tracer
+
+	// Record, if positive, is the number of recent instructions
+	// each process keeps in its execution history (see pdp11.CPU.Record),
+	// for stepping backward from a crash.
+	Record int
+
+	// Model, if non-nil, is the processor model for processes.
+	Model *pdp11.Model
+
+	// Strict, if set, limits each process's memory accesses to its
+	// text, data, and stack segments, as the PDP-11 memory management
+	// unit did (see pdp11.MemMap). An access outside them sends SIGSEG,
+	// unless it is just below the stack, in which case the stack grows,
+	// and a word access at an odd address sends SIGBUS.
+	// Break and stack growth fail when the segments would not fit
+	// in the address space; otherwise they always succeed.
+	Strict bool
+
+	// Timing, if non-nil, is the instruction timing model for processes.
+	// If Throttle is also set, processes run no faster than that model's
+	// processor would, in real time.
+	Timing   *pdp11.Timing
+	Throttle bool
+	simTime  time.Duration // simulated processor time, for throttling
+	realTime time.Time     // real time corresponding to simTime = 0
+}
+
+// traceSys reports whether system calls are being traced.
+func (s *System) traceSys() bool {
+	return s.Trace || s.TraceSys
 }
 
 func (s *System) lookpid(pid int16) *Proc {
@@ -143,6 +193,62 @@ func (p *Proc) mem(addr, count uint16) []byte {
 	return p.Mem[addr : addr+count]
 }
 
+// recordMem runs f, which may change p's memory directly,
+// and, if p is recording its execution, adds the changes
+// to the history, so that stepping backward across a system call
+// or signal delivery undoes them (see pdp11.CPU.NoteWrite).
+func (p *Proc) recordMem(f func()) {
+	if p.Sys.Record <= 0 {
+		f()
+		return
+	}
+	old := p.Mem
+	f()
+	for i, b := range p.Mem {
+		if b != old[i] {
+			p.CPU.NoteWrite(uint16(i), old[i], b)
+		}
+	}
+}
+
+// setMemMap updates the process's memory map after a change
+// to its segments, if the system is checking memory accesses.
+func (p *Proc) setMemMap() {
+	if !p.Sys.Strict {
+		return
+	}
+	m := &p.memMap
+	m.Text = pdp11.Extent{Start: 0, End: uint32(p.TextSize)}
+	m.Data = pdp11.Extent{Start: uint32(p.DataStart), End: uint32(p.DataStart) + uint32(p.DataSize)}
+	m.Stack = pdp11.Extent{Start: 1<<16 - uint32(p.StackSize), End: 1 << 16}
+	p.CPU.MemMap = m
+}
+
+// fits reports whether a data segment of size dsize and a stack of
+// size ssize fit in memory: like the PDP-11/40 memory management unit,
+// the text, data, and stack must each start on a separate 8kB page.
+func (p *Proc) fits(dsize, ssize uint16) bool {
+	return segsFit(int(p.DataStart), int(dsize), int(ssize))
+}
+
+// segsFit reports whether a data segment of dsize bytes starting at start
+// and a stack of ssize bytes fit in the eight pages of the address space.
+func segsFit(start, dsize, ssize int) bool {
+	const page = 0o20000
+	pages := func(n int) int { return (n + page - 1) / page }
+	return pages(start+dsize)+pages(ssize) <= 8
+}
+
+// setText sets the process's separate instruction space to text,
+// or removes it if text is nil.
+func (p *Proc) setText(text *pdp11.ArrayMem) {
+	p.Text = text
+	p.CPU.IMem = nil
+	if text != nil {
+		p.CPU.IMem = text
+	}
+}
+
 type Times struct {
 	UTime  int16
 	STime  int16
@@ -170,6 +276,15 @@ func (sys *System) Fork(parent *Proc) (*Proc, error) {
 	p.CPU.R = parent.CPU.R
 	p.CPU.PS = parent.CPU.PS
 	p.Mem = parent.Mem
+	if parent.Text != nil {
+		text := *parent.Text
+		p.setText(&text)
+	}
+	p.TextSize = parent.TextSize
+	p.DataStart = parent.DataStart
+	p.DataSize = parent.DataSize
+	p.StackSize = parent.StackSize
+	p.setMemMap()
 	p.Ppid = parent.Pid
 	p.Uid = parent.Uid
 	p.RUid = p.Uid
@@ -194,6 +309,12 @@ func (sys *System) newProc() *Proc {
 	p := new(Proc)
 	p.Sys = sys
 	p.CPU.Mem = &p.Mem
+	p.CPU.Model = sys.Model
+	p.CPU.Timing = sys.Timing
+	p.StackSize = SSIZE * 64
+	if sys.Record > 0 {
+		p.CPU.Record(sys.Record)
+	}
 	p.status = _SIDL
 
 Retry:
@@ -215,6 +336,21 @@ Retry:
 	return p
 }
 
+// throttle accounts for d of simulated processor time,
+// sleeping as needed to keep the simulated time from running ahead of real time.
+// Time when no process is running (the real processor would be idle)
+// is not made up for later.
+func (sys *System) throttle(d time.Duration) {
+	now := time.Now()
+	if sys.realTime.IsZero() || now.Sub(sys.realTime)-sys.simTime > 10*time.Millisecond {
+		sys.realTime = now.Add(-sys.simTime)
+	}
+	sys.simTime += d
+	if ahead := sys.simTime - now.Sub(sys.realTime); ahead > time.Millisecond {
+		time.Sleep(ahead)
+	}
+}
+
 type rw struct {
 	io.Reader
 	io.Writer
@@ -227,9 +363,13 @@ func NewSystem(archive []byte) (*System, error) {
 		return nil, err
 	}
 	sys.Disk = d
+	sys.archive = archive
 	sys.idle = make(chan bool)
+	sys.Exit1.L = &sys.Big
 	for i := range sys.TTY {
 		sys.TTY[i].Sys = sys
+		sys.TTY[i].major = 4
+		sys.TTY[i].minor = uint8(i)
 	}
 	return sys, nil
 }
@@ -254,19 +394,7 @@ func (sys *System) Start(exe []byte, argv []string, stdout io.Writer) (*Proc, er
 	p.Pid = 1
 	p.Ppid = 0
 	p.Dir = p.iget(1)
-	sys.Exit1.L = &sys.Big
-	sys.TTY[8].Print = func(b []byte, echo bool) (int, Errno) {
-		n, err := stdout.Write(b)
-		if err != nil {
-			return 0, EIO
-		}
-		return n, 0
-	}
-	for i := range sys.TTY {
-		sys.TTY[i].major = 4
-		sys.TTY[i].minor = uint8(i)
-	}
-
+	sys.TTY[8].Print = consolePrint(stdout)
 	p.exec(exe, argv, nil)
 	if p.Error != 0 {
 		return nil, fmt.Errorf("exec: %v", p.Error)
@@ -276,6 +404,17 @@ func (sys *System) Start(exe []byte, argv []string, stdout io.Writer) (*Proc, er
 	return p, nil
 }
 
+// consolePrint returns a TTY Print function writing to stdout.
+func consolePrint(stdout io.Writer) func([]byte, bool) (int, Errno) {
+	return func(b []byte, echo bool) (int, Errno) {
+		n, err := stdout.Write(b)
+		if err != nil {
+			return 0, EIO
+		}
+		return n, 0
+	}
+}
+
 func (sys *System) Wait() {
 	if !sys.Timer.IsZero() && !time.Now().Before(sys.Timer) {
 		sys.Timer = time.Time{}
@@ -283,8 +422,10 @@ func (sys *System) Wait() {
 	}
 	// Every proc is waiting on p.sched in p.swtch; waking up any of them is fine
 	// since their scheduler loop will find the right next process to run.
+	sys.running.Store(true)
 	sys.Procs[0].sched <- true
 	<-sys.idle
+	sys.running.Store(false)
 }
 
 func sysfork(p *Proc) {
@@ -296,7 +437,7 @@ func sysfork(p *Proc) {
 	p.CPU.R[0] = uint16(c.Pid)
 	c.CPU.R[0] = uint16(p.Pid)
 	p.CPU.R[pdp11.PC] += 2
-	if p.Sys.Trace {
+	if p.Sys.traceSys() {
 		fmt.Fprintf(os.Stderr, "[pid %d] fork -> %d\n", p.Pid, c.Pid)
 	}
 	p.Sys.setrun(c)
@@ -307,36 +448,59 @@ func (sys *System) run(p *Proc) {
 	if p.status == _SZOMB {
 		runtime.Goexit()
 	}
+	if sys.Tracer != nil {
+		p.CPU.Tracer = sys.Tracer(int(p.Pid))
+	} else if sys.Trace {
+		p.CPU.Tracer = &pdp11.TextTracer{W: os.Stderr, Prefix: fmt.Sprintf("[pid %d] ", p.Pid)}
+	}
 	for {
 		if p.issig() {
-			p.psig()
+			p.recordMem(p.psig)
 		}
-		pc := p.CPU.R[pdp11.PC]
-		n := 100
-		if p.Sys.Trace {
-			text, next, err := p.CPU.Disasm(pc)
-			if err != nil {
-				text = "???"
-			}
-			op, _, _ := strings.Cut(text, " ")
-			inst, _ := p.CPU.ReadW(pc)
-			fmt.Fprintf(os.Stderr, "%06o %06o %4s %06o %06o %06o %06o %06o %06o %06o   NZVC1 %04b\n", p.CPU.R[pdp11.PC], inst, strings.ToLower(op),
-				p.CPU.R[0], p.CPU.R[1], p.CPU.R[2], p.CPU.R[3], p.CPU.R[4], p.CPU.R[5], p.CPU.R[6], p.CPU.PS)
-			fmt.Fprintf(os.Stderr, "f0=%v f1=%v f2=%v f3=%v f4=%v f5=%v fps=%v\n",
-				p.CPU.F[0], p.CPU.F[1], p.CPU.F[2], p.CPU.F[3], p.CPU.F[4], p.CPU.F[5], p.CPU.FPS)
-			fmt.Fprintf(os.Stderr, "# %06o %v (nextPC=%06o)\n", pc, text, next)
-			n = 1
+		start := p.CPU.Nanoseconds
+		err := p.CPU.Step(100)
+		if sys.Throttle && sys.Timing != nil {
+			sys.throttle(time.Duration(p.CPU.Nanoseconds - start))
+		}
+		if err == nil {
+			continue
+		}
+		t, ok := err.(*pdp11.Trap)
+		if !ok {
+			log.Fatalf("pid %d: %v", p.Pid, err)
 		}
-		err := p.CPU.Step(n)
 		var sig int
-		switch err {
+		switch t.Err {
 		case pdp11.ErrTrap:
-			err = Trap(p)
+			var terr error
+			p.recordMem(func() { terr = Trap(p) })
+			if terr != nil {
+				// An invalid system call gets SIGSYS, as in V6,
+				// and a fault reading its arguments gets SIGSEG.
+				sig = SIGSYS
+				if errors.Is(terr, pdp11.ErrMem) {
+					sig = SIGSEG
+				}
+				if p.Sys.traceSys() {
+					fmt.Fprintf(os.Stderr, "[pid %d] %v: %v\n", p.Pid, t, terr)
+				}
+				sys.psignal(p, sig)
+				continue
+			}
 			if p.Error < 100 {
 				continue
 			}
 			sig = SIGSYS
 		case pdp11.ErrInst:
+			if t.Odd {
+				// Odd PC: bus error fetching the instruction.
+				sig = SIGBUS
+				break
+			}
+			// The hardware trap leaves the PC past the instruction word.
+			// The floating point simulator (fptrap) depends on that
+			// to find the instruction on processors without an FP11.
+			p.CPU.R[pdp11.PC] += 2
 			sig = SIGINS
 		case pdp11.ErrBPT:
 			sig = SIGTRC
@@ -345,17 +509,27 @@ func (sys *System) run(p *Proc) {
 		case pdp11.ErrEMT:
 			sig = SIGEMT
 		case pdp11.ErrFPT:
+			// The PC is past the failing instruction,
+			// and the FP11 holds the error code and address
+			// for the signal handler to read with stst.
 			sig = SIGFPT
 		case pdp11.ErrMem:
+			if t.Odd {
+				sig = SIGBUS
+				break
+			}
+			// The instruction was abandoned, so if the stack
+			// can grow to cover the stack pointer, it can simply run again.
+			if p.grow(p.CPU.R[pdp11.SP]) {
+				continue
+			}
 			sig = SIGSEG
-			// TODO stack growth
-		}
-		if sig != 0 {
-			sys.psignal(p, sig)
-			continue
+		default:
+			log.Fatalf("pid %d: %v", p.Pid, err)
 		}
-		if err != nil {
-			log.Fatalf("%06o: %v", p.CPU.R[pdp11.PC], err)
+		if p.Sys.traceSys() && sig != SIGSYS {
+			fmt.Fprintf(os.Stderr, "[pid %d] %v\n", p.Pid, err)
 		}
+		sys.psignal(p, sig)
 	}
 }
@@ -0,0 +1,66 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package v6unix
+
+import (
+	"testing"
+
+	"rsc.io/unix/pdp11"
+)
+
+// TestRecordRead checks that the execution history includes
+// the bytes a read system call stores into user memory.
+func TestRecordRead(t *testing.T) {
+	sys, err := NewSystem(FS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sys.Record = 100
+	p, err := sys.Start(exe(t,
+		"trap 52", // 0: sys pipe
+		"mov r0, r2",
+		"mov r1, r0",
+		"trap 4", // 6: sys write; 0; 4
+		".word 0",
+		".word 4",
+		"mov r2, r0",
+		"trap 3", // 16: sys read; 1000; 4
+		".word 1000",
+		".word 4",
+		"trap 1", // 24: sys exit
+	), []string{"read"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sys.Wait()
+	if p.status != _SZOMB {
+		t.Fatalf("process did not exit")
+	}
+	want, _ := p.Mem.ReadW(0)
+	if got, _ := p.Mem.ReadW(0o1000); got != want {
+		t.Fatalf("read stored %06o, want %06o", got, want)
+	}
+
+	count, pc, ok := p.CPU.LastWrite(0o1000)
+	if !ok || pc != 0o16 {
+		t.Fatalf("LastWrite(01000) = %d, %06o, %v, want read at 000016", count, pc, ok)
+	}
+	// Step back across the read and then replay it.
+	if err := p.CPU.Seek(count + 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.CPU.StepBack(1); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := p.Mem.ReadW(0o1000); got != 0 || p.CPU.R[pdp11.PC] != 0o16 {
+		t.Errorf("before read: *01000 = %06o, PC = %06o, want 0, 000016", got, p.CPU.R[pdp11.PC])
+	}
+	if err := p.CPU.Seek(count + 1); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := p.Mem.ReadW(0o1000); got != want || p.CPU.R[pdp11.PC] != 0o24 {
+		t.Errorf("after read: *01000 = %06o, PC = %06o, want %06o, 000024", got, p.CPU.R[pdp11.PC], want)
+	}
+}
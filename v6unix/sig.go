@@ -197,6 +197,21 @@ func (p *Proc) core() bool {
  * true return if successful.
  */
 func (p *Proc) grow(sp uint16) bool {
+	if int(sp) >= 1<<16-int(p.StackSize) {
+		return false
+	}
+	si := (1<<16-int(sp))/64 - int(p.StackSize)/64 + SINCR
+	if si <= 0 {
+		return false
+	}
+	ssize := int(p.StackSize) + si*64
+	if ssize >= 1<<16 || p.Sys.Strict && !p.fits(p.DataSize, uint16(ssize)) {
+		return false
+	}
+	// The stack is at the top of the address space,
+	// so unlike in V6, growing it does not move it.
+	p.StackSize = uint16(ssize)
+	p.setMemMap()
 	return true
 	/*
 		register a, si, i;
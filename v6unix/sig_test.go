@@ -0,0 +1,53 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package v6unix
+
+import (
+	"testing"
+
+	"rsc.io/unix/pdp11"
+)
+
+// A program that divides by zero and catches the floating exception.
+// The handler exits with the error code saved by stst,
+// complemented if the saved error address is not that of the divf.
+var fptText = []string{
+	"trap 60", // 0: sys signal; SIGFPT; 14
+	".word 10",
+	".word 14",
+	"clrf f0",       // 6
+	"divf f0, f0",   // 10
+	"trap 1",        // 12: sys exit
+	"mov #4000, r1", // 14
+	"stst (r1)",
+	"mov (r1)+, r0",
+	"cmp (r1), #10",
+	"beq 34",
+	"com r0",
+	"trap 1", // 34: sys exit
+}
+
+// TestFPT checks that a SIGFPT handler can read
+// the floating point error code and address with stst.
+func TestFPT(t *testing.T) {
+	sys, err := NewSystem(FS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := sys.Start(exe(t, fptText...), []string{"fpt"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sys.Wait()
+	if p.status != _SZOMB {
+		t.Fatalf("process did not exit")
+	}
+	if sig := p.Args[0] & 0o177; sig != 0 {
+		t.Fatalf("process killed by signal %d", sig)
+	}
+	if status := p.Args[0] >> 8; status != pdp11.FEC_DIV {
+		t.Errorf("exit status %#o, want FEC_DIV (%#o)", status, pdp11.FEC_DIV)
+	}
+}
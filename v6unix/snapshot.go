@@ -0,0 +1,360 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package v6unix
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"io"
+
+	"rsc.io/unix/pdp11"
+)
+
+// A process snapshot is a gob-encoded procFile.
+// It refers to the file system by inode number:
+// the current directory and open files must exist in the
+// file system of the system loading the snapshot.
+// Pipes are saved with their buffered data.
+//
+// A snapshot records a process at an instruction boundary.
+// A process blocked in a system call is saved as it was at the
+// start of the call, so that loading the snapshot restarts the call.
+
+const procSnapVersion = 1
+
+// A procFile is the encoded form of a single process snapshot.
+type procFile struct {
+	Version int
+	Proc    procSnap
+	Files   []fileSnap
+	Pipes   []pipeSnap
+}
+
+// A procSnap is the saved state of a process.
+type procSnap struct {
+	Pid, Ppid  int16
+	Flag       uint8
+	Pri        int8
+	PendSig    int8 // procState.sig
+	Uid        int8
+	Time       int8
+	CPUUse     int8
+	Nice       int8
+	TTYP       int16
+	Gid        int8
+	RUid       int8
+	RGid       int8
+	Sig        int8
+	Dir        uint16 // current directory inode number
+	Files      [NOFILE]int
+	Signals    [NSIG]uint16
+	Prof       [4]uint16
+	Times      Times
+	UNice      int16 // Proc.Nice
+	TextSize   uint16
+	DataStart  uint16
+	DataSize   uint16
+	StackSize  uint16
+	TTY        int // TTY minor number + 1, or 0 for none
+	Text       bool
+	CPU        []byte // pdp11 snapshot
+	Zombie     bool   // process has exited
+	ExitStatus uint16 // exit status of exited process
+}
+
+// A fileSnap is the saved state of an open file.
+// A process's Files hold the index of the fileSnap plus one, or 0 for none.
+type fileSnap struct {
+	Flag   int
+	Offset int
+	Inum   uint16
+	Pipe   int // index of pipeSnap plus one, or 0 for none
+}
+
+// A pipeSnap is the saved state of a pipe.
+type pipeSnap struct {
+	Buf []byte
+}
+
+// A snapEncoder collects the files and pipes shared by saved processes.
+type snapEncoder struct {
+	files map[*File]int
+	pipes map[*pipe]int
+	Files []fileSnap
+	Pipes []pipeSnap
+}
+
+func (e *snapEncoder) proc(p *Proc) (*procSnap, error) {
+	s := &procSnap{
+		Pid:       p.Pid,
+		Ppid:      p.Ppid,
+		Flag:      p.flag,
+		Pri:       p.pri,
+		PendSig:   p.procState.sig,
+		Uid:       p.Uid,
+		Time:      p.time,
+		CPUUse:    p.cpu,
+		Nice:      p.nice,
+		TTYP:      p.ttyp,
+		Gid:       p.Gid,
+		RUid:      p.RUid,
+		RGid:      p.RGid,
+		Sig:       p.Sig,
+		Signals:   p.Signals,
+		Prof:      p.Prof,
+		Times:     p.Times,
+		UNice:     p.Nice,
+		TextSize:  p.TextSize,
+		DataStart: p.DataStart,
+		DataSize:  p.DataSize,
+		StackSize: p.StackSize,
+		Text:      p.Text != nil,
+	}
+	if p.status == _SZOMB {
+		// An exited process is only its exit status and times,
+		// waiting for its parent to collect them.
+		s.Zombie = true
+		s.ExitStatus = p.Args[0]
+		return s, nil
+	}
+	if p.Dir != nil {
+		s.Dir = p.Dir.inum
+	}
+	for fd, f := range p.Files {
+		if f != nil {
+			s.Files[fd] = e.file(f)
+		}
+	}
+	for i := range p.Sys.TTY {
+		if p.TTY == &p.Sys.TTY[i] {
+			s.TTY = i + 1
+		}
+	}
+
+	cpu := p.CPU
+	if p.inTrap {
+		cpu.R = p.trapR
+		cpu.PS = p.trapPS
+	}
+	var buf bytes.Buffer
+	if err := cpu.SaveSnapshot(&buf); err != nil {
+		return nil, err
+	}
+	s.CPU = buf.Bytes()
+	return s, nil
+}
+
+// file returns the index plus one of the saved form of f.
+func (e *snapEncoder) file(f *File) int {
+	if i, ok := e.files[f]; ok {
+		return i + 1
+	}
+	if e.files == nil {
+		e.files = make(map[*File]int)
+		e.pipes = make(map[*pipe]int)
+	}
+	fs := fileSnap{Flag: f.flag, Offset: f.offset, Inum: f.inode.inum}
+	if f.pipe != nil {
+		i, ok := e.pipes[f.pipe]
+		if !ok {
+			i = len(e.Pipes)
+			e.pipes[f.pipe] = i
+			e.Pipes = append(e.Pipes, pipeSnap{append([]byte(nil), f.pipe.buf[:f.pipe.n]...)})
+		}
+		fs.Pipe = i + 1
+	}
+	e.files[f] = len(e.Files)
+	e.Files = append(e.Files, fs)
+	return len(e.Files)
+}
+
+// A snapDecoder recreates the files and pipes shared by loaded processes.
+type snapDecoder struct {
+	sys       *System
+	Files     []fileSnap
+	Pipes     []pipeSnap
+	linkPipes bool // pipes use their saved inodes, restored with the file system
+	files     []*File
+	pipes     []*pipe
+	pipeI     []*inode // new inodes for pipes, if !linkPipes
+}
+
+func (d *snapDecoder) proc(s *procSnap) (*Proc, error) {
+	p := d.sys.newProc()
+	if err := d.load(p, s); err != nil {
+		// Stop the new process's goroutine.
+		p.status = _SZOMB
+		p.sched <- true
+		return nil, err
+	}
+	if p.status == _SZOMB {
+		// Stop the goroutine: a zombie never runs again.
+		p.sched <- true
+	}
+	return p, nil
+}
+
+func (d *snapDecoder) load(p *Proc, s *procSnap) error {
+	sys := d.sys
+	if sys.lookpid(s.Pid) == nil {
+		p.Pid = s.Pid
+	}
+	p.Ppid = s.Ppid
+	p.flag = s.Flag
+	p.pri = s.Pri
+	p.procState.sig = s.PendSig
+	p.Uid = s.Uid
+	p.time = s.Time
+	p.cpu = s.CPUUse
+	p.nice = s.Nice
+	p.ttyp = s.TTYP
+	p.Gid = s.Gid
+	p.RUid = s.RUid
+	p.RGid = s.RGid
+	p.Sig = s.Sig
+	p.Signals = s.Signals
+	p.Prof = s.Prof
+	p.Times = s.Times
+	p.Nice = s.UNice
+	p.TextSize = s.TextSize
+	p.DataStart = s.DataStart
+	p.DataSize = s.DataSize
+	if s.StackSize != 0 { // not saved in older snapshots
+		p.StackSize = s.StackSize
+	}
+	p.setMemMap()
+	if s.TTY > 0 {
+		if s.TTY > len(sys.TTY) {
+			return fmt.Errorf("snapshot: invalid tty %d", s.TTY-1)
+		}
+		p.TTY = &sys.TTY[s.TTY-1]
+	}
+	if s.Zombie {
+		p.status = _SZOMB
+		p.Args[0] = s.ExitStatus
+		return nil
+	}
+	if s.Text {
+		p.setText(new(pdp11.ArrayMem))
+	}
+	if err := p.CPU.LoadSnapshot(bytes.NewReader(s.CPU)); err != nil {
+		return err
+	}
+
+	if s.Dir != 0 {
+		if p.Dir = p.iget(s.Dir); p.Dir == nil {
+			return fmt.Errorf("snapshot: missing directory inode %d", s.Dir)
+		}
+	}
+	for fd, i := range s.Files {
+		if i == 0 {
+			continue
+		}
+		f, err := d.file(p, i)
+		if err != nil {
+			return err
+		}
+		f.count++
+		p.Files[fd] = f
+	}
+	return nil
+}
+
+// file returns the file for the index plus one i,
+// recreating it if needed.
+func (d *snapDecoder) file(p *Proc, i int) (*File, error) {
+	if i < 1 || i > len(d.Files) {
+		return nil, fmt.Errorf("snapshot: invalid file %d", i)
+	}
+	if d.files == nil {
+		d.files = make([]*File, len(d.Files))
+		d.pipes = make([]*pipe, len(d.Pipes))
+		d.pipeI = make([]*inode, len(d.Pipes))
+	}
+	if f := d.files[i-1]; f != nil {
+		return f, nil
+	}
+	fs := &d.Files[i-1]
+	f := &File{flag: fs.Flag, offset: fs.Offset}
+	if fs.Pipe == 0 {
+		if f.inode = p.iget(fs.Inum); f.inode == nil {
+			return nil, fmt.Errorf("snapshot: missing inode %d", fs.Inum)
+		}
+		d.files[i-1] = f
+		return f, nil
+	}
+
+	j := fs.Pipe - 1
+	if j >= len(d.Pipes) {
+		return nil, fmt.Errorf("snapshot: invalid pipe %d", fs.Pipe)
+	}
+	if d.pipes[j] == nil {
+		pip := new(pipe)
+		pip.read.L = &p.Sys.Big
+		pip.write.L = &p.Sys.Big
+		pip.n = copy(pip.buf[:], d.Pipes[j].Buf)
+		d.pipes[j] = pip
+	}
+	f.pipe = d.pipes[j]
+	if d.linkPipes {
+		if f.inode = p.iget(fs.Inum); f.inode == nil {
+			return nil, fmt.Errorf("snapshot: missing pipe inode %d", fs.Inum)
+		}
+	} else {
+		if d.pipeI[j] == nil {
+			ip := p.ialloc()
+			if ip == nil {
+				return nil, fmt.Errorf("snapshot: cannot allocate pipe inode")
+			}
+			ip.count = 0
+			ip.atime = now()
+			ip.mtime = ip.atime
+			ip.mode = _IALLOC
+			d.pipeI[j] = ip
+		}
+		f.inode = d.pipeI[j]
+		f.inode.count++
+	}
+	d.files[i-1] = f
+	return f, nil
+}
+
+// Snapshot writes a snapshot of the process to w.
+// It must be called only while the system is idle,
+// such as after System.Wait returns.
+func (p *Proc) Snapshot(w io.Writer) error {
+	if p.status == _SZOMB {
+		return fmt.Errorf("snapshot: process %d has exited", p.Pid)
+	}
+	var e snapEncoder
+	s, err := e.proc(p)
+	if err != nil {
+		return err
+	}
+	return gob.NewEncoder(w).Encode(&procFile{procSnapVersion, *s, e.Files, e.Pipes})
+}
+
+// LoadProc adds to the system a process restored from
+// a snapshot written by Proc.Snapshot, making it ready to run.
+// The process keeps its saved pid unless another process is using it.
+// Like Snapshot, LoadProc must be called only while the system is idle.
+func (sys *System) LoadProc(r io.Reader) (*Proc, error) {
+	var pf procFile
+	if err := gob.NewDecoder(r).Decode(&pf); err != nil {
+		return nil, fmt.Errorf("snapshot: %v", err)
+	}
+	if pf.Version != procSnapVersion {
+		return nil, fmt.Errorf("snapshot: unsupported version %d", pf.Version)
+	}
+	d := &snapDecoder{sys: sys, Files: pf.Files, Pipes: pf.Pipes}
+	p, err := d.proc(&pf.Proc)
+	if err != nil {
+		return nil, err
+	}
+	sys.Procs = append(sys.Procs, p)
+	sys.setrun(p)
+	return p, nil
+}
@@ -0,0 +1,100 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package v6unix
+
+import (
+	"bytes"
+	"testing"
+
+	"rsc.io/unix/pdp11"
+)
+
+func TestProcSnapshot(t *testing.T) {
+	sys, err := NewSystem(FS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := sys.newProc()
+	p.Dir = p.iget(1)
+	p.Args[0] = 0o1000
+	copy(p.Mem[0o1000:], "/dev\x00")
+	syschdir(p)
+	p.open("null", 1)
+	syspipe(p)
+	p.Files[2].pipe.n = copy(p.Files[2].pipe.buf[:], "hello")
+	p.CPU.R[0] = 1
+	sysdup(p)
+	p.Signals[SIGINT] = 0o1234
+	p.CPU.R = [8]uint16{1, 2, 3, 4, 5, 6, 0o7000, 0o1000}
+	p.CPU.F[2] = 0o040200 << 48
+	p.Mem[0o4000] = 42
+	if p.Error != 0 {
+		t.Fatal(p.Error)
+	}
+
+	var buf bytes.Buffer
+	if err := p.Snapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	sys2, err := NewSystem(FS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q, err := sys2.LoadProc(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.Pid != p.Pid || q.Dir.inum != p.Dir.inum || q.Signals != p.Signals {
+		t.Errorf("restored pid=%d dir=%d signals=%v, want %d, %d, %v", q.Pid, q.Dir.inum, q.Signals, p.Pid, p.Dir.inum, p.Signals)
+	}
+	if q.CPU.R != p.CPU.R || q.CPU.F != p.CPU.F || q.Mem != p.Mem {
+		t.Errorf("restored CPU differs")
+	}
+	if q.Files[0].inode.inum != p.Files[0].inode.inum || q.Files[0].flag != _FWRITE {
+		t.Errorf("restored fd 0 = #%d flag %d, want #%d flag %d", q.Files[0].inode.inum, q.Files[0].flag, p.Files[0].inode.inum, _FWRITE)
+	}
+	if rf, wf := q.Files[1], q.Files[2]; rf.pipe != wf.pipe || string(rf.pipe.buf[:rf.pipe.n]) != "hello" || rf.inode.count != 2 {
+		t.Errorf("restored pipe not shared or lost data")
+	}
+	if q.Files[3] != q.Files[1] || q.Files[1].count != 2 {
+		t.Errorf("restored dup not shared")
+	}
+
+	// Saving the restored process gives the same snapshot.
+	buf.Reset()
+	if err := q.Snapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), data) {
+		t.Errorf("snapshot did not round-trip")
+	}
+}
+
+// A process in a system call is saved at the start of the call.
+func TestProcSnapshotSyscall(t *testing.T) {
+	sys, err := NewSystem(FS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := sys.newProc()
+	p.CPU.R[pdp11.PC] = 0o1000
+	p.inTrap, p.trapR, p.trapPS = true, p.CPU.R, p.CPU.PS
+	p.CPU.R[pdp11.PC] = 0o1002
+	p.CPU.R[0] = 99
+
+	var buf bytes.Buffer
+	if err := p.Snapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+	q, err := sys.LoadProc(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.CPU.R != p.trapR {
+		t.Errorf("restored registers %06o, want %06o", q.CPU.R, p.trapR)
+	}
+}
@@ -0,0 +1,150 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package v6unix
+
+import (
+	"encoding/binary"
+	"strconv"
+	"strings"
+	"testing"
+
+	"rsc.io/unix/aout"
+	"rsc.io/unix/pdp11"
+)
+
+// exe returns a 0407 a.out executable for the assembly language program text.
+// A line ".word n" assembles the octal data word n,
+// such as an inline system call argument.
+func exe(t *testing.T, text ...string) []byte {
+	return exeFile(t, &aout.File{Header: aout.Header{Magic: aout.MagicImpure}}, text...)
+}
+
+// exeFile is like exe but returns the a.out executable f
+// with the assembled program text as its text segment.
+func exeFile(t *testing.T, f *aout.File, text ...string) []byte {
+	var code []uint16
+	for _, line := range text {
+		if n, ok := strings.CutPrefix(line, ".word "); ok {
+			w, err := strconv.ParseUint(n, 8, 16)
+			if err != nil {
+				t.Fatalf("%s: %v", line, err)
+			}
+			code = append(code, uint16(w))
+			continue
+		}
+		c, err := pdp11.Asm(uint16(2*len(code)), line)
+		if err != nil {
+			t.Fatalf("%s: %v", line, err)
+		}
+		code = append(code, c...)
+	}
+	f.Text = nil
+	for _, w := range code {
+		f.Text = binary.LittleEndian.AppendUint16(f.Text, w)
+	}
+	b, err := f.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+var strictTests = []struct {
+	name string
+	text []string
+	sig  int
+}{
+	{"odd", []string{"mov #1, r0", "tst (r0)", "trap 1"}, SIGBUS},
+	{"oddpc", []string{"jmp 1"}, SIGBUS},
+	{"segv", []string{"tst 40000", "trap 1"}, SIGSEG},
+	{"text", []string{"clr 0", "trap 1"}, 0},
+	{"grow", []string{"mov #170000, sp", "clr -(sp)", "clr r0", "trap 1"}, 0},
+	{"nogrow", []string{"mov #20000, sp", "clr -(sp)", "trap 1"}, SIGSEG},
+}
+
+func TestStrict(t *testing.T) {
+	for _, tt := range strictTests {
+		sys, err := NewSystem(FS)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sys.Strict = true
+		p, err := sys.Start(exe(t, tt.text...), []string{tt.name}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sys.Wait()
+		if p.status != _SZOMB {
+			t.Errorf("%s: process did not exit", tt.name)
+			continue
+		}
+		if sig := int(p.Args[0] & 0o177); sig != tt.sig {
+			t.Errorf("%s: exit signal %d, want %d", tt.name, sig, tt.sig)
+		}
+	}
+}
+
+// TestBreak checks that break runs out of memory only on a strict system.
+func TestBreak(t *testing.T) {
+	prog := exe(t,
+		"clr r0",
+		"trap 21", // sys break; 170000
+		".word 170000",
+		"trap 1", // sys exit
+	)
+	for _, strict := range []bool{false, true} {
+		sys, err := NewSystem(FS)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sys.Strict = strict
+		p, err := sys.Start(prog, []string{"break"}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sys.Wait()
+		status, want := p.Args[0]>>8, uint16(0)
+		if strict {
+			want = uint16(ENOMEM)
+		}
+		if p.status != _SZOMB || status != want {
+			t.Errorf("strict=%v: exit status %d, want %d", strict, status, want)
+		}
+	}
+}
+
+var trapErrorTests = []struct {
+	name string
+	text []string
+	sig  int
+}{
+	// The indirect system call is outside the data segment.
+	{"indirfault", []string{"trap 0", ".word 100000", "trap 1"}, SIGSEG},
+	// The indirect system call is not a sys instruction.
+	{"indirbad", []string{"trap 0", ".word 6", "trap 1", "clr r0"}, SIGSYS},
+}
+
+// TestTrapErrors checks that invalid system calls send signals.
+func TestTrapErrors(t *testing.T) {
+	for _, tt := range trapErrorTests {
+		sys, err := NewSystem(FS)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sys.Strict = true
+		p, err := sys.Start(exe(t, tt.text...), []string{tt.name}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sys.Wait()
+		if p.status != _SZOMB {
+			t.Errorf("%s: process did not exit", tt.name)
+			continue
+		}
+		if sig := int(p.Args[0] & 0o177); sig != tt.sig {
+			t.Errorf("%s: exit signal %d, want %d", tt.name, sig, tt.sig)
+		}
+	}
+}
@@ -16,6 +16,7 @@ import (
 	"slices"
 	"unsafe"
 
+	"rsc.io/unix/aout"
 	"rsc.io/unix/pdp11"
 )
 
@@ -68,41 +69,54 @@ func sysexec(p *Proc) {
 	p.exec(ip.data, argv, ip)
 }
 
-func (p *Proc) exec(aout []byte, argv []string, ip *inode) {
-	// parse header
-	hdr := (*[4]uint16)(unsafe.Pointer(&aout[0]))
-
-	var ts, ds int
-	var sep bool
-	switch hdr[0] {
-	default:
+func (p *Proc) exec(exe []byte, argv []string, ip *inode) {
+	// parse header; overlays cannot be executed directly
+	hdr, err := aout.ParseHeader(exe)
+	if err != nil || hdr.Magic == aout.MagicOverlay {
 		p.Error = ENOEXEC
 		return
-	case 0o000407:
-		ds = int(hdr[1]) + int(hdr[2])
-	case 0o000410, 0o000411:
-		ts = int(hdr[1])
-		ds = int(hdr[2])
-		sep = hdr[0]&1 != 0
 	}
-	_ = sep
-	if 0o20+ts+ds > len(aout) || (ts|ds)&1 != 0 {
-		p.Error = ENOEXEC
-		return
+
+	var ts, ds int
+	sep := hdr.Magic == aout.MagicSep
+	if hdr.Magic == aout.MagicImpure {
+		ds = int(hdr.TextSize) + int(hdr.DataSize)
+	} else {
+		ts = int(hdr.TextSize)
+		ds = int(hdr.DataSize)
 	}
 	const maxText = 50000
-	if ts+ds > maxText {
+	if !sep && ts+ds > maxText || sep && (ts > maxText || ds > maxText) {
 		p.Error = E2BIG
 		return
 	}
 
+	// A separate I/D program has text at 0 in its own
+	// instruction space, and data at 0 in data space.
 	const round = 0o20000
 	tsr := (ts + round - 1) &^ (round - 1)
+	if sep {
+		tsr = 0
+	}
+
+	// As in V6 estabur, the data and bss must fit in the address space,
+	// and on a strict system, they must also leave room for the stack.
+	dsize := ds + int(hdr.BSSSize)
+	if tsr+dsize >= 1<<16 || p.Sys.Strict && !segsFit(tsr, dsize, SSIZE*64) {
+		p.Error = ENOMEM
+		return
+	}
 
-	// lay out new memory image
+	// lay out new memory image.
 	var mem pdp11.ArrayMem
-	copy(mem[:ts], aout[0o20:])
-	copy(mem[tsr:tsr+ds], aout[0o20+ts:])
+	var text *pdp11.ArrayMem
+	if sep {
+		text = new(pdp11.ArrayMem)
+		copy(text[:ts], exe[aout.HeaderSize:])
+	} else {
+		copy(mem[:ts], exe[aout.HeaderSize:])
+	}
+	copy(mem[tsr:tsr+ds], exe[aout.HeaderSize+ts:])
 
 	na := (1 + len(argv) + 1) * 2
 	for _, s := range argv {
@@ -133,15 +147,13 @@ func (p *Proc) exec(aout []byte, argv []string, ip *inode) {
 	sp := ap
 
 	p.Mem = mem
-	if hdr[0] == 0o407 {
-		p.TextSize = hdr[1]
-		p.DataStart = hdr[1]
-		p.DataSize = hdr[2]
-	} else {
-		p.TextSize = uint16(ts)
-		p.DataStart = uint16(tsr)
-		p.DataSize = uint16(ds)
-	}
+	p.setText(text)
+	// As in V6, a 0407 program's text is part of its data segment.
+	p.TextSize = uint16(ts)
+	p.DataStart = uint16(tsr)
+	p.DataSize = uint16(dsize) // data and bss
+	p.StackSize = SSIZE * 64
+	p.setMemMap()
 
 	// TODO check STRC
 	if true && ip != nil {
@@ -164,6 +176,12 @@ func (p *Proc) exec(aout []byte, argv []string, ip *inode) {
 	clear(p.CPU.R[:])
 	p.CPU.R[pdp11.SP] = sp
 
+	// The new image replaced memory without the CPU recording it,
+	// so the old history no longer applies.
+	if p.Sys.Record > 0 {
+		p.CPU.Record(p.Sys.Record)
+	}
+
 	if false {
 		for i := 0; i < 1<<16; i += 2 {
 			v := *(*uint16)(unsafe.Pointer(&p.Mem[i]))
@@ -257,5 +275,18 @@ func syswait(p *Proc) {
 }
 
 func sysbreak(p *Proc) {
-
+	// set n to new data size
+	end := (int(p.Args[0]) + 63) &^ 63
+	n := end - int(p.DataStart)
+	if n < 0 {
+		n = 0
+	}
+	// Without a memory map, all of memory is available,
+	// so only a strict system runs out.
+	if p.Sys.Strict && (end >= 1<<16 || !p.fits(uint16(n), p.StackSize)) {
+		p.Error = ENOMEM
+		return
+	}
+	p.DataSize = uint16(min(n, 1<<16-1))
+	p.setMemMap()
 }
@@ -12,10 +12,16 @@ import (
 	"rsc.io/unix/pdp11"
 )
 
+// Trap executes the system call for the trap instruction in p.CPU.Inst,
+// where Step leaves it when it returns the trap.
+// It returns an error, without making the call, if the system call
+// is invalid or its arguments cannot be read.
 func Trap(p *Proc) error {
-	if p.Sys.Trace {
+	if p.Sys.traceSys() {
 		fmt.Fprintf(os.Stderr, "[pid %d] TRAP\n", p.Pid)
 	}
+	p.inTrap, p.trapR, p.trapPS = true, p.CPU.R, p.CPU.PS
+	defer func() { p.inTrap = false }()
 	trap := p.CPU.Inst & 0o77
 	p.CPU.R[pdp11.PC] += 2
 	argp := p.CPU.R[pdp11.PC]
@@ -24,15 +30,15 @@ func Trap(p *Proc) error {
 		p.CPU.R[pdp11.PC] += 2 // consume argp
 		var err error
 		// old := argp
-		argp, err = p.CPU.ReadW(argp)
+		argp, err = p.CPU.ReadIW(argp)
 		if err != nil {
-			return err
+			return fmt.Errorf("reading indirect system call address: %w", err)
 		}
 		// fmt.Fprintf(os.Stderr, "argp *%06o = %06o\n", old, argp)
 		// old = argp
 		trap, err = p.CPU.ReadW(argp)
 		if err != nil {
-			return err
+			return fmt.Errorf("reading indirect system call at %06o: %w", argp, err)
 		}
 		// fmt.Fprintf(os.Stderr, "trap *%06o = %06o\n", old, trap)
 		argp += 2
@@ -47,10 +53,16 @@ func Trap(p *Proc) error {
 	old := argp
 	sys := &sysent[trap]
 	for i := 0; i < int(sys.args); i++ {
+		// Direct arguments follow the sys instruction in instruction space;
+		// indirect ones are in data space with the sys instruction they follow.
+		read := p.CPU.ReadIW
+		if otrap == 0 {
+			read = p.CPU.ReadW
+		}
 		var err error
-		p.Args[i], err = p.CPU.ReadW(argp)
+		p.Args[i], err = read(argp)
 		if err != nil {
-			return err
+			return fmt.Errorf("reading system call argument at %06o: %w", argp, err)
 		}
 		argp += 2
 	}
@@ -59,7 +71,7 @@ func Trap(p *Proc) error {
 	}
 
 	var desc []byte
-	if p.Sys.Trace {
+	if p.Sys.traceSys() {
 		reg := 0
 		arg := 0
 		for i := 0; i < len(sys.name); i++ {
@@ -110,7 +122,7 @@ func Trap(p *Proc) error {
 		}()
 		sys.impl(p)
 	}()
-	if p.Sys.Trace {
+	if p.Sys.traceSys() {
 		fmt.Fprintf(os.Stderr, "[pid %d] trap DONE %06o %s %06o %06o\n", p.Pid, old, desc, p.CPU.R[:], p.Args[:sys.args])
 	}
 	if interrupted {
@@ -122,7 +134,7 @@ func Trap(p *Proc) error {
 		p.CPU.R[0] = uint16(p.Error)
 	}
 
-	if p.Sys.Trace {
+	if p.Sys.traceSys() {
 		if p.Error != 0 {
 			desc = fmt.Appendf(desc, ": %v", p.Error)
 		} else if i := strings.Index(sys.name, ")"); i >= 0 {
@@ -8,6 +8,8 @@
 package main
 
 import (
+	"bytes"
+	"encoding/base64"
 	"fmt"
 	"html"
 	"log"
@@ -55,28 +57,104 @@ func tprint(tty int) func(b []byte, echo bool) (int, v6unix.Errno) {
 	}
 }
 
-func main() {
+// The system is checkpointed to local storage when the page is unloaded,
+// so that a session survives reloading the page.
+// Loading the page with ?reset in the URL discards the checkpoint.
+const checkpointKey = "v6unix.checkpoint"
+
+var ttys = []int{0, 1, 2, 3, 8}
+
+// restore returns a system restored from the checkpoint in local storage.
+// If there is no checkpoint, or it cannot be restored,
+// restore returns nil.
+func restore() *v6unix.System {
+	storage := js.Global().Get("localStorage")
+	if js.Global().Get("location").Get("search").String() == "?reset" {
+		discard()
+		return nil
+	}
+	v := storage.Call("getItem", checkpointKey)
+	if v.IsNull() {
+		return nil
+	}
 	sys, err := v6unix.NewSystem(v6unix.FS)
 	if err != nil {
 		fatal(err)
 	}
-
-	aout, err := sys.ReadFile("/etc/init")
+	data, err := base64.StdEncoding.DecodeString(v.String())
+	if err == nil {
+		err = sys.Restore(bytes.NewReader(data), os.Stdout)
+	}
 	if err != nil {
-		fatal(err)
+		log.Printf("discarding checkpoint: %v", err)
+		discard()
+		return nil
+	}
+	for _, i := range ttys {
+		name := fmt.Sprintf("tty%d", i)
+		if v := storage.Call("getItem", checkpointKey+"."+name); !v.IsNull() {
+			doc.Call("getElementById", name).Set("innerHTML", v)
+		}
+	}
+	return sys
+}
+
+// save writes a checkpoint of sys, along with the screen contents, to local storage.
+// If it cannot, it discards any older checkpoint,
+// so that reloading the page does not go back in time.
+func save(sys *v6unix.System) {
+	var buf bytes.Buffer
+	if err := sys.Checkpoint(&buf); err != nil {
+		log.Printf("checkpoint: %v", err)
+		discard()
+		return
 	}
+	defer func() {
+		// setItem throws an exception if storage is full.
+		if e := recover(); e != nil {
+			log.Printf("checkpoint: %v", e)
+			discard()
+		}
+	}()
+	storage := js.Global().Get("localStorage")
+	storage.Call("setItem", checkpointKey, base64.StdEncoding.EncodeToString(buf.Bytes()))
+	for _, i := range ttys {
+		name := fmt.Sprintf("tty%d", i)
+		storage.Call("setItem", checkpointKey+"."+name, doc.Call("getElementById", name).Get("innerHTML"))
+	}
+}
 
+// discard removes the checkpoint from local storage.
+func discard() {
+	storage := js.Global().Get("localStorage")
+	storage.Call("removeItem", checkpointKey)
+	for _, i := range ttys {
+		storage.Call("removeItem", fmt.Sprintf("%s.tty%d", checkpointKey, i))
+	}
+}
+
+func main() {
 	doc = js.Global().Get("document")
 	input = doc.Call("getElementById", "input")
 	bottom = doc.Call("getElementById", "bottom")
 	ttyall = doc.Call("getElementById", "ttyall")
 
-	if _, err := sys.Start(aout, []string{"/etc/init"}, os.Stdout); err != nil {
-		fatal(err)
+	sys := restore()
+	if sys == nil {
+		var err error
+		sys, err = v6unix.NewSystem(v6unix.FS)
+		if err != nil {
+			fatal(err)
+		}
+		aout, err := sys.ReadFile("/etc/init")
+		if err != nil {
+			fatal(err)
+		}
+		if _, err := sys.Start(aout, []string{"/etc/init"}, os.Stdout); err != nil {
+			fatal(err)
+		}
 	}
 
-	ttys := []int{0, 1, 2, 3, 8}
-
 	setTTY := func(i int) {
 		curtty = i
 		for _, j := range ttys {
@@ -172,6 +250,13 @@ func main() {
 	input.Call("addEventListener", "input", change)
 	input.Call("focus")
 
+	// Checkpoint fails if the page is closed while processes are running
+	// (for example, while throttled), rather than saving an inconsistent state.
+	js.Global().Call("addEventListener", "beforeunload", js.FuncOf(func(this js.Value, args []js.Value) any {
+		save(sys)
+		return nil
+	}))
+
 	fmt.Printf("started\n")
 
 	var timer *time.Timer